@@ -0,0 +1,224 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/bariiss/stream-capture/internal/hls"
+	"github.com/spf13/cobra"
+)
+
+var checkJSON bool
+
+var checkCmd = &cobra.Command{
+	Use:   "check <url>",
+	Short: "Check that a URL is a usable HLS stream",
+	Long: `Fetches the playlist at the URL and reports its HTTP status, whether it is
+a master or media playlist, its variants, segment count, target duration and
+whether it is live or VOD, then probes the first segment with a HEAD request
+(or a one-byte ranged GET). A master playlist is checked through its highest
+bandwidth variant. Exits non-zero if the stream is unusable.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runCheck,
+	// The report explains the failure; the usage would only bury it
+	SilenceUsage: true,
+}
+
+func init() {
+	checkCmd.Flags().BoolVar(&checkJSON, "json", false, "Print the report as JSON")
+	rootCmd.AddCommand(checkCmd)
+}
+
+// streamCheck is the report of the check command.
+type streamCheck struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"status_code"`
+	// Type is "master" or "media", empty when the body wasn't read.
+	Type     string         `json:"type,omitempty"`
+	Variants []checkVariant `json:"variants,omitempty"`
+	// MediaURL is the media playlist checked: the URL itself or, for a
+	// master playlist, its highest bandwidth variant.
+	MediaURL              string        `json:"media_url,omitempty"`
+	Segments              int           `json:"segments"`
+	FirstSequence         int           `json:"first_sequence"`
+	LastSequence          int           `json:"last_sequence"`
+	TargetDurationSeconds float64       `json:"target_duration_seconds"`
+	Live                  bool          `json:"live"`
+	FirstSegment          *segmentCheck `json:"first_segment,omitempty"`
+	Usable                bool          `json:"usable"`
+	// Problem says why the stream is unusable.
+	Problem string `json:"problem,omitempty"`
+}
+
+type checkVariant struct {
+	URL       string `json:"url"`
+	Bandwidth int    `json:"bandwidth"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	Codecs    string `json:"codecs,omitempty"`
+}
+
+type segmentCheck struct {
+	URL        string `json:"url"`
+	Method     string `json:"method,omitempty"`
+	StatusCode int    `json:"status_code,omitempty"`
+	// ContentLength is the segment size, -1 if the server didn't tell.
+	ContentLength int64  `json:"content_length"`
+	ContentType   string `json:"content_type,omitempty"`
+	Reachable     bool   `json:"reachable"`
+}
+
+func runCheck(cmd *cobra.Command, args []string) error {
+	logger, err := newStatusLogger(cmd)
+	if err != nil {
+		return err
+	}
+	status = logger
+
+	fetcher, err := requestFetcher()
+	if err != nil {
+		return err
+	}
+
+	report := checkStream(cmd.Context(), fetcher, args[0])
+	if checkJSON {
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return fmt.Errorf("error encoding report: %w", err)
+		}
+	} else {
+		printCheck(report)
+	}
+
+	if !report.Usable {
+		return fmt.Errorf("stream is not usable: %s", report.Problem)
+	}
+	return nil
+}
+
+// checkStream examines the stream at playlistURL, stopping at the first
+// problem that makes it unusable.
+func checkStream(ctx context.Context, fetcher *hls.Fetcher, playlistURL string) *streamCheck {
+	report := &streamCheck{URL: playlistURL}
+	fail := func(format string, args ...any) *streamCheck {
+		report.Problem = fmt.Sprintf(format, args...)
+		return report
+	}
+
+	diagnosis, err := fetcher.DiagnoseContext(ctx, playlistURL)
+	if err != nil {
+		return fail("%v", err)
+	}
+	report.StatusCode = diagnosis.StatusCode
+	if diagnosis.StatusCode != http.StatusOK {
+		return fail("playlist request returned %d %s", diagnosis.StatusCode, http.StatusText(diagnosis.StatusCode))
+	}
+
+	content, mediaURL := diagnosis.Body, playlistURL
+	if hls.IsMasterPlaylist(diagnosis.Body) {
+		report.Type = "master"
+		variants, err := hls.ParseMasterPlaylist(diagnosis.Body, playlistURL)
+		if err != nil {
+			return fail("%v", err)
+		}
+		for _, v := range variants {
+			report.Variants = append(report.Variants, checkVariant{
+				URL: v.URL, Bandwidth: v.Bandwidth, Width: v.Width, Height: v.Height, Codecs: v.Codecs,
+			})
+		}
+		variant := hls.SelectVariant(variants, hls.VariantPreference{})
+		if variant == nil {
+			return fail("master playlist without regular variants")
+		}
+		mediaURL = variant.URL
+		if content, err = fetcher.FetchPlaylistContext(ctx, mediaURL); err != nil {
+			return fail("error fetching variant playlist: %v", err)
+		}
+	} else {
+		report.Type = "media"
+	}
+	report.MediaURL = mediaURL
+
+	playlist, err := hls.ParsePlaylistFull(content, mediaURL)
+	if err != nil {
+		return fail("%v", err)
+	}
+	if len(playlist.Segments) == 0 {
+		return fail("media playlist without segments")
+	}
+	first := hls.GetFirstSegment(playlist.Segments)
+	report.Segments = len(playlist.Segments)
+	report.FirstSequence = first.Sequence
+	report.LastSequence = hls.GetLastSegment(playlist.Segments).Sequence
+	report.TargetDurationSeconds = playlist.TargetDuration.Seconds()
+	report.Live = !playlist.EndList
+
+	report.FirstSegment = &segmentCheck{URL: first.URL, ContentLength: -1}
+	info, err := fetcher.ProbeContext(ctx, first.URL)
+	if info != nil {
+		report.FirstSegment.Method = info.Method
+		report.FirstSegment.StatusCode = info.StatusCode
+		report.FirstSegment.ContentLength = info.ContentLength
+		report.FirstSegment.ContentType = info.ContentType
+	}
+	if err != nil {
+		return fail("first segment is not reachable: %v", err)
+	}
+	report.FirstSegment.Reachable = true
+
+	report.Usable = true
+	return report
+}
+
+// printCheck prints the report for people.
+func printCheck(report *streamCheck) {
+	status.Infof("Checking %s\n\n", report.URL)
+	if report.StatusCode != 0 {
+		status.Infof("HTTP status:     %d %s\n", report.StatusCode, http.StatusText(report.StatusCode))
+	}
+	if report.Type == "master" {
+		status.Infof("Playlist:        master, %d variants\n", len(report.Variants))
+		for _, v := range report.Variants {
+			details := fmt.Sprintf("%d bps", v.Bandwidth)
+			if v.Height > 0 {
+				details += fmt.Sprintf(", %dx%d", v.Width, v.Height)
+			}
+			if v.Codecs != "" {
+				details += ", " + v.Codecs
+			}
+			status.Infof("  - %s (%s)\n", v.URL, details)
+		}
+		if report.MediaURL != "" {
+			status.Infof("Checked variant: %s\n", report.MediaURL)
+		}
+	} else if report.Type == "media" {
+		status.Infof("Playlist:        media\n")
+	}
+	if report.Segments > 0 {
+		status.Infof("Segments:        %d (%d-%d)\n", report.Segments, report.FirstSequence, report.LastSequence)
+		status.Infof("Target duration: %gs\n", report.TargetDurationSeconds)
+		if report.Live {
+			status.Infof("Stream type:     live\n")
+		} else {
+			status.Infof("Stream type:     VOD (#EXT-X-ENDLIST present)\n")
+		}
+	}
+	if segment := report.FirstSegment; segment != nil && segment.StatusCode != 0 {
+		details := fmt.Sprintf("%s %d", segment.Method, segment.StatusCode)
+		if segment.ContentType != "" {
+			details += ", " + segment.ContentType
+		}
+		if segment.ContentLength >= 0 {
+			details += fmt.Sprintf(", %d bytes", segment.ContentLength)
+		}
+		status.Infof("First segment:   %s\n", details)
+	}
+
+	status.Infof("\n")
+	if report.Usable {
+		status.Successf("Stream is usable\n")
+	}
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var configFile string
+
+// loadConfig applies the settings of the --config file to the flags not
+// given on the command line, so flags take precedence over the file and the
+// file over the built-in defaults. Keys are flag names, e.g. "url",
+// "count", "interval" or "subtitle-model"; a key matching no flag is an
+// error rather than being ignored. The "streams" key lists streams to
+// capture concurrently, see runStreams.
+func loadConfig(cmd *cobra.Command, args []string) error {
+	if configFile == "" {
+		return nil
+	}
+
+	v := viper.New()
+	v.SetConfigFile(configFile)
+	if err := v.ReadInConfig(); err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+
+	flags := cmd.Flags()
+	var unknown []string
+	for _, key := range v.AllKeys() {
+		if key == "streams" {
+			if err := readStreams(v.Get(key)); err != nil {
+				return fmt.Errorf("invalid %q in config file %s: %w", key, configFile, err)
+			}
+			continue
+		}
+		flag := flags.Lookup(key)
+		if flag == nil || key == "config" {
+			unknown = append(unknown, key)
+			continue
+		}
+		if flag.Changed {
+			continue
+		}
+		if err := setFlagFromConfig(flags, flag, v.Get(key)); err != nil {
+			return fmt.Errorf("invalid %q in config file %s: %w", key, configFile, err)
+		}
+	}
+	if len(unknown) > 0 {
+		slices.Sort(unknown)
+		return fmt.Errorf("unknown settings in config file %s: %s", configFile, strings.Join(unknown, ", "))
+	}
+
+	// Each stream names its own URL
+	if len(streamConfigs) > 0 {
+		flags.SetAnnotation("url", cobra.BashCompOneRequiredFlag, []string{"false"})
+	}
+	return nil
+}
+
+// readStreams records the "streams" list of the config file, a list of
+// settings keyed by flag name.
+func readStreams(value any) error {
+	list, ok := value.([]any)
+	if !ok || len(list) == 0 {
+		return fmt.Errorf("expected a list of streams")
+	}
+	for i, item := range list {
+		settings, ok := item.(map[string]any)
+		if !ok {
+			return fmt.Errorf("stream %d: expected settings keyed by flag name", i+1)
+		}
+		streamConfigs = append(streamConfigs, settings)
+	}
+	return nil
+}
+
+// setFlagFromConfig sets flag to a config value as if it had been given on
+// the command line. A list sets a repeatable flag once per element,
+// replacing its previous values.
+func setFlagFromConfig(flags *pflag.FlagSet, flag *pflag.Flag, value any) error {
+	if _, isMap := value.(map[string]any); isMap {
+		return fmt.Errorf("expected a value, got a section")
+	}
+	list, isList := value.([]any)
+	if !isList {
+		return flags.Set(flag.Name, fmt.Sprint(value))
+	}
+	slice, ok := flag.Value.(pflag.SliceValue)
+	if !ok {
+		return fmt.Errorf("expected a single value, got a list")
+	}
+	if err := slice.Replace(nil); err != nil {
+		return err
+	}
+	for _, item := range list {
+		if err := flags.Set(flag.Name, fmt.Sprint(item)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
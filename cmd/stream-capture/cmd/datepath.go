@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// expandDateLayout replaces strftime-style placeholders in an output path
+// with the capture start time, so runs started periodically can partition
+// their files by date (e.g. "out/%Y/%m/%d/%H.ts" → "out/2024/06/12/14.ts").
+// Supported: %Y %m %d %H %M %S, %j (day of year) and %% for a literal %.
+func expandDateLayout(path string, t time.Time) string {
+	if !strings.Contains(path, "%") {
+		return path
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] != '%' || i+1 == len(path) {
+			b.WriteByte(path[i])
+			continue
+		}
+
+		i++
+		switch path[i] {
+		case 'Y':
+			fmt.Fprintf(&b, "%04d", t.Year())
+		case 'm':
+			fmt.Fprintf(&b, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&b, "%02d", t.Day())
+		case 'H':
+			fmt.Fprintf(&b, "%02d", t.Hour())
+		case 'M':
+			fmt.Fprintf(&b, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&b, "%02d", t.Second())
+		case 'j':
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		case '%':
+			b.WriteByte('%')
+		default:
+			// Unknown placeholders are kept as written
+			b.WriteByte('%')
+			b.WriteByte(path[i])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpandDateLayout(t *testing.T) {
+	started := time.Date(2024, time.February, 3, 4, 5, 6, 0, time.UTC)
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"out.ts", "out.ts"},
+		{"out/%Y/%m/%d/%H.ts", "out/2024/02/03/04.ts"},
+		{"%Y-%m-%dT%H%M%S.ts", "2024-02-03T040506.ts"},
+		{"day-%j.ts", "day-034.ts"},
+		{"100%%.ts", "100%.ts"},
+		{"%%Y.ts", "%Y.ts"},
+		// Unknown placeholders and a trailing % are kept as written
+		{"%q-%Y.ts", "%q-2024.ts"},
+		{"out%", "out%"},
+		{"%Y%Y", "20242024"},
+	}
+	for _, tt := range tests {
+		if got := expandDateLayout(tt.path, started); got != tt.want {
+			t.Errorf("expandDateLayout(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestExpandDateLayoutPadding(t *testing.T) {
+	started := time.Date(987, time.December, 31, 23, 59, 58, 0, time.UTC)
+	if got, want := expandDateLayout("%Y/%m/%d %H:%M:%S %j", started), "0987/12/31 23:59:58 365"; got != want {
+		t.Errorf("expandDateLayout = %q, want %q", got, want)
+	}
+}
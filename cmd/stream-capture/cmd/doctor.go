@@ -0,0 +1,258 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bariiss/stream-capture/internal/hls"
+	"github.com/spf13/cobra"
+)
+
+// certExpiryWarning is how close to expiry a certificate is reported as a
+// warning rather than a pass.
+const certExpiryWarning = 14 * 24 * time.Hour
+
+// doctorTimeout bounds the DNS check; the playlist request uses the
+// Fetcher's own timeouts.
+const doctorTimeout = 10 * time.Second
+
+// playlistContentTypes are the MIME types registered for HLS playlists.
+var playlistContentTypes = []string{
+	"application/vnd.apple.mpegurl",
+	"application/x-mpegurl",
+	"audio/mpegurl",
+	"audio/x-mpegurl",
+}
+
+var doctorURL string
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check that a playlist URL is reachable and valid",
+	Long: `Runs preflight checks against a playlist URL, one after another: DNS
+resolution, TLS certificate, HTTP status and redirects, content type and
+whether the body parses as a playlist. Each check reports pass, warn or fail
+with its timing.`,
+	RunE: runDoctor,
+	// Failed checks are reported above; the usage would only bury them
+	SilenceUsage: true,
+}
+
+func init() {
+	doctorCmd.Flags().StringVarP(&doctorURL, "url", "u", "", "M3U8 playlist URL (required)")
+	doctorCmd.MarkFlagRequired("url")
+	rootCmd.AddCommand(doctorCmd)
+}
+
+// checkResult is the outcome of one doctor check.
+type checkResult int
+
+const (
+	checkPass checkResult = iota
+	checkWarn
+	checkFail
+)
+
+// doctorReport prints check outcomes and counts the failures.
+type doctorReport struct {
+	failed int
+}
+
+func (r *doctorReport) report(name string, result checkResult, elapsed time.Duration, format string, args ...any) {
+	detail := fmt.Sprintf(format, args...)
+	line := fmt.Sprintf("%-14s (%v): %s\n", name, elapsed.Round(time.Millisecond), detail)
+	switch result {
+	case checkPass:
+		status.Successf("[PASS] %s", line)
+	case checkWarn:
+		status.Warnf("[WARN] %s", line)
+	default:
+		r.failed++
+		status.Errorf("[FAIL] %s", line)
+	}
+}
+
+func (r *doctorReport) skip(name, reason string) {
+	status.Infof("[SKIP] %-14s %s\n", name, reason)
+}
+
+func runDoctor(cmd *cobra.Command, args []string) error {
+	logger, err := newStatusLogger(cmd)
+	if err != nil {
+		return err
+	}
+	status = logger
+
+	u, err := url.Parse(doctorURL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("invalid playlist URL %q: expected an http or https URL", doctorURL)
+	}
+
+	fetcher, err := requestFetcher()
+	if err != nil {
+		return err
+	}
+
+	status.Infof("Checking %s\n\n", doctorURL)
+	r := &doctorReport{}
+
+	switch {
+	case proxyURL != "":
+		// The proxy resolves and connects to the host, which may not even be
+		// reachable from here
+		r.skip("DNS", "resolved by the proxy")
+	case !r.checkDNS(u.Hostname()):
+		r.skip("HTTP", "DNS resolution failed")
+		r.skip("TLS", "DNS resolution failed")
+		r.skip("Content type", "DNS resolution failed")
+		r.skip("Playlist", "DNS resolution failed")
+		return r.result()
+	}
+
+	diagnosis := r.checkHTTP(cmd.Context(), fetcher, doctorURL)
+	if diagnosis == nil {
+		r.skip("TLS", "no response")
+		r.skip("Content type", "no response")
+		r.skip("Playlist", "no response")
+		return r.result()
+	}
+
+	r.checkTLS(diagnosis.TLS, diagnosis.TLSHandshake)
+	r.checkContentType(diagnosis.ContentType)
+	r.checkPlaylist(diagnosis, doctorURL)
+	return r.result()
+}
+
+func (r *doctorReport) result() error {
+	status.Infof("\n")
+	if r.failed > 0 {
+		return fmt.Errorf("%d check(s) failed", r.failed)
+	}
+	status.Successf("All checks passed\n")
+	return nil
+}
+
+func (r *doctorReport) checkDNS(host string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+
+	start := time.Now()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		r.report("DNS", checkFail, time.Since(start), "%v", err)
+		return false
+	}
+	r.report("DNS", checkPass, time.Since(start), "%s -> %s", host, strings.Join(addrs, ", "))
+	return true
+}
+
+// checkTLS reports the certificate of the connection that served the
+// playlist, after any redirects, timed by its handshake.
+func (r *doctorReport) checkTLS(state *tls.ConnectionState, handshake time.Duration) {
+	if state == nil {
+		r.skip("TLS", "plain HTTP")
+		return
+	}
+	if len(state.PeerCertificates) == 0 {
+		r.report("TLS", checkWarn, handshake, "%s without a server certificate", tls.VersionName(state.Version))
+		return
+	}
+
+	cert := state.PeerCertificates[0]
+	remaining := time.Until(cert.NotAfter)
+	detail := fmt.Sprintf("%s, issued by %s, expires %s (in %d days)",
+		cert.Subject.CommonName, cert.Issuer.CommonName, cert.NotAfter.Format(time.DateOnly), int(remaining.Hours()/24))
+	if remaining < certExpiryWarning {
+		r.report("TLS", checkWarn, handshake, "%s", detail)
+		return
+	}
+	r.report("TLS", checkPass, handshake, "%s", detail)
+}
+
+// checkHTTP requests the playlist through the Fetcher and reports the status
+// and redirect chain. It returns nil when no response was received.
+func (r *doctorReport) checkHTTP(ctx context.Context, fetcher *hls.Fetcher, playlistURL string) *hls.Diagnosis {
+	start := time.Now()
+	diagnosis, err := fetcher.DiagnoseContext(ctx, playlistURL)
+	if err != nil {
+		r.report("HTTP", checkFail, time.Since(start), "%v", err)
+		return nil
+	}
+
+	detail := fmt.Sprintf("%d %s", diagnosis.StatusCode, http.StatusText(diagnosis.StatusCode))
+	if len(diagnosis.Redirects) > 0 {
+		detail += ", redirected via " + strings.Join(diagnosis.Redirects, " -> ")
+	}
+	result := checkPass
+	if diagnosis.StatusCode != http.StatusOK {
+		result = checkFail
+	}
+	r.report("HTTP", result, diagnosis.Duration, "%s", detail)
+	return diagnosis
+}
+
+func (r *doctorReport) checkContentType(contentType string) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	switch {
+	case contentType == "":
+		r.report("Content type", checkWarn, 0, "missing")
+	case err != nil:
+		r.report("Content type", checkWarn, 0, "unparsable %q: %v", contentType, err)
+	case !containsFold(playlistContentTypes, mediaType):
+		r.report("Content type", checkWarn, 0, "%s is not an HLS playlist type; the body is still checked", mediaType)
+	default:
+		r.report("Content type", checkPass, 0, "%s", mediaType)
+	}
+}
+
+func (r *doctorReport) checkPlaylist(diagnosis *hls.Diagnosis, playlistURL string) {
+	start := time.Now()
+	if hls.IsMasterPlaylist(diagnosis.Body) {
+		variants, err := hls.ParseMasterPlaylist(diagnosis.Body, playlistURL)
+		switch {
+		case err != nil:
+			r.report("Playlist", checkFail, time.Since(start), "%v", err)
+		case len(variants) == 0:
+			r.report("Playlist", checkWarn, time.Since(start), "master playlist without regular variants")
+		default:
+			r.report("Playlist", checkPass, time.Since(start), "master playlist with %d variants", len(variants))
+		}
+		return
+	}
+
+	segments, err := hls.ParsePlaylist(diagnosis.Body, playlistURL)
+	switch {
+	case err != nil:
+		r.report("Playlist", checkFail, time.Since(start), "%v", err)
+	case len(segments) == 0:
+		r.report("Playlist", checkFail, time.Since(start), "media playlist without segments")
+	default:
+		kind := "live"
+		if hls.IsEndList(diagnosis.Body) {
+			kind = "complete (VOD)"
+		}
+		result, note := checkPass, ""
+		if !hls.HasPlaylistHeader(diagnosis.Body) {
+			result, note = checkWarn, ", missing the #EXTM3U header"
+		}
+		r.report("Playlist", result, time.Since(start), "%s media playlist with %d segments (%d-%d)%s",
+			kind, len(segments), hls.GetFirstSegment(segments).Sequence, hls.GetLastSegment(segments).Sequence, note)
+	}
+}
+
+// containsFold reports whether list contains s, ignoring case.
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
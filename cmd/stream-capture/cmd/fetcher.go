@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/bariiss/stream-capture/internal/hls"
+)
+
+// requestFetcher returns a Fetcher honouring the request flags shared by
+// every command: --header, --user-agent, --proxy, --insecure, --ca-cert and
+// --cookies.
+func requestFetcher() (*hls.Fetcher, error) {
+	httpHeaders, err := requestHeaders(headers, userAgent)
+	if err != nil {
+		return nil, err
+	}
+	fetcher := hls.NewFetcher()
+	fetcher.Headers = httpHeaders
+	if proxyURL != "" {
+		if err := fetcher.SetProxy(proxyURL); err != nil {
+			return nil, err
+		}
+	}
+	tlsConfig, err := clientTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		fetcher.SetTLSConfig(tlsConfig)
+	}
+	if cookieFile != "" {
+		if err := fetcher.LoadCookieFile(cookieFile); err != nil {
+			return nil, fmt.Errorf("error loading cookies: %w", err)
+		}
+	}
+	return fetcher, nil
+}
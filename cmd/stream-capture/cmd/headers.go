@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// requestHeaders builds the headers sent with every request from --header
+// and --user-agent, which takes precedence over a User-Agent header.
+func requestHeaders(headers []string, userAgent string) (http.Header, error) {
+	h := make(http.Header)
+	for _, header := range headers {
+		name, value, ok := strings.Cut(header, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid --header %q: expected \"Name: Value\"", header)
+		}
+		h.Add(name, strings.TrimSpace(value))
+	}
+	if userAgent != "" {
+		h.Set("User-Agent", userAgent)
+	}
+	return h, nil
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/bariiss/stream-capture/internal/capture"
+	"github.com/spf13/cobra"
+)
+
+// Formats of --log-format.
+const (
+	logFormatConsole = "console"
+	logFormatText    = "text"
+	logFormatJSON    = "json"
+)
+
+var (
+	logLevel  string
+	logFormat string
+	quiet     bool
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "Lowest level of the messages shown: debug, info, warn or error")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", logFormatConsole, "Message format: console (colored lines), or text (key=value) or json records on stderr for log aggregation")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Only show errors (same as --log-level error)")
+}
+
+// newStatusLogger returns the process-wide logger set up by --log-level,
+// --log-format, --quiet and --no-color.
+func newStatusLogger(cmd *cobra.Command) (*capture.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+		return nil, fmt.Errorf("invalid --log-level %q: use debug, info, warn or error", logLevel)
+	}
+	if quiet {
+		if cmd.Flags().Changed("log-level") {
+			return nil, fmt.Errorf("--quiet cannot be combined with --log-level")
+		}
+		level = slog.LevelError
+	}
+
+	// Records go to stderr, leaving stdout to --stats json
+	options := &slog.HandlerOptions{Level: level}
+	switch logFormat {
+	case logFormatConsole:
+		logger := capture.NewLogger(noColor)
+		logger.SetLevel(level)
+		return logger, nil
+	case logFormatText:
+		return capture.NewSlogLogger(slog.New(slog.NewTextHandler(os.Stderr, options))), nil
+	case logFormatJSON:
+		return capture.NewSlogLogger(slog.New(slog.NewJSONHandler(os.Stderr, options))), nil
+	}
+	return nil, fmt.Errorf("invalid --log-format %q: use %s, %s or %s", logFormat, logFormatConsole, logFormatText, logFormatJSON)
+}
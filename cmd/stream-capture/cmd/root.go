@@ -1,35 +1,151 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
 	"time"
 
+	"github.com/bariiss/stream-capture/internal/audio"
+	"github.com/bariiss/stream-capture/internal/capture"
+	"github.com/bariiss/stream-capture/internal/downloader"
+	"github.com/bariiss/stream-capture/internal/hls"
+	"github.com/bariiss/stream-capture/internal/subtitle"
+	"github.com/bariiss/stream-capture/internal/transcode"
+	"github.com/bariiss/stream-capture/internal/upload"
 	"github.com/spf13/cobra"
 )
 
 var (
-	playlistURL      string
-	segmentCount     int
-	mergeFile        string
-	outputFile       string
-	pollInterval     time.Duration
-	extractAudio     bool
-	audioOnly        bool
-	audioOutput      string
-	extractSubtitle  bool
-	subtitleOutput   string
-	subtitleLanguage string
-	subtitleModel    string
+	playlistURL       string
+	segmentCount      int
+	captureDuration   time.Duration
+	stateFile         string
+	mergeFile         string
+	outputFiles       []string
+	pollInterval      time.Duration
+	adaptivePolling   bool
+	minPollInterval   time.Duration
+	maxPollInterval   time.Duration
+	extractAudio      bool
+	audioOnly         bool
+	audioOutput       string
+	audioFormat       string
+	audioBitDepth     int
+	audioCodec        string
+	audioBitrate      string
+	audioSampleRate   int
+	audioChannels     int
+	keepSampleRate    bool
+	audioNormalize    bool
+	audioLoudness     float64
+	audioSplitOn      string
+	trimStart         time.Duration
+	trimEnd           time.Duration
+	trimVideo         bool
+	extractSubtitle   bool
+	subtitleOutput    string
+	subtitleLanguage  string
+	subtitleModel     string
+	subtitleFormat    string
+	subtitleTranslate bool
+	subtitleWords     bool
+	iframeVariant     string
+	audioTrack        string
+	subtitleTrack     string
+	splitTracks       bool
+	sequenceStrategy  string
+	variantCodec      string
+	variantChoice     string
+	liveDelay         int
+	keepUp            bool
+	probeSegments     bool
+	startSequence     int
+	fromPosition      string
+	endSequence       int
+	startTimeFlag     string
+	endTimeFlag       string
+	preview           bool
+	dryRun            bool
+	skipMissingTools  bool
+	priority          string
+	vodCount          string
+	resumeMode        string
+	rawConcat         bool
+	refreshCommand    string
+	pipeline          bool
+	keyHex            string
+	shutdownGrace     time.Duration
+	staleTimeout      time.Duration
+	maxDisk           string
+	maxRetries        int
+	maxConsecErrors   int
+	maxTotalErrors    int
+	keyFile           string
+	ivHex             string
+	quietProgress     bool
+	progressInterval  time.Duration
+	noColor           bool
+	headers           []string
+	userAgent         string
+	cookieFile        string
+	proxyURL          string
+	insecureTLS       bool
+	caCertFile        string
+	streamID          string
+	logDir            string
+	transcodeMerged   bool
+	videoBitrate      string
+	videoScale        string
+	videoEncoder      string
+	videoPreset       string
+	hashManifest      string
+	checksumFile      bool
+	maxConnsPerHost   int
+	maxRate           string
+	segmentTypes      []string
+	maxPlaylistSize   string
+	maxSegmentSize    string
+	connectTimeout    time.Duration
+	segmentTimeout    time.Duration
+	verifyMerged      bool
+	verifyMaxErrors   int
+	verifySegments    bool
+	thumbnailFile     string
+	onDiscontinuity   string
+	execCommand       string
+	execIgnoreErrors  bool
+	statsFormat       string
+	s3Bucket          string
+	s3Endpoint        string
+	s3Prefix          string
+	s3DeleteLocal     bool
+	webhookURL        string
+	webhookSecret     string
+	statsFile         string
 )
 
+// status is the process-wide logger; plain until a command configures it.
+var status = &capture.Logger{}
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "stream-capture",
 	Short: "HLS stream capture tool",
 	Long: `A Go library and CLI tool for capturing HLS (HTTP Live Streaming) live streams
-by downloading and merging segments. Supports audio extraction using FFmpeg.`,
-	RunE: runCapture,
+by downloading and merging segments. Supports audio extraction using FFmpeg.
+
+Settings can be kept in a YAML or JSON file passed with --config, keyed by flag
+name. Flags given on the command line override the file, which overrides the
+built-in defaults. A "streams" list in the file captures several streams
+concurrently, each with its own url, output and other settings.`,
+	PreRunE: loadConfig,
+	RunE:    runCapture,
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -45,25 +161,319 @@ func init() {
 	rootCmd.Flags().StringVarP(&playlistURL, "url", "u", "", "M3U8 playlist URL (required)")
 	rootCmd.MarkFlagRequired("url")
 
+	rootCmd.Flags().StringVar(&configFile, "config", "", "YAML or JSON file of default flag values, keyed by flag name (e.g. url, count, interval); command-line flags override it. A \"streams\" list of such settings captures several streams concurrently")
+
 	// Optional flags
-	rootCmd.Flags().IntVarP(&segmentCount, "count", "c", 10, "Number of segments to download (starting from the latest)")
+	rootCmd.Flags().IntVarP(&segmentCount, "count", "c", 10, "Number of segments to download (starting from the latest); a VOD playlist is captured whole unless --count or --duration is given")
+	rootCmd.Flags().StringVar(&vodCount, "vod-count", capture.VODFirst, "Which segments of a VOD playlist (one with #EXT-X-ENDLIST) --count and --duration select: first or last")
+	rootCmd.Flags().StringVar(&stateFile, "resume", "", "State file recording the capture's progress; if it exists, the interrupted capture it describes is resumed")
+	rootCmd.Flags().DurationVar(&captureDuration, "duration", 0, "Capture until this much stream time (sum of segment durations) is downloaded, e.g. 30m; replaces --count")
 	rootCmd.Flags().StringVarP(&mergeFile, "merge", "m", "", "Output file for merged segments (alternative to -output)")
-	rootCmd.Flags().StringVarP(&outputFile, "output", "o", "", "Output file for merged segments (alternative to -merge)")
-	rootCmd.Flags().DurationVarP(&pollInterval, "interval", "i", 2*time.Second, "Playlist polling interval")
+	rootCmd.Flags().StringArrayVarP(&outputFiles, "output", "o", nil, "Output file for merged segments (alternative to -merge); repeat to write several outputs (files or FIFOs) in one pass")
+	rootCmd.Flags().DurationVarP(&pollInterval, "interval", "i", 0, "Playlist polling interval (default: half the playlist's target duration)")
+	rootCmd.Flags().BoolVar(&adaptivePolling, "adaptive-interval", false, "Poll faster while new segments keep appearing and slower during quiet periods")
+	rootCmd.Flags().DurationVar(&minPollInterval, "min-interval", 500*time.Millisecond, "Shortest polling interval with --adaptive-interval")
+	rootCmd.Flags().DurationVar(&maxPollInterval, "max-interval", 10*time.Second, "Longest polling interval with --adaptive-interval")
+	rootCmd.Flags().BoolVar(&transcodeMerged, "transcode", false, "Re-encode the merged video with FFmpeg (slow, lossy) using the --video-* and --scale settings")
+	rootCmd.Flags().StringVar(&videoBitrate, "video-bitrate", "", "Target video bitrate for --transcode (e.g. 2500k, 4M)")
+	rootCmd.Flags().StringVar(&videoScale, "scale", "", "Target resolution for --transcode as WIDTHxHEIGHT (e.g. 1280x720, -2x720)")
+	rootCmd.Flags().StringVar(&videoEncoder, "video-encoder", transcode.EncoderH264, "Video encoder for --transcode (libx264, libx265)")
+	rootCmd.Flags().StringVar(&videoPreset, "preset", "", "Encoder preset for --transcode (e.g. veryfast, medium, slow)")
+	rootCmd.Flags().BoolVar(&quietProgress, "quiet-progress", false, "Throttle per-segment progress lines (updated in place on a terminal)")
+	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false, "Disable colored output (also disabled by NO_COLOR and when not writing to a terminal)")
+	rootCmd.PersistentFlags().StringArrayVarP(&headers, "header", "H", nil, "HTTP header sent with every playlist, segment and key request, as \"Name: Value\"; repeat for several headers")
+	rootCmd.PersistentFlags().StringVar(&cookieFile, "cookies", "", "Netscape-format cookies.txt file whose cookies are sent with matching requests")
+	rootCmd.PersistentFlags().StringVar(&proxyURL, "proxy", "", "Proxy for all requests (http://, https://, socks5:// or socks5h://); defaults to HTTP_PROXY/HTTPS_PROXY")
+	rootCmd.PersistentFlags().BoolVar(&insecureTLS, "insecure", false, "Skip TLS certificate verification for all requests (unsafe: for self-signed test servers only)")
+	rootCmd.PersistentFlags().StringVar(&caCertFile, "ca-cert", "", "PEM file of CA certificates trusted in addition to the system roots")
+	rootCmd.PersistentFlags().StringVar(&userAgent, "user-agent", "", "User-Agent sent with every request (default: Go's HTTP client)")
+	rootCmd.Flags().StringVar(&streamID, "stream-id", "", "Identifier prefixed to this capture's log lines, e.g. the variant name")
+	rootCmd.Flags().StringVar(&logDir, "log-dir", "", "Also write the capture's log to <dir>/<stream-id>.log (named after the playlist URL without --stream-id)")
+	rootCmd.Flags().DurationVar(&progressInterval, "progress-interval", 250*time.Millisecond, "Minimum time between progress lines with --quiet-progress")
+	rootCmd.Flags().IntVar(&maxConsecErrors, "max-consecutive-errors", 0, "Abort after this many playlist/segment fetches fail in a row (0 = unlimited)")
+	rootCmd.Flags().IntVar(&maxTotalErrors, "max-total-errors", 0, "Abort after this many playlist/segment fetches fail in total (0 = unlimited)")
+	rootCmd.Flags().IntVar(&maxRetries, "max-retries", hls.DefaultRetryPolicy.MaxRetries, "Retries of a playlist or segment request failing with a network error or 408/429/5xx status (0 = no retries)")
+	rootCmd.Flags().DurationVar(&staleTimeout, "stale-timeout", 60*time.Second, "Treat a live stream as ended when its playlist gets no new segments for this long (0 = wait indefinitely)")
+	rootCmd.Flags().StringVar(&maxDisk, "max-disk", "", "Keep a rolling buffer of at most this much segment data, e.g. 2GB, discarding the oldest segments; only the retained window is merged")
+	rootCmd.Flags().DurationVar(&shutdownGrace, "shutdown-grace", 10*time.Second, "On Ctrl-C, how long to wait for in-flight segment downloads before merging what completed")
+	rootCmd.Flags().BoolVar(&splitTracks, "split-tracks", false, "Also write the audio and subtitles into files sharing the output's base name (out.ts, out.mp3, out.vtt or out.srt), capturing the variant's audio and subtitle renditions alongside the video when it has them")
+	rootCmd.Flags().StringVar(&keyHex, "key-hex", "", "AES-128 key as 32 hex digits, used instead of fetching the EXT-X-KEY URI")
+	rootCmd.Flags().StringVar(&keyFile, "key-file", "", "File holding the AES-128 key (16 raw bytes or 32 hex digits), used instead of fetching the EXT-X-KEY URI")
+	rootCmd.Flags().StringVar(&ivHex, "iv-hex", "", "AES-128 IV as 32 hex digits, overriding the playlist's IV (requires --key-hex or --key-file)")
+	rootCmd.Flags().BoolVar(&pipeline, "pipeline", false, "Extract audio while segments are still downloading instead of after the merge")
+	rootCmd.Flags().StringVar(&refreshCommand, "refresh-url-command", "", "Command printing a fresh playlist URL when signed URLs expire (placeholder: {url})")
+	rootCmd.Flags().BoolVar(&rawConcat, "raw-concat", false, "Write segments byte-for-byte into the output without any FFmpeg processing")
+	rootCmd.Flags().StringVar(&priority, "priority", capture.PriorityOldest, "Download order when several segments are available: oldest or newest (covers the live edge first)")
+	rootCmd.Flags().StringVar(&resumeMode, "resume-mode", capture.ResumeCatchUp, "After a pause (SIGUSR1, resumed by SIGUSR2): catchup downloads the held segments, live jumps to the live edge")
+	rootCmd.Flags().BoolVar(&skipMissingTools, "skip-missing-tools", false, "Keep the capture and warn instead of failing when ffmpeg or whisper is not installed")
+	rootCmd.Flags().BoolVar(&preview, "preview", false, "Quick validation: grab the latest few segments without waiting and a short audio clip")
+	rootCmd.Flags().BoolVar(&dryRun, "dry-run", false, "List the playlist's segments, their total duration and whether the stream is live, without downloading anything")
+	rootCmd.Flags().IntVar(&maxConnsPerHost, "max-conns-per-host", 0, "Maximum simultaneous requests to a single host across all captures (0 = unlimited)")
+	rootCmd.Flags().StringVar(&maxRate, "max-rate", "", "Maximum total segment download speed across all captures, e.g. 2MB/s or 500KB/s (default: unlimited)")
+	rootCmd.Flags().StringVar(&maxPlaylistSize, "max-playlist-size", "8MB", "Fail when a playlist body exceeds this size, e.g. 16MB; 0 disables the limit")
+	rootCmd.Flags().StringVar(&maxSegmentSize, "max-segment-size", "", "Fail when a segment response exceeds this size, e.g. 50MB (default: unlimited)")
+	rootCmd.Flags().DurationVar(&connectTimeout, "connect-timeout", hls.DefaultConnectTimeout, "Give up on a request that takes longer to connect, complete the TLS handshake or receive the response headers (0 = no limit)")
+	rootCmd.Flags().DurationVar(&segmentTimeout, "segment-timeout", hls.DefaultSegmentTimeout, "Abort a segment download that receives no data for this long; a slow segment that keeps progressing is never cut off (0 = no limit)")
+	rootCmd.Flags().StringSliceVar(&segmentTypes, "segment-content-types", nil, "Media types accepted for segment responses, comma-separated; type/* matches any subtype and * disables the check (default: video/*, audio/*, application/octet-stream, binary/octet-stream, application/mp4, text/vtt)")
+	rootCmd.Flags().StringVar(&fromPosition, "from", capture.FromLatest, "Where in the live window to start: latest (the live edge), start (the oldest segment, capturing the backlog first) or a media sequence still in the window")
+	rootCmd.Flags().IntVar(&startSequence, "start-sequence", 0, "Media sequence of the first segment to capture (default: start at the live edge)")
+	rootCmd.Flags().IntVar(&endSequence, "end-sequence", 0, "Media sequence of the last segment to capture, inclusive; with --start-sequence replaces --count")
+	rootCmd.Flags().StringVar(&startTimeFlag, "start-time", "", "Start with the segment playing at this RFC3339 time, e.g. 2024-05-01T20:00:00+02:00, per the playlist's #EXT-X-PROGRAM-DATE-TIME; waits for the stream to reach it")
+	rootCmd.Flags().StringVar(&endTimeFlag, "end-time", "", "Stop before the first segment starting at or after this RFC3339 time, per the playlist's #EXT-X-PROGRAM-DATE-TIME; replaces --count")
+	rootCmd.Flags().BoolVar(&keepUp, "keep-up", false, "When the capture falls behind the live window, jump to the live edge instead of skipping the evicted segments one by one")
+	rootCmd.Flags().BoolVar(&probeSegments, "probe-segments", false, "Wait for the next segment with HEAD requests to its predicted URL, polling the playlist less often and picking up segments published before the playlist lists them")
+	rootCmd.Flags().IntVar(&liveDelay, "live-delay", 0, "Stay this many segments behind the live edge for stability (adds latency)")
+	rootCmd.Flags().BoolVar(&checksumFile, "checksum-file", false, "Write the merged output's SHA-256 to <output>.sha256 (sha256sum format)")
+	rootCmd.Flags().StringVar(&hashManifest, "hash-manifest", "", "Write a JSON manifest of each segment's SHA-256, size and source URL")
+	rootCmd.Flags().BoolVar(&verifyMerged, "verify", false, "Decode the merged output with FFmpeg and fail if it is corrupted")
+	rootCmd.Flags().IntVar(&verifyMaxErrors, "verify-max-errors", 0, "Number of decode errors tolerated by --verify")
+	rootCmd.Flags().StringVar(&thumbnailFile, "thumbnail", "", "Save the frame at the middle of the capture as a .jpg or .png image (requires FFmpeg)")
+	rootCmd.Flags().BoolVar(&verifySegments, "verify-segments", false, "Re-download segments shorter than their Content-Length up to --max-retries times and check segment hashes before merging")
+	rootCmd.Flags().StringVar(&onDiscontinuity, "on-discontinuity", capture.DiscontinuityIgnore, "Merging across EXT-X-DISCONTINUITY: ignore (concatenate as is), split (one <output>.partN file per part) or remux (re-timestamp the parts into one output with FFmpeg)")
+	rootCmd.Flags().StringVar(&statsFormat, "stats", "", "Print capture statistics to stdout on completion; the only format is json")
+	rootCmd.Flags().StringVar(&statsFile, "stats-file", "", "Write capture statistics as JSON to this file on completion")
 	rootCmd.Flags().BoolVarP(&extractAudio, "audio", "a", false, "Extract audio as MP3 from the merged video file")
 	rootCmd.Flags().BoolVar(&audioOnly, "audio-only", false, "Extract only audio (video file will be deleted after extraction)")
-	rootCmd.Flags().StringVar(&audioOutput, "audio-output", "", "Output path for audio file (default: <merge-file>.mp3)")
+	rootCmd.Flags().StringVar(&audioOutput, "audio-output", "", "Output path for audio file (default: <merge-file>.<audio-format>)")
+	rootCmd.Flags().StringVar(&audioFormat, "audio-format", audio.FormatMP3, "Extracted audio format: mp3, aac (.m4a), or lossless wav (PCM) or flac (default: from the --audio-output extension, else mp3)")
+	rootCmd.Flags().StringVar(&audioCodec, "audio-codec", "", "FFmpeg audio encoder, e.g. libfdk_aac for aac or pcm_f32le for wav (default: the format's standard encoder)")
+	rootCmd.Flags().StringVar(&audioBitrate, "audio-bitrate", "", "Bitrate of mp3 and aac audio, e.g. 128k (default: 192k)")
+	rootCmd.Flags().IntVar(&audioSampleRate, "audio-sample-rate", 0, "Sample rate of the extracted audio in Hz (default: 44100)")
+	rootCmd.Flags().IntVar(&audioChannels, "audio-channels", 0, "Number of audio channels, e.g. 1 for mono or 2 for stereo (default: keep the source layout)")
+	rootCmd.Flags().IntVar(&audioBitDepth, "audio-bitdepth", 0, "Bits per sample for wav/flac audio: 16 or 24 (default: 16 for wav, encoder default for flac)")
+	rootCmd.Flags().BoolVar(&keepSampleRate, "audio-keep-sample-rate", false, "Keep the source sample rate instead of resampling audio to 44.1 kHz")
+	rootCmd.Flags().BoolVar(&audioNormalize, "audio-normalize", false, "Normalize the extracted audio's loudness with FFmpeg's loudnorm filter (EBU R128)")
+	rootCmd.Flags().Float64Var(&audioLoudness, "audio-lufs", audio.DefaultLoudness, "Integrated loudness targeted by --audio-normalize, in LUFS (-70 to -5)")
+	rootCmd.Flags().DurationVar(&trimStart, "trim-start", 0, "Cut this much from the start of the extracted audio, measured on the merged file (e.g. 2.5s)")
+	rootCmd.Flags().DurationVar(&trimEnd, "trim-end", 0, "Cut this much from the end of the extracted audio, measured on the merged file (e.g. 1m)")
+	rootCmd.Flags().BoolVar(&trimVideo, "trim-video", false, "Apply --trim-start and --trim-end to the merged video as well (cuts snap to keyframes)")
+	rootCmd.Flags().StringVar(&audioSplitOn, "audio-split-on", "", "Split extracted audio into numbered files plus an index at boundaries (discontinuity, chapter)")
 	rootCmd.Flags().BoolVar(&extractSubtitle, "subtitle", false, "Extract subtitles from audio using Whisper")
-	rootCmd.Flags().StringVar(&subtitleOutput, "subtitle-output", "", "Output path for subtitle file (default: <audio-file>.srt)")
+	rootCmd.Flags().StringVar(&subtitleOutput, "subtitle-output", "", "Output path for subtitle file (default: <audio-file>.<format>)")
+	rootCmd.Flags().StringVar(&subtitleFormat, "subtitle-format", subtitle.FormatSRT, "Subtitle format: srt, vtt, txt or json; comma-separate several to write each next to --subtitle-output")
 	rootCmd.Flags().StringVar(&subtitleLanguage, "subtitle-language", "", "Language code for subtitle extraction (e.g., tr, en). Auto-detect if not specified")
-	rootCmd.Flags().StringVar(&subtitleModel, "subtitle-model", "base", "Whisper model to use (tiny, base, small, medium, large, large-v2, large-v3). Default: base")
+	rootCmd.Flags().BoolVar(&subtitleTranslate, "subtitle-translate", false, "Translate the speech into English subtitles instead of transcribing it in the source language")
+	rootCmd.Flags().BoolVar(&subtitleWords, "subtitle-word-timestamps", false, "Time every word for karaoke-style output, recorded in the json subtitle format (slower)")
+	rootCmd.Flags().StringVar(&subtitleModel, "subtitle-model", subtitle.DefaultModel, "Whisper model to use (tiny, base, small, medium, large, large-v2, large-v3, turbo, or a .en variant); larger models need more RAM and time")
+	rootCmd.Flags().StringVar(&variantChoice, "variant", "", "Variant of a master playlist to capture: max or min bandwidth, or the resolution closest to e.g. 720p or 1280x720 (default: max)")
+	rootCmd.Flags().StringVar(&variantCodec, "variant-codec", "", "Pick the best-bandwidth variant of a master playlist whose codecs contain this string (e.g. avc1, hvc1)")
+	rootCmd.Flags().StringVar(&audioTrack, "audio-track", "", "Audio rendition of a master playlist to extract: index, language (e.g. en) or name (default: the stream's default rendition)")
+	rootCmd.Flags().StringVar(&subtitleTrack, "subtitle-track", "", "WebVTT subtitle rendition of a master playlist to capture alongside the video: index, language (e.g. en) or name")
+	rootCmd.Flags().StringVar(&iframeVariant, "iframe-variant", "", "Capture an I-frame-only variant of a master playlist for previews (max, min, or variant index)")
+	rootCmd.Flags().Lookup("iframe-variant").NoOptDefVal = "max"
+	rootCmd.Flags().StringVar(&sequenceStrategy, "sequence-strategy", string(hls.SequenceMediaSequence), "Where segment sequence numbers come from: media-sequence (#EXT-X-MEDIA-SEQUENCE), regex (\"_<n>.ts\" in the URL) or filename (the number ending the file name)")
+	rootCmd.Flags().StringVar(&execCommand, "exec", "", "Command to run after a successful capture; supports {output}, {audio}, {subtitle}, {url} and {count} placeholders")
+	rootCmd.Flags().StringVar(&s3Bucket, "s3-bucket", "", "Upload the merged output, audio and subtitles to this S3 bucket (credentials from the AWS_* environment variables)")
+	rootCmd.Flags().StringVar(&s3Endpoint, "s3-endpoint", "", "URL of an S3-compatible service to upload to instead of AWS, e.g. http://minio:9000")
+	rootCmd.Flags().StringVar(&s3Prefix, "s3-prefix", "", "Prefix of the uploaded object keys, e.g. captures/")
+	rootCmd.Flags().BoolVar(&s3DeleteLocal, "s3-delete-local", false, "Delete each local file once it was uploaded")
+	rootCmd.Flags().StringVar(&webhookURL, "webhook-url", "", "POST a JSON report (status, outputs, stats, error) to this URL when the capture completes or fails")
+	rootCmd.Flags().StringVar(&webhookSecret, "webhook-secret", "", "Sign --webhook-url requests with an HMAC-SHA256 of the body in the X-Stream-Capture-Signature-256 header")
+	rootCmd.Flags().BoolVar(&execIgnoreErrors, "exec-ignore-errors", false, "Don't fail the run when the --exec command exits non-zero")
 }
 
 func runCapture(cmd *cobra.Command, args []string) error {
-	// Use -merge if provided, otherwise use -output
-	finalOutputFile := mergeFile
-	if finalOutputFile == "" {
-		finalOutputFile = outputFile
+	logger, err := newStatusLogger(cmd)
+	if err != nil {
+		return err
+	}
+	status = logger
+
+	// Connection and bandwidth limits apply across all captures of the process
+	hostLimiter := hls.NewHostLimiter(maxConnsPerHost)
+	var rateLimiter *hls.RateLimiter
+	if maxRate != "" {
+		bytesPerSecond, err := hls.ParseRate(maxRate)
+		if err != nil {
+			return fmt.Errorf("invalid --max-rate: %w", err)
+		}
+		rateLimiter = hls.NewRateLimiter(bytesPerSecond)
+	}
+
+	if len(streamConfigs) > 0 {
+		return runStreams(cmd, hostLimiter, rateLimiter)
+	}
+	if failFast {
+		return fmt.Errorf("--fail-fast requires a --config file listing streams")
+	}
+
+	opts, err := captureOptions(cmd)
+	if err != nil {
+		return err
+	}
+	opts.HostLimiter = hostLimiter
+	opts.RateLimiter = rateLimiter
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	result, err := capture.Run(ctx, opts)
+	// Statistics are reported for failed captures too
+	if statsErr := writeStats(result.Stats, statsFormat, statsFile); statsErr != nil && err == nil {
+		err = statsErr
+	}
+	return err
+}
+
+// captureOptions validates the capture flags and returns the options of
+// the capture they describe. The limits shared by all captures of the
+// process, HostLimiter and RateLimiter, are left for the caller to set.
+func captureOptions(cmd *cobra.Command) (capture.Options, error) {
+	if preview && !cmd.Flags().Changed("count") {
+		segmentCount = capture.PreviewSegmentCount
+	}
+
+	if !adaptivePolling && (cmd.Flags().Changed("min-interval") || cmd.Flags().Changed("max-interval")) {
+		return capture.Options{}, fmt.Errorf("--min-interval and --max-interval require --adaptive-interval")
+	}
+
+	if cmd.Flags().Changed("duration") {
+		switch {
+		case captureDuration <= 0:
+			return capture.Options{}, fmt.Errorf("--duration must be positive")
+		case cmd.Flags().Changed("count"):
+			return capture.Options{}, fmt.Errorf("--duration and --count are mutually exclusive: give the capture length as a stream duration or a segment count")
+		case preview || cmd.Flags().Changed("end-sequence"):
+			return capture.Options{}, fmt.Errorf("--duration cannot be combined with --preview or --end-sequence")
+		}
+		// The segment count follows from the segment durations
+		segmentCount = 0
+	}
+
+	if err := resolveSequenceRange(cmd); err != nil {
+		return capture.Options{}, err
+	}
+	if err := resolveFrom(cmd); err != nil {
+		return capture.Options{}, err
+	}
+	startTime, endTime, err := resolveTimeWindow(cmd)
+	if err != nil {
+		return capture.Options{}, err
+	}
+
+	var maxDiskBytes int64
+	if maxDisk != "" {
+		var err error
+		if maxDiskBytes, err = hls.ParseSize(maxDisk); err != nil {
+			return capture.Options{}, fmt.Errorf("invalid --max-disk: %w", err)
+		}
+		if maxDiskBytes <= 0 {
+			return capture.Options{}, fmt.Errorf("--max-disk must be positive")
+		}
+	}
+
+	playlistLimit, err := parseSizeLimit("--max-playlist-size", maxPlaylistSize)
+	if err != nil {
+		return capture.Options{}, err
+	}
+	if playlistLimit == 0 {
+		playlistLimit = -1 // Options treats 0 as the default limit
+	}
+	segmentLimit, err := parseSizeLimit("--max-segment-size", maxSegmentSize)
+	if err != nil {
+		return capture.Options{}, err
+	}
+	if connectTimeout < 0 || segmentTimeout < 0 {
+		return capture.Options{}, fmt.Errorf("--connect-timeout and --segment-timeout must not be negative")
+	}
+
+	if stateFile != "" {
+		for _, flag := range []string{"duration", "from", "start-sequence", "end-sequence", "start-time", "end-time", "preview", "pipeline"} {
+			if cmd.Flags().Changed(flag) {
+				return capture.Options{}, fmt.Errorf("--resume cannot be combined with --%s", flag)
+			}
+		}
+	}
+
+	strategy, err := hls.ParseSequenceStrategy(sequenceStrategy)
+	if err != nil {
+		return capture.Options{}, fmt.Errorf("invalid --sequence-strategy: %w", err)
+	}
+
+	if audioSplitOn != "" {
+		extractAudio = true
+	}
+	if splitTracks {
+		extractAudio = true
+	}
+
+	// The audio output's extension picks the format unless it is given
+	if !cmd.Flags().Changed("audio-format") && audioOutput != "" {
+		if format, ok := audio.FormatForExt(filepath.Ext(audioOutput)); ok {
+			audioFormat = format
+		}
+	}
+	audioOptions := audio.Options{
+		Format:         audioFormat,
+		Codec:          audioCodec,
+		Bitrate:        audioBitrate,
+		SampleRate:     audioSampleRate,
+		Channels:       audioChannels,
+		BitDepth:       audioBitDepth,
+		KeepSampleRate: keepSampleRate,
+		Normalize:      audioNormalize,
+	}
+	if cmd.Flags().Changed("audio-lufs") {
+		if !audioNormalize {
+			return capture.Options{}, fmt.Errorf("--audio-lufs requires --audio-normalize")
+		}
+		audioOptions.Loudness = audioLoudness
+	}
+
+	subtitleFormats, err := subtitle.ParseFormats(subtitleFormat)
+	if err != nil {
+		return capture.Options{}, fmt.Errorf("invalid --subtitle-format: %w", err)
+	}
+
+	keyOverride, err := loadKeyOverride(keyHex, keyFile, ivHex)
+	if err != nil {
+		return capture.Options{}, err
+	}
+
+	transcodeOptions := transcode.Options{
+		Encoder:      videoEncoder,
+		Preset:       videoPreset,
+		VideoBitrate: videoBitrate,
+		Scale:        videoScale,
+	}
+	if !transcodeMerged && (videoBitrate != "" || videoScale != "" || videoPreset != "" || cmd.Flags().Changed("video-encoder")) {
+		return capture.Options{}, fmt.Errorf("--video-bitrate, --scale, --video-encoder and --preset require --transcode")
+	}
+
+	// Use -merge if provided, otherwise use -output. Any further targets
+	// receive a copy of the merged stream.
+	targets := outputFiles
+	if mergeFile != "" {
+		targets = append([]string{mergeFile}, outputFiles...)
+	}
+
+	var s3Options *upload.S3Options
+	if s3Bucket != "" {
+		s3Options = &upload.S3Options{Bucket: s3Bucket, Endpoint: s3Endpoint, Prefix: s3Prefix}
+	} else if s3Endpoint != "" || s3Prefix != "" || s3DeleteLocal {
+		return capture.Options{}, fmt.Errorf("--s3-endpoint, --s3-prefix and --s3-delete-local require --s3-bucket")
+	}
+
+	if statsFormat != "" && statsFormat != "json" {
+		return capture.Options{}, fmt.Errorf("invalid --stats %q: the only format is json", statsFormat)
+	}
+
+	// Output paths may carry date placeholders (%Y/%m/%d/%H...), expanded
+	// once with the capture start time so every file of a run agrees.
+	started := time.Now()
+	expanded := make([]string, len(targets))
+	for i, target := range targets {
+		expanded[i] = expandDateLayout(target, started)
+	}
+	targets = expanded
+	audioOutput = expandDateLayout(audioOutput, started)
+	subtitleOutput = expandDateLayout(subtitleOutput, started)
+	hashManifest = expandDateLayout(hashManifest, started)
+
+	var finalOutputFile string
+	var extraOutputs []string
+	if len(targets) > 0 {
+		finalOutputFile, extraOutputs = targets[0], targets[1:]
 	}
 
 	// If subtitle is enabled, automatically enable audio extraction (subtitle needs audio)
@@ -74,18 +484,317 @@ func runCapture(cmd *cobra.Command, args []string) error {
 	// If audio-only is enabled, automatically enable audio extraction
 	if audioOnly {
 		extractAudio = true
-		// In audio-only mode, audio-output is required
-		if audioOutput == "" {
-			return fmt.Errorf("--audio-output is required when using --audio-only")
-		}
 		// If no output file specified, use temporary file
 		if finalOutputFile == "" {
 			finalOutputFile = os.TempDir() + "/stream-capture-temp.ts"
 		}
-	} else if finalOutputFile == "" {
-		return fmt.Errorf("either -output or -merge flag is required")
 	}
 
-	// Import here to avoid circular dependencies
-	return executeCapture(playlistURL, segmentCount, finalOutputFile, pollInterval, extractAudio, audioOnly, audioOutput, extractSubtitle, subtitleOutput, subtitleLanguage, subtitleModel)
+	httpHeaders, err := requestHeaders(headers, userAgent)
+	if err != nil {
+		return capture.Options{}, err
+	}
+	tlsConfig, err := clientTLSConfig()
+	if err != nil {
+		return capture.Options{}, err
+	}
+
+	// The flags keep -1 for an unset start sequence
+	var startSequenceOpt *int
+	if startSequence >= 0 {
+		start := startSequence
+		startSequenceOpt = &start
+	}
+
+	opts := capture.Options{
+		URL:                  playlistURL,
+		SegmentCount:         segmentCount,
+		Duration:             captureDuration,
+		StateFile:            stateFile,
+		Output:               finalOutputFile,
+		ExtraOutputs:         extraOutputs,
+		PollInterval:         pollInterval,
+		AdaptivePolling:      adaptivePolling,
+		MinPollInterval:      minPollInterval,
+		MaxPollInterval:      maxPollInterval,
+		ExtractAudio:         extractAudio,
+		AudioOnly:            audioOnly,
+		AudioOutput:          audioOutput,
+		AudioOptions:         audioOptions,
+		AudioSplitOn:         audioSplitOn,
+		TrimStart:            trimStart,
+		TrimEnd:              trimEnd,
+		TrimVideo:            trimVideo,
+		ExtractSubtitle:      extractSubtitle,
+		SubtitleOutput:       subtitleOutput,
+		SubtitleLanguage:     subtitleLanguage,
+		SubtitleModel:        subtitleModel,
+		SubtitleFormats:      subtitleFormats,
+		SubtitleTranslate:    subtitleTranslate,
+		SubtitleWords:        subtitleWords,
+		IFrameVariant:        iframeVariant,
+		AudioTrack:           audioTrack,
+		SubtitleTrack:        subtitleTrack,
+		SequenceStrategy:     strategy,
+		Variant:              variantChoice,
+		VariantCodec:         variantCodec,
+		StartSequence:        startSequenceOpt,
+		From:                 fromPosition,
+		StartInWindow:        cmd.Flags().Changed("from") && startSequence >= 0,
+		StartTime:            startTime,
+		EndTime:              endTime,
+		LiveDelay:            liveDelay,
+		KeepUp:               keepUp,
+		ProbeSegments:        probeSegments,
+		Preview:              preview,
+		DryRun:               dryRun,
+		SkipMissingTools:     skipMissingTools,
+		Priority:             priority,
+		VODCount:             vodCount,
+		VODAll:               !cmd.Flags().Changed("count") && !cmd.Flags().Changed("duration") && startSequence < 0 && !preview,
+		ResumeMode:           resumeMode,
+		RawConcat:            rawConcat,
+		RefreshCommand:       refreshCommand,
+		Pipeline:             pipeline,
+		KeyOverride:          keyOverride,
+		SplitTracks:          splitTracks,
+		ShutdownGrace:        shutdownGrace,
+		StaleTimeout:         staleTimeout,
+		MaxDiskBytes:         maxDiskBytes,
+		MaxRetries:           maxRetries,
+		MaxConsecutiveErrors: maxConsecErrors,
+		MaxTotalErrors:       maxTotalErrors,
+		QuietProgress:        quietProgress,
+		ProgressInterval:     progressInterval,
+		Transcode:            transcodeMerged,
+		TranscodeOptions:     transcodeOptions,
+		HashManifest:         hashManifest,
+		ChecksumFile:         checksumFile,
+		SegmentContentTypes:  segmentTypes,
+		MaxPlaylistSize:      playlistLimit,
+		MaxSegmentSize:       segmentLimit,
+		ConnectTimeout:       noLimit(connectTimeout),
+		SegmentTimeout:       noLimit(segmentTimeout),
+		Headers:              httpHeaders,
+		CookieFile:           cookieFile,
+		ProxyURL:             proxyURL,
+		TLSConfig:            tlsConfig,
+		Verify:               verifyMerged,
+		VerifyMaxErrors:      verifyMaxErrors,
+		Thumbnail:            thumbnailFile,
+		VerifySegments:       verifySegments,
+		OnDiscontinuity:      onDiscontinuity,
+		StreamID:             streamID,
+		LogDir:               logDir,
+		ExecCommand:          execCommand,
+		ExecIgnoreErrors:     execIgnoreErrors,
+		Upload:               s3Options,
+		UploadDeleteLocal:    s3DeleteLocal,
+		WebhookURL:           webhookURL,
+		WebhookSecret:        webhookSecret,
+		PauseSignals:         true,
+		Logger:               status,
+	}
+	if err := opts.Validate(); err != nil {
+		return capture.Options{}, err
+	}
+
+	// Stdout receives the merged bytes and nothing else, so every message
+	// goes to stderr instead.
+	if finalOutputFile == capture.StdoutOutput {
+		if statsFormat == "json" {
+			return capture.Options{}, fmt.Errorf("--stats json cannot be combined with --output -, which writes the stream to stdout; use --stats-file")
+		}
+		status.UseStderr()
+	}
+	return opts, nil
+}
+
+// writeStats prints stats to stdout when format is json and writes them to
+// file when it is set.
+func writeStats(stats any, format, file string) error {
+	if format == "" && file == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding statistics: %w", err)
+	}
+	data = append(data, '\n')
+	if format == "json" {
+		os.Stdout.Write(data)
+	}
+	if file != "" {
+		if err := os.WriteFile(file, data, 0644); err != nil {
+			return fmt.Errorf("error writing statistics: %w", err)
+		}
+	}
+	return nil
+}
+
+// interruptContext returns a context canceled on Ctrl-C or SIGTERM, which
+// stops a capture gracefully, merging what was downloaded.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(sigChan)
+		select {
+		case <-sigChan:
+			status.Infof("\nShutting down...\n")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// resolveFrom validates --from. An explicit sequence becomes the start
+// sequence, leaving From at its default.
+func resolveFrom(cmd *cobra.Command) error {
+	if !cmd.Flags().Changed("from") || fromPosition == capture.FromLatest {
+		return nil
+	}
+	if cmd.Flags().Changed("start-sequence") || cmd.Flags().Changed("end-sequence") || preview {
+		return fmt.Errorf("--from cannot be combined with --start-sequence, --end-sequence or --preview")
+	}
+	if fromPosition == capture.FromStart {
+		return nil
+	}
+	sequence, err := strconv.Atoi(fromPosition)
+	if err != nil || sequence < 0 {
+		return fmt.Errorf("invalid --from %q: use %s, %s or a media sequence number", fromPosition, capture.FromLatest, capture.FromStart)
+	}
+	startSequence = sequence
+	fromPosition = capture.FromLatest
+	return nil
+}
+
+// resolveTimeWindow parses --start-time and --end-time.
+func resolveTimeWindow(cmd *cobra.Command) (start, end time.Time, err error) {
+	if startTimeFlag != "" {
+		if start, err = time.Parse(time.RFC3339, startTimeFlag); err != nil {
+			return start, end, fmt.Errorf("invalid --start-time %q: use an RFC3339 time such as 2024-05-01T20:00:00Z", startTimeFlag)
+		}
+		for _, flag := range []string{"start-sequence", "end-sequence", "from", "preview"} {
+			if cmd.Flags().Changed(flag) {
+				return start, end, fmt.Errorf("--start-time cannot be combined with --%s", flag)
+			}
+		}
+	}
+	if endTimeFlag != "" {
+		if end, err = time.Parse(time.RFC3339, endTimeFlag); err != nil {
+			return start, end, fmt.Errorf("invalid --end-time %q: use an RFC3339 time such as 2024-05-01T20:30:00Z", endTimeFlag)
+		}
+		for _, flag := range []string{"count", "duration", "end-sequence", "preview"} {
+			if cmd.Flags().Changed(flag) {
+				return start, end, fmt.Errorf("--end-time cannot be combined with --%s", flag)
+			}
+		}
+		if !start.IsZero() && !end.After(start) {
+			return start, end, fmt.Errorf("--end-time must be after --start-time")
+		}
+	}
+	return start, end, nil
+}
+
+// resolveSequenceRange turns --start-sequence and --end-sequence into a
+// start sequence and segment count. Given only an end, the range is the
+// --count segments ending there.
+func resolveSequenceRange(cmd *cobra.Command) error {
+	hasStart := cmd.Flags().Changed("start-sequence")
+	hasEnd := cmd.Flags().Changed("end-sequence")
+	if !hasStart && !hasEnd {
+		startSequence = -1
+		return nil
+	}
+
+	if preview {
+		return fmt.Errorf("--preview cannot be combined with --start-sequence or --end-sequence")
+	}
+	if (hasStart && startSequence < 0) || (hasEnd && endSequence < 0) {
+		return fmt.Errorf("--start-sequence and --end-sequence must not be negative")
+	}
+
+	switch {
+	case hasStart && hasEnd:
+		if cmd.Flags().Changed("count") {
+			return fmt.Errorf("--count cannot be combined with both --start-sequence and --end-sequence")
+		}
+		if endSequence < startSequence {
+			return fmt.Errorf("--end-sequence %d is before --start-sequence %d", endSequence, startSequence)
+		}
+		segmentCount = endSequence - startSequence + 1
+	case hasEnd:
+		if endSequence-segmentCount+1 < 0 {
+			return fmt.Errorf("--end-sequence %d leaves no room for %d segments", endSequence, segmentCount)
+		}
+		startSequence = endSequence - segmentCount + 1
+	}
+	return nil
+}
+
+// aesKeySize is the size in bytes of AES-128 keys and IVs.
+const aesKeySize = 16
+
+// loadKeyOverride builds the out-of-band AES key from --key-hex/--key-file
+// and --iv-hex. It returns nil when no key was given.
+func loadKeyOverride(keyHex, keyFile, ivHex string) (*downloader.KeyOverride, error) {
+	if keyHex != "" && keyFile != "" {
+		return nil, fmt.Errorf("--key-hex and --key-file cannot be combined")
+	}
+	if keyHex == "" && keyFile == "" {
+		if ivHex != "" {
+			return nil, fmt.Errorf("--iv-hex requires --key-hex or --key-file")
+		}
+		return nil, nil
+	}
+
+	override := &downloader.KeyOverride{}
+	var err error
+	if keyHex != "" {
+		if override.Key, err = hls.ParseHex(keyHex, aesKeySize); err != nil {
+			return nil, fmt.Errorf("invalid --key-hex: %w", err)
+		}
+	} else {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading --key-file: %w", err)
+		}
+		// Key URIs serve the 16 raw bytes; hand-written files tend to be hex
+		if len(data) == aesKeySize {
+			override.Key = data
+		} else if override.Key, err = hls.ParseHex(string(data), aesKeySize); err != nil {
+			return nil, fmt.Errorf("invalid --key-file: expected 16 raw bytes or 32 hex digits")
+		}
+	}
+
+	if ivHex != "" {
+		if override.IV, err = hls.ParseHex(ivHex, aesKeySize); err != nil {
+			return nil, fmt.Errorf("invalid --iv-hex: %w", err)
+		}
+	}
+	return override, nil
+}
+
+// parseSizeLimit parses the size given to flag; empty means 0 (no limit).
+func parseSizeLimit(flag, value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	size, err := hls.ParseSize(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", flag, err)
+	}
+	return size, nil
+}
+
+// noLimit maps a timeout flag's 0, meaning no limit, to the negative value
+// Options takes for it; Options treats 0 as the default.
+func noLimit(timeout time.Duration) time.Duration {
+	if timeout == 0 {
+		return -1
+	}
+	return timeout
 }
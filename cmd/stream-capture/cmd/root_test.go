@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+// sequenceRangeCommand returns a command with the flags read by
+// resolveSequenceRange, parsed from args into the package variables.
+func sequenceRangeCommand(t *testing.T, args ...string) *cobra.Command {
+	t.Helper()
+	cmd := &cobra.Command{}
+	cmd.Flags().IntVarP(&segmentCount, "count", "c", 10, "")
+	cmd.Flags().IntVar(&startSequence, "start-sequence", 0, "")
+	cmd.Flags().IntVar(&endSequence, "end-sequence", 0, "")
+	cmd.Flags().BoolVar(&preview, "preview", false, "")
+	if err := cmd.Flags().Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cmd
+}
+
+func TestResolveSequenceRange(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		wantStart int
+		wantCount int
+		wantErr   bool
+	}{
+		{"no range", nil, -1, 10, false},
+		{"start and end", []string{"--start-sequence", "100", "--end-sequence", "104"}, 100, 5, false},
+		{"single segment", []string{"--start-sequence", "100", "--end-sequence", "100"}, 100, 1, false},
+		{"open-ended start", []string{"--start-sequence", "100"}, 100, 10, false},
+		{"open-ended start with count", []string{"--start-sequence", "100", "-c", "3"}, 100, 3, false},
+		{"end only", []string{"--end-sequence", "100"}, 91, 10, false},
+		{"end only with count", []string{"--end-sequence", "100", "-c", "1"}, 100, 1, false},
+		{"end at zero", []string{"--end-sequence", "0", "-c", "1"}, 0, 1, false},
+		{"start after end", []string{"--start-sequence", "105", "--end-sequence", "100"}, 0, 0, true},
+		{"count with start and end", []string{"--start-sequence", "100", "--end-sequence", "104", "-c", "5"}, 0, 0, true},
+		{"negative start", []string{"--start-sequence", "-1"}, 0, 0, true},
+		{"negative end", []string{"--end-sequence", "-5"}, 0, 0, true},
+		{"end too early for count", []string{"--end-sequence", "3", "-c", "10"}, 0, 0, true},
+		{"preview", []string{"--start-sequence", "100", "--preview"}, 0, 0, true},
+	}
+	for _, tt := range tests {
+		cmd := sequenceRangeCommand(t, tt.args...)
+		err := resolveSequenceRange(cmd)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: resolveSequenceRange = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if startSequence != tt.wantStart || segmentCount != tt.wantCount {
+			t.Errorf("%s: start %d, count %d; want %d and %d", tt.name, startSequence, segmentCount, tt.wantStart, tt.wantCount)
+		}
+	}
+}
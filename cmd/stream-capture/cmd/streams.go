@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/bariiss/stream-capture/internal/capture"
+	"github.com/bariiss/stream-capture/internal/hls"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// streamConfigs holds the "streams" list of the --config file: the flag
+// settings of each stream captured concurrently, keyed by flag name.
+var streamConfigs []map[string]any
+
+var failFast bool
+
+// processFlags configure the process rather than a capture, so a stream of
+// the config file cannot set them.
+var processFlags = []string{"config", "streams", "no-color", "max-conns-per-host", "max-rate", "fail-fast", "log-level", "log-format", "quiet"}
+
+func init() {
+	rootCmd.Flags().BoolVar(&failFast, "fail-fast", false, "With several streams in --config, stop all captures as soon as one fails (default: the others keep running)")
+}
+
+// streamCapture is one capture of a multi-stream run.
+type streamCapture struct {
+	opts        capture.Options
+	statsFormat string
+	statsFile   string
+}
+
+// runStreams captures the streams listed in the config file concurrently,
+// each in its own capture sharing the signal handler and the connection
+// and bandwidth limits. A failing stream leaves the others running unless
+// --fail-fast is set.
+func runStreams(cmd *cobra.Command, hostLimiter *hls.HostLimiter, rateLimiter *hls.RateLimiter) error {
+	streams, err := streamCaptures(cmd)
+	if err != nil {
+		return err
+	}
+	status.Infof("Capturing %d streams\n", len(streams))
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	results := make([]*capture.Result, len(streams))
+	errs := make([]error, len(streams))
+	var wg sync.WaitGroup
+	for i, stream := range streams {
+		stream.opts.HostLimiter = hostLimiter
+		stream.opts.RateLimiter = rateLimiter
+		wg.Go(func() {
+			results[i], errs[i] = capture.Run(ctx, stream.opts)
+			if errs[i] == nil {
+				return
+			}
+			status.Errorf("[%s] Capture failed: %v\n", stream.opts.StreamID, errs[i])
+			if failFast && ctx.Err() == nil {
+				status.Warnf("Stopping the other streams (--fail-fast)\n")
+				cancel()
+			}
+		})
+	}
+	wg.Wait()
+
+	// Each stream reports its statistics; those printed to stdout are
+	// combined into one JSON object keyed by stream ID.
+	var failed []error
+	printed := make(map[string]capture.Stats)
+	for i, stream := range streams {
+		if errs[i] != nil {
+			failed = append(failed, fmt.Errorf("stream %s: %w", stream.opts.StreamID, errs[i]))
+		}
+		if err := writeStats(results[i].Stats, "", stream.statsFile); err != nil {
+			failed = append(failed, fmt.Errorf("stream %s: %w", stream.opts.StreamID, err))
+		}
+		if stream.statsFormat == "json" {
+			printed[stream.opts.StreamID] = results[i].Stats
+		}
+	}
+	if len(printed) > 0 {
+		if err := writeStats(printed, "json", ""); err != nil {
+			failed = append(failed, err)
+		}
+	}
+
+	if len(failed) > 0 {
+		status.Errorf("\n%d of %d streams failed\n", countFailed(errs), len(streams))
+		return errors.Join(failed...)
+	}
+	status.Successf("\nAll %d streams captured\n", len(streams))
+	return nil
+}
+
+// countFailed returns the number of non-nil errors.
+func countFailed(errs []error) int {
+	n := 0
+	for _, err := range errs {
+		if err != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// streamCaptures validates the streams of the config file. Each stream
+// starts from the shared settings (command line, config file, defaults)
+// and overrides them with its own; a stream without a stream-id is named
+// stream-<N> after its position.
+func streamCaptures(cmd *cobra.Command) ([]streamCapture, error) {
+	flags := cmd.Flags()
+	restore := snapshotFlags(flags)
+	defer restore()
+
+	streams := make([]streamCapture, 0, len(streamConfigs))
+	ids := make(map[string]bool)
+	outputs := make(map[string]string)
+	for i, settings := range streamConfigs {
+		restore()
+		if err := applyStreamSettings(flags, settings); err != nil {
+			return nil, fmt.Errorf("stream %d in config file %s: %w", i+1, configFile, err)
+		}
+		if streamID == "" {
+			streamID = fmt.Sprintf("stream-%d", i+1)
+		}
+		if ids[streamID] {
+			return nil, fmt.Errorf("stream %d in config file %s: duplicate stream-id %q", i+1, configFile, streamID)
+		}
+		ids[streamID] = true
+		if playlistURL == "" {
+			return nil, fmt.Errorf("stream %s: no url", streamID)
+		}
+
+		opts, err := captureOptions(cmd)
+		if err != nil {
+			return nil, fmt.Errorf("stream %s: %w", streamID, err)
+		}
+		for _, output := range append([]string{opts.Output}, opts.ExtraOutputs...) {
+			if other, ok := outputs[output]; ok && output != "" {
+				return nil, fmt.Errorf("streams %s and %s both write %s", other, streamID, output)
+			}
+			outputs[output] = streamID
+		}
+		streams = append(streams, streamCapture{opts: opts, statsFormat: statsFormat, statsFile: statsFile})
+	}
+	return streams, nil
+}
+
+// applyStreamSettings sets the flags named by the settings of one stream.
+// Unlike the top level of the config file, a stream's settings override
+// the command line.
+func applyStreamSettings(flags *pflag.FlagSet, settings map[string]any) error {
+	var unknown []string
+	for _, key := range slices.Sorted(maps.Keys(settings)) {
+		flag := flags.Lookup(key)
+		if flag == nil || slices.Contains(processFlags, key) {
+			unknown = append(unknown, key)
+			continue
+		}
+		if err := setFlagFromConfig(flags, flag, settings[key]); err != nil {
+			return fmt.Errorf("invalid %q: %w", key, err)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown or process-wide settings: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// snapshotFlags records the value of every flag and whether it was set,
+// returning a function restoring them.
+func snapshotFlags(flags *pflag.FlagSet) func() {
+	type saved struct {
+		flag    *pflag.Flag
+		value   string
+		list    []string
+		changed bool
+	}
+	var all []saved
+	flags.VisitAll(func(flag *pflag.Flag) {
+		s := saved{flag: flag, value: flag.Value.String(), changed: flag.Changed}
+		if slice, ok := flag.Value.(pflag.SliceValue); ok {
+			s.list = slices.Clone(slice.GetSlice())
+		}
+		all = append(all, s)
+	})
+
+	return func() {
+		for _, s := range all {
+			if slice, ok := s.flag.Value.(pflag.SliceValue); ok {
+				slice.Replace(slices.Clone(s.list))
+			} else {
+				s.flag.Value.Set(s.value)
+			}
+			s.flag.Changed = s.changed
+		}
+	}
+}
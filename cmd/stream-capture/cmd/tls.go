@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/bariiss/stream-capture/internal/hls"
+)
+
+// clientTLSConfig builds the TLS settings from --insecure and --ca-cert,
+// or returns nil when neither is given. --insecure is warned about so it
+// isn't left on by accident.
+func clientTLSConfig() (*tls.Config, error) {
+	if !insecureTLS && caCertFile == "" {
+		return nil, nil
+	}
+	config, err := hls.NewTLSConfig(insecureTLS, caCertFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --ca-cert: %w", err)
+	}
+	if insecureTLS {
+		status.Warnf("Warning: --insecure disables TLS certificate verification; don't use it in production\n")
+	}
+	return config, nil
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/spf13/cobra"
+)
+
+// Build metadata, set by release builds with
+//
+//	go build -ldflags "-X github.com/bariiss/stream-capture/cmd/stream-capture/cmd.version=1.2.3 \
+//	  -X github.com/bariiss/stream-capture/cmd/stream-capture/cmd.commit=$(git rev-parse HEAD) \
+//	  -X github.com/bariiss/stream-capture/cmd/stream-capture/cmd.date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Builds without them fall back to what the Go toolchain recorded.
+var (
+	version string
+	commit  string
+	date    string
+)
+
+var versionJSON bool
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Print the version and build details",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		info := currentBuildInfo()
+		if versionJSON {
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			return enc.Encode(info)
+		}
+		fmt.Fprint(cmd.OutOrStdout(), info.String())
+		return nil
+	},
+}
+
+func init() {
+	versionCmd.Flags().BoolVar(&versionJSON, "json", false, "Print the build details as JSON")
+	rootCmd.AddCommand(versionCmd)
+
+	// --version/-v on the root command prints the same text
+	info := currentBuildInfo()
+	rootCmd.Version = info.Version
+	rootCmd.SetVersionTemplate(info.String())
+}
+
+// buildInfo describes the running binary.
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// currentBuildInfo returns the -ldflags metadata, completed from the module
+// version and VCS details embedded by the Go toolchain: "go install ...@v1.2.3"
+// records the version, a build inside the git checkout the commit and date.
+func currentBuildInfo() buildInfo {
+	info := buildInfo{
+		Version:   version,
+		Commit:    commit,
+		Date:      date,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.Date == "" {
+					info.Date = setting.Value
+				}
+			}
+		}
+	}
+
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.Date == "" {
+		info.Date = "unknown"
+	}
+	return info
+}
+
+// String formats the build details for the terminal.
+func (b buildInfo) String() string {
+	return fmt.Sprintf("stream-capture %s\n  commit: %s\n  built:  %s\n  go:     %s %s\n",
+		b.Version, b.Commit, b.Date, b.GoVersion, b.Platform)
+}
@@ -2,24 +2,30 @@ package audio
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/bariiss/stream-capture/internal/ffmpeg"
 )
 
 // Extractor handles audio extraction from video files using FFmpeg.
 type Extractor struct {
 	ffmpegPath string
+
+	// Options selects the output format; the zero value produces MP3.
+	Options Options
 }
 
 // NewExtractor creates a new audio extractor with FFmpeg path detection.
 func NewExtractor() (*Extractor, error) {
 	// Try to find ffmpeg in PATH
-	ffmpegPath, err := exec.LookPath("ffmpeg")
+	ffmpegPath, err := ffmpeg.LookPath()
 	if err != nil {
-		installHint := getInstallHint()
-		return nil, fmt.Errorf("ffmpeg not found in PATH: %w\n%s", err, installHint)
+		return nil, err
 	}
 
 	return &Extractor{
@@ -27,40 +33,108 @@ func NewExtractor() (*Extractor, error) {
 	}, nil
 }
 
-// ExtractAudio extracts audio from a video file and saves it as MP3.
-// Returns the path to the output MP3 file.
+// ExtractAudio extracts audio from a video file and saves it in the
+// extractor's format (MP3 by default).
 func (e *Extractor) ExtractAudio(videoPath string, outputPath string) error {
+	return e.extract(videoPath, outputPath, nil)
+}
+
+// ExtractAudioRange extracts the audio between start and start+duration of a
+// video file and saves it in the extractor's format.
+func (e *Extractor) ExtractAudioRange(videoPath string, outputPath string, start, duration time.Duration) error {
+	// -ss before -i seeks the input quickly; -t limits the output length
+	return e.extract(videoPath, outputPath, []string{
+		"-ss", formatSeconds(start),
+		"-t", formatSeconds(duration),
+	})
+}
+
+// extract runs FFmpeg on videoPath with inputArgs placed before -i.
+func (e *Extractor) extract(videoPath string, outputPath string, inputArgs []string) error {
 	// Ensure output directory exists
 	outputDir := filepath.Dir(outputPath)
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
 
-	// FFmpeg command to extract audio and convert to MP3
+	cmd := e.command(inputArgs, videoPath, outputPath)
+
+	// FFmpeg writes straight to the terminal, so a failure is explained by
+	// its own output rather than the returned error
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg extraction failed: %w", err)
+	}
+
+	return nil
+}
+
+// command builds the FFmpeg command converting input to audio at
+// outputPath in the extractor's format.
+func (e *Extractor) command(inputArgs []string, input string, outputPath string) *exec.Cmd {
+	// FFmpeg command to extract audio
 	// -i: input file
 	// -vn: no video
-	// -acodec libmp3lame: use MP3 codec
-	// -ab 192k: audio bitrate 192kbps
-	// -ar 44100: audio sample rate 44.1kHz
 	// -y: overwrite output file if exists
-	cmd := exec.Command(e.ffmpegPath,
-		"-i", videoPath,
+	args := append(inputArgs,
+		"-i", input,
 		"-vn",
-		"-acodec", "libmp3lame",
-		"-ab", "192k",
-		"-ar", "44100",
-		"-y",
-		outputPath,
 	)
+	args = append(args, e.Options.codecArgs()...)
+	args = append(args, "-y", outputPath)
+	return exec.Command(e.ffmpegPath, args...)
+}
+
+// Stream is an audio extraction running while its MPEG-TS input is still
+// being written. Close must be called to finish the output file.
+type Stream struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+// ExtractAudioStream starts FFmpeg reading MPEG-TS from the returned
+// Stream and writing audio to outputPath, so extraction can overlap with the
+// download of later segments.
+func (e *Extractor) ExtractAudioStream(outputPath string) (*Stream, error) {
+	// Ensure output directory exists
+	outputDir := filepath.Dir(outputPath)
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
 
-	// Capture both stdout and stderr for better error messages
+	// -f mpegts: the input format can't be probed from a pipe name
+	cmd := e.command([]string{"-f", "mpegts"}, "pipe:0", outputPath)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("ffmpeg extraction failed: %w", err)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ffmpeg input: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ffmpeg: %w", err)
 	}
 
+	return &Stream{cmd: cmd, stdin: stdin}, nil
+}
+
+// Write feeds MPEG-TS data to FFmpeg.
+func (s *Stream) Write(p []byte) (int, error) {
+	n, err := s.stdin.Write(p)
+	if err != nil {
+		return n, fmt.Errorf("ffmpeg stopped reading input: %w", err)
+	}
+	return n, nil
+}
+
+// Close ends the input and waits for FFmpeg to finish the output file.
+func (s *Stream) Close() error {
+	s.stdin.Close()
+	if err := s.cmd.Wait(); err != nil {
+		return fmt.Errorf("ffmpeg extraction failed: %w", err)
+	}
 	return nil
 }
 
@@ -70,23 +144,7 @@ func (e *Extractor) ExtractAudioFromTS(tsPath string, outputPath string) error {
 	return e.ExtractAudio(tsPath, outputPath)
 }
 
-// getInstallHint returns platform-specific installation instructions for FFmpeg.
-func getInstallHint() string {
-	switch runtime.GOOS {
-	case "darwin":
-		return "To install FFmpeg on macOS, run: brew install ffmpeg"
-	case "linux":
-		return "To install FFmpeg on Linux:\n" +
-			"  Ubuntu/Debian: sudo apt-get update && sudo apt-get install -y ffmpeg\n" +
-			"  Alpine: sudo apk add ffmpeg\n" +
-			"  CentOS/RHEL: sudo yum install ffmpeg (or sudo dnf install ffmpeg)"
-	case "windows":
-		return "To install FFmpeg on Windows:\n" +
-			"  1. Download from https://ffmpeg.org/download.html\n" +
-			"  2. Extract and add the bin directory to your PATH environment variable\n" +
-			"  Or use Chocolatey: choco install ffmpeg\n" +
-			"  Or use Scoop: scoop install ffmpeg"
-	default:
-		return "Please install FFmpeg for your platform. Visit https://ffmpeg.org/download.html"
-	}
+// formatSeconds formats d as fractional seconds for FFmpeg time options.
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
 }
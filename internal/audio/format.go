@@ -0,0 +1,264 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Supported audio formats.
+const (
+	FormatMP3  = "mp3"
+	FormatAAC  = "aac"
+	FormatWAV  = "wav"
+	FormatFLAC = "flac"
+)
+
+// defaultSampleRate is the rate audio is resampled to unless the source rate
+// is kept.
+const defaultSampleRate = "44100"
+
+// defaultBitrate is the bitrate of the lossy formats unless one is given.
+const defaultBitrate = "192k"
+
+// formatCodecs lists the FFmpeg encoders each format's container can hold;
+// the first one is the default.
+var formatCodecs = map[string][]string{
+	FormatMP3:  {"libmp3lame"},
+	FormatAAC:  {"aac", "libfdk_aac"},
+	FormatWAV:  {"pcm_s16le", "pcm_s24le", "pcm_s32le", "pcm_f32le"},
+	FormatFLAC: {"flac"},
+}
+
+// DefaultLoudness is the integrated loudness, in LUFS, normalized audio
+// targets unless another is given: the EBU R128 broadcast level.
+const DefaultLoudness = -23.0
+
+// loudnorm's true peak (dBTP) and loudness range (LU) targets.
+const (
+	loudnormTruePeak = "-1.5"
+	loudnormRange    = "11"
+)
+
+// mp3SampleRates are the sample rates MPEG audio can encode.
+var mp3SampleRates = []int{8000, 11025, 12000, 16000, 22050, 24000, 32000, 44100, 48000}
+
+// Options controls the encoding of extracted audio.
+type Options struct {
+	// Format is the output format: FormatMP3 (default), FormatAAC (in an
+	// M4A container), FormatWAV (PCM) or FormatFLAC. WAV and FLAC are
+	// lossless.
+	Format string
+	// Codec overrides the FFmpeg encoder the format uses by default, e.g.
+	// libfdk_aac for AAC or pcm_f32le for WAV. It must be one the format's
+	// container can hold.
+	Codec string
+	// Bitrate is the target bitrate of MP3 and AAC audio in FFmpeg notation
+	// (e.g. "128k"). Empty means 192k.
+	Bitrate string
+	// SampleRate is the output sample rate in Hz. 0 resamples to 44.1 kHz
+	// unless KeepSampleRate is set.
+	SampleRate int
+	// Channels is the number of output channels, e.g. 1 for mono or 2 for
+	// stereo. 0 keeps the source layout.
+	Channels int
+	// BitDepth is the sample size in bits, 16 or 24, for WAV and FLAC.
+	// 0 keeps 16-bit for WAV and the encoder default for FLAC.
+	BitDepth int
+	// KeepSampleRate keeps the source sample rate instead of resampling
+	// to 44.1 kHz.
+	KeepSampleRate bool
+	// Normalize runs FFmpeg's loudnorm filter (EBU R128) so captures of
+	// different streams play at the same volume, targeting Loudness LUFS
+	// (0 means DefaultLoudness).
+	Normalize bool
+	Loudness  float64
+	// Track is the zero-based index of the input's audio stream to
+	// extract, applied as -map 0:a:<Track> when SelectTrack is set.
+	// Otherwise FFmpeg picks the best audio stream itself.
+	Track       int
+	SelectTrack bool
+}
+
+// FormatForExt returns the format of audio files with extension ext
+// (".m4a" is AAC), or false for an extension of no supported format.
+func FormatForExt(ext string) (string, bool) {
+	switch strings.ToLower(ext) {
+	case ".mp3":
+		return FormatMP3, true
+	case ".m4a", ".aac":
+		return FormatAAC, true
+	case ".wav":
+		return FormatWAV, true
+	case ".flac":
+		return FormatFLAC, true
+	}
+	return "", false
+}
+
+// Validate checks the options without running FFmpeg, so a combination
+// FFmpeg would reject is reported before the capture starts.
+func (o Options) Validate() error {
+	format := o.format()
+	switch format {
+	case FormatMP3, FormatAAC:
+		if o.BitDepth != 0 {
+			return fmt.Errorf("bit depth only applies to %s and %s audio", FormatWAV, FormatFLAC)
+		}
+		if o.Bitrate != "" && !isBitrate(o.Bitrate) {
+			return fmt.Errorf("invalid bitrate %q: use bits per second with an optional k or M suffix, e.g. 128k", o.Bitrate)
+		}
+	case FormatWAV, FormatFLAC:
+		if o.BitDepth != 0 && o.BitDepth != 16 && o.BitDepth != 24 {
+			return fmt.Errorf("unsupported bit depth %d: use 16 or 24", o.BitDepth)
+		}
+		if o.Bitrate != "" {
+			return fmt.Errorf("bitrate only applies to %s and %s audio; %s is lossless", FormatMP3, FormatAAC, format)
+		}
+	default:
+		return fmt.Errorf("unsupported audio format %q: use %s, %s, %s or %s", o.Format, FormatMP3, FormatAAC, FormatWAV, FormatFLAC)
+	}
+
+	if codecs := formatCodecs[format]; o.Codec != "" && !slices.Contains(codecs, o.Codec) {
+		return fmt.Errorf("audio codec %q can't be written as %s: use %s", o.Codec, format, strings.Join(codecs, ", "))
+	}
+	if format == FormatWAV && o.Codec != "" && o.BitDepth != 0 {
+		return fmt.Errorf("a %s codec sets the bit depth itself; give either a codec or a bit depth", FormatWAV)
+	}
+
+	switch {
+	case o.SampleRate < 0:
+		return fmt.Errorf("invalid sample rate %d", o.SampleRate)
+	case o.SampleRate > 0 && o.KeepSampleRate:
+		return fmt.Errorf("a sample rate can't be combined with keeping the source sample rate")
+	case o.SampleRate > 0 && format == FormatMP3 && !slices.Contains(mp3SampleRates, o.SampleRate):
+		return fmt.Errorf("unsupported %s sample rate %d: use one of %s", FormatMP3, o.SampleRate, joinInts(mp3SampleRates))
+	case o.SampleRate > 0 && (o.SampleRate < 8000 || o.SampleRate > 192000):
+		return fmt.Errorf("unsupported sample rate %d: use 8000 to 192000 Hz", o.SampleRate)
+	}
+
+	if o.Normalize {
+		switch {
+		case o.Loudness != 0 && (o.Loudness < -70 || o.Loudness > -5):
+			return fmt.Errorf("unsupported loudness target %g LUFS: use -70 to -5", o.Loudness)
+		case o.KeepSampleRate:
+			// loudnorm upsamples to 192 kHz, losing the source rate
+			return fmt.Errorf("loudness normalization resamples the audio and can't keep the source sample rate; give a sample rate instead")
+		}
+	} else if o.Loudness != 0 {
+		return fmt.Errorf("a loudness target requires loudness normalization")
+	}
+
+	switch {
+	case o.Channels < 0 || o.Channels > 8:
+		return fmt.Errorf("unsupported channel count %d: use 1 to 8", o.Channels)
+	case o.Channels > 2 && format == FormatMP3:
+		return fmt.Errorf("%s audio has at most 2 channels", FormatMP3)
+	}
+	return nil
+}
+
+// Ext returns the file extension of the format, including the dot.
+func (o Options) Ext() string {
+	if o.format() == FormatAAC {
+		return ".m4a"
+	}
+	return "." + o.format()
+}
+
+// format returns Format, defaulting to FormatMP3.
+func (o Options) format() string {
+	if o.Format == "" {
+		return FormatMP3
+	}
+	return o.Format
+}
+
+// codecArgs returns the FFmpeg output options encoding audio as o describes.
+func (o Options) codecArgs() []string {
+	var args []string
+	// -map 0:a:N: the Nth audio stream instead of FFmpeg's own choice
+	if o.SelectTrack {
+		args = append(args, "-map", fmt.Sprintf("0:a:%d", o.Track))
+	}
+	// -af loudnorm: single-pass EBU R128 normalization to the integrated
+	// loudness I, true peak TP and loudness range LRA
+	if o.Normalize {
+		loudness := o.Loudness
+		if loudness == 0 {
+			loudness = DefaultLoudness
+		}
+		args = append(args, "-af", fmt.Sprintf("loudnorm=I=%s:TP=%s:LRA=%s",
+			strconv.FormatFloat(loudness, 'f', -1, 64), loudnormTruePeak, loudnormRange))
+	}
+
+	switch o.format() {
+	case FormatWAV:
+		// -acodec pcm_s16le/pcm_s24le: uncompressed little-endian PCM
+		codec := o.Codec
+		if codec == "" {
+			codec = "pcm_s16le"
+			if o.BitDepth == 24 {
+				codec = "pcm_s24le"
+			}
+		}
+		args = append(args, "-acodec", codec)
+	case FormatFLAC:
+		args = append(args, "-acodec", "flac")
+		switch o.BitDepth {
+		case 16:
+			args = append(args, "-sample_fmt", "s16")
+		case 24:
+			// FLAC takes 24-bit samples in 32-bit containers, marked as
+			// holding 24 significant bits
+			args = append(args, "-sample_fmt", "s32", "-bits_per_raw_sample", "24")
+		}
+	default:
+		// -acodec libmp3lame -ab 192k: MP3 at 192kbps (or AAC with the
+		// format's encoder)
+		codec := o.Codec
+		if codec == "" {
+			codec = formatCodecs[o.format()][0]
+		}
+		bitrate := o.Bitrate
+		if bitrate == "" {
+			bitrate = defaultBitrate
+		}
+		args = append(args, "-acodec", codec, "-ab", bitrate)
+	}
+
+	// -ar: output sample rate
+	switch {
+	case o.SampleRate > 0:
+		args = append(args, "-ar", strconv.Itoa(o.SampleRate))
+	case !o.KeepSampleRate:
+		args = append(args, "-ar", defaultSampleRate)
+	}
+	// -ac: output channel count
+	if o.Channels > 0 {
+		args = append(args, "-ac", strconv.Itoa(o.Channels))
+	}
+	return args
+}
+
+// isBitrate reports whether s is a bitrate in FFmpeg notation: a positive
+// number with an optional k or M suffix.
+func isBitrate(s string) bool {
+	number := strings.TrimRight(s, "kKM")
+	if len(s)-len(number) > 1 {
+		return false
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	return err == nil && n > 0 && !math.IsInf(n, 1)
+}
+
+// joinInts formats values as a comma-separated list.
+func joinInts(values []int) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = strconv.Itoa(v)
+	}
+	return strings.Join(s, ", ")
+}
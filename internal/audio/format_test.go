@@ -0,0 +1,112 @@
+package audio
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr string
+	}{
+		{"defaults", Options{}, ""},
+		{"mp3 bitrate", Options{Format: FormatMP3, Bitrate: "128k"}, ""},
+		{"aac bitrate in bits", Options{Format: FormatAAC, Bitrate: "96000"}, ""},
+		{"aac megabit bitrate", Options{Format: FormatAAC, Bitrate: "1.5M"}, ""},
+		{"aac fdk codec", Options{Format: FormatAAC, Codec: "libfdk_aac"}, ""},
+		{"wav 24-bit", Options{Format: FormatWAV, BitDepth: 24}, ""},
+		{"wav float codec", Options{Format: FormatWAV, Codec: "pcm_f32le"}, ""},
+		{"flac 16-bit", Options{Format: FormatFLAC, BitDepth: 16}, ""},
+		{"mp3 48 kHz stereo", Options{SampleRate: 48000, Channels: 2}, ""},
+		{"aac 5.1", Options{Format: FormatAAC, Channels: 6}, ""},
+		{"keep sample rate", Options{KeepSampleRate: true}, ""},
+		{"normalize", Options{Normalize: true, Loudness: -16}, ""},
+
+		{"unknown format", Options{Format: "ogg"}, "unsupported audio format"},
+		{"bitrate without unit digits", Options{Bitrate: "k"}, "invalid bitrate"},
+		{"bitrate with two suffixes", Options{Bitrate: "128kk"}, "invalid bitrate"},
+		{"zero bitrate", Options{Bitrate: "0k"}, "invalid bitrate"},
+		{"negative bitrate", Options{Bitrate: "-128k"}, "invalid bitrate"},
+		{"infinite bitrate", Options{Bitrate: "Inf"}, "invalid bitrate"},
+		{"bitrate of lossless audio", Options{Format: FormatFLAC, Bitrate: "128k"}, "lossless"},
+		{"bit depth of lossy audio", Options{Format: FormatMP3, BitDepth: 16}, "bit depth only applies"},
+		{"unsupported bit depth", Options{Format: FormatWAV, BitDepth: 32}, "unsupported bit depth"},
+		{"codec of another format", Options{Format: FormatMP3, Codec: "aac"}, "can't be written as mp3"},
+		{"wav codec and bit depth", Options{Format: FormatWAV, Codec: "pcm_s24le", BitDepth: 24}, "either a codec or a bit depth"},
+		{"negative sample rate", Options{SampleRate: -1}, "invalid sample rate"},
+		{"sample rate and keep", Options{SampleRate: 48000, KeepSampleRate: true}, "can't be combined"},
+		{"mp3 sample rate", Options{SampleRate: 96000}, "unsupported mp3 sample rate"},
+		{"sample rate too low", Options{Format: FormatWAV, SampleRate: 4000}, "use 8000 to 192000"},
+		{"loudness out of range", Options{Normalize: true, Loudness: -80}, "unsupported loudness target"},
+		{"normalize keeping the rate", Options{Normalize: true, KeepSampleRate: true}, "can't keep the source sample rate"},
+		{"loudness without normalize", Options{Loudness: -16}, "requires loudness normalization"},
+		{"too many channels", Options{Format: FormatAAC, Channels: 9}, "use 1 to 8"},
+		{"mp3 surround", Options{Channels: 6}, "at most 2 channels"},
+	}
+	for _, tt := range tests {
+		err := tt.opts.Validate()
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: Validate returned error: %v", tt.name, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: Validate = %v, want an error containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCodecArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		want []string
+	}{
+		{"mp3 defaults", Options{}, []string{"-acodec", "libmp3lame", "-ab", "192k", "-ar", "44100"}},
+		{"mp3 bitrate", Options{Format: FormatMP3, Bitrate: "320k"}, []string{"-acodec", "libmp3lame", "-ab", "320k", "-ar", "44100"}},
+		{"aac defaults", Options{Format: FormatAAC}, []string{"-acodec", "aac", "-ab", "192k", "-ar", "44100"}},
+		{"aac fdk bitrate", Options{Format: FormatAAC, Codec: "libfdk_aac", Bitrate: "96k"}, []string{"-acodec", "libfdk_aac", "-ab", "96k", "-ar", "44100"}},
+		{"wav defaults", Options{Format: FormatWAV}, []string{"-acodec", "pcm_s16le", "-ar", "44100"}},
+		{"wav 24-bit", Options{Format: FormatWAV, BitDepth: 24}, []string{"-acodec", "pcm_s24le", "-ar", "44100"}},
+		{"wav codec", Options{Format: FormatWAV, Codec: "pcm_f32le"}, []string{"-acodec", "pcm_f32le", "-ar", "44100"}},
+		{"flac defaults", Options{Format: FormatFLAC}, []string{"-acodec", "flac", "-ar", "44100"}},
+		{"flac 16-bit", Options{Format: FormatFLAC, BitDepth: 16}, []string{"-acodec", "flac", "-sample_fmt", "s16", "-ar", "44100"}},
+		{"flac 24-bit", Options{Format: FormatFLAC, BitDepth: 24}, []string{"-acodec", "flac", "-sample_fmt", "s32", "-bits_per_raw_sample", "24", "-ar", "44100"}},
+		{"sample rate and channels", Options{SampleRate: 48000, Channels: 1}, []string{"-acodec", "libmp3lame", "-ab", "192k", "-ar", "48000", "-ac", "1"}},
+		{"keep sample rate", Options{Format: FormatFLAC, KeepSampleRate: true}, []string{"-acodec", "flac"}},
+		{"track", Options{Track: 2, SelectTrack: true}, []string{"-map", "0:a:2", "-acodec", "libmp3lame", "-ab", "192k", "-ar", "44100"}},
+		// Track 0 is only mapped when selected explicitly
+		{"unselected track", Options{Track: 2}, []string{"-acodec", "libmp3lame", "-ab", "192k", "-ar", "44100"}},
+		{"normalize", Options{Normalize: true}, []string{"-af", "loudnorm=I=-23:TP=-1.5:LRA=11", "-acodec", "libmp3lame", "-ab", "192k", "-ar", "44100"}},
+		{"normalize to a target", Options{Format: FormatAAC, Normalize: true, Loudness: -16.5, Bitrate: "128k"}, []string{"-af", "loudnorm=I=-16.5:TP=-1.5:LRA=11", "-acodec", "aac", "-ab", "128k", "-ar", "44100"}},
+	}
+	for _, tt := range tests {
+		if got := tt.opts.codecArgs(); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: codecArgs() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFormatForExt(t *testing.T) {
+	tests := []struct {
+		ext    string
+		format string
+		ok     bool
+	}{
+		{".mp3", FormatMP3, true},
+		{".M4A", FormatAAC, true},
+		{".aac", FormatAAC, true},
+		{".wav", FormatWAV, true},
+		{".flac", FormatFLAC, true},
+		{".ogg", "", false},
+	}
+	for _, tt := range tests {
+		if format, ok := FormatForExt(tt.ext); format != tt.format || ok != tt.ok {
+			t.Errorf("FormatForExt(%q) = %q, %v; want %q, %v", tt.ext, format, ok, tt.format, tt.ok)
+		}
+	}
+	if ext := (Options{Format: FormatAAC}).Ext(); ext != ".m4a" {
+		t.Errorf("Ext of AAC = %q, want .m4a", ext)
+	}
+}
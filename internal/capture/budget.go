@@ -0,0 +1,32 @@
+package capture
+
+import "fmt"
+
+// errorBudget aborts a capture once too many fetches have failed, either
+// back-to-back or in total. Zero limits are unlimited.
+type errorBudget struct {
+	maxConsecutive int
+	maxTotal       int
+
+	consecutive int
+	total       int
+}
+
+// fail records a failed fetch and returns an error once a limit is reached.
+func (b *errorBudget) fail(err error) error {
+	b.consecutive++
+	b.total++
+
+	if b.maxConsecutive > 0 && b.consecutive >= b.maxConsecutive {
+		return fmt.Errorf("giving up after %d consecutive fetch errors, last: %w", b.consecutive, err)
+	}
+	if b.maxTotal > 0 && b.total >= b.maxTotal {
+		return fmt.Errorf("giving up after %d fetch errors in total, last: %w", b.total, err)
+	}
+	return nil
+}
+
+// succeed records a successful fetch, resetting the consecutive count.
+func (b *errorBudget) succeed() {
+	b.consecutive = 0
+}
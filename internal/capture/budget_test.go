@@ -0,0 +1,65 @@
+package capture
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+var errFetch = errors.New("connection reset")
+
+func TestErrorBudgetConsecutive(t *testing.T) {
+	b := &errorBudget{maxConsecutive: 3}
+	for i := 1; i < 3; i++ {
+		if err := b.fail(errFetch); err != nil {
+			t.Fatalf("fail %d returned %v, want nil below the limit", i, err)
+		}
+	}
+	err := b.fail(errFetch)
+	if err == nil {
+		t.Fatal("third consecutive failure didn't abort")
+	}
+	if !errors.Is(err, errFetch) || !strings.Contains(err.Error(), "3 consecutive") {
+		t.Errorf("error = %v, want 3 consecutive errors wrapping the last one", err)
+	}
+}
+
+func TestErrorBudgetResetsAfterSuccess(t *testing.T) {
+	b := &errorBudget{maxConsecutive: 3}
+	for range 5 {
+		if err := b.fail(errFetch); err != nil {
+			t.Fatalf("fail returned %v", err)
+		}
+		if err := b.fail(errFetch); err != nil {
+			t.Fatalf("fail returned %v", err)
+		}
+		b.succeed()
+	}
+	if b.consecutive != 0 || b.total != 10 {
+		t.Errorf("consecutive = %d, total = %d; want 0 and 10", b.consecutive, b.total)
+	}
+}
+
+func TestErrorBudgetTotal(t *testing.T) {
+	b := &errorBudget{maxConsecutive: 3, maxTotal: 4}
+	for i := 1; i < 4; i++ {
+		if err := b.fail(errFetch); err != nil {
+			t.Fatalf("fail %d returned %v", i, err)
+		}
+		b.succeed()
+	}
+	// The success doesn't reset the total
+	err := b.fail(errFetch)
+	if err == nil || !strings.Contains(err.Error(), "4 fetch errors in total") {
+		t.Errorf("error = %v, want the total limit reached", err)
+	}
+}
+
+func TestErrorBudgetUnlimited(t *testing.T) {
+	var b errorBudget
+	for range 1000 {
+		if err := b.fail(errFetch); err != nil {
+			t.Fatalf("fail returned %v with no limits", err)
+		}
+	}
+}
@@ -0,0 +1,1753 @@
+package capture
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bariiss/stream-capture/internal/audio"
+	"github.com/bariiss/stream-capture/internal/downloader"
+	"github.com/bariiss/stream-capture/internal/ffmpeg"
+	"github.com/bariiss/stream-capture/internal/frame"
+	"github.com/bariiss/stream-capture/internal/hls"
+	"github.com/bariiss/stream-capture/internal/mux"
+	"github.com/bariiss/stream-capture/internal/retry"
+	"github.com/bariiss/stream-capture/internal/transcode"
+	"github.com/bariiss/stream-capture/internal/upload"
+	"github.com/bariiss/stream-capture/internal/verify"
+)
+
+// resumeRetryPolicy resumes segment downloads whose transfer broke off, from
+// their partial file. Failed requests are retried by the Fetcher itself.
+// A segment is skipped once its attempts are used up.
+var resumeRetryPolicy = retry.Policy{
+	BaseDelay:  500 * time.Millisecond,
+	MaxDelay:   5 * time.Second,
+	Multiplier: 2,
+	Jitter:     0.2,
+	Retryable: func(err error) bool {
+		return errors.Is(err, hls.ErrTransferInterrupted)
+	},
+}
+
+// maxURLRefreshes is how many times in a row --refresh-url-command is run
+// before an expired-credentials error is considered final.
+const maxURLRefreshes = 3
+
+// Download orders of Options.Priority.
+const (
+	PriorityOldest = "oldest"
+	PriorityNewest = "newest"
+)
+
+// Handling of EXT-X-DISCONTINUITY selected by Options.OnDiscontinuity.
+const (
+	DiscontinuityIgnore = "ignore"
+	DiscontinuitySplit  = "split"
+	DiscontinuityRemux  = "remux"
+)
+
+// Starting points in the live window of Options.From.
+const (
+	FromLatest = "latest"
+	FromStart  = "start"
+)
+
+// Ends of an ended playlist that Options.VODCount counts segments from.
+const (
+	VODFirst = "first"
+	VODLast  = "last"
+)
+
+// StdoutOutput as Options.Output writes the merged stream to stdout, e.g.
+// to pipe it into a player. The status messages then go to stderr.
+const StdoutOutput = "-"
+
+// DefaultPollInterval is the playlist polling interval of Options with no
+// PollInterval, for playlists without EXT-X-TARGETDURATION.
+const DefaultPollInterval = 2 * time.Second
+
+// Defaults of Options.Preview: a few already published segments and a short
+// clip.
+const (
+	PreviewSegmentCount  = 3
+	previewAudioDuration = 30 * time.Second
+)
+
+// Options holds the settings of a single capture run. They mirror the
+// flags of the stream-capture command.
+type Options struct {
+	// URL is the media or master playlist to capture.
+	URL          string
+	SegmentCount int
+	// Duration, when positive, replaces SegmentCount: segments are captured
+	// until their EXTINF durations add up to it.
+	Duration time.Duration
+	// VODCount selects where SegmentCount or Duration is taken from in a
+	// playlist with EXT-X-ENDLIST: VODFirst (the default) or VODLast.
+	// VODAll captures every segment of such a playlist instead.
+	VODCount string
+	VODAll   bool
+	// Output is the merged video file, or StdoutOutput. In AudioOnly mode
+	// it is a temporary file removed after the audio is extracted.
+	Output string
+	// ExtraOutputs receive a copy of the merged stream in the same pass.
+	ExtraOutputs []string
+	// PollInterval is the wait between playlist polls. When zero, it is
+	// half the playlist's target duration.
+	PollInterval time.Duration
+	// AdaptivePolling varies the polling interval with the rate new
+	// segments appear, within [MinPollInterval, MaxPollInterval].
+	AdaptivePolling bool
+	MinPollInterval time.Duration
+	MaxPollInterval time.Duration
+	ExtractAudio    bool
+	AudioOnly       bool
+	AudioOutput     string
+	// AudioOptions selects the extracted audio's format, bit depth and
+	// sample rate handling.
+	AudioOptions     audio.Options
+	ExtractSubtitle  bool
+	SubtitleOutput   string
+	SubtitleLanguage string
+	SubtitleModel    string
+	// SubtitleFormats are the Whisper output formats; the first one names
+	// the default subtitle path.
+	SubtitleFormats []string
+	// SubtitleTranslate makes Whisper produce English subtitles whatever
+	// the spoken language.
+	SubtitleTranslate bool
+	// SubtitleWords makes Whisper time each word, recorded in the
+	// json subtitle format.
+	SubtitleWords bool
+	// Variant chooses among the regular variants of a master playlist:
+	// "max" (default) or "min" bandwidth, or a target resolution such as
+	// "720p".
+	Variant string
+	// VariantCodec narrows the variants of a master playlist, regular or
+	// I-frame, to those whose CODECS contain this substring.
+	VariantCodec string
+	// IFrameVariant selects an I-frame-only variant of a master playlist:
+	// "max" or "min" bandwidth, or a zero-based index. Empty disables it.
+	IFrameVariant string
+	// AudioTrack picks among the alternative audio renditions of the
+	// selected variant by index, language or name; empty means the
+	// group's default. A rendition with its own playlist is captured
+	// instead of the variant in AudioOnly mode, one muxed into the variant
+	// is extracted with AudioOptions.Track.
+	AudioTrack string
+	// SubtitleTrack captures a WebVTT subtitle rendition of the selected
+	// variant, picked by index, language or name, alongside the video.
+	// Its cues are rebased to start with the merged output and written to
+	// SubtitleOutput, or next to the output with a .vtt extension.
+	SubtitleTrack string
+	// SequenceStrategy selects where segment sequence numbers come from;
+	// empty means hls.SequenceMediaSequence.
+	SequenceStrategy hls.SequenceStrategy
+	// AudioSplitOn splits audio extraction into numbered files at
+	// discontinuities ("discontinuity") or date jumps ("chapter").
+	AudioSplitOn string
+	// TrimStart and TrimEnd cut that much from the start and end of the
+	// extracted audio, measured on the merged file so fractional trims
+	// are exact; TrimVideo cuts the primary video output as well.
+	TrimStart time.Duration
+	TrimEnd   time.Duration
+	TrimVideo bool
+	// Verify decodes the merged output with FFmpeg and fails the run when
+	// more than VerifyMaxErrors decode errors are reported.
+	Verify          bool
+	VerifyMaxErrors int
+	// Thumbnail is the path of a JPEG or PNG image of the frame at the
+	// middle of the capture. Empty disables it.
+	Thumbnail string
+	// HashManifest is the path of a JSON sidecar listing each segment's
+	// SHA-256, size and source URL. Empty disables it.
+	HashManifest string
+	// ChecksumFile writes the merged output's SHA-256 to a "<output>.sha256"
+	// sidecar in sha256sum format; split parts each get their own.
+	ChecksumFile bool
+	// Transcode re-encodes the output with TranscodeOptions after merging,
+	// instead of keeping the original streams. It takes a single output.
+	Transcode        bool
+	TranscodeOptions transcode.Options
+	// RawConcat writes the segments verbatim, one after another, and never
+	// runs FFmpeg on the result.
+	RawConcat bool
+	// QuietProgress throttles the per-segment progress lines to one per
+	// ProgressInterval, updated in place on a terminal.
+	QuietProgress    bool
+	ProgressInterval time.Duration
+	// MaxConsecutiveErrors and MaxTotalErrors abort the capture after that
+	// many failed playlist or segment fetches (0 = unlimited).
+	MaxConsecutiveErrors int
+	MaxTotalErrors       int
+	// ShutdownGrace is how long an interrupted capture waits for in-flight
+	// segment downloads before merging what completed.
+	ShutdownGrace time.Duration
+	// StaleTimeout treats a live stream as ended once its last segment
+	// hasn't changed for that long, e.g. because the encoder stopped
+	// without EXT-X-ENDLIST (0 = wait indefinitely).
+	StaleTimeout time.Duration
+	// MaxDiskBytes, when positive, keeps a rolling buffer: once the stored
+	// segments exceed it, the oldest are discarded, and only the retained
+	// window is merged.
+	MaxDiskBytes int64
+	// MaxRetries is how often a playlist or segment request failing with a
+	// transient error is retried.
+	MaxRetries int
+	// VerifySegments re-downloads segments arriving shorter than their
+	// Content-Length up to MaxRetries times and checks every segment
+	// against its download hash when merging.
+	VerifySegments bool
+	// OnDiscontinuity is how the merge treats discontinuities:
+	// DiscontinuityIgnore (default) concatenates across them,
+	// DiscontinuitySplit writes one numbered file per part and
+	// DiscontinuityRemux re-timestamps the parts into a single output.
+	OnDiscontinuity string
+	// SplitTracks writes the video, audio and subtitles as separate files
+	// sharing the output's base name. Audio and subtitle renditions with
+	// their own playlists are captured alongside the video and aligned
+	// with its start; otherwise the audio is extracted from the video and
+	// the subtitles are transcribed by Whisper. Requires ExtractAudio.
+	SplitTracks bool
+	// KeyOverride decrypts AES-128 segments with a key supplied out of band.
+	KeyOverride *downloader.KeyOverride
+	// Pipeline extracts audio while segments are still downloading instead
+	// of after the merge.
+	Pipeline bool
+	// RefreshCommand prints a fresh playlist URL when signed URLs expire.
+	RefreshCommand string
+	// ResumeMode is what a capture does when resumed after a pause:
+	// ResumeCatchUp downloads the held segments, ResumeLive jumps to the
+	// live edge.
+	ResumeMode string
+	// Priority is the download order: PriorityOldest (default) or
+	// PriorityNewest, which covers the live edge before backfilling.
+	Priority string
+	// SkipMissingTools turns a missing ffmpeg or whisper into a warning,
+	// keeping what was produced so far instead of failing the run.
+	SkipMissingTools bool
+	// Preview captures a few already published segments without waiting
+	// and limits extracted audio to a short clip.
+	Preview bool
+	// DryRun lists the playlist's segments and whether it is live, then
+	// returns without downloading or writing anything.
+	DryRun bool
+	// StartSequence, when set, is the first media sequence to capture
+	// instead of one derived from the live edge; SegmentCount then covers
+	// the range up to the requested end sequence.
+	StartSequence *int
+	// StartInWindow makes a StartSequence that has already aged out of the
+	// live window an error instead of a gap.
+	StartInWindow bool
+	// StartTime and EndTime, when set, bound the capture to a wall-clock
+	// window using the segments' EXT-X-PROGRAM-DATE-TIME: it starts with
+	// the segment playing at StartTime, waiting for the stream to reach it,
+	// and ends with the one playing just before EndTime. Playlists without
+	// program date-times fall back to the sequence-based selection.
+	StartTime time.Time
+	EndTime   time.Time
+	// From is where a capture without StartSequence starts: FromLatest
+	// (the live edge, the default) or FromStart (the oldest segment in the
+	// window, so the backlog is downloaded before new segments).
+	From string
+	// LiveDelay keeps the capture this many segments behind the live edge.
+	LiveDelay int
+	// ProbeSegments waits for a segment the playlist doesn't list yet by
+	// sending HEAD requests to its URL predicted by hls.PredictSegment,
+	// taking it as soon as it is published and polling the playlist only
+	// every few probes. Servers rejecting HEAD fall back to polling. It is
+	// ignored with a LiveDelay.
+	ProbeSegments bool
+	// KeepUp makes a capture that fell behind the live window jump to the
+	// live edge, keeping the number of segments still to download, instead
+	// of skipping the evicted segments one at a time.
+	KeepUp bool
+	// HostLimiter bounds simultaneous requests per host across every
+	// capture sharing it. Nil means unlimited.
+	HostLimiter *hls.HostLimiter
+	// RateLimiter bounds the aggregate segment download speed across every
+	// capture sharing it. Nil means unlimited.
+	RateLimiter *hls.RateLimiter
+	// SegmentContentTypes overrides the media types accepted for segment
+	// responses; nil keeps hls.DefaultSegmentContentTypes.
+	SegmentContentTypes []string
+	// MaxPlaylistSize caps playlist bodies; 0 keeps
+	// hls.DefaultMaxPlaylistSize and a negative value disables the cap.
+	MaxPlaylistSize int64
+	// MaxSegmentSize caps each segment response; 0 means unlimited.
+	MaxSegmentSize int64
+	// ConnectTimeout bounds connecting, the TLS handshake and the wait for
+	// the response headers of every request; SegmentTimeout bounds the time
+	// a segment body may go without data. 0 keeps hls.DefaultConnectTimeout
+	// and hls.DefaultSegmentTimeout, a negative value removes the bound.
+	ConnectTimeout time.Duration
+	SegmentTimeout time.Duration
+	// Headers are sent with every playlist, segment and key request.
+	Headers http.Header
+	// CookieFile, if set, is a cookies.txt file pre-loaded into the
+	// fetcher's cookie jar.
+	CookieFile string
+	// StateFile, if set, persists the capture's progress so an interrupted
+	// run can be resumed; an existing file is resumed from.
+	StateFile string
+	// ProxyURL, if set, routes all requests through the proxy instead of
+	// the one from HTTP_PROXY/HTTPS_PROXY.
+	ProxyURL string
+	// TLSConfig, if set, is used for HTTPS connections instead of the
+	// default verification against the system roots.
+	TLSConfig *tls.Config
+	// StreamID identifies the capture in its log lines, which are
+	// prefixed with "[StreamID] " when it is set.
+	StreamID string
+	// LogDir, when set, receives a copy of the capture's log in
+	// <stream>.log, named after StreamID or the playlist URL.
+	LogDir string
+	// ExecCommand is run after a successful capture; see runHook.
+	ExecCommand      string
+	ExecIgnoreErrors bool
+	// Upload, when set, uploads the produced files to an S3-compatible
+	// bucket after the ExecCommand ran. UploadDeleteLocal then removes
+	// each file once it was uploaded.
+	Upload            *upload.S3Options
+	UploadDeleteLocal bool
+	// WebhookURL, when set, receives a JSON POST describing the outcome
+	// once the capture completed or failed, signed with WebhookSecret in
+	// the WebhookSignatureHeader when that is set.
+	WebhookURL    string
+	WebhookSecret string
+	// PauseSignals lets SIGUSR1 pause downloads and SIGUSR2 resume them
+	// while the capture runs. The signals are process-wide: every running
+	// capture enabling it pauses and resumes with them.
+	PauseSignals bool
+	// Logger receives the capture's status messages; nil prints them
+	// uncolored to stdout and stderr, unless Slog is set.
+	Logger *Logger
+	// Slog, used when Logger is nil, receives the status messages as
+	// records through NewSlogLogger, so embedders can supply their own
+	// handler.
+	Slog *slog.Logger
+}
+
+// Result describes the files a capture produced.
+type Result struct {
+	// Output is the merged video file, empty in audio-only mode. It is
+	// also empty when the merge was split into Parts.
+	Output string
+	// ExtraOutputs received a copy of Output.
+	ExtraOutputs []string
+	// Parts are the files written instead of Output when the merge was
+	// split at discontinuities.
+	Parts []string
+	// Audio is the extracted audio file, or the index of split audio.
+	Audio string
+	// Subtitles are the extracted subtitle files, one per format.
+	Subtitles []string
+	// Thumbnail is the image extracted from the middle of the capture.
+	Thumbnail string
+	// Stats summarizes the transfers, also after a failed capture.
+	Stats Stats
+	// Expired are the sequences skipped after leaving the live window.
+	Expired []int
+	// Interrupted is set when ctx was canceled before the capture
+	// completed; the segments downloaded until then were still merged.
+	Interrupted bool
+	// Uploaded are the URLs of the objects written by Options.Upload.
+	Uploaded []string
+}
+
+// Run captures the stream described by opts until the requested segments
+// or stream time are downloaded, then merges and post-processes them.
+// Canceling ctx stops the capture gracefully: in-flight downloads get
+// Options.ShutdownGrace to finish and the complete segments are merged.
+// On error the Result describes what was produced before it.
+func Run(ctx context.Context, opts Options) (*Result, error) {
+	start := time.Now()
+	result := &Result{}
+	err := run(ctx, opts, result)
+	result.Stats.Elapsed = time.Since(start)
+	if opts.WebhookURL != "" {
+		notifyWebhook(ctx, opts, result, err)
+	}
+	return result, err
+}
+
+// run performs the capture of Run, recording its outcome in result.
+func run(ctx context.Context, opts Options, result *Result) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	// Each capture logs through its own logger so that concurrent captures
+	// can be told apart
+	logger, closeLog, err := captureLogger(opts)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	c, err := newCapturer(ctx, opts, result, logger)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	c.logStart()
+	initial, err := c.resolvePlaylist(ctx)
+	if err != nil {
+		return err
+	}
+	if opts.DryRun {
+		printSegments(logger, initial)
+		return nil
+	}
+	if err := c.plan(ctx, initial); err != nil {
+		return err
+	}
+	if err := c.prepareOutput(); err != nil {
+		return err
+	}
+
+	downloaded, err := c.download(ctx)
+	if err != nil || !downloaded {
+		return err
+	}
+	merged, err := c.merge(ctx)
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		logger.Infof("Capture interrupted; skipping post-processing\n")
+		return nil
+	}
+	return c.postProcess(ctx, merged)
+}
+
+// capturer holds the state of a single capture, shared by the phases run
+// goes through: resolving the playlist, planning the segment range,
+// downloading, merging and post-processing.
+type capturer struct {
+	opts     Options
+	result   *Result
+	logger   *Logger
+	uploader *upload.S3Uploader
+	fetcher  *hls.Fetcher
+
+	// Fetches may retry from the background download, so the counters
+	// shared with it are atomic.
+	retries, received atomic.Int64
+	failedSegments    int
+
+	// Segment downloads outlive ctx by up to ShutdownGrace so an in-flight
+	// one can finish; abortDownloads then cancels its HTTP transfer.
+	downloadCtx    context.Context
+	abortDownloads context.CancelFunc
+	// inFlight tracks running segment downloads so shutdown can wait for
+	// them and only merge complete segments.
+	inFlight sync.WaitGroup
+
+	playlistURL  string
+	outputFile   string
+	outputPaths  []string
+	segmentCount int
+	// fragmented is set for fMP4 segments, which need their EXT-X-MAP
+	// initialization segment
+	fragmented bool
+
+	playlists *hls.PlaylistPoller
+	poller    *pollScheduler
+	// previous is the latest playlist and known the segments seen so far,
+	// keyed by sequence. Each poll only adds the playlist delta instead of
+	// rescanning the whole window.
+	previous *hls.Playlist
+	known    map[int]*hls.Segment
+	liveEdge int
+	// lastAdvance is when the live edge last moved. Only successful polls
+	// move it, so fetch failures are left to the error budget rather than
+	// taken for the end of the stream.
+	lastAdvance time.Time
+	refreshes   int
+	budget      *errorBudget
+	progress    *progressPrinter
+
+	startTime, endTime            time.Time
+	startSequence, targetSequence int
+	resumeState                   *downloader.State
+
+	// manager is nil for a single segment written straight to the output
+	manager *downloader.Manager
+	tempDir string
+	// completed is set once the state file is no longer needed
+	completed bool
+
+	// Sequences still to download, oldest first, and the one to add next
+	// when more are wanted
+	pending      []int
+	nextSequence int
+	// probing is cleared when the server turns out not to support HEAD
+	probing bool
+
+	downloadedSequences []int
+	downloadedSegments  []*hls.Segment
+	expiredSequences    []int
+	// captured is the stream time downloaded so far
+	captured time.Duration
+	// stale is set when the playlist stopped advancing
+	stale bool
+	// reachedEnd is set once a downloaded segment reaches the end time
+	reachedEnd bool
+
+	audioStream *audio.Stream
+	pipelined   bool
+
+	// subtitleRendition is the rendition chosen with SubtitleTrack,
+	// captured as the subtitles track
+	subtitleRendition *hls.Rendition
+	subtitles         *track
+	// audioRendition is the audio rendition captured alongside the video
+	// with SplitTracks
+	audioRendition *hls.Rendition
+	audio          *track
+	tracks         []*track
+	// audioSource is the merged audio track, extracted from audioOffset
+	// on for audioLength to line up with the video
+	audioSource string
+	audioOffset time.Duration
+	audioLength time.Duration
+}
+
+// newCapturer checks the upload configuration and sets up the fetcher
+// shared by every request of the capture.
+func newCapturer(ctx context.Context, opts Options, result *Result, logger *Logger) (*capturer, error) {
+	c := &capturer{
+		opts:         opts,
+		result:       result,
+		logger:       logger,
+		playlistURL:  opts.URL,
+		outputFile:   opts.Output,
+		segmentCount: opts.SegmentCount,
+	}
+
+	// The upload configuration is checked before anything is downloaded
+	if opts.Upload != nil {
+		uploader, err := upload.NewS3Uploader(ctx, *opts.Upload)
+		if err != nil {
+			return nil, err
+		}
+		c.uploader = uploader
+	}
+
+	// Create HLS fetcher, shared by the playlist poller and the download
+	// manager so both honour the same per-host limits
+	fetcher := hls.NewFetcher()
+	fetcher.HostLimiter = opts.HostLimiter
+	fetcher.RateLimiter = opts.RateLimiter
+	fetcher.SegmentContentTypes = opts.SegmentContentTypes
+	if opts.MaxPlaylistSize != 0 {
+		fetcher.MaxPlaylistSize = max(opts.MaxPlaylistSize, 0)
+	}
+	fetcher.MaxSegmentSize = opts.MaxSegmentSize
+	if opts.ConnectTimeout != 0 {
+		fetcher.SetConnectTimeout(max(opts.ConnectTimeout, 0))
+	}
+	if opts.SegmentTimeout != 0 {
+		fetcher.SegmentTimeout = max(opts.SegmentTimeout, 0)
+	}
+	fetcher.Headers = opts.Headers
+	if opts.ProxyURL != "" {
+		if err := fetcher.SetProxy(opts.ProxyURL); err != nil {
+			return nil, err
+		}
+	}
+	if opts.TLSConfig != nil {
+		fetcher.SetTLSConfig(opts.TLSConfig)
+	}
+	if opts.CookieFile != "" {
+		if err := fetcher.LoadCookieFile(opts.CookieFile); err != nil {
+			return nil, fmt.Errorf("error loading cookies: %w", err)
+		}
+	}
+	fetcher.Retry.MaxRetries = opts.MaxRetries
+	fetcher.OnRetry = func(url string, attempt int, err error, delay time.Duration) {
+		c.retries.Add(1)
+		logger.Warnf("Error fetching %s: %v (retrying in %v, attempt %d/%d)\n", path.Base(url), err, delay.Round(time.Millisecond), attempt+1, opts.MaxRetries+1)
+	}
+	c.fetcher = fetcher
+
+	c.downloadCtx, c.abortDownloads = context.WithCancel(context.WithoutCancel(ctx))
+	return c, nil
+}
+
+// close releases what the capture holds, however it ended, and records
+// the transfer stats in the result.
+func (c *capturer) close() {
+	if c.audioStream != nil {
+		c.audioStream.Close()
+	}
+	// No download outlives the capture to write into the temporary
+	// directory or the output as they are cleaned up.
+	c.abortDownloads()
+	c.inFlight.Wait()
+
+	// With a state file the temporary directory is kept until the capture
+	// completes, so it can be resumed after a crash or interruption.
+	if c.opts.StateFile == "" || c.completed {
+		if c.manager != nil {
+			c.manager.Cleanup()
+		}
+		if c.tempDir != "" {
+			os.RemoveAll(c.tempDir)
+		}
+	}
+
+	c.result.Stats.Retries = int(c.retries.Load())
+	c.result.Stats.Bytes = c.received.Load()
+	c.result.Stats.FailedSegments = c.failedSegments
+}
+
+// logStart reports what the capture is about to do.
+func (c *capturer) logStart() {
+	opts := c.opts
+	if opts.DryRun {
+		c.logger.Infof("Dry run: listing segments without downloading\n")
+		c.logger.Infof("Playlist URL: %s\n\n", c.playlistURL)
+		return
+	}
+	c.logger.Infof("Live stream capture started\n")
+	c.logger.Infof("Playlist URL: %s\n", c.playlistURL)
+	if !opts.StartTime.IsZero() {
+		c.logger.Infof("Start time: %s\n", opts.StartTime.Format(time.RFC3339))
+	}
+	if !opts.EndTime.IsZero() {
+		c.logger.Infof("End time: %s\n", opts.EndTime.Format(time.RFC3339))
+	} else if opts.Duration > 0 {
+		c.logger.Infof("Target duration: %v\n", opts.Duration)
+	} else {
+		c.logger.Infof("Target segments: %d\n", c.segmentCount)
+	}
+}
+
+// resolvePlaylist fetches the playlist to capture, picking the variant and
+// audio rendition of a master playlist, and parses it.
+func (c *capturer) resolvePlaylist(ctx context.Context) (*hls.Playlist, error) {
+	opts := c.opts
+	logger := c.logger
+	content, err := c.fetcher.FetchPlaylistContext(ctx, c.playlistURL)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching playlist: %w", err)
+	}
+
+	// A master playlist lists variant streams instead of segments: pick
+	// one and capture its media playlist.
+	if hls.IsMasterPlaylist(content) {
+		variant, err := selectVariant(content, c.playlistURL, opts)
+		if err != nil {
+			return nil, err
+		}
+		kind := "variant"
+		if variant.IFrame {
+			kind = "I-frame variant"
+		}
+		details := fmt.Sprintf("bandwidth: %d", variant.Bandwidth)
+		if variant.Height > 0 {
+			details += fmt.Sprintf(", resolution: %dx%d", variant.Width, variant.Height)
+		}
+		if variant.Codecs != "" {
+			details += ", codecs: " + variant.Codecs
+		}
+		logger.Infof("Using %s: %s (%s)\n", kind, variant.URL, details)
+		rendition, group, err := selectAudioRendition(content, c.playlistURL, variant, opts.AudioTrack)
+		if err != nil {
+			return nil, err
+		}
+		subtitles, subtitleGroup, err := selectSubtitleRendition(content, c.playlistURL, variant, opts.SubtitleTrack)
+		if err != nil {
+			return nil, err
+		}
+		c.playlistURL = variant.URL
+		if opts.DryRun && len(group) > 0 {
+			printRenditions(logger, "Audio", "--audio-track", group, rendition)
+		}
+		if opts.SubtitleTrack == "" && (!opts.SplitTracks || opts.ExtractSubtitle) {
+			// Subtitles are only captured on request
+			subtitles = nil
+		}
+		if opts.DryRun && len(subtitleGroup) > 0 {
+			printRenditions(logger, "Subtitle", "--subtitle-track", subtitleGroup, subtitles)
+		}
+		if subtitles != nil {
+			if subtitles.URL == "" {
+				return nil, fmt.Errorf("subtitle rendition %s has no playlist of its own to capture", describeRendition(subtitles))
+			}
+			logger.Infof("Using subtitle rendition: %s (%s)\n", subtitles.URL, describeRendition(subtitles))
+			c.subtitleRendition = subtitles
+		}
+		switch {
+		case rendition == nil:
+		case rendition.URL == "":
+			// Muxed renditions are numbered in the variant's audio streams
+			// in playlist order; with only one FFmpeg finds it unaided.
+			if track, muxed := muxedTrack(group, rendition); muxed > 1 {
+				c.opts.AudioOptions.Track = track
+				c.opts.AudioOptions.SelectTrack = true
+				logger.Infof("Using audio track %d of the variant: %s\n", track, describeRendition(rendition))
+			}
+		case opts.AudioOnly:
+			logger.Infof("Using audio rendition: %s (%s)\n", rendition.URL, describeRendition(rendition))
+			c.playlistURL = rendition.URL
+		case opts.SplitTracks:
+			logger.Infof("Using audio rendition: %s (%s)\n", rendition.URL, describeRendition(rendition))
+			c.audioRendition = rendition
+		case opts.AudioTrack != "":
+			return nil, fmt.Errorf("audio rendition %s has its own playlist: capture it with --audio-only or --split-tracks", describeRendition(rendition))
+		}
+		logger.Infof("\n")
+
+		if content, err = c.fetcher.FetchPlaylistContext(ctx, c.playlistURL); err != nil {
+			return nil, fmt.Errorf("error fetching variant playlist: %w", err)
+		}
+	} else if opts.IFrameVariant != "" || opts.VariantCodec != "" || opts.Variant != "" || opts.AudioTrack != "" || opts.SubtitleTrack != "" {
+		return nil, fmt.Errorf("--variant, --variant-codec, --iframe-variant, --audio-track and --subtitle-track require a master playlist")
+	}
+	// Split tracks without a subtitle rendition transcribe the audio
+	if opts.SplitTracks && c.subtitleRendition == nil {
+		c.opts.ExtractSubtitle = true
+	}
+
+	if !hls.HasPlaylistHeader(content) {
+		logger.Warnf("Warning: playlist has no #EXTM3U header; parsing it as a legacy M3U file\n")
+	}
+	parseOpts := hls.ParseOptions{SequenceStrategy: opts.SequenceStrategy}
+	initial, err := hls.ParsePlaylistFullWithOptions(content, c.playlistURL, parseOpts)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing playlist: %w", err)
+	}
+	// Later polls are conditional requests, skipping unchanged playlists
+	c.playlists = hls.NewPlaylistPoller(c.fetcher, c.playlistURL, parseOpts)
+
+	if len(initial.Segments) == 0 {
+		return nil, fmt.Errorf("no segments found in playlist")
+	}
+	return initial, nil
+}
+
+// plan sets up playlist polling and works out the range of segments to
+// capture from the initial playlist, or from the state of a resumed
+// capture.
+func (c *capturer) plan(ctx context.Context, initial *hls.Playlist) error {
+	opts := c.opts
+	logger := c.logger
+	segments := initial.Segments
+
+	// Without an explicit interval, poll about twice per target duration
+	// so each new segment is picked up soon after it is published
+	targetDuration := initial.TargetDuration
+	pollInterval := opts.PollInterval
+	pollSource := ""
+	if pollInterval <= 0 {
+		pollInterval = DefaultPollInterval
+		if targetDuration > 0 {
+			pollInterval = targetDuration / 2
+			pollSource = fmt.Sprintf(" (half the %v target duration)", targetDuration)
+		}
+	}
+	c.poller = newPollScheduler(pollInterval, opts.AdaptivePolling, opts.MinPollInterval, opts.MaxPollInterval)
+	if opts.AdaptivePolling {
+		logger.Debugf("Polling interval: adaptive, starting at %v (%v-%v)\n", c.poller.Interval(), opts.MinPollInterval, opts.MaxPollInterval)
+	} else {
+		logger.Debugf("Polling interval: %v%s\n", pollInterval, pollSource)
+	}
+	if opts.LiveDelay > 0 {
+		logger.Infof("Live delay: %d segments\n", opts.LiveDelay)
+	}
+	logger.Infof("\n")
+
+	c.fragmented = segments[0].Map != ""
+	if opts.RawConcat {
+		logger.Warnf("Warning: --raw-concat writes segments byte-for-byte; the output is not remuxed and may not play in every player\n")
+		// fMP4 fragments play once their EXT-X-MAP initialization segment,
+		// which the merge prepends, precedes them
+		if ext := segments[0].Ext; !strings.EqualFold(ext, ".ts") && !c.fragmented {
+			logger.Warnf("Warning: segments are not MPEG-TS (%q); concatenated output is unlikely to play without further processing\n", ext)
+		}
+	}
+
+	// A wall-clock window maps to segments through their program
+	// date-times; without them the sequence-based selection applies
+	startTime, endTime := opts.StartTime, opts.EndTime
+	if (!startTime.IsZero() || !endTime.IsZero()) && !hls.HasProgramDateTime(segments) {
+		logger.Warnf("Warning: the playlist has no #EXT-X-PROGRAM-DATE-TIME, ignoring the start and end times and selecting segments by sequence\n")
+		startTime, endTime = time.Time{}, time.Time{}
+	}
+	if !startTime.IsZero() {
+		var err error
+		if initial, err = waitForProgramTime(ctx, logger, c.playlists, initial, startTime, c.poller.Interval()); err != nil {
+			return err
+		}
+		segments = initial.Segments
+	}
+	c.startTime, c.endTime = startTime, endTime
+
+	// Find last segment
+	lastSegment := hls.GetLastSegment(segments)
+	if lastSegment == nil {
+		return fmt.Errorf("could not determine last segment")
+	}
+
+	// Stay LiveDelay segments behind the live edge so we never grab a
+	// segment the encoder may still be finalizing.
+	liveEdge := lastSegment.Sequence
+	firstSequence := hls.GetFirstSegment(segments).Sequence
+	ended := initial.EndList
+	segmentCount := c.segmentCount
+	var startSequence int
+	if !startTime.IsZero() {
+		first := hls.SegmentAtTime(segments, startTime)
+		if first == nil {
+			return fmt.Errorf("the playlist ended at %s, before the start time %s",
+				lastSegment.EndDateTime().Format(time.RFC3339), startTime.Format(time.RFC3339))
+		}
+		if oldest := hls.GetFirstSegment(segments).ProgramDateTime; !oldest.IsZero() && startTime.Before(oldest) {
+			logger.Warnf("Warning: the start time %s has left the playlist, starting at its oldest segment (%s)\n",
+				startTime.Format(time.RFC3339), oldest.Format(time.RFC3339))
+		}
+		startSequence = first.Sequence
+		if ended && opts.VODAll {
+			segmentCount = liveEdge - startSequence + 1
+		} else if ended && opts.Duration <= 0 {
+			segmentCount = min(segmentCount, liveEdge-startSequence+1)
+		}
+		logger.Infof("Starting at segment %d (%s)\n", startSequence, first.ProgramDateTime.Format(time.RFC3339))
+	} else if opts.StartSequence != nil {
+		// An explicit range is taken as is: later segments are waited for,
+		// and those already gone from the window are skipped.
+		startSequence = *opts.StartSequence
+	} else if ended && !opts.Preview {
+		// A VOD playlist won't grow: the segments are taken from its start
+		// or its end, all of them unless a count or duration is given.
+		startSequence = vodStartSequence(segments, opts, segmentCount)
+		if opts.VODAll || opts.VODCount == VODLast {
+			segmentCount = liveEdge - startSequence + 1
+		} else if opts.Duration <= 0 {
+			segmentCount = min(segmentCount, liveEdge-startSequence+1)
+		}
+		logger.Infof("Playlist has ended (VOD): capturing from segment %d of %d-%d\n", startSequence, firstSequence, liveEdge)
+	} else if opts.From == FromStart {
+		startSequence = firstSequence
+		logger.Infof("Starting at the beginning of the live window: %d segments of backlog\n", liveEdge-firstSequence+1)
+	} else {
+		startSequence = liveEdge - opts.LiveDelay
+		if opts.Preview {
+			// Preview only takes segments that are already published
+			startSequence -= segmentCount - 1
+		}
+		startSequence = max(startSequence, firstSequence)
+	}
+	if !endTime.IsZero() {
+		if first := hls.FindSegmentBySequence(segments, startSequence); first != nil && !first.ProgramDateTime.IsZero() && !first.ProgramDateTime.Before(endTime) {
+			return fmt.Errorf("the end time %s is before the first segment to capture (%d, %s)",
+				endTime.Format(time.RFC3339), startSequence, first.ProgramDateTime.Format(time.RFC3339))
+		}
+		// Segments are added until one reaches the end time
+		segmentCount = 0
+	}
+	// A duration-based capture starts with one segment and adds the next
+	// until enough stream time is downloaded.
+	targetSequence := startSequence + max(segmentCount, 1) - 1
+
+	// A resumed capture continues the range it was started with
+	if opts.StateFile != "" {
+		if _, err := os.Stat(opts.StateFile); err == nil {
+			if c.resumeState, err = downloader.LoadState(opts.StateFile); err != nil {
+				return fmt.Errorf("error loading capture state: %w", err)
+			}
+			startSequence, targetSequence = c.resumeState.StartSequence, c.resumeState.TargetSequence
+			segmentCount = targetSequence - startSequence + 1
+		}
+	}
+
+	if opts.StartSequence != nil {
+		if err := checkSequenceRange(startSequence, targetSequence, firstSequence, liveEdge, ended, opts.StartInWindow); err != nil {
+			return err
+		}
+	}
+
+	c.startSequence, c.targetSequence = startSequence, targetSequence
+	c.segmentCount = segmentCount
+	c.liveEdge = liveEdge
+	c.previous = initial
+	c.known = make(map[int]*hls.Segment, len(segments))
+	for _, seg := range segments {
+		c.known[seg.Sequence] = seg
+	}
+	return nil
+}
+
+// prepareOutput creates the output directories and, unless the capture is
+// written straight to the output, the download manager and its temporary
+// directory.
+func (c *capturer) prepareOutput() error {
+	opts := c.opts
+	logger := c.logger
+
+	// Ensure output directories exist
+	c.outputPaths = append([]string{c.outputFile}, opts.ExtraOutputs...)
+	if c.resumeState != nil && !slices.Equal(c.resumeState.Outputs, c.outputPaths) {
+		return fmt.Errorf("capture state %s was saved for output %s, not %s",
+			opts.StateFile, strings.Join(c.resumeState.Outputs, ", "), strings.Join(c.outputPaths, ", "))
+	}
+	for _, path := range c.outputPaths {
+		if path == StdoutOutput {
+			continue
+		}
+		outputDir := filepath.Dir(path)
+		if outputDir != "" && outputDir != "." {
+			if err := os.MkdirAll(outputDir, 0755); err != nil {
+				return fmt.Errorf("error creating output directory: %w", err)
+			}
+		}
+	}
+
+	// A single segment without post-processing is streamed straight into
+	// the output; everything else is downloaded to a temporary directory
+	// and merged afterwards.
+	if isDirectCapture(opts, c.previous.Segments) {
+		logger.Infof("Single segment capture: writing directly to %s\n", c.outputFile)
+	} else {
+		var manager *downloader.Manager
+		var err error
+		if c.resumeState != nil {
+			c.tempDir = c.resumeState.TempDir
+			manager, err = downloader.ResumeManager(c.resumeState, c.fetcher)
+		} else {
+			if c.tempDir, err = os.MkdirTemp("", "stream-capture-*"); err != nil {
+				return fmt.Errorf("error creating temp directory: %w", err)
+			}
+			manager, err = downloader.NewManagerWithFetcher(c.tempDir, c.fetcher)
+		}
+		if err != nil {
+			return fmt.Errorf("error creating download manager: %w", err)
+		}
+		c.manager = manager
+		manager.KeyOverride = opts.KeyOverride
+		manager.MaxDiskBytes = opts.MaxDiskBytes
+		if opts.VerifySegments {
+			manager.IncompleteRetries = opts.MaxRetries
+			manager.VerifyChecksums = true
+		}
+		logger.Debugf("Temp directory: %s\n", c.tempDir)
+
+		if c.subtitleRendition != nil {
+			t, err := c.newTrack("subtitle", c.subtitleRendition)
+			if err != nil {
+				return err
+			}
+			c.subtitles = t
+			c.tracks = append(c.tracks, t)
+		}
+		if c.audioRendition != nil {
+			t, err := c.newTrack("audio", c.audioRendition)
+			if err != nil {
+				return err
+			}
+			c.audio = t
+			c.tracks = append(c.tracks, t)
+		}
+
+		if opts.StateFile != "" {
+			err := manager.SaveState(opts.StateFile, downloader.State{
+				PlaylistURL:    c.playlistURL,
+				Outputs:        c.outputPaths,
+				StartSequence:  c.startSequence,
+				TargetSequence: c.targetSequence,
+			})
+			if err != nil {
+				return fmt.Errorf("error saving capture state: %w", err)
+			}
+		}
+	}
+
+	if !c.endTime.IsZero() {
+		logger.Infof("Starting from segment %d, capturing until %s\n\n", c.startSequence, c.endTime.Format(time.RFC3339))
+	} else if opts.Duration > 0 {
+		logger.Infof("Starting from segment %d, capturing %v of stream time\n\n", c.startSequence, opts.Duration)
+	} else {
+		logger.Infof("Starting from segment %d, target: %d (need %d segments)\n\n", c.startSequence, c.targetSequence, c.segmentCount)
+	}
+	return nil
+}
+
+// uploadResult uploads the files of result, recording their URLs in
+// result.Uploaded, and removes each local copy when deleteLocal is set.
+func uploadResult(ctx context.Context, logger *Logger, uploader *upload.S3Uploader, deleteLocal bool, result *Result) error {
+	var files []string
+	if result.Output != "" {
+		files = append(files, result.Output)
+	}
+	files = append(files, result.Parts...)
+	if result.Audio != "" {
+		files = append(files, result.Audio)
+	}
+	files = append(files, result.Subtitles...)
+	if result.Thumbnail != "" {
+		files = append(files, result.Thumbnail)
+	}
+
+	for _, path := range files {
+		logger.Infof("Uploading %s\n", path)
+		url, err := uploader.Upload(ctx, path)
+		if err != nil {
+			return err
+		}
+		result.Uploaded = append(result.Uploaded, url)
+		logger.Successf("Uploaded to %s\n", url)
+		if deleteLocal {
+			if err := os.Remove(path); err != nil {
+				logger.Warnf("Warning: failed to delete %s after upload: %v\n", path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// selectVariant picks the variant of a master playlist to capture: the
+// I-frame variant chosen by --iframe-variant if given, otherwise the regular
+// variant matching --variant, narrowed to --variant-codec.
+func selectVariant(content, playlistURL string, opts Options) (*hls.Variant, error) {
+	if opts.IFrameVariant != "" {
+		return selectIFrameVariant(content, playlistURL, opts.IFrameVariant, opts.VariantCodec)
+	}
+
+	pref, err := ParseVariantPreference(opts.Variant)
+	if err != nil {
+		return nil, err
+	}
+
+	variants, err := hls.ParseMasterPlaylist(content, playlistURL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing master playlist: %w", err)
+	}
+	if len(variants) == 0 {
+		if iframes, _ := hls.ParseIFrameVariants(content, playlistURL); len(iframes) > 0 {
+			return nil, fmt.Errorf("master playlist has only I-frame variants: capture one with --iframe-variant")
+		}
+		return nil, fmt.Errorf("master playlist has no variants")
+	}
+	if opts.VariantCodec != "" {
+		if variants, err = filterVariantsByCodec(variants, opts.VariantCodec); err != nil {
+			return nil, err
+		}
+	}
+	return hls.SelectVariant(variants, pref), nil
+}
+
+// selectAudioRendition returns the audio rendition of variant's group
+// chosen by choice (see hls.SelectRendition) along with the group.
+// Both are nil when the variant has no alternative audio renditions.
+func selectAudioRendition(content, playlistURL string, variant *hls.Variant, choice string) (*hls.Rendition, []*hls.Rendition, error) {
+	renditions, err := hls.ParseAudioRenditions(content, playlistURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing master playlist: %w", err)
+	}
+	return selectRendition(renditions, variant.Audio, choice, "--audio-track", "alternative audio")
+}
+
+// selectSubtitleRendition is selectAudioRendition for the subtitle
+// renditions of variant's SUBTITLES group.
+func selectSubtitleRendition(content, playlistURL string, variant *hls.Variant, choice string) (*hls.Rendition, []*hls.Rendition, error) {
+	renditions, err := hls.ParseSubtitleRenditions(content, playlistURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing master playlist: %w", err)
+	}
+	return selectRendition(renditions, variant.Subtitles, choice, "--subtitle-track", "subtitle")
+}
+
+// selectRendition picks the rendition of groupID chosen by choice, given
+// with flag; kind describes the renditions in errors.
+func selectRendition(renditions []*hls.Rendition, groupID, choice, flag, kind string) (*hls.Rendition, []*hls.Rendition, error) {
+	var group []*hls.Rendition
+	if groupID != "" {
+		group = hls.RenditionGroup(renditions, groupID)
+	}
+	if len(group) == 0 {
+		if choice != "" {
+			return nil, nil, fmt.Errorf("invalid %s %q: the selected variant has no %s renditions", flag, choice, kind)
+		}
+		return nil, nil, nil
+	}
+
+	rendition := hls.SelectRendition(group, choice)
+	if rendition == nil {
+		available := make([]string, len(group))
+		for i, r := range group {
+			available[i] = fmt.Sprintf("%d: %s", i, describeRendition(r))
+		}
+		return nil, nil, fmt.Errorf("invalid %s %q: use an index, language or name (available: %s)", flag, choice, strings.Join(available, "; "))
+	}
+	return rendition, group, nil
+}
+
+// muxedTrack returns the index of rendition among the audio streams muxed
+// into the variant, and how many renditions of group are muxed.
+func muxedTrack(group []*hls.Rendition, rendition *hls.Rendition) (int, int) {
+	track, muxed := 0, 0
+	for _, r := range group {
+		if r.URL != "" {
+			continue
+		}
+		if r == rendition {
+			track = muxed
+		}
+		muxed++
+	}
+	return track, muxed
+}
+
+// describeRendition names a rendition with its language.
+func describeRendition(r *hls.Rendition) string {
+	name := strconv.Quote(r.Name)
+	if r.Language != "" {
+		name += " [" + r.Language + "]"
+	}
+	return name
+}
+
+// printRenditions lists the renditions a dry run could pick with flag,
+// marking the selected one.
+func printRenditions(logger *Logger, title, flag string, group []*hls.Rendition, selected *hls.Rendition) {
+	logger.Infof("%s renditions (%s):\n", title, flag)
+	for i, r := range group {
+		var notes []string
+		if r.Default {
+			notes = append(notes, "default")
+		}
+		if r.AutoSelect {
+			notes = append(notes, "autoselect")
+		}
+		if r.URL == "" {
+			notes = append(notes, "muxed")
+		} else {
+			notes = append(notes, r.URL)
+		}
+		marker := " "
+		if r == selected {
+			marker = "*"
+		}
+		logger.Infof("%s %d\t%s\t%s\n", marker, i, describeRendition(r), strings.Join(notes, ", "))
+	}
+}
+
+// ParseVariantPreference parses --variant: "max" or "min" bandwidth, or a
+// target height given as 720, 720p or 1280x720.
+func ParseVariantPreference(s string) (hls.VariantPreference, error) {
+	switch s {
+	case "", "max":
+		return hls.VariantPreference{}, nil
+	case "min":
+		return hls.VariantPreference{Lowest: true}, nil
+	}
+
+	height := strings.TrimSuffix(s, "p")
+	if _, h, ok := strings.Cut(s, "x"); ok {
+		height = h
+	}
+	n, err := strconv.Atoi(height)
+	if err != nil || n <= 0 {
+		return hls.VariantPreference{}, fmt.Errorf("invalid --variant %q: use max, min, or a resolution such as 720p or 1280x720", s)
+	}
+	return hls.VariantPreference{Height: n}, nil
+}
+
+// selectIFrameVariant returns the I-frame-only variant of a master playlist
+// chosen by choice: "max" or "min" bandwidth, or an index.
+func selectIFrameVariant(content, playlistURL, choice, codec string) (*hls.Variant, error) {
+	variants, err := hls.ParseIFrameVariants(content, playlistURL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing master playlist: %w", err)
+	}
+	if len(variants) == 0 {
+		return nil, fmt.Errorf("master playlist has no I-frame variants")
+	}
+	if codec != "" {
+		if variants, err = filterVariantsByCodec(variants, codec); err != nil {
+			return nil, err
+		}
+	}
+
+	switch choice {
+	case "max":
+		return hls.SelectByBandwidth(variants, true), nil
+	case "min":
+		return hls.SelectByBandwidth(variants, false), nil
+	}
+
+	index, err := strconv.Atoi(choice)
+	if err != nil || index < 0 || index >= len(variants) {
+		return nil, fmt.Errorf("invalid --iframe-variant %q: use max, min, or an index between 0 and %d", choice, len(variants)-1)
+	}
+	return variants[index], nil
+}
+
+// filterVariantsByCodec narrows variants to those using codec, listing the
+// codecs on offer when none match.
+func filterVariantsByCodec(variants []*hls.Variant, codec string) ([]*hls.Variant, error) {
+	if matched := hls.FilterByCodec(variants, codec); len(matched) > 0 {
+		return matched, nil
+	}
+
+	var available []string
+	for _, v := range variants {
+		if v.Codecs != "" && !slices.Contains(available, v.Codecs) {
+			available = append(available, v.Codecs)
+		}
+	}
+	return nil, fmt.Errorf("no variant matches codec %q (available: %s)", codec, strings.Join(available, "; "))
+}
+
+// vodStartSequence returns the first sequence to capture from an ended
+// playlist: its first segment, or with VODLast the one starting the last
+// segmentCount segments or Duration of stream time.
+func vodStartSequence(segments []*hls.Segment, opts Options, segmentCount int) int {
+	first := hls.GetFirstSegment(segments).Sequence
+	last := hls.GetLastSegment(segments).Sequence
+	if opts.VODAll || opts.VODCount != VODLast {
+		return first
+	}
+	if opts.Duration <= 0 {
+		return max(last-segmentCount+1, first)
+	}
+	var total time.Duration
+	start := last
+	for start > first && total < opts.Duration {
+		if seg := hls.FindSegmentBySequence(segments, start); seg != nil {
+			total += time.Duration(seg.Duration * float64(time.Second))
+		}
+		if total < opts.Duration {
+			start--
+		}
+	}
+	return start
+}
+
+// checkSequenceRange rejects an explicit range start-target that can't be
+// captured from a playlist window of first-last: one that has already left
+// the window, or, with inWindow, whose start has, and one reaching beyond a
+// complete playlist, which won't grow.
+func checkSequenceRange(start, target, first, last int, ended, inWindow bool) error {
+	if inWindow && start < first {
+		return fmt.Errorf("cannot start capture: %w", &hls.SegmentExpiredError{Sequence: start, FirstSequence: first})
+	}
+	if target < first {
+		return fmt.Errorf("sequence range %d-%d has expired from the playlist (oldest available: %d)", start, target, first)
+	}
+	if ended && (start < first || target > last) {
+		return fmt.Errorf("sequence range %d-%d is outside the playlist (%d-%d) and the playlist has ended", start, target, first, last)
+	}
+	return nil
+}
+
+// printSegments lists the segments of playlist with their durations, the
+// stream time they add up to and the playlist header, for a dry run.
+func printSegments(logger *Logger, playlist *hls.Playlist) {
+	var total time.Duration
+	for _, segment := range playlist.Segments {
+		duration := time.Duration(segment.Duration * float64(time.Second))
+		total += duration
+		logger.Infof("%d\t%v\t%s\n", segment.Sequence, duration, segment.URL)
+	}
+	logger.Infof("\n%d segments, %v of stream time\n", len(playlist.Segments), total)
+	details := fmt.Sprintf("version %d", playlist.Version)
+	if playlist.Type != "" {
+		details += ", type " + playlist.Type
+	}
+	if playlist.TargetDuration > 0 {
+		details += fmt.Sprintf(", target duration %v", playlist.TargetDuration)
+	}
+	logger.Infof("Playlist: %s\n", details)
+	if playlist.EndList {
+		logger.Infof("Stream type: VOD (#EXT-X-ENDLIST present)\n")
+	} else {
+		logger.Infof("Stream type: live (no #EXT-X-ENDLIST)\n")
+	}
+}
+
+// isDirectCapture reports whether the capture is a single unencrypted
+// segment with no processing that needs the downloaded file, so it can be
+// written straight into the output.
+func isDirectCapture(opts Options, segments []*hls.Segment) bool {
+	if opts.SegmentCount != 1 || len(opts.ExtraOutputs) > 0 || opts.ExtractAudio || opts.StateFile != "" ||
+		opts.Verify || opts.VerifySegments || opts.Transcode || opts.HashManifest != "" || opts.ChecksumFile ||
+		opts.Output == StdoutOutput || opts.SubtitleTrack != "" || strings.EqualFold(filepath.Ext(opts.Output), ".mp4") {
+		return false
+	}
+	for _, seg := range segments {
+		// Initialization segments are prepended by the merge
+		if seg.Key != nil || seg.Map != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// fetchToFile downloads a segment straight into path and returns the
+// number of bytes written. Like a merge, it writes a regular file to
+// <path>.tmp and renames it into place once the download succeeded, so a
+// failed or abandoned download leaves any previous file at path untouched;
+// FIFOs and devices are written directly.
+func fetchToFile(ctx context.Context, fetcher *hls.Fetcher, segment *hls.Segment, path string) (int64, error) {
+	name := path
+	if info, err := os.Stat(path); err != nil || info.Mode().IsRegular() {
+		name = path + ".tmp"
+	}
+	file, err := os.Create(name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create output file: %w", err)
+	}
+	discard := func() {
+		if name != path {
+			os.Remove(name)
+		}
+	}
+
+	written, err := fetcher.FetchSegmentRangeContext(ctx, segment.URL, segment.Offset, segment.Length, file)
+	if err != nil {
+		file.Close()
+		discard()
+		return written, err
+	}
+	if err := file.Close(); err != nil {
+		discard()
+		return written, fmt.Errorf("failed to write output file: %w", err)
+	}
+	if name != path {
+		if err := os.Rename(name, path); err != nil {
+			discard()
+			return written, fmt.Errorf("failed to finalize output file: %w", err)
+		}
+	}
+	return written, nil
+}
+
+// captureLogger returns the logger of a capture and a function closing its
+// log file, if opts.LogDir asks for one.
+// Each capture logs through its own copy of opts.Logger.
+func captureLogger(opts Options) (*Logger, func() error, error) {
+	base := opts.Logger
+	switch {
+	case base != nil:
+	case opts.Slog != nil:
+		base = NewSlogLogger(opts.Slog)
+	default:
+		base = &Logger{}
+	}
+	if opts.LogDir == "" {
+		return streamLogger(base, opts, nil), func() error { return nil }, nil
+	}
+
+	name := opts.StreamID
+	if name == "" {
+		name = streamIDFromURL(opts.URL)
+	}
+	if err := os.MkdirAll(opts.LogDir, 0755); err != nil {
+		return nil, nil, fmt.Errorf("error creating log directory: %w", err)
+	}
+	file, err := os.OpenFile(filepath.Join(opts.LogDir, name+".log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening log file: %w", err)
+	}
+	return streamLogger(base, opts, file), file.Close, nil
+}
+
+// streamLogger returns the copy of base logging the capture of opts,
+// which keeps stdout free when the merged stream is written there.
+func streamLogger(base *Logger, opts Options, file io.Writer) *Logger {
+	logger := base.forStream(opts.StreamID, file)
+	if opts.Output == StdoutOutput {
+		logger.UseStderr()
+	}
+	return logger
+}
+
+// streamIDFromURL derives a file-name-safe stream identifier from the host
+// and path of a playlist URL, e.g. "cdn.example.com_live_720p_index".
+func streamIDFromURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "stream"
+	}
+	id := u.Host + strings.TrimSuffix(u.Path, path.Ext(u.Path))
+	id = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, id)
+	if id = strings.Trim(id, "_"); id == "" {
+		return "stream"
+	}
+	return id
+}
+
+// reanchorPending moves the pending sequences to start at edge, keeping
+// their number, so a capture resumed after a pause or falling behind the
+// live window continues from the live edge instead of catching up.
+func reanchorPending(pending []int, edge int) []int {
+	if len(pending) == 0 || pending[0] >= edge {
+		return pending
+	}
+
+	reanchored := make([]int, len(pending))
+	for i := range reanchored {
+		reanchored[i] = edge + i
+	}
+	return reanchored
+}
+
+// waitForProgramTime polls the playlist every interval until a segment
+// plays at or after t or the playlist ends, and returns the latest
+// playlist. Failed polls are logged and repeated.
+func waitForProgramTime(ctx context.Context, logger *Logger, playlists *hls.PlaylistPoller, playlist *hls.Playlist, t time.Time, interval time.Duration) (*hls.Playlist, error) {
+	if playlist.EndList || hls.SegmentAtTime(playlist.Segments, t) != nil {
+		return playlist, nil
+	}
+	edge := hls.GetLastSegment(playlist.Segments).EndDateTime()
+	logger.Waitf("Waiting for the stream to reach %s (%v ahead of its live edge)\n", t.Format(time.RFC3339), t.Sub(edge).Round(time.Second))
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("cancelled while waiting for the start time: %w", ctx.Err())
+		case <-time.After(interval):
+		}
+
+		latest, changed, err := playlists.Poll(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Errorf("Error polling playlist: %v\n", err)
+			}
+			continue
+		}
+		if changed && (latest.EndList || hls.SegmentAtTime(latest.Segments, t) != nil) {
+			return latest, nil
+		}
+	}
+}
+
+// waitTimeout waits for wg, giving up after d. It reports whether wg
+// finished in time.
+func waitTimeout(wg *sync.WaitGroup, d time.Duration) bool {
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-finished:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// audioOutputFor returns where extracted audio is written: --audio-output,
+// or the video output path with the audio format's extension.
+func audioOutputFor(opts Options) string {
+	if opts.AudioOutput != "" {
+		return opts.AudioOutput
+	}
+	ext := filepath.Ext(opts.Output)
+	return opts.Output[:len(opts.Output)-len(ext)] + opts.AudioOptions.Ext()
+}
+
+// startAudioPipeline starts an FFmpeg audio extraction that reads segments
+// as they are downloaded.
+func startAudioPipeline(logger *Logger, opts Options) (*audio.Stream, error) {
+	extractor, err := audio.NewExtractor()
+	if err != nil {
+		return nil, fmt.Errorf("error initializing audio extractor: %w", err)
+	}
+	extractor.Options = opts.AudioOptions
+
+	audioPath := audioOutputFor(opts)
+	logger.Infof("Extracting audio during capture to: %s\n", audioPath)
+	stream, err := extractor.ExtractAudioStream(audioPath)
+	if err != nil {
+		return nil, fmt.Errorf("error starting audio extraction: %w", err)
+	}
+	return stream, nil
+}
+
+// transcodeFile re-encodes the merged file in place: FFmpeg writes a
+// sibling file which then replaces the original.
+func transcodeFile(logger *Logger, path string, options transcode.Options) error {
+	transcoder, err := transcode.NewTranscoder()
+	if err != nil {
+		return fmt.Errorf("error initializing transcoder: %w", err)
+	}
+
+	ext := filepath.Ext(path)
+	tempPath := path[:len(path)-len(ext)] + ".transcoding" + ext
+
+	logger.Infof("Transcoding merged output: %s\n", path)
+	if err := transcoder.Transcode(path, tempPath, options); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("error transcoding output: %w", err)
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("error replacing output with transcoded file: %w", err)
+	}
+	logger.Successf("Successfully transcoded %s\n", path)
+	return nil
+}
+
+// remuxFile moves the MPEG-TS data merged into path into the container its
+// extension names, without re-encoding: the merged file is renamed to an
+// intermediate .ts file, which is removed once FFmpeg wrote path.
+func remuxFile(logger *Logger, path string) error {
+	remuxer, err := mux.NewRemuxer()
+	if err != nil {
+		return fmt.Errorf("error initializing remuxer: %w", err)
+	}
+
+	ext := filepath.Ext(path)
+	tempPath := path[:len(path)-len(ext)] + ".remuxing.ts"
+	if err := os.Rename(path, tempPath); err != nil {
+		return fmt.Errorf("error preparing output for remuxing: %w", err)
+	}
+
+	logger.Infof("Remuxing merged output to %s: %s\n", strings.TrimPrefix(ext, "."), path)
+	if err := remuxer.Remux(tempPath, path); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("error remuxing output (merged MPEG-TS kept at %s): %w", tempPath, err)
+	}
+	if err := os.Remove(tempPath); err != nil {
+		logger.Warnf("Warning: could not remove intermediate file %s: %v\n", tempPath, err)
+	}
+	logger.Successf("Successfully remuxed %s\n", path)
+	return nil
+}
+
+// trimmedLength returns how long the media file at path lasts once start
+// and end are cut from it.
+func trimmedLength(path string, start, end time.Duration) (time.Duration, error) {
+	total, err := ffmpeg.Duration(path)
+	if err != nil {
+		return 0, err
+	}
+	length := total - start - end
+	if length <= 0 {
+		return 0, fmt.Errorf("trimming %v from the start and %v from the end leaves nothing of the %v capture", start, end, total)
+	}
+	return length, nil
+}
+
+// trimFile cuts path down to the length of media starting at start, in place
+// without re-encoding.
+func trimFile(logger *Logger, path string, start, length time.Duration) error {
+	remuxer, err := mux.NewRemuxer()
+	if err != nil {
+		return fmt.Errorf("error initializing remuxer: %w", err)
+	}
+
+	ext := filepath.Ext(path)
+	tempPath := path[:len(path)-len(ext)] + ".trimming" + ext
+	if err := os.Rename(path, tempPath); err != nil {
+		return fmt.Errorf("error preparing output for trimming: %w", err)
+	}
+
+	logger.Infof("Trimming video to %v starting at %v: %s\n", length, start, path)
+	if err := remuxer.Trim(tempPath, path, start, length); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("error trimming output (untrimmed output kept at %s): %w", tempPath, err)
+	}
+	if err := os.Remove(tempPath); err != nil {
+		logger.Warnf("Warning: could not remove intermediate file %s: %v\n", tempPath, err)
+	}
+	logger.Successf("Successfully trimmed %s\n", path)
+	return nil
+}
+
+// skipMissingTool reports whether err is a missing external tool that
+// --skip-missing-tools allows continuing without.
+func skipMissingTool(opts Options, err error) bool {
+	return opts.SkipMissingTools && errors.Is(err, exec.ErrNotFound)
+}
+
+// concatDiscontinuityParts merges each discontinuity-free run of segments
+// into an intermediate file next to path and joins them into path with
+// FFmpeg's concat demuxer, which re-timestamps every part to continue where
+// the previous one ended. On failure the intermediate parts are kept.
+func concatDiscontinuityParts(logger *Logger, manager *downloader.Manager, remuxer *mux.Remuxer, path string, parts [][]int, fragmented bool) error {
+	ext := ".ts"
+	if fragmented {
+		ext = ".mp4"
+	}
+	base := strings.TrimSuffix(path, filepath.Ext(path)) + ".discontinuity" + ext
+	logger.Infof("Merging %d parts separated by discontinuities\n", len(parts))
+	partFiles, err := manager.MergeSegmentParts(base, parts)
+	if err != nil {
+		return fmt.Errorf("error merging segments: %w", err)
+	}
+
+	logger.Infof("Re-timestamping parts into: %s\n", path)
+	if err := remuxer.Concat(partFiles, path); err != nil {
+		return fmt.Errorf("error joining parts (merged parts kept at %s): %w", strings.Join(partFiles, ", "), err)
+	}
+	for _, partFile := range partFiles {
+		os.Remove(partFile)
+	}
+	logger.Successf("Successfully merged segments into %s\n", path)
+	return nil
+}
+
+// thumbnailSource returns the merged file holding the middle of the
+// capture and the offset of that point within it. A merge split into parts
+// is searched using the durations of the segments in each part.
+func thumbnailSource(output string, partFiles []string, parts [][]int, segments []*hls.Segment) (string, time.Duration) {
+	durations := make(map[int]time.Duration, len(segments))
+	var total time.Duration
+	for _, seg := range segments {
+		d := time.Duration(seg.Duration * float64(time.Second))
+		durations[seg.Sequence] = d
+		total += d
+	}
+	middle := total / 2
+	if len(partFiles) == 0 {
+		return output, middle
+	}
+
+	var start time.Duration
+	for i, part := range parts {
+		var length time.Duration
+		for _, seq := range part {
+			length += durations[seq]
+		}
+		if middle < start+length || i == len(partFiles)-1 {
+			return partFiles[i], middle - start
+		}
+		start += length
+	}
+	return partFiles[0], 0
+}
+
+// extractThumbnail writes the frame at the given offset of videoPath to
+// path.
+func extractThumbnail(logger *Logger, videoPath, path string, at time.Duration) error {
+	extractor, err := frame.NewExtractor()
+	if err != nil {
+		return fmt.Errorf("error initializing frame extractor: %w", err)
+	}
+
+	logger.Infof("Extracting thumbnail at %v to: %s\n", at.Round(time.Millisecond), path)
+	if err := extractor.ExtractFrame(videoPath, path, at); err != nil {
+		return fmt.Errorf("error extracting thumbnail: %w", err)
+	}
+	logger.Successf("Successfully extracted thumbnail to %s\n", path)
+	return nil
+}
+
+// verifyOutput runs an FFmpeg decode pass over the merged file and returns an
+// error if more than maxErrors decode errors are found.
+func verifyOutput(logger *Logger, path string, maxErrors int) error {
+	verifier, err := verify.NewVerifier()
+	if err != nil {
+		return fmt.Errorf("error initializing verifier: %w", err)
+	}
+
+	logger.Infof("Verifying merged output: %s\n", path)
+	result, err := verifier.Verify(path)
+	if err != nil {
+		return fmt.Errorf("error verifying output: %w", err)
+	}
+
+	const maxShown = 10
+	for i, msg := range result.Messages {
+		if i == maxShown {
+			logger.Warnf("  ... and %d more\n", len(result.Messages)-maxShown)
+			break
+		}
+		logger.Warnf("  %s\n", msg)
+	}
+
+	if result.Errors > maxErrors {
+		return fmt.Errorf("verification failed: %d decode errors (allowed: %d)", result.Errors, maxErrors)
+	}
+	if result.Errors > 0 {
+		logger.Warnf("Warning: %d decode errors within the allowed threshold\n", result.Errors)
+	} else {
+		logger.Successf("Verification passed: output decodes cleanly\n")
+	}
+	return nil
+}
+
+// writeChecksumFile writes hash for the file at outputPath to path in the
+// format of sha256sum, so it can be checked with "sha256sum -c".
+func writeChecksumFile(path, outputPath, hash string) error {
+	line := fmt.Sprintf("%s  %s\n", hash, filepath.Base(outputPath))
+	return os.WriteFile(path, []byte(line), 0644)
+}
+
+// writeOutputChecksum hashes the file at outputPath into its
+// "<output>.sha256" sidecar.
+func writeOutputChecksum(logger *Logger, outputPath string) error {
+	checksumPath := outputPath + ".sha256"
+	if err := writeFileChecksum(checksumPath, outputPath); err != nil {
+		return fmt.Errorf("error writing checksum file: %w", err)
+	}
+	logger.Infof("Wrote checksum: %s\n", checksumPath)
+	return nil
+}
+
+// writeFileChecksum hashes the file at outputPath and writes the checksum
+// file for it to path.
+func writeFileChecksum(path, outputPath string) error {
+	f, err := os.Open(outputPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return err
+	}
+	return writeChecksumFile(path, outputPath, hex.EncodeToString(hasher.Sum(nil)))
+}
+
+// writeHashManifest writes the per-segment hashes as indented JSON to path.
+func writeHashManifest(path string, infos []downloader.SegmentInfo) error {
+	if dir := filepath.Dir(path); dir != "" && dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(infos, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,312 @@
+package capture
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bariiss/stream-capture/internal/hls"
+)
+
+func TestFetchToFileKeepsOutputOnError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp2t")
+		if r.URL.Path == "/broken.ts" {
+			// The connection is closed after the short body
+			w.Header().Set("Content-Length", "100")
+			w.Write([]byte("truncated"))
+			return
+		}
+		w.Write([]byte("segment"))
+	}))
+	t.Cleanup(server.Close)
+
+	fetcher := hls.NewFetcher()
+	fetcher.Retry.MaxRetries = 0
+	dir := t.TempDir()
+	output := filepath.Join(dir, "out.ts")
+	if err := os.WriteFile(output, []byte("previous capture"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fetchToFile(context.Background(), fetcher, &hls.Segment{URL: server.URL + "/broken.ts"}, output); err == nil {
+		t.Fatal("fetchToFile of a truncated segment succeeded")
+	}
+	if data, err := os.ReadFile(output); err != nil || string(data) != "previous capture" {
+		t.Errorf("output after a failed download = %q, %v; want the previous file untouched", data, err)
+	}
+	if _, err := os.Stat(output + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind after a failed download: %v", err)
+	}
+
+	fresh := filepath.Join(dir, "fresh.ts")
+	if _, err := fetchToFile(context.Background(), fetcher, &hls.Segment{URL: server.URL + "/broken.ts"}, fresh); err == nil {
+		t.Fatal("fetchToFile of a truncated segment succeeded")
+	}
+	if _, err := os.Stat(fresh); !os.IsNotExist(err) {
+		t.Errorf("failed download left an output behind: %v", err)
+	}
+
+	written, err := fetchToFile(context.Background(), fetcher, &hls.Segment{URL: server.URL + "/seg.ts"}, output)
+	if err != nil {
+		t.Fatalf("fetchToFile returned error: %v", err)
+	}
+	if data, _ := os.ReadFile(output); string(data) != "segment" || written != int64(len("segment")) {
+		t.Errorf("output = %q (%d bytes written), want the segment", data, written)
+	}
+}
+
+func TestCheckSequenceRange(t *testing.T) {
+	// The playlist window holds sequences 100-110
+	const first, last = 100, 110
+	tests := []struct {
+		name          string
+		start, target int
+		ended         bool
+		inWindow      bool
+		wantErr       bool
+		wantExpired   bool
+	}{
+		{"inside the window", 102, 108, false, false, false, false},
+		{"beyond the live edge is waited for", 108, 120, false, false, false, false},
+		{"start already gone is skipped", 95, 105, false, false, false, false},
+		{"start already gone with --from", 95, 105, false, true, true, true},
+		{"start in the window with --from", 100, 105, false, true, false, false},
+		{"whole range expired", 80, 99, false, false, true, false},
+		{"ended playlist", 100, 110, true, false, false, false},
+		{"end beyond an ended playlist", 105, 111, true, false, true, false},
+		{"start before an ended playlist", 99, 105, true, false, true, false},
+	}
+	for _, tt := range tests {
+		err := checkSequenceRange(tt.start, tt.target, first, last, tt.ended, tt.inWindow)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: checkSequenceRange(%d, %d) = %v, want error %v", tt.name, tt.start, tt.target, err, tt.wantErr)
+			continue
+		}
+		var expired *hls.SegmentExpiredError
+		if got := errors.As(err, &expired); got != tt.wantExpired {
+			t.Errorf("%s: error %v matches SegmentExpiredError = %v, want %v", tt.name, err, got, tt.wantExpired)
+		}
+	}
+}
+
+// hlsServer serves a media playlist of segments named seg<N>.ts whose
+// bodies are "segment <N>". A live playlist holds a sliding window that
+// advances by one segment on every playlist request.
+type hlsServer struct {
+	mu       sync.Mutex
+	live     bool
+	window   int
+	next     int // first sequence of the window served next
+	requests int
+}
+
+func (s *hlsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if name == "index.m3u8" {
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Write([]byte(s.playlist()))
+		return
+	}
+	var sequence int
+	if _, err := fmt.Sscanf(name, "seg%d.ts", &sequence); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "video/mp2t")
+	fmt.Fprintf(w, "segment %d\n", sequence)
+}
+
+func (s *hlsServer) playlist() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	first := s.next
+	if s.live {
+		s.next++
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:%d\n", first)
+	for sequence := first; sequence < first+s.window; sequence++ {
+		fmt.Fprintf(&b, "#EXTINF:1.0,\nseg%d.ts\n", sequence)
+	}
+	if !s.live {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return b.String()
+}
+
+func testOptions(t *testing.T, url string) Options {
+	t.Helper()
+	return Options{
+		URL:           url,
+		Output:        filepath.Join(t.TempDir(), "out.ts"),
+		PollInterval:  10 * time.Millisecond,
+		ShutdownGrace: time.Second,
+		QuietProgress: true,
+		Logger:        NewSlogLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	}
+}
+
+func TestRunVOD(t *testing.T) {
+	server := httptest.NewServer(&hlsServer{window: 4})
+	t.Cleanup(server.Close)
+
+	opts := testOptions(t, server.URL+"/index.m3u8")
+	opts.VODAll = true
+	result, err := Run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if result.Output != opts.Output {
+		t.Errorf("Output = %q, want %q", result.Output, opts.Output)
+	}
+	data, err := os.ReadFile(opts.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "segment 0\nsegment 1\nsegment 2\nsegment 3\n"; string(data) != want {
+		t.Errorf("merged output = %q, want %q", data, want)
+	}
+	if result.Stats.Segments != 4 || result.Stats.Bytes != int64(len(data)) {
+		t.Errorf("Stats = %d segments, %d bytes; want 4 segments, %d bytes", result.Stats.Segments, result.Stats.Bytes, len(data))
+	}
+	if result.Interrupted || len(result.Expired) != 0 {
+		t.Errorf("Interrupted = %v, Expired = %v; want a complete capture", result.Interrupted, result.Expired)
+	}
+}
+
+func TestRunLive(t *testing.T) {
+	stream := &hlsServer{live: true, window: 3, next: 10}
+	server := httptest.NewServer(stream)
+	t.Cleanup(server.Close)
+
+	opts := testOptions(t, server.URL+"/index.m3u8")
+	opts.SegmentCount = 5
+	result, err := Run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	data, err := os.ReadFile(opts.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 5 || result.Stats.Segments != 5 {
+		t.Fatalf("merged %d segments (Stats.Segments = %d), want 5: %q", len(lines), result.Stats.Segments, data)
+	}
+	// The segments are consecutive, in playlist order
+	var first int
+	fmt.Sscanf(lines[0], "segment %d", &first)
+	for i, line := range lines {
+		if want := fmt.Sprintf("segment %d", first+i); line != want {
+			t.Errorf("segment %d of the output = %q, want %q", i, line, want)
+		}
+	}
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if stream.requests < 2 {
+		t.Errorf("playlist fetched %d times, want it polled while the stream advances", stream.requests)
+	}
+}
+
+func TestRunCancelWhileWaiting(t *testing.T) {
+	// A live playlist that never advances
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/index.m3u8" {
+			fmt.Fprint(w, "#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n#EXTINF:1.0,\nseg0.ts\n")
+			return
+		}
+		w.Header().Set("Content-Type", "video/mp2t")
+		fmt.Fprint(w, "segment\n")
+	}))
+	t.Cleanup(server.Close)
+
+	opts := testOptions(t, server.URL+"/index.m3u8")
+	opts.SegmentCount = 2
+	opts.PollInterval = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := Run(ctx, opts); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	// The wait for the next segment ends with the context, not the poll
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Run took %v after cancellation", elapsed)
+	}
+}
+
+// fakeFFmpeg puts an ffmpeg on PATH that reports a ten-second input
+// starting at start seconds and writes "rewritten" to the output path, its
+// last argument.
+func fakeFFmpeg(t *testing.T, start string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake ffmpeg is a shell script")
+	}
+	dir := t.TempDir()
+	script := `#!/bin/sh
+for last; do :; done
+if [ "$#" -eq 3 ]; then
+	echo "  Duration: 00:00:10.00, start: ` + start + `, bitrate: 1 kb/s" >&2
+	exit 1
+fi
+echo rewritten > "$last"
+`
+	if err := os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+}
+
+func TestRunChecksumAfterRewrite(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Options)
+	}{
+		{"transcode", func(o *Options) { o.Transcode = true }},
+		{"trim video", func(o *Options) {
+			o.TrimVideo = true
+			o.TrimStart = 2 * time.Second
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeFFmpeg(t, "0.000000")
+			server := httptest.NewServer(&hlsServer{window: 4})
+			t.Cleanup(server.Close)
+
+			opts := testOptions(t, server.URL+"/index.m3u8")
+			opts.VODAll = true
+			opts.ChecksumFile = true
+			tt.modify(&opts)
+			if _, err := Run(context.Background(), opts); err != nil {
+				t.Fatalf("Run returned error: %v", err)
+			}
+
+			// The sidecar covers the rewritten output, not the merge
+			data, err := os.ReadFile(opts.Output + ".sha256")
+			if err != nil {
+				t.Fatal(err)
+			}
+			sum := sha256.Sum256([]byte("rewritten\n"))
+			if want := hex.EncodeToString(sum[:]) + "  out.ts\n"; string(data) != want {
+				t.Errorf("checksum file = %q, want %q", data, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,570 @@
+package capture
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/bariiss/stream-capture/internal/hls"
+	"github.com/bariiss/stream-capture/internal/retry"
+)
+
+// download downloads the planned segments, polling the playlist for those
+// not published yet. It reports whether any segments were downloaded for
+// the merge; an interrupted capture keeps the complete ones.
+func (c *capturer) download(ctx context.Context) (bool, error) {
+	opts := c.opts
+	logger := c.logger
+
+	c.progress = newProgressPrinter(logger, opts.QuietProgress, opts.ProgressInterval)
+	logger.beforeWrite = c.progress.breakLine
+	c.budget = &errorBudget{
+		maxConsecutive: opts.MaxConsecutiveErrors,
+		maxTotal:       opts.MaxTotalErrors,
+	}
+	c.lastAdvance = time.Now()
+	c.probing = opts.ProbeSegments && opts.LiveDelay == 0
+
+	// SIGUSR1 pauses downloads and SIGUSR2 resumes them
+	control := newPauseControl(ctx, opts.PauseSignals)
+
+	// With --pipeline, audio is extracted by an FFmpeg process fed each
+	// segment as soon as it is downloaded, overlapping network and CPU work.
+	if opts.Pipeline {
+		stream, err := startAudioPipeline(logger, opts)
+		if err != nil {
+			if !skipMissingTool(opts, err) {
+				return false, err
+			}
+			logger.Warnf("Warning: not pipelining audio extraction: %v\n", err)
+		}
+		c.audioStream = stream
+	}
+	c.pipelined = c.audioStream != nil
+
+	c.downloadedSequences = make([]int, 0, c.segmentCount)
+	c.downloadedSegments = make([]*hls.Segment, 0, c.segmentCount)
+	c.pending = make([]int, 0, c.segmentCount)
+	for seq := c.startSequence; seq <= c.targetSequence; seq++ {
+		c.pending = append(c.pending, seq)
+	}
+	if c.resumeState != nil {
+		// Segments restored from the state are only merged
+		c.pending = slices.DeleteFunc(c.pending, func(seq int) bool {
+			if _, ok := c.manager.GetSegmentPath(seq); !ok {
+				return false
+			}
+			segment := c.known[seq]
+			if segment == nil {
+				segment = &hls.Segment{Sequence: seq}
+			}
+			c.downloadedSequences = append(c.downloadedSequences, seq)
+			c.downloadedSegments = append(c.downloadedSegments, segment)
+			return true
+		})
+		logger.Infof("Resuming capture from %s: %d of %d segments already downloaded\n", opts.StateFile, len(c.downloadedSequences), c.segmentCount)
+	}
+	c.nextSequence = c.targetSequence + 1
+
+	if err := c.downloadSegments(ctx, control); err != nil {
+		return false, err
+	}
+
+	c.finishTracks(ctx)
+	c.progress.Flush()
+
+	if c.audioStream != nil {
+		logger.Infof("Finishing audio extraction: %s\n", audioOutputFor(opts))
+		err := c.audioStream.Close()
+		c.audioStream = nil
+		if err != nil {
+			return false, fmt.Errorf("error extracting audio: %w", err)
+		}
+	}
+
+	// Out-of-order downloads are still merged in sequence order
+	slices.Sort(c.downloadedSequences)
+	slices.SortFunc(c.downloadedSegments, func(a, b *hls.Segment) int {
+		return a.Sequence - b.Sequence
+	})
+
+	downloaded := len(c.downloadedSequences)
+	c.result.Stats.Segments = downloaded
+	c.result.Stats.StreamTime = c.captured
+	c.result.Expired = c.expiredSequences
+	c.result.Interrupted = ctx.Err() != nil
+
+	if ctx.Err() != nil {
+		if downloaded == 0 {
+			return false, nil
+		}
+		logger.Infof("Capture interrupted, saving the %d complete segments downloaded so far\n", downloaded)
+	} else if c.stale {
+		if downloaded == 0 {
+			return false, fmt.Errorf("stream stopped updating before any segment was captured")
+		}
+		logger.Infof("Saving the %d segments captured before the stream stopped\n", downloaded)
+	}
+
+	if opts.Duration > 0 {
+		logger.Successf("\nSuccessfully downloaded %d segments (%v of stream time)\n", downloaded, c.captured.Round(time.Millisecond))
+	} else {
+		logger.Successf("\nSuccessfully downloaded %d segments\n", downloaded)
+	}
+	if len(c.expiredSequences) > 0 {
+		logger.Warnf("Warning: %d segments expired from the live window and were skipped: %v\n", len(c.expiredSequences), c.expiredSequences)
+	}
+	return true, nil
+}
+
+// downloadSegments downloads the pending segments until none are left or
+// wanted, the stream ends or ctx is canceled.
+func (c *capturer) downloadSegments(ctx context.Context, control *pauseControl) error {
+	opts := c.opts
+	for {
+		if len(c.pending) == 0 && c.wantMore() && !(c.previous.EndList && c.nextSequence > c.liveEdge) {
+			c.pending = append(c.pending, c.nextSequence)
+			c.nextSequence++
+		}
+		if len(c.pending) == 0 {
+			return nil
+		}
+
+		if control.Paused() {
+			if err := c.waitWhilePaused(ctx, control); err != nil {
+				return err
+			}
+		}
+
+		currentSeq := c.takePending()
+
+		// Check for context cancellation
+		if ctx.Err() != nil {
+			c.logger.Infof("Cancelled by user\n")
+			return nil
+		}
+
+		segment, stop, err := c.waitForSegment(ctx, currentSeq)
+		if err != nil || stop {
+			return err
+		}
+		if segment == nil {
+			continue
+		}
+		delete(c.known, currentSeq)
+
+		// Backfilled segments may have scrolled out while newer ones were
+		// downloaded first, and a capture keeping up doesn't fetch segments
+		// it has fallen behind on.
+		if (opts.Priority == PriorityNewest || opts.KeepUp) && c.leftWindow(currentSeq) {
+			continue
+		}
+
+		if !c.endTime.IsZero() && !segment.ProgramDateTime.IsZero() && !segment.ProgramDateTime.Before(c.endTime) {
+			c.logger.Infof("Reached the end time at segment %d (%s)\n", currentSeq, segment.ProgramDateTime.Format(time.RFC3339))
+			return nil
+		}
+
+		segment, stop, err = c.fetchSegment(ctx, currentSeq, segment)
+		if err != nil || stop {
+			return err
+		}
+		if segment == nil {
+			continue
+		}
+
+		c.refreshes = 0
+		if c.audioStream != nil {
+			if err := c.manager.WriteSegments(c.audioStream, []int{currentSeq}); err != nil {
+				return fmt.Errorf("error streaming segment %d to audio extraction: %w", currentSeq, err)
+			}
+		}
+		c.downloadedSequences = append(c.downloadedSequences, currentSeq)
+		c.downloadedSegments = append(c.downloadedSegments, segment)
+		c.fetchTracks(ctx, segment)
+		c.captured += time.Duration(segment.Duration * float64(time.Second))
+		if !c.endTime.IsZero() && !segment.EndDateTime().IsZero() && !segment.EndDateTime().Before(c.endTime) {
+			c.reachedEnd = true
+		}
+	}
+}
+
+// wantMore reports whether a capture by duration or end time needs
+// segments beyond the pending ones.
+func (c *capturer) wantMore() bool {
+	if !c.endTime.IsZero() {
+		return !c.reachedEnd
+	}
+	return c.opts.Duration > 0 && c.captured < c.opts.Duration
+}
+
+// takePending removes the next sequence to download from the pending ones.
+// With newest-first priority the newest one already available is taken
+// before older backlog.
+func (c *capturer) takePending() int {
+	next := 0
+	if c.opts.Priority == PriorityNewest {
+		for i := len(c.pending) - 1; i > 0; i-- {
+			if c.available(c.pending[i]) != nil {
+				next = i
+				break
+			}
+		}
+	}
+	seq := c.pending[next]
+	c.pending = slices.Delete(c.pending, next, next+1)
+	return seq
+}
+
+// waitWhilePaused holds downloads while control is paused, still polling
+// the playlist. With ResumeLive the capture resumes at the live edge.
+func (c *capturer) waitWhilePaused(ctx context.Context, control *pauseControl) error {
+	c.logger.Waitf("Paused: downloads held while the playlist is still polled (send SIGUSR2 to resume)\n")
+	for control.Paused() && ctx.Err() == nil {
+		if _, err := c.pollPlaylist(ctx); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+		case <-control.Resumed():
+		case <-time.After(c.poller.Interval()):
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	c.logger.Infof("Resumed (live edge: %d)\n", c.liveEdge)
+	if edge := c.liveEdge - c.opts.LiveDelay; c.opts.ResumeMode == ResumeLive && len(c.pending) > 0 && c.pending[0] < edge {
+		c.logger.Warnf("Resuming at the live edge, skipping segments %d-%d\n", c.pending[0], edge-1)
+		c.pending = reanchorPending(c.pending, edge)
+		c.nextSequence = max(c.nextSequence, c.pending[len(c.pending)-1]+1)
+	}
+	return nil
+}
+
+// available returns the segment for seq once it is published and at
+// least LiveDelay segments behind the live edge. An ended playlist's
+// segments are all final.
+func (c *capturer) available(seq int) *hls.Segment {
+	if !c.previous.EndList && seq > c.liveEdge-c.opts.LiveDelay {
+		return nil
+	}
+	return c.known[seq]
+}
+
+// waitForSegment waits for segment seq to become available. It returns a
+// nil segment when seq is skipped, and reports stop when the capture ends
+// without it.
+func (c *capturer) waitForSegment(ctx context.Context, seq int) (segment *hls.Segment, stop bool, err error) {
+	opts := c.opts
+	logger := c.logger
+	segment = c.available(seq)
+	if segment == nil && opts.Preview {
+		return nil, false, fmt.Errorf("segment %d is not available yet (preview mode does not wait for new segments)", seq)
+	}
+	retryCount := 0
+	for segment == nil {
+		// An ended playlist gets no new segments to wait for
+		if c.previous.EndList {
+			if seq > c.liveEdge {
+				logger.Infof("Playlist has ended at segment %d\n", c.liveEdge)
+				return nil, true, nil
+			}
+			logger.Warnf("Skipping segment %d: not in the ended playlist\n", seq)
+			return nil, false, nil
+		}
+
+		if ctx.Err() != nil {
+			logger.Infof("Cancelled by user\n")
+			return nil, true, nil
+		}
+
+		// A segment found at its predicted URL is taken before the
+		// playlist lists it, and the playlist is only polled every
+		// few probes
+		if c.probing {
+			if predicted := hls.PredictSegment(c.previous.Segments, seq); predicted != nil {
+				if c.probeSegment(ctx, predicted) {
+					return predicted, false, nil
+				}
+				if c.probing && retryCount%probesPerPoll != 0 {
+					retryCount++
+					if !c.sleep(ctx) {
+						logger.Infof("Cancelled by user\n")
+						return nil, true, nil
+					}
+					continue
+				}
+			}
+		}
+
+		updated, err := c.pollPlaylist(ctx)
+		if err != nil {
+			return nil, false, err
+		}
+		if !updated {
+			if !c.sleep(ctx) {
+				logger.Infof("Cancelled by user\n")
+				return nil, true, nil
+			}
+			continue
+		}
+
+		if segment = c.available(seq); segment != nil {
+			break
+		}
+
+		if c.leftWindow(seq) {
+			// We fell behind the live window; this segment will never
+			// appear
+			return nil, false, nil
+		}
+
+		if opts.StaleTimeout > 0 && time.Since(c.lastAdvance) >= opts.StaleTimeout {
+			logger.Warnf("No new segments for %v (last segment: %d) and no #EXT-X-ENDLIST: treating the stream as ended\n", opts.StaleTimeout, c.liveEdge)
+			c.stale = true
+			return nil, true, nil
+		}
+
+		if retryCount%5 == 0 || retryCount == 0 {
+			logger.Waitf("Waiting for segment %d... (current last: %d)\n", seq, c.liveEdge)
+		}
+		retryCount++
+		if !c.sleep(ctx) {
+			logger.Infof("Cancelled by user\n")
+			return nil, true, nil
+		}
+	}
+	return segment, false, nil
+}
+
+// sleep waits one polling interval, returning false if ctx is canceled
+// first.
+func (c *capturer) sleep(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(c.poller.Interval()):
+		return true
+	}
+}
+
+// fetchSegment downloads segment seq into the temporary directory, or
+// straight into the output without a download manager. It returns the
+// downloaded segment, re-resolved if its URL was refreshed, or nil when
+// the download failed within the error budget; stop reports that ctx was
+// canceled and the download didn't finish within ShutdownGrace.
+func (c *capturer) fetchSegment(ctx context.Context, seq int, segment *hls.Segment) (_ *hls.Segment, stop bool, err error) {
+	opts := c.opts
+	logger := c.logger
+
+	if !c.endTime.IsZero() && !segment.ProgramDateTime.IsZero() {
+		c.progress.Update(fmt.Sprintf("[%s/%s] Downloading segment %d: %s", segment.ProgramDateTime.Format(time.TimeOnly), c.endTime.Format(time.TimeOnly), seq, filepath.Base(segment.URL)))
+	} else if opts.Duration > 0 {
+		c.progress.Update(fmt.Sprintf("[%v/%v] Downloading segment %d: %s", c.captured.Round(100*time.Millisecond), opts.Duration, seq, filepath.Base(segment.URL)))
+	} else {
+		c.progress.Update(fmt.Sprintf("[%d/%d] Downloading segment %d: %s", c.segmentCount-len(c.pending), c.segmentCount, seq, filepath.Base(segment.URL)))
+	}
+
+	// Interrupted downloads are resumed from their partial file on retry
+	policy := resumeRetryPolicy
+	policy.MaxAttempts = opts.MaxRetries + 1
+	policy.OnRetry = func(attempt int, err error, delay time.Duration) {
+		c.retries.Add(1)
+		logger.Errorf("Error downloading segment %d: %v (resuming in %v, attempt %d/%d)\n", seq, err, delay.Round(time.Millisecond), attempt+1, policy.MaxAttempts)
+	}
+	// The download runs in the background so that on shutdown it can be
+	// given up to ShutdownGrace to complete instead of being abandoned.
+	done := make(chan error, 1)
+	c.inFlight.Add(1)
+	go func() {
+		defer c.inFlight.Done()
+		done <- retry.Do(ctx, policy, func() error {
+			return c.downloadSegment(segment)
+		})
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		logger.Waitf("Waiting up to %v for segment %d to finish downloading...\n", opts.ShutdownGrace, seq)
+		if !waitTimeout(&c.inFlight, opts.ShutdownGrace) {
+			logger.Warnf("Shutdown grace period expired, discarding segment %d\n", seq)
+			// The canceled download returns promptly; it must be gone
+			// before the merge reads the segments
+			c.abortDownloads()
+			c.inFlight.Wait()
+			return nil, true, nil
+		}
+		err = <-done
+	}
+	for errors.Is(err, hls.ErrCredentialsExpired) {
+		if err := c.refreshPlaylist(ctx, err); err != nil {
+			return nil, false, fmt.Errorf("error downloading segment %d: %w", seq, err)
+		}
+		if refreshed := c.known[seq]; refreshed != nil {
+			segment = refreshed
+			delete(c.known, seq)
+		}
+		err = c.downloadSegment(segment)
+	}
+	if err != nil {
+		logger.Errorf("Error downloading segment %d: %v\n", seq, err)
+		c.failedSegments++
+		return nil, false, c.budget.fail(err)
+	}
+	c.budget.succeed()
+	return segment, false, nil
+}
+
+// downloadSegment makes a single attempt at downloading segment.
+func (c *capturer) downloadSegment(segment *hls.Segment) error {
+	var n int64
+	var err error
+	if c.manager == nil {
+		n, err = fetchToFile(c.downloadCtx, c.fetcher, segment, c.outputFile)
+	} else {
+		_, n, err = c.manager.DownloadSegment(c.downloadCtx, segment)
+	}
+	c.received.Add(n)
+	return err
+}
+
+// refreshPlaylist handles expired signed URLs: it asks the
+// --refresh-url-command for a fresh playlist URL and re-resolves every
+// known segment against the refreshed playlist.
+func (c *capturer) refreshPlaylist(ctx context.Context, cause error) error {
+	if c.opts.RefreshCommand == "" {
+		return fmt.Errorf("%w (use --refresh-url-command to refresh it automatically)", cause)
+	}
+	if c.refreshes >= maxURLRefreshes {
+		return fmt.Errorf("%w (still failing after %d URL refreshes)", cause, c.refreshes)
+	}
+	c.refreshes++
+
+	c.logger.Warnf("Credentials expired, refreshing playlist URL (%d/%d)\n", c.refreshes, maxURLRefreshes)
+	refreshedURL, err := refreshPlaylistURL(c.opts.RefreshCommand, c.playlistURL)
+	if err != nil {
+		return err
+	}
+	c.playlists.SetURL(refreshedURL)
+	playlist, _, err := c.playlists.Poll(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching refreshed playlist: %w", err)
+	}
+
+	c.playlistURL = refreshedURL
+	for _, seg := range playlist.Segments {
+		c.known[seg.Sequence] = seg
+	}
+	c.previous = playlist
+	if last := hls.GetLastSegment(playlist.Segments); last != nil {
+		c.liveEdge = last.Sequence
+	}
+	return nil
+}
+
+// pollPlaylist fetches the playlist once, recording new segments and
+// the live edge. It reports false when the poll failed and should be
+// repeated after the interval; errors are fatal.
+func (c *capturer) pollPlaylist(ctx context.Context) (bool, error) {
+	playlist, changed, err := c.playlists.Poll(ctx)
+	if ctx.Err() != nil {
+		// Interrupted by shutdown, not a failed poll
+		return false, nil
+	}
+	if errors.Is(err, hls.ErrCredentialsExpired) {
+		if err := c.refreshPlaylist(ctx, err); err != nil {
+			return false, fmt.Errorf("error fetching playlist: %w", err)
+		}
+		return true, nil
+	}
+	if err != nil {
+		c.logger.Errorf("Error polling playlist: %v\n", err)
+		return false, c.budget.fail(err)
+	}
+	c.budget.succeed()
+	if !changed {
+		c.poller.Observe(0)
+		return true, nil
+	}
+
+	added := playlist.Diff(c.previous)
+	for _, seg := range added {
+		c.known[seg.Sequence] = seg
+	}
+	c.poller.Observe(len(added))
+	c.previous = playlist
+	if last := hls.GetLastSegment(playlist.Segments); last != nil {
+		if last.Sequence > c.liveEdge {
+			c.lastAdvance = time.Now()
+		}
+		c.liveEdge = last.Sequence
+	}
+	return true, nil
+}
+
+// leftWindow handles a segment that has been evicted from the live
+// window, reporting false if seq is still in it. The segment is
+// recorded as a gap, along with the pending segments that left the window
+// too; with KeepUp the segments still to download are moved to the live
+// edge instead, recording all those left behind.
+func (c *capturer) leftWindow(seq int) bool {
+	first := c.previous.MinSequence()
+	if first < 0 || seq >= first {
+		return false
+	}
+	if !c.opts.KeepUp {
+		// Every pending segment below the window is gone as well, so they
+		// are all skipped now rather than one per poll
+		expired := []int{seq}
+		c.pending = slices.DeleteFunc(c.pending, func(s int) bool {
+			if s < first {
+				expired = append(expired, s)
+				return true
+			}
+			return false
+		})
+		slices.Sort(expired)
+		for _, s := range expired {
+			c.logger.Warnf("Skipping: %v\n", &hls.SegmentExpiredError{Sequence: s, FirstSequence: first})
+		}
+		c.expiredSequences = append(c.expiredSequences, expired...)
+		c.nextSequence = max(c.nextSequence, first)
+		return true
+	}
+
+	edge := max(c.liveEdge-c.opts.LiveDelay, first)
+	remaining := append([]int{seq}, c.pending...)
+	slices.Sort(remaining)
+	for _, s := range remaining {
+		if s < edge {
+			c.expiredSequences = append(c.expiredSequences, s)
+		}
+	}
+	c.logger.Warnf("Segment %d has left the live window (oldest: %d), jumping to segment %d to keep up\n", seq, first, edge)
+	c.pending = reanchorPending(remaining, edge)
+	c.nextSequence = max(c.nextSequence, c.pending[len(c.pending)-1]+1)
+	return true
+}
+
+// probeSegment reports whether the predicted segment is published.
+func (c *capturer) probeSegment(ctx context.Context, predicted *hls.Segment) bool {
+	exists, err := c.fetcher.SegmentExistsContext(ctx, predicted.URL)
+	switch {
+	case errors.Is(err, hls.ErrHeadUnsupported):
+		c.logger.Warnf("Warning: the server rejects HEAD requests, waiting for segments by polling the playlist\n")
+		c.probing = false
+	case err != nil:
+		if ctx.Err() == nil {
+			c.logger.Debugf("Probing segment %d failed: %v\n", predicted.Sequence, err)
+		}
+	case exists:
+		c.logger.Debugf("Segment %d found at %s before the playlist listed it\n", predicted.Sequence, predicted.URL)
+		c.lastAdvance = time.Now()
+		return true
+	}
+	return false
+}
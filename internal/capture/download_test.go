@@ -0,0 +1,32 @@
+package capture
+
+import (
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+
+	"github.com/bariiss/stream-capture/internal/hls"
+)
+
+func TestLeftWindowSkipsEveryExpiredSegment(t *testing.T) {
+	// The window moved on to segment 14 while 10-15 were still to download
+	c := &capturer{
+		logger:       NewSlogLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		previous:     &hls.Playlist{Segments: []*hls.Segment{{Sequence: 14}, {Sequence: 15}}},
+		pending:      []int{11, 12, 13, 14, 15},
+		nextSequence: 16,
+	}
+	if !c.leftWindow(10) {
+		t.Fatal("leftWindow(10) = false, want true below the window")
+	}
+	if want := []int{10, 11, 12, 13}; !slices.Equal(c.expiredSequences, want) {
+		t.Errorf("expiredSequences = %v, want %v", c.expiredSequences, want)
+	}
+	if want := []int{14, 15}; !slices.Equal(c.pending, want) {
+		t.Errorf("pending = %v, want %v", c.pending, want)
+	}
+	if c.leftWindow(14) {
+		t.Error("leftWindow(14) = true, want false inside the window")
+	}
+}
@@ -0,0 +1,139 @@
+package capture
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// hookResult describes a finished capture for placeholder substitution in
+// the --exec command.
+type hookResult struct {
+	Output   string
+	Audio    string
+	Subtitle string
+	URL      string
+	Count    int
+}
+
+// runHook runs the post-capture command template with its placeholders
+// ({output}, {audio}, {subtitle}, {url}, {count}) substituted. The template is
+// split into arguments before substitution so paths containing spaces are
+// passed through intact. The command's output is streamed to stdout, or
+// to stderr when stdout holds the merged stream, and its errors to stderr.
+func runHook(template string, result hookResult, stdout io.Writer) error {
+	args, err := splitCommandLine(template)
+	if err != nil {
+		return fmt.Errorf("invalid --exec command: %w", err)
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("invalid --exec command: empty command")
+	}
+
+	replacer := strings.NewReplacer(
+		"{output}", result.Output,
+		"{audio}", result.Audio,
+		"{subtitle}", result.Subtitle,
+		"{url}", result.URL,
+		"{count}", fmt.Sprint(result.Count),
+	)
+	for i, arg := range args {
+		args[i] = replacer.Replace(arg)
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("post-capture command failed: %w", err)
+	}
+	return nil
+}
+
+// refreshPlaylistURL runs the --refresh-url-command template with {url}
+// substituted by the current playlist URL and returns the first non-empty
+// line it prints as the new playlist URL.
+func refreshPlaylistURL(template, currentURL string) (string, error) {
+	args, err := splitCommandLine(template)
+	if err != nil {
+		return "", fmt.Errorf("invalid --refresh-url-command: %w", err)
+	}
+	if len(args) == 0 {
+		return "", fmt.Errorf("invalid --refresh-url-command: empty command")
+	}
+	for i, arg := range args {
+		args[i] = strings.ReplaceAll(arg, "{url}", currentURL)
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("refresh command failed: %w", err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line, nil
+		}
+	}
+	return "", fmt.Errorf("refresh command printed no URL")
+}
+
+// splitCommandLine splits a command line into arguments, honouring single
+// quotes, double quotes and backslash escapes the way a POSIX shell would.
+func splitCommandLine(line string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	var quote rune
+
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\\' && quote != '\'':
+			if i+1 >= len(runes) {
+				return nil, fmt.Errorf("trailing backslash")
+			}
+			i++
+			current.WriteRune(runes[i])
+			inArg = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
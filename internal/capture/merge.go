@@ -0,0 +1,183 @@
+package capture
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bariiss/stream-capture/internal/downloader"
+	"github.com/bariiss/stream-capture/internal/hls"
+	"github.com/bariiss/stream-capture/internal/mux"
+)
+
+// merged describes how the downloaded segments were merged, for the
+// post-processing.
+type merged struct {
+	// remux is set when the MPEG-TS merge is still to be remuxed into the
+	// output's MP4 container
+	remux bool
+	// parts are the sequences of each discontinuity-free run, when
+	// discontinuities are handled
+	parts [][]int
+	// split is set when the parts were merged into partFiles instead of
+	// the output
+	split     bool
+	partFiles []string
+}
+
+// merge writes the downloaded segments to the outputs, recording them in
+// the result. The state file of a completed capture is removed.
+func (c *capturer) merge(ctx context.Context) (*merged, error) {
+	opts := c.opts
+	logger := c.logger
+	manager := c.manager
+	outputFile := c.outputFile
+
+	// A rolling buffer only merges the segments it retained
+	if manager != nil {
+		if evicted := manager.Evicted(); len(evicted) > 0 {
+			c.downloadedSequences = slices.DeleteFunc(c.downloadedSequences, func(seq int) bool {
+				_, found := slices.BinarySearch(evicted, seq)
+				return found
+			})
+			c.downloadedSegments = slices.DeleteFunc(c.downloadedSegments, func(seg *hls.Segment) bool {
+				_, found := slices.BinarySearch(evicted, seg.Sequence)
+				return found
+			})
+			logger.Infof("Disk limit of %d bytes reached: discarded the %d oldest segments, keeping %d (%d bytes)\n",
+				opts.MaxDiskBytes, len(evicted), len(c.downloadedSequences), manager.StoredBytes())
+		}
+	}
+
+	if opts.HashManifest != "" {
+		if err := writeHashManifest(opts.HashManifest, manager.SegmentInfos(c.downloadedSequences)); err != nil {
+			return nil, fmt.Errorf("error writing hash manifest: %w", err)
+		}
+		logger.Infof("Wrote segment hash manifest: %s\n", opts.HashManifest)
+	}
+
+	// An .mp4 output is merged as MPEG-TS and remuxed afterwards; fMP4
+	// segments merge into an MP4 already and transcoding writes one anyway.
+	m := &merged{
+		remux: manager != nil && !opts.AudioOnly && !opts.RawConcat && !opts.Transcode &&
+			strings.EqualFold(filepath.Ext(outputFile), ".mp4") && !c.fragmented,
+	}
+
+	// Discontinuities only change the merge when there is one to handle
+	if manager != nil && opts.OnDiscontinuity != "" && opts.OnDiscontinuity != DiscontinuityIgnore {
+		m.parts = downloader.DiscontinuityParts(c.downloadedSegments)
+	}
+	m.split = len(m.parts) > 1 && opts.OnDiscontinuity == DiscontinuitySplit
+	concatParts := len(m.parts) > 1 && opts.OnDiscontinuity == DiscontinuityRemux
+	var concatRemuxer *mux.Remuxer
+	if concatParts {
+		var err error
+		concatRemuxer, err = mux.NewRemuxer()
+		if err != nil {
+			if !skipMissingTool(opts, err) {
+				return nil, fmt.Errorf("error initializing remuxer: %w", err)
+			}
+			logger.Warnf("Warning: merging across discontinuities without re-timestamping: %v\n", err)
+			concatParts = false
+		} else {
+			// The concatenation writes the output's container itself
+			m.remux = false
+		}
+	}
+
+	if manager == nil {
+		if len(c.downloadedSequences) > 0 {
+			logger.Successf("Successfully saved segment into %s\n", outputFile)
+		}
+	} else if m.split {
+		logger.Infof("Splitting merge at %d discontinuities\n", len(m.parts)-1)
+		partFiles, err := manager.MergeSegmentParts(outputFile, m.parts)
+		if err != nil {
+			return nil, fmt.Errorf("error merging segments: %w", err)
+		}
+		m.partFiles = partFiles
+		for _, path := range partFiles {
+			logger.Successf("Successfully merged segments into %s\n", path)
+			// Remuxed parts are hashed once rewritten
+			if opts.ChecksumFile && !m.remux {
+				if err := writeOutputChecksum(logger, path); err != nil {
+					return nil, err
+				}
+			}
+		}
+	} else if concatParts {
+		if err := concatDiscontinuityParts(logger, manager, concatRemuxer, outputFile, m.parts, c.fragmented); err != nil {
+			return nil, err
+		}
+		if opts.ChecksumFile && !rewritten(opts) {
+			if err := writeOutputChecksum(logger, outputFile); err != nil {
+				return nil, err
+			}
+		}
+	} else if outputFile == StdoutOutput {
+		// Stdout takes the merge on its own, with no processing after it
+		logger.Infof("Merging segments into stdout\n")
+		outputHash, err := manager.MergeSegmentsTo(os.Stdout, c.downloadedSequences)
+		if err != nil {
+			return nil, fmt.Errorf("error merging segments: %w", err)
+		}
+		logger.Successf("Successfully merged segments into stdout\n")
+		logger.Infof("SHA-256: %s\n", outputHash)
+	} else {
+		// Merge once, teeing into every output target
+		outputHash, err := manager.MergeSegmentsToFiles(c.outputPaths, c.downloadedSequences)
+		if err != nil {
+			return nil, fmt.Errorf("error merging segments: %w", err)
+		}
+		if !opts.AudioOnly {
+			logger.Successf("Successfully merged segments into %s\n", outputFile)
+			// Transcoding, remuxing and trimming rewrite the output,
+			// invalidating the hash
+			if !opts.Transcode && !m.remux && !opts.TrimVideo {
+				logger.Infof("SHA-256: %s\n", outputHash)
+			}
+			if opts.ChecksumFile && !m.remux && !rewritten(opts) {
+				checksumPath := outputFile + ".sha256"
+				if err := writeChecksumFile(checksumPath, outputFile, outputHash); err != nil {
+					return nil, fmt.Errorf("error writing checksum file: %w", err)
+				}
+				logger.Infof("Wrote checksum: %s\n", checksumPath)
+			}
+		} else {
+			// For audio-only, the primary output is a temporary video file
+			logger.Infof("Merged segments to temporary file for audio extraction\n")
+		}
+		for _, path := range opts.ExtraOutputs {
+			logger.Successf("Successfully merged segments into %s\n", path)
+		}
+	}
+
+	if m.split {
+		c.result.Parts = m.partFiles
+	} else {
+		c.result.Output = outputFile
+		c.result.ExtraOutputs = opts.ExtraOutputs
+	}
+
+	if opts.StateFile != "" {
+		if ctx.Err() != nil {
+			logger.Infof("Capture state saved; rerun with --resume %s to continue\n", opts.StateFile)
+		} else {
+			c.completed = true
+			if err := os.Remove(opts.StateFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+				logger.Warnf("Warning: could not remove capture state %s: %v\n", opts.StateFile, err)
+			}
+		}
+	}
+	return m, nil
+}
+
+// rewritten reports whether the post-processing rewrites the primary output
+// after the merge, so its checksum is only written afterwards.
+func rewritten(opts Options) bool {
+	return opts.Transcode || opts.TrimVideo
+}
@@ -0,0 +1,116 @@
+package capture
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// Resume behaviours of Options.ResumeMode.
+const (
+	ResumeCatchUp = "catchup"
+	ResumeLive    = "live"
+)
+
+// pauseControl holds the paused state of a capture, toggled at runtime by
+// pauseSignal and resumeSignal. While paused the capture keeps polling the
+// playlist but holds downloads.
+type pauseControl struct {
+	mu      sync.Mutex
+	paused  bool
+	resumed chan struct{} // closed when a pause ends
+}
+
+// pauseListeners are the pause controls of the running captures handling
+// the pause and resume signals. A single signal handler fans the signals
+// out to all of them, so the captures of a process pause and resume
+// together.
+var pauseListeners struct {
+	mu       sync.Mutex
+	controls map[*pauseControl]struct{}
+	signals  chan os.Signal
+}
+
+// newPauseControl listens for the pause and resume signals until ctx is
+// done, if enabled. Otherwise, or where the platform has no such signals,
+// the capture never pauses.
+func newPauseControl(ctx context.Context, enabled bool) *pauseControl {
+	p := &pauseControl{}
+	if !enabled || pauseSignal == nil {
+		return p
+	}
+
+	l := &pauseListeners
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.controls) == 0 {
+		if l.signals == nil {
+			l.signals = make(chan os.Signal, 1)
+			go func() {
+				for sig := range l.signals {
+					dispatchPauseSignal(sig)
+				}
+			}()
+		}
+		l.controls = make(map[*pauseControl]struct{})
+		signal.Notify(l.signals, pauseSignal, resumeSignal)
+	}
+	l.controls[p] = struct{}{}
+
+	context.AfterFunc(ctx, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.controls, p)
+		if len(l.controls) == 0 {
+			signal.Stop(l.signals)
+		}
+	})
+	return p
+}
+
+// dispatchPauseSignal pauses or resumes every listening capture.
+func dispatchPauseSignal(sig os.Signal) {
+	l := &pauseListeners
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for p := range l.controls {
+		if sig == pauseSignal {
+			p.pause()
+		} else {
+			p.resume()
+		}
+	}
+}
+
+// Paused reports whether downloads are currently held.
+func (p *pauseControl) Paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+// Resumed returns a channel closed when the current pause ends.
+func (p *pauseControl) Resumed() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.resumed
+}
+
+func (p *pauseControl) pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.paused {
+		p.paused = true
+		p.resumed = make(chan struct{})
+	}
+}
+
+func (p *pauseControl) resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paused {
+		p.paused = false
+		close(p.resumed)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package capture
+
+import "os"
+
+// pauseSignal and resumeSignal are unset where SIGUSR1 and SIGUSR2 don't
+// exist, so captures can't be paused.
+var (
+	pauseSignal  os.Signal
+	resumeSignal os.Signal
+)
@@ -0,0 +1,26 @@
+package capture
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPauseSignalsReachEveryCapture(t *testing.T) {
+	if pauseSignal == nil {
+		t.Skip("no pause signals on this platform")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Two streams of a --config run
+	first := newPauseControl(ctx, true)
+	second := newPauseControl(ctx, true)
+
+	dispatchPauseSignal(pauseSignal)
+	if !first.Paused() || !second.Paused() {
+		t.Fatalf("paused = %v, %v after the pause signal, want both", first.Paused(), second.Paused())
+	}
+	dispatchPauseSignal(resumeSignal)
+	if first.Paused() || second.Paused() {
+		t.Errorf("paused = %v, %v after the resume signal, want neither", first.Paused(), second.Paused())
+	}
+}
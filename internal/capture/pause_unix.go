@@ -0,0 +1,14 @@
+//go:build unix
+
+package capture
+
+import (
+	"os"
+	"syscall"
+)
+
+// pauseSignal and resumeSignal pause and resume a running capture.
+var (
+	pauseSignal  os.Signal = syscall.SIGUSR1
+	resumeSignal os.Signal = syscall.SIGUSR2
+)
@@ -0,0 +1,59 @@
+package capture
+
+import "time"
+
+// slowDownFactor stretches the adaptive polling interval after a poll that
+// found no new segments.
+const slowDownFactor = 1.5
+
+// probesPerPoll is how many waits for a segment go by between playlist
+// polls while the segment's predicted URL is probed instead.
+const probesPerPoll = 3
+
+// pollScheduler decides how long to wait between playlist polls. With
+// adaptive polling it aims for one new segment per poll: when several
+// appeared since the last poll the interval shrinks in proportion, after a
+// quiet poll it grows, and once segments appear again it drops back to at
+// most the starting interval, always staying within [min, max]. Otherwise
+// the interval is fixed.
+type pollScheduler struct {
+	interval time.Duration
+	// base is the starting interval, derived from the target duration
+	// unless set explicitly.
+	base     time.Duration
+	adaptive bool
+	min, max time.Duration
+}
+
+func newPollScheduler(interval time.Duration, adaptive bool, min, max time.Duration) *pollScheduler {
+	s := &pollScheduler{interval: interval, base: interval, adaptive: adaptive, min: min, max: max}
+	if adaptive {
+		s.interval = s.clamp(interval)
+	}
+	return s
+}
+
+// Interval returns the current wait between polls.
+func (s *pollScheduler) Interval() time.Duration {
+	return s.interval
+}
+
+// Observe adjusts the interval after a poll that found newSegments segments
+// not present in the previous playlist.
+func (s *pollScheduler) Observe(newSegments int) {
+	if !s.adaptive {
+		return
+	}
+
+	if newSegments == 0 {
+		s.interval = s.clamp(time.Duration(float64(s.interval) * slowDownFactor))
+		return
+	}
+	// The slowdown of a quiet period is dropped as soon as content flows
+	// again rather than unwound poll by poll
+	s.interval = s.clamp(min(s.interval, s.base) / time.Duration(newSegments))
+}
+
+func (s *pollScheduler) clamp(d time.Duration) time.Duration {
+	return min(max(d, s.min), s.max)
+}
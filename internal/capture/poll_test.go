@@ -0,0 +1,75 @@
+package capture
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPollSchedulerFixed(t *testing.T) {
+	s := newPollScheduler(3*time.Second, false, 500*time.Millisecond, 10*time.Second)
+	for _, added := range []int{0, 0, 5, 1} {
+		s.Observe(added)
+		if got := s.Interval(); got != 3*time.Second {
+			t.Fatalf("Interval after Observe(%d) = %v, want the fixed 3s", added, got)
+		}
+	}
+}
+
+func TestPollSchedulerBacksOffWhileUnchanged(t *testing.T) {
+	s := newPollScheduler(2*time.Second, true, 500*time.Millisecond, 5*time.Second)
+
+	want := []time.Duration{3 * time.Second, 4500 * time.Millisecond, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		s.Observe(0)
+		if got := s.Interval(); got != w {
+			t.Errorf("Interval after %d quiet polls = %v, want %v", i+1, got, w)
+		}
+	}
+}
+
+func TestPollSchedulerResetsWhenChanged(t *testing.T) {
+	// The starting interval is half a 4s target duration
+	s := newPollScheduler(2*time.Second, true, 500*time.Millisecond, 10*time.Second)
+	for range 4 {
+		s.Observe(0)
+	}
+	if s.Interval() <= 2*time.Second {
+		t.Fatalf("Interval after quiet polls = %v, want it backed off", s.Interval())
+	}
+
+	s.Observe(1)
+	if got := s.Interval(); got != 2*time.Second {
+		t.Errorf("Interval once a segment appeared = %v, want the starting 2s", got)
+	}
+	s.Observe(1)
+	if got := s.Interval(); got != 2*time.Second {
+		t.Errorf("Interval with one segment per poll = %v, want it kept at 2s", got)
+	}
+}
+
+func TestPollSchedulerSpeedsUp(t *testing.T) {
+	s := newPollScheduler(4*time.Second, true, 500*time.Millisecond, 10*time.Second)
+
+	s.Observe(2)
+	if got := s.Interval(); got != 2*time.Second {
+		t.Errorf("Interval after 2 new segments = %v, want 2s", got)
+	}
+	// One segment per poll is the aim: the faster interval is kept
+	s.Observe(1)
+	if got := s.Interval(); got != 2*time.Second {
+		t.Errorf("Interval after 1 new segment = %v, want 2s", got)
+	}
+	s.Observe(10)
+	if got := s.Interval(); got != 500*time.Millisecond {
+		t.Errorf("Interval after a burst = %v, want the 500ms minimum", got)
+	}
+}
+
+func TestPollSchedulerClampsStart(t *testing.T) {
+	if got := newPollScheduler(100*time.Millisecond, true, time.Second, 5*time.Second).Interval(); got != time.Second {
+		t.Errorf("Interval = %v, want it raised to the 1s minimum", got)
+	}
+	if got := newPollScheduler(time.Minute, true, time.Second, 5*time.Second).Interval(); got != 5*time.Second {
+		t.Errorf("Interval = %v, want it lowered to the 5s maximum", got)
+	}
+}
@@ -0,0 +1,298 @@
+package capture
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bariiss/stream-capture/internal/audio"
+	"github.com/bariiss/stream-capture/internal/subtitle"
+)
+
+// postProcess runs the processing requested after the merge: remuxing,
+// transcoding, verification, the thumbnail, audio and subtitle extraction,
+// trimming, the --exec hook and the upload.
+func (c *capturer) postProcess(ctx context.Context, m *merged) error {
+	opts := c.opts
+	logger := c.logger
+	result := c.result
+	outputFile := c.outputFile
+	tempVideoFile := outputFile
+
+	// The merge still carries the stream's timestamps, which remuxing and
+	// transcoding reset, so the tracks are aligned with it first
+	var subtitleTrackPath string
+	if c.subtitles != nil {
+		path, err := c.writeSubtitleTrack(c.subtitles, outputFile)
+		if err != nil {
+			return err
+		}
+		if path != "" {
+			subtitleTrackPath = path
+			result.Subtitles = []string{path}
+		}
+	}
+	if c.audio != nil {
+		if err := c.mergeAudioTrack(c.audio, outputFile); err != nil {
+			return err
+		}
+	}
+
+	if m.remux && m.split {
+		for _, path := range m.partFiles {
+			if err := c.remux(path); err != nil {
+				return err
+			}
+		}
+	} else if m.remux {
+		if err := c.remux(outputFile); err != nil {
+			return err
+		}
+	}
+
+	if opts.Transcode {
+		if err := transcodeFile(logger, outputFile, opts.TranscodeOptions); err != nil {
+			return err
+		}
+	}
+
+	if opts.Verify {
+		if err := verifyOutput(logger, tempVideoFile, opts.VerifyMaxErrors); err != nil {
+			if !skipMissingTool(opts, err) {
+				return err
+			}
+			logger.Warnf("Warning: skipping verification: %v\n", err)
+		}
+	}
+
+	if opts.Thumbnail != "" {
+		source, at := thumbnailSource(tempVideoFile, m.partFiles, m.parts, c.downloadedSegments)
+		if err := extractThumbnail(logger, source, opts.Thumbnail, at); err != nil {
+			if !skipMissingTool(opts, err) {
+				return err
+			}
+			logger.Warnf("Warning: skipping thumbnail: %v\n", err)
+		} else {
+			result.Thumbnail = opts.Thumbnail
+		}
+	}
+
+	// Trims are measured against the merged file rather than segment
+	// boundaries, so fractional trims cut where asked.
+	var trimLength time.Duration
+	trimmed := opts.TrimStart > 0 || opts.TrimEnd > 0
+	if trimmed {
+		var err error
+		trimLength, err = trimmedLength(tempVideoFile, opts.TrimStart, opts.TrimEnd)
+		if err != nil {
+			if !skipMissingTool(opts, err) {
+				return fmt.Errorf("error trimming capture: %w", err)
+			}
+			logger.Warnf("Warning: skipping trimming: %v\n", err)
+			trimmed = false
+		}
+	}
+
+	audioOutputPath, subtitleOutputPath, err := c.extractTracks(tempVideoFile, trimmed, trimLength)
+	if err != nil {
+		return err
+	}
+	result.Audio = audioOutputPath
+	if subtitleOutputPath == "" {
+		subtitleOutputPath = subtitleTrackPath
+	}
+
+	// The video is trimmed last so the audio above is cut exactly from the
+	// untrimmed merge rather than from keyframe-aligned video.
+	if opts.TrimVideo && trimmed {
+		if err := trimFile(logger, outputFile, opts.TrimStart, trimLength); err != nil {
+			if !skipMissingTool(opts, err) {
+				return err
+			}
+			logger.Warnf("Warning: skipping video trimming, %s is untrimmed: %v\n", outputFile, err)
+		}
+	}
+	if opts.ChecksumFile && rewritten(opts) {
+		if err := writeOutputChecksum(logger, outputFile); err != nil {
+			return err
+		}
+	}
+
+	if opts.SplitTracks && audioOutputPath != "" {
+		logger.Infof("Split tracks:\n")
+		logger.Infof("  video:    %s\n", outputFile)
+		logger.Infof("  audio:    %s\n", audioOutputPath)
+		if subtitleOutputPath != "" {
+			logger.Infof("  subtitle: %s\n", subtitleOutputPath)
+		}
+	}
+
+	if opts.ExecCommand != "" {
+		hook := hookResult{
+			Output:   result.Output,
+			Audio:    audioOutputPath,
+			Subtitle: subtitleOutputPath,
+			URL:      opts.URL,
+			Count:    len(c.downloadedSequences),
+		}
+
+		logger.Infof("Running post-capture command: %s\n", opts.ExecCommand)
+		if err := runHook(opts.ExecCommand, hook, logger.out()); err != nil {
+			if !opts.ExecIgnoreErrors {
+				return err
+			}
+			logger.Warnf("Warning: %v\n", err)
+		}
+	}
+
+	if c.uploader != nil {
+		// An interrupted capture's merged output is uploaded as well
+		if err := uploadResult(context.WithoutCancel(ctx), logger, c.uploader, opts.UploadDeleteLocal, result); err != nil {
+			return err
+		}
+	}
+
+	if c.manager != nil {
+		logger.Debugf("Temp directory cleaned up\n")
+	}
+	return nil
+}
+
+// remux rewrites the MPEG-TS merge at path into its MP4 container, then
+// writes its checksum if requested and nothing rewrites it later.
+func (c *capturer) remux(path string) error {
+	if err := remuxFile(c.logger, path); err != nil {
+		if !skipMissingTool(c.opts, err) {
+			return err
+		}
+		c.logger.Warnf("Warning: skipping remux, %s holds the merged MPEG-TS data: %v\n", path, err)
+	}
+	if c.opts.ChecksumFile && !rewritten(c.opts) {
+		return writeOutputChecksum(c.logger, path)
+	}
+	return nil
+}
+
+// extractTracks extracts the audio of the merged video, or of the captured
+// audio rendition, and transcribes it into subtitles, as requested,
+// returning the paths of both. In audio-only
+// mode the video is removed afterwards.
+func (c *capturer) extractTracks(videoPath string, trimmed bool, trimLength time.Duration) (audioPath, subtitlePath string, err error) {
+	opts := c.opts
+	logger := c.logger
+
+	var audioExtractor *audio.Extractor
+	if opts.ExtractAudio {
+		audioExtractor, err = audio.NewExtractor()
+		if err != nil {
+			if !skipMissingTool(opts, err) {
+				return "", "", fmt.Errorf("error initializing audio extractor: %w", err)
+			}
+			logger.Warnf("Warning: skipping audio extraction: %v\n", err)
+			logger.Warnf("Captured video kept at %s\n", videoPath)
+		} else {
+			audioExtractor.Options = opts.AudioOptions
+		}
+	}
+	if audioExtractor == nil {
+		return "", "", nil
+	}
+	audioPath = audioOutputFor(opts)
+	if c.pipelined {
+		logger.Infof("Audio was extracted during capture to %s\n", audioPath)
+	} else if opts.AudioSplitOn != "" {
+		indexPath, err := extractSplitAudio(logger, audioExtractor, videoPath, audioPath, c.downloadedSegments, opts.AudioSplitOn)
+		if err != nil {
+			return "", "", fmt.Errorf("error extracting audio: %w", err)
+		}
+		logger.Successf("Successfully extracted split audio, index: %s\n", indexPath)
+		audioPath = indexPath
+	} else {
+		logger.Infof("Extracting audio to: %s\n", audioPath)
+		extract := audioExtractor.ExtractAudio
+		if c.audioSource != "" {
+			// The audio rendition replaces the video's own audio, cut to
+			// the video's stretch of it
+			start, length := c.audioOffset, c.audioLength
+			if trimmed {
+				start, length = start+opts.TrimStart, trimLength
+			}
+			if opts.Preview {
+				length = min(length, previewAudioDuration)
+			}
+			logger.Infof("Extracting the audio rendition from %v for %v\n", start.Round(time.Millisecond), length.Round(time.Millisecond))
+			extract = func(_, outputPath string) error {
+				return audioExtractor.ExtractAudioRange(c.audioSource, outputPath, start, length)
+			}
+		} else if opts.Preview {
+			extract = func(videoPath, outputPath string) error {
+				return audioExtractor.ExtractAudioRange(videoPath, outputPath, 0, previewAudioDuration)
+			}
+		} else if trimmed {
+			logger.Infof("Trimming audio to %v starting at %v\n", trimLength, opts.TrimStart)
+			extract = func(videoPath, outputPath string) error {
+				return audioExtractor.ExtractAudioRange(videoPath, outputPath, opts.TrimStart, trimLength)
+			}
+		}
+		if err := extract(videoPath, audioPath); err != nil {
+			return "", "", fmt.Errorf("error extracting audio: %w", err)
+		}
+		logger.Successf("Successfully extracted audio to %s\n", audioPath)
+	}
+
+	// Extract subtitles if requested
+	var subtitleExtractor *subtitle.Extractor
+	if opts.ExtractSubtitle {
+		subtitleExtractor, err = subtitle.NewExtractor()
+		if err != nil {
+			if !skipMissingTool(opts, err) {
+				return "", "", fmt.Errorf("error initializing subtitle extractor: %w", err)
+			}
+			logger.Warnf("Warning: skipping subtitle extraction: %v\n", err)
+			logger.Warnf("Extracted audio kept at %s\n", audioPath)
+		}
+	}
+	if subtitleExtractor != nil {
+		subtitleExtractor.Translate = opts.SubtitleTranslate
+		subtitleExtractor.WordTimestamps = opts.SubtitleWords
+
+		formats := opts.SubtitleFormats
+		if len(formats) == 0 {
+			formats = []string{subtitle.FormatSRT}
+		}
+
+		// Determine subtitle output path
+		subtitlePath = opts.SubtitleOutput
+		if subtitlePath == "" {
+			// Default to same name as audio file but with the extension
+			// of the (first) subtitle format
+			ext := filepath.Ext(audioPath)
+			subtitlePath = audioPath[:len(audioPath)-len(ext)] + "." + formats[0]
+		}
+
+		paths := subtitle.OutputPaths(subtitlePath, formats)
+		logger.Infof("Extracting subtitles to: %s (model: %s)\n", strings.Join(paths, ", "), opts.SubtitleModel)
+		paths, err := subtitleExtractor.ExtractSubtitle(audioPath, subtitlePath, opts.SubtitleLanguage, opts.SubtitleModel, formats)
+		if err != nil {
+			return "", "", fmt.Errorf("error extracting subtitles: %w", err)
+		}
+		// Reported and passed to --exec as the primary subtitle file
+		subtitlePath = paths[0]
+		c.result.Subtitles = paths
+		logger.Successf("Successfully extracted subtitles to %s\n", strings.Join(paths, ", "))
+	}
+
+	// If audio-only mode, delete the video file
+	if opts.AudioOnly {
+		if err := os.Remove(videoPath); err != nil {
+			logger.Warnf("Warning: failed to remove temporary video file: %v\n", err)
+		} else {
+			logger.Infof("Removed temporary video file: %s\n", videoPath)
+		}
+		c.result.Output = ""
+	}
+	return audioPath, subtitlePath, nil
+}
@@ -0,0 +1,87 @@
+package capture
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// progressPrinter prints the per-segment progress lines. With throttling
+// enabled, lines are printed at most once per interval: on a terminal they
+// overwrite each other in place, otherwise only the latest line of each
+// interval is printed.
+type progressPrinter struct {
+	logger   *Logger
+	throttle bool
+	interval time.Duration
+	tty      bool
+
+	last    time.Time
+	pending string
+	inPlace bool // an in-place line is on screen without a newline
+}
+
+func newProgressPrinter(logger *Logger, throttle bool, interval time.Duration) *progressPrinter {
+	return &progressPrinter{
+		logger:   logger,
+		throttle: throttle,
+		interval: interval,
+		tty:      isTerminal(logger.out()) && logger.slog == nil,
+	}
+}
+
+// Update reports the latest progress line.
+func (p *progressPrinter) Update(line string) {
+	if !p.logger.Enabled(slog.LevelInfo) {
+		return
+	}
+	if !p.throttle {
+		p.logger.Infof("%s\n", line)
+		return
+	}
+
+	p.pending = line
+	if time.Since(p.last) >= p.interval {
+		p.print()
+	}
+}
+
+// Flush prints the last progress line held back by throttling and ends the
+// in-place line.
+func (p *progressPrinter) Flush() {
+	if p.pending != "" {
+		p.print()
+	}
+	p.breakLine()
+}
+
+func (p *progressPrinter) print() {
+	if p.tty {
+		// Return to the line start and clear it before rewriting
+		fmt.Fprint(p.logger.out(), "\r\033[K"+p.logger.prefix+p.pending)
+		if p.logger.file != nil {
+			p.logger.writeFile(p.pending)
+		}
+		p.inPlace = true
+	} else {
+		p.logger.Infof("%s\n", p.pending)
+	}
+	p.pending = ""
+	p.last = time.Now()
+}
+
+// breakLine finishes any in-place progress line so that a following
+// message doesn't run into it.
+func (p *progressPrinter) breakLine() {
+	if p.inPlace {
+		fmt.Fprintln(p.logger.out())
+		p.inPlace = false
+	}
+}
+
+// isTerminal reports whether f is attached to a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,92 @@
+package capture
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bariiss/stream-capture/internal/audio"
+	"github.com/bariiss/stream-capture/internal/hls"
+)
+
+// Boundaries of Options.AudioSplitOn.
+const (
+	SplitOnDiscontinuity = "discontinuity"
+	SplitOnChapter       = "chapter"
+)
+
+// audioPart is one numbered audio file produced by --audio-split-on.
+type audioPart struct {
+	File          string  `json:"file"`
+	Start         float64 `json:"start"`
+	Duration      float64 `json:"duration"`
+	FirstSequence int     `json:"first_sequence"`
+	LastSequence  int     `json:"last_sequence"`
+}
+
+// splitTimeline groups the merged segments into consecutive parts, starting
+// a new part at each discontinuity or, for chapters, at each jump in the
+// program date-time timeline. Start offsets are relative to the merged file.
+func splitTimeline(segments []*hls.Segment, mode string) []audioPart {
+	var parts []audioPart
+	var offset float64
+
+	for i, seg := range segments {
+		boundary := i == 0
+		switch mode {
+		case SplitOnDiscontinuity:
+			boundary = boundary || seg.Discontinuity
+		case SplitOnChapter:
+			boundary = boundary || seg.DateJump
+		}
+
+		if boundary {
+			parts = append(parts, audioPart{Start: offset, FirstSequence: seg.Sequence})
+		}
+
+		part := &parts[len(parts)-1]
+		part.Duration += seg.Duration
+		part.LastSequence = seg.Sequence
+		offset += seg.Duration
+	}
+
+	return parts
+}
+
+// extractSplitAudio extracts one numbered audio file per timeline part next
+// to audioOutputPath (name.part01.mp3, ...) and writes a JSON index of the
+// parts. Returns the path of the index.
+func extractSplitAudio(logger *Logger, extractor *audio.Extractor, videoPath, audioOutputPath string, segments []*hls.Segment, mode string) (string, error) {
+	parts := splitTimeline(segments, mode)
+	if len(parts) == 0 {
+		return "", fmt.Errorf("no segments to split")
+	}
+
+	ext := filepath.Ext(audioOutputPath)
+	base := audioOutputPath[:len(audioOutputPath)-len(ext)]
+
+	for i := range parts {
+		part := &parts[i]
+		part.File = fmt.Sprintf("%s.part%02d%s", base, i+1, ext)
+
+		logger.Infof("Extracting audio part %d/%d (segments %d-%d) to: %s\n", i+1, len(parts), part.FirstSequence, part.LastSequence, part.File)
+		start := time.Duration(part.Start * float64(time.Second))
+		duration := time.Duration(part.Duration * float64(time.Second))
+		if err := extractor.ExtractAudioRange(videoPath, part.File, start, duration); err != nil {
+			return "", fmt.Errorf("part %d: %w", i+1, err)
+		}
+	}
+
+	indexPath := base + ".index.json"
+	data, err := json.MarshalIndent(parts, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode audio index: %w", err)
+	}
+	if err := os.WriteFile(indexPath, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write audio index: %w", err)
+	}
+
+	return indexPath, nil
+}
@@ -0,0 +1,53 @@
+package capture
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Stats summarizes the transfers of a capture.
+type Stats struct {
+	// Segments is the number of segments downloaded and FailedSegments
+	// the number given up on after their retries were used up.
+	Segments       int
+	FailedSegments int
+	// Retries counts the repeated playlist and segment requests.
+	Retries int
+	// Bytes is the number of bytes received for segments, including
+	// those of failed attempts.
+	Bytes int64
+	// Elapsed is the wall-clock time of the capture and StreamTime the
+	// sum of the downloaded segments' durations.
+	Elapsed    time.Duration
+	StreamTime time.Duration
+}
+
+// Throughput returns the average number of bytes received per second.
+func (s Stats) Throughput() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.Elapsed.Seconds()
+}
+
+// MarshalJSON encodes the stats with durations in seconds and the average
+// throughput included.
+func (s Stats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Segments          int     `json:"segments"`
+		FailedSegments    int     `json:"failed_segments"`
+		Retries           int     `json:"retries"`
+		Bytes             int64   `json:"bytes"`
+		ElapsedSeconds    float64 `json:"elapsed_seconds"`
+		StreamTimeSeconds float64 `json:"stream_time_seconds"`
+		Throughput        float64 `json:"throughput_bytes_per_second"`
+	}{
+		Segments:          s.Segments,
+		FailedSegments:    s.FailedSegments,
+		Retries:           s.Retries,
+		Bytes:             s.Bytes,
+		ElapsedSeconds:    s.Elapsed.Seconds(),
+		StreamTimeSeconds: s.StreamTime.Seconds(),
+		Throughput:        s.Throughput(),
+	})
+}
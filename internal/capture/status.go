@@ -0,0 +1,206 @@
+package capture
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// ANSI colors used for status messages.
+const (
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorReset  = "\033[0m"
+)
+
+// Logger prints status messages by kind: errors (red) and warnings
+// (yellow) go to stderr, waits (yellow), successes (green), plain
+// information and debug details to stdout, or to stderr after UseStderr.
+// Messages below the logger's level are dropped. The zero Logger prints
+// from slog.LevelInfo up, without color.
+//
+// A Logger created by NewSlogLogger passes its messages to a slog.Logger
+// as records instead.
+type Logger struct {
+	stdoutColor bool
+	stderrColor bool
+	// stdout receives the messages meant for stdout; nil means os.Stdout.
+	stdout *os.File
+
+	// prefix is prepended to every line, identifying the stream when
+	// several captures share the output.
+	prefix string
+	// file, if set, receives a timestamped, uncolored copy of every line.
+	file io.Writer
+
+	// beforeWrite, if set, runs before every message, e.g. to finish an
+	// in-place progress line.
+	beforeWrite func()
+
+	// level is the lowest level printed.
+	level slog.Level
+	// slog, if set, receives the messages instead of stdout and stderr.
+	slog *slog.Logger
+}
+
+// NewLogger returns a Logger coloring its messages on terminals, unless
+// noColor is set or the NO_COLOR environment variable is.
+func NewLogger(noColor bool) *Logger {
+	color := !noColor && os.Getenv("NO_COLOR") == ""
+	return &Logger{
+		stdoutColor: color && isTerminal(os.Stdout),
+		stderrColor: color && isTerminal(os.Stderr),
+	}
+}
+
+// NewSlogLogger returns a Logger passing each line of its messages to
+// logger as a record: Debugf at slog.LevelDebug, Warnf at slog.LevelWarn,
+// Errorf at slog.LevelError and the others at slog.LevelInfo. Which levels
+// are kept is up to logger's handler.
+func NewSlogLogger(logger *slog.Logger) *Logger {
+	return &Logger{slog: logger, level: slog.LevelDebug}
+}
+
+// SetLevel drops the messages below level from then on.
+func (l *Logger) SetLevel(level slog.Level) {
+	l.level = level
+}
+
+// UseStderr prints the messages meant for stdout to stderr from then on,
+// keeping stdout for data such as a merged stream.
+func (l *Logger) UseStderr() {
+	l.stdout = os.Stderr
+	l.stdoutColor = l.stderrColor
+}
+
+// out returns the file receiving the messages meant for stdout.
+func (l *Logger) out() *os.File {
+	if l.stdout != nil {
+		return l.stdout
+	}
+	return os.Stdout
+}
+
+// Enabled reports whether messages at level are printed.
+func (l *Logger) Enabled(level slog.Level) bool {
+	if level < l.level {
+		return false
+	}
+	return l.slog == nil || l.slog.Enabled(context.Background(), level)
+}
+
+// forStream returns a copy of l for one capture, prefixing its lines with
+// "[id] " (unless id is empty) and copying them to file when set. Records
+// passed to a slog.Logger carry the id as their "stream" attribute.
+func (l *Logger) forStream(id string, file io.Writer) *Logger {
+	c := *l
+	if id != "" {
+		c.prefix = "[" + id + "] "
+		if c.slog != nil {
+			c.slog = c.slog.With("stream", id)
+		}
+	}
+	c.file = file
+	return &c
+}
+
+// Debugf prints a detail only wanted when diagnosing a capture.
+func (l *Logger) Debugf(format string, args ...any) {
+	l.write(slog.LevelDebug, l.out(), "", format, args)
+}
+
+// Infof prints an uncolored message to stdout.
+func (l *Logger) Infof(format string, args ...any) {
+	l.write(slog.LevelInfo, l.out(), "", format, args)
+}
+
+// Successf prints a message reporting a completed step.
+func (l *Logger) Successf(format string, args ...any) {
+	l.write(slog.LevelInfo, l.out(), l.colorIf(l.stdoutColor, colorGreen), format, args)
+}
+
+// Waitf prints a message reporting that the capture is waiting.
+func (l *Logger) Waitf(format string, args ...any) {
+	l.write(slog.LevelInfo, l.out(), l.colorIf(l.stdoutColor, colorYellow), format, args)
+}
+
+// Warnf prints a warning to stderr.
+func (l *Logger) Warnf(format string, args ...any) {
+	l.write(slog.LevelWarn, os.Stderr, l.colorIf(l.stderrColor, colorYellow), format, args)
+}
+
+// Errorf prints an error to stderr.
+func (l *Logger) Errorf(format string, args ...any) {
+	l.write(slog.LevelError, os.Stderr, l.colorIf(l.stderrColor, colorRed), format, args)
+}
+
+func (l *Logger) colorIf(enabled bool, color string) string {
+	if enabled {
+		return color
+	}
+	return ""
+}
+
+// write formats a message at level, prefixes its lines and wraps their
+// text, but not the surrounding newlines, in color.
+func (l *Logger) write(level slog.Level, w io.Writer, color, format string, args []any) {
+	if !l.Enabled(level) {
+		return
+	}
+	if l.beforeWrite != nil {
+		l.beforeWrite()
+	}
+
+	msg := fmt.Sprintf(format, args...)
+	if l.file != nil {
+		l.writeFile(msg)
+	}
+	if l.slog != nil {
+		// Records have no layout: blank lines are dropped and the
+		// indentation of the others trimmed
+		for line := range strings.Lines(msg) {
+			if text := strings.TrimSpace(line); text != "" {
+				l.slog.Log(context.Background(), level, text)
+			}
+		}
+		return
+	}
+	if color == "" && l.prefix == "" {
+		fmt.Fprint(w, msg)
+		return
+	}
+
+	var b strings.Builder
+	for line := range strings.Lines(msg) {
+		text, newline := strings.CutSuffix(line, "\n")
+		if text != "" {
+			b.WriteString(l.prefix)
+			if color != "" {
+				text = color + text + colorReset
+			}
+			b.WriteString(text)
+		}
+		if newline {
+			b.WriteString("\n")
+		}
+	}
+	fmt.Fprint(w, b.String())
+}
+
+// writeFile appends the non-empty lines of msg to the log file, each
+// with a timestamp.
+func (l *Logger) writeFile(msg string) {
+	now := time.Now().Format(time.RFC3339)
+	var b strings.Builder
+	for line := range strings.Lines(msg) {
+		if text := strings.TrimRight(line, "\n"); text != "" {
+			fmt.Fprintf(&b, "%s %s%s\n", now, l.prefix, text)
+		}
+	}
+	io.WriteString(l.file, b.String())
+}
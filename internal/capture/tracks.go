@@ -0,0 +1,276 @@
+package capture
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bariiss/stream-capture/internal/downloader"
+	"github.com/bariiss/stream-capture/internal/ffmpeg"
+	"github.com/bariiss/stream-capture/internal/hls"
+	"github.com/bariiss/stream-capture/internal/subtitle"
+)
+
+// track is a rendition with its own media playlist, captured alongside the
+// video: after each video segment is downloaded, the track segments
+// covering the same stretch of the stream are fetched into a download
+// manager of their own.
+type track struct {
+	// kind names the track in messages, e.g. "subtitle"
+	kind      string
+	rendition *hls.Rendition
+	playlists *hls.PlaylistPoller
+	previous  *hls.Playlist
+	manager   *downloader.Manager
+	// downloaded are the track sequences fetched so far
+	downloaded map[int]bool
+	// unmatched are the video segments whose track segments the track
+	// playlist didn't list yet
+	unmatched []*hls.Segment
+}
+
+// newTrack sets up the capture of rendition, downloading its segments into
+// a directory of the capture's temporary directory.
+func (c *capturer) newTrack(kind string, rendition *hls.Rendition) (*track, error) {
+	manager, err := downloader.NewManagerWithFetcher(filepath.Join(c.tempDir, kind), c.fetcher)
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s download manager: %w", kind, err)
+	}
+	manager.KeyOverride = c.opts.KeyOverride
+	return &track{
+		kind:       kind,
+		rendition:  rendition,
+		playlists:  hls.NewPlaylistPoller(c.fetcher, rendition.URL, hls.ParseOptions{SequenceStrategy: c.opts.SequenceStrategy}),
+		manager:    manager,
+		downloaded: make(map[int]bool),
+	}, nil
+}
+
+// fetchTracks fetches the segments of every track covering the downloaded
+// video segment. A track is secondary to the video, so its failures are
+// only reported.
+func (c *capturer) fetchTracks(ctx context.Context, segment *hls.Segment) {
+	for _, t := range c.tracks {
+		t.unmatched = append(t.unmatched, segment)
+		c.syncTrack(ctx, t)
+	}
+}
+
+// finishTracks makes a last attempt at the video segments whose track
+// segments weren't listed yet, reporting those still missing.
+func (c *capturer) finishTracks(ctx context.Context) {
+	for _, t := range c.tracks {
+		if len(t.unmatched) == 0 {
+			continue
+		}
+		c.syncTrack(ctx, t)
+		if len(t.unmatched) > 0 {
+			sequences := make([]int, len(t.unmatched))
+			for i, seg := range t.unmatched {
+				sequences[i] = seg.Sequence
+			}
+			c.logger.Warnf("Warning: no %s segments found for video segments %v\n", t.kind, sequences)
+		}
+	}
+}
+
+// syncTrack polls the track playlist and downloads the track segments of
+// the video segments it now covers.
+func (c *capturer) syncTrack(ctx context.Context, t *track) {
+	playlist, _, err := t.playlists.Poll(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			c.logger.Warnf("Warning: error polling the %s playlist: %v\n", t.kind, err)
+		}
+		return
+	}
+	t.previous = playlist
+
+	t.unmatched = slices.DeleteFunc(t.unmatched, func(video *hls.Segment) bool {
+		segments, ok := trackSegments(playlist, video)
+		if !ok {
+			return false
+		}
+		for _, seg := range segments {
+			if t.downloaded[seg.Sequence] {
+				continue
+			}
+			_, n, err := t.manager.DownloadSegment(c.downloadCtx, seg)
+			c.received.Add(n)
+			if err != nil {
+				c.logger.Warnf("Warning: error downloading %s segment %d: %v\n", t.kind, seg.Sequence, err)
+				continue
+			}
+			t.downloaded[seg.Sequence] = true
+		}
+		return true
+	})
+}
+
+// trackSegments returns the segments of a track playlist covering the
+// video segment, and whether the playlist settles them: once it lists
+// segments past the video segment, or has ended. Segments are matched by
+// program date-time when both carry one, otherwise by sequence number, as
+// renditions of one stream are usually segmented alike.
+func trackSegments(playlist *hls.Playlist, video *hls.Segment) ([]*hls.Segment, bool) {
+	last := hls.GetLastSegment(playlist.Segments)
+	if last == nil {
+		return nil, playlist.EndList
+	}
+
+	if !video.ProgramDateTime.IsZero() && !last.ProgramDateTime.IsZero() {
+		var matched []*hls.Segment
+		for _, seg := range playlist.Segments {
+			if seg.ProgramDateTime.Before(video.EndDateTime()) && seg.EndDateTime().After(video.ProgramDateTime) {
+				matched = append(matched, seg)
+			}
+		}
+		return matched, playlist.EndList || !last.EndDateTime().Before(video.EndDateTime())
+	}
+
+	for _, seg := range playlist.Segments {
+		if seg.Sequence == video.Sequence {
+			return []*hls.Segment{seg}, true
+		}
+	}
+	// A sequence already gone from the window won't come back
+	return nil, playlist.EndList || video.Sequence < playlist.MinSequence()
+}
+
+// sequences returns the downloaded track sequences in order.
+func (t *track) sequences() []int {
+	sequences := make([]int, 0, len(t.downloaded))
+	for seq := range t.downloaded {
+		sequences = append(sequences, seq)
+	}
+	slices.Sort(sequences)
+	return sequences
+}
+
+// mediaStart returns the offset that rebases a track onto the merged video
+// at videoPath: the video's first presentation timestamp, the origin of
+// the WebVTT X-TIMESTAMP-MAP, plus the start trimmed from the video.
+func (c *capturer) mediaStart(videoPath string) (time.Duration, error) {
+	start, err := ffmpeg.StartTime(videoPath)
+	if err != nil {
+		return 0, err
+	}
+	if c.opts.TrimVideo {
+		start += c.opts.TrimStart
+	}
+	return start, nil
+}
+
+// writeSubtitleTrack merges the captured WebVTT segments into a single
+// file, their cues rebased to start with the merged video at videoPath.
+func (c *capturer) writeSubtitleTrack(t *track, videoPath string) (string, error) {
+	opts := c.opts
+	logger := c.logger
+
+	sequences := t.sequences()
+	if len(sequences) == 0 {
+		logger.Warnf("Warning: no subtitle segments were captured\n")
+		return "", nil
+	}
+
+	offset, err := c.mediaStart(videoPath)
+	if err != nil {
+		if !skipMissingTool(opts, err) {
+			return "", fmt.Errorf("error rebasing subtitles: %w", err)
+		}
+		logger.Warnf("Warning: subtitle cues keep the stream's timeline: %v\n", err)
+		offset = 0
+	}
+
+	path := opts.SubtitleOutput
+	if path == "" {
+		path = strings.TrimSuffix(c.outputFile, filepath.Ext(c.outputFile)) + "." + subtitle.FormatVTT
+	}
+
+	var segments []io.Reader
+	for _, seq := range sequences {
+		segmentPath, _ := t.manager.GetSegmentPath(seq)
+		f, err := os.Open(segmentPath)
+		if err != nil {
+			return "", fmt.Errorf("error reading subtitle segment %d: %w", seq, err)
+		}
+		defer f.Close()
+		segments = append(segments, f)
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("error creating subtitle file: %w", err)
+	}
+	if err := subtitle.MergeWebVTT(out, segments, offset); err != nil {
+		out.Close()
+		return "", fmt.Errorf("error merging subtitles: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return "", fmt.Errorf("error writing subtitle file: %w", err)
+	}
+	logger.Successf("Merged %d subtitle segments into %s (rebased by %v)\n", len(sequences), path, offset.Round(time.Millisecond))
+	return path, nil
+}
+
+// mergeAudioTrack merges the captured audio segments and works out the
+// stretch of them lining up with the merged video at videoPath: from the
+// difference of their first presentation timestamps, for as long as the
+// video lasts. Packed audio without timestamps starts at zero and is taken
+// to start with the video, as its segments were matched to it.
+func (c *capturer) mergeAudioTrack(t *track, videoPath string) error {
+	logger := c.logger
+
+	sequences := t.sequences()
+	if len(sequences) == 0 {
+		logger.Warnf("Warning: no audio segments were captured, extracting the audio from the video\n")
+		return nil
+	}
+
+	first, _ := t.manager.GetSegmentPath(sequences[0])
+	path := filepath.Join(c.tempDir, "audio-track"+filepath.Ext(first))
+	if _, err := t.manager.MergeSegments(path, sequences); err != nil {
+		return fmt.Errorf("error merging audio segments: %w", err)
+	}
+	logger.Successf("Merged %d audio segments into %s\n", len(sequences), path)
+
+	videoStart, audioStart, length, err := alignment(videoPath, path)
+	if err != nil {
+		if !skipMissingTool(c.opts, err) {
+			return fmt.Errorf("error aligning audio: %w", err)
+		}
+		logger.Warnf("Warning: skipping audio alignment: %v\n", err)
+		return nil
+	}
+
+	var offset time.Duration
+	if audioStart > 0 {
+		offset = videoStart - audioStart
+	}
+	if offset < 0 {
+		logger.Warnf("Warning: the audio starts %v after the video\n", (-offset).Round(time.Millisecond))
+		offset = 0
+	}
+	c.audioSource, c.audioOffset, c.audioLength = path, offset, length
+	return nil
+}
+
+// alignment probes the start of the video and audio files and the length
+// of the video.
+func alignment(videoPath, audioPath string) (videoStart, audioStart, length time.Duration, err error) {
+	if videoStart, err = ffmpeg.StartTime(videoPath); err != nil {
+		return 0, 0, 0, err
+	}
+	if audioStart, err = ffmpeg.StartTime(audioPath); err != nil {
+		return 0, 0, 0, err
+	}
+	if length, err = ffmpeg.Duration(videoPath); err != nil {
+		return 0, 0, 0, err
+	}
+	return videoStart, audioStart, length, nil
+}
@@ -0,0 +1,141 @@
+package capture
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// renditionStream serves a master playlist whose variant has an audio
+// rendition and a WebVTT subtitle rendition. Every media playlist holds
+// segments 0-3 of two seconds; the cues of subtitle segment N sit half a
+// second into it on an MPEG-TS timeline starting at 10s. Requested audio
+// segments are recorded in audioFetched.
+type renditionStream struct {
+	mu           sync.Mutex
+	audioFetched []int
+}
+
+func (s *renditionStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var seq int
+	switch {
+	case r.URL.Path == "/master.m3u8":
+		fmt.Fprint(w, "#EXTM3U\n"+
+			"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aud\",NAME=\"English\",LANGUAGE=\"en\",DEFAULT=YES,URI=\"audio.m3u8\"\n"+
+			"#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"subs\",NAME=\"English\",LANGUAGE=\"en\",URI=\"subs.m3u8\"\n"+
+			"#EXT-X-STREAM-INF:BANDWIDTH=1000,AUDIO=\"aud\",SUBTITLES=\"subs\"\n"+
+			"video.m3u8\n")
+	case r.URL.Path == "/video.m3u8" || r.URL.Path == "/audio.m3u8" || r.URL.Path == "/subs.m3u8":
+		name, ext := "seg", ".ts"
+		switch r.URL.Path {
+		case "/audio.m3u8":
+			name, ext = "aud", ".aac"
+		case "/subs.m3u8":
+			name, ext = "sub", ".vtt"
+		}
+		var b strings.Builder
+		b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:0\n")
+		for i := range 4 {
+			fmt.Fprintf(&b, "#EXTINF:2.0,\n%s%d%s\n", name, i, ext)
+		}
+		b.WriteString("#EXT-X-ENDLIST\n")
+		fmt.Fprint(w, b.String())
+	case scan(r.URL.Path, "/seg%d.ts", &seq):
+		w.Header().Set("Content-Type", "video/mp2t")
+		fmt.Fprintf(w, "segment %d\n", seq)
+	case scan(r.URL.Path, "/aud%d.aac", &seq):
+		s.mu.Lock()
+		s.audioFetched = append(s.audioFetched, seq)
+		s.mu.Unlock()
+		w.Header().Set("Content-Type", "audio/aac")
+		fmt.Fprintf(w, "audio %d\n", seq)
+	case scan(r.URL.Path, "/sub%d.vtt", &seq):
+		w.Header().Set("Content-Type", "text/vtt")
+		fmt.Fprintf(w, "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:900000,LOCAL:00:00:00.000\n\n00:00:%02d.500 --> 00:00:%02d.500\ncue %d\n", seq*2, seq*2+1, seq)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// scan reports whether s matches format, parsing its verbs into args.
+func scan(s, format string, args ...any) bool {
+	n, err := fmt.Sscanf(s, format, args...)
+	return err == nil && n == len(args)
+}
+
+func TestRunSubtitleTrack(t *testing.T) {
+	// The merged video starts with segment 2, 14s into the stream
+	fakeFFmpeg(t, "14.000000")
+	server := httptest.NewServer(&renditionStream{})
+	t.Cleanup(server.Close)
+
+	opts := testOptions(t, server.URL+"/master.m3u8")
+	start := 2
+	opts.StartSequence = &start
+	opts.SegmentCount = 2
+	opts.SubtitleTrack = "en"
+	result, err := Run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+
+	path := strings.TrimSuffix(opts.Output, ".ts") + ".vtt"
+	if len(result.Subtitles) != 1 || result.Subtitles[0] != path {
+		t.Fatalf("Subtitles = %v, want [%s]", result.Subtitles, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The cues are shifted from the stream's timeline to the capture's
+	want := "WEBVTT\n\n" +
+		"00:00:00.500 --> 00:00:01.500\ncue 2\n\n" +
+		"00:00:02.500 --> 00:00:03.500\ncue 3\n\n"
+	if string(data) != want {
+		t.Errorf("subtitles = %q, want %q", data, want)
+	}
+}
+
+func TestRunSplitTracks(t *testing.T) {
+	fakeFFmpeg(t, "14.000000")
+	stream := &renditionStream{}
+	server := httptest.NewServer(stream)
+	t.Cleanup(server.Close)
+
+	opts := testOptions(t, server.URL+"/master.m3u8")
+	start := 2
+	opts.StartSequence = &start
+	opts.SegmentCount = 2
+	opts.ExtractAudio = true
+	opts.SplitTracks = true
+	result, err := Run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+
+	// The audio rendition is captured alongside the video instead of
+	// being extracted from it
+	stream.mu.Lock()
+	fetched := slices.Sorted(slices.Values(stream.audioFetched))
+	stream.mu.Unlock()
+	if !slices.Equal(fetched, []int{2, 3}) {
+		t.Errorf("audio segments fetched = %v, want [2 3]", fetched)
+	}
+	base := strings.TrimSuffix(opts.Output, ".ts")
+	if result.Audio != base+".mp3" {
+		t.Errorf("Audio = %q, want %q", result.Audio, base+".mp3")
+	}
+	if _, err := os.Stat(result.Audio); err != nil {
+		t.Errorf("audio output: %v", err)
+	}
+	// The subtitle rendition replaces Whisper
+	if len(result.Subtitles) != 1 || result.Subtitles[0] != base+".vtt" {
+		t.Errorf("Subtitles = %v, want [%s]", result.Subtitles, base+".vtt")
+	}
+}
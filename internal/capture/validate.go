@@ -0,0 +1,294 @@
+package capture
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/bariiss/stream-capture/internal/frame"
+	"github.com/bariiss/stream-capture/internal/hls"
+	"github.com/bariiss/stream-capture/internal/subtitle"
+)
+
+// Validate checks the options without fetching anything, so a combination
+// the capture can't honour is reported before it starts. Run calls it
+// first; the errors name the stream-capture flags the options mirror.
+func (o Options) Validate() error {
+	if err := o.validateRange(); err != nil {
+		return err
+	}
+	if err := o.validateDownload(); err != nil {
+		return err
+	}
+	if err := o.validateOutputs(); err != nil {
+		return err
+	}
+	return o.validateProcessing()
+}
+
+// validateRange checks the options selecting the segments to capture.
+func (o Options) validateRange() error {
+	switch {
+	case o.LiveDelay < 0:
+		return fmt.Errorf("--live-delay must not be negative")
+	case o.Duration < 0:
+		return fmt.Errorf("--duration must be positive")
+	case o.Duration > 0 && o.Preview:
+		return fmt.Errorf("--duration cannot be combined with --preview")
+	case o.StartSequence != nil && *o.StartSequence < 0:
+		return fmt.Errorf("--start-sequence and --end-sequence must not be negative")
+	case o.StartSequence != nil && o.Preview:
+		return fmt.Errorf("--preview cannot be combined with --start-sequence or --end-sequence")
+	}
+
+	switch o.From {
+	case "", FromLatest:
+	case FromStart:
+		if o.StartSequence != nil || o.Preview {
+			return fmt.Errorf("--from cannot be combined with --start-sequence, --end-sequence or --preview")
+		}
+	default:
+		return fmt.Errorf("invalid --from %q: use %s, %s or a media sequence number", o.From, FromLatest, FromStart)
+	}
+
+	if !o.StartTime.IsZero() && (o.StartSequence != nil || o.From == FromStart || o.Preview) {
+		return fmt.Errorf("--start-time cannot be combined with --start-sequence, --end-sequence, --from or --preview")
+	}
+	if !o.EndTime.IsZero() {
+		if o.Duration > 0 || o.Preview {
+			return fmt.Errorf("--end-time cannot be combined with --duration or --preview")
+		}
+		if !o.StartTime.IsZero() && !o.EndTime.After(o.StartTime) {
+			return fmt.Errorf("--end-time must be after --start-time")
+		}
+	}
+
+	switch o.VODCount {
+	case "", VODFirst, VODLast:
+	default:
+		return fmt.Errorf("invalid --vod-count %q: use %s or %s", o.VODCount, VODFirst, VODLast)
+	}
+
+	if o.StateFile != "" && (o.Duration > 0 || o.From == FromStart || o.StartSequence != nil ||
+		!o.StartTime.IsZero() || !o.EndTime.IsZero() || o.Preview || o.Pipeline) {
+		return fmt.Errorf("--resume cannot be combined with --duration, --from, --start-sequence, --end-sequence, --start-time, --end-time, --preview or --pipeline")
+	}
+	return nil
+}
+
+// validateDownload checks the options of playlist polling and segment
+// downloads.
+func (o Options) validateDownload() error {
+	switch {
+	case o.PollInterval < 0:
+		return fmt.Errorf("--interval must not be negative")
+	case o.StaleTimeout < 0:
+		return fmt.Errorf("--stale-timeout must not be negative")
+	case o.AdaptivePolling && (o.MinPollInterval <= 0 || o.MaxPollInterval < o.MinPollInterval):
+		return fmt.Errorf("--min-interval must be positive and not above --max-interval")
+	case o.ProbeSegments && o.LiveDelay > 0:
+		return fmt.Errorf("--probe-segments cannot be combined with --live-delay, which waits for segments the playlist already lists")
+	case o.MaxConsecutiveErrors < 0 || o.MaxTotalErrors < 0:
+		return fmt.Errorf("--max-consecutive-errors and --max-total-errors must not be negative")
+	case o.MaxRetries < 0:
+		return fmt.Errorf("--max-retries must not be negative")
+	case o.MaxDiskBytes < 0:
+		return fmt.Errorf("--max-disk must be positive")
+	case o.MaxDiskBytes > 0 && (o.StateFile != "" || o.Pipeline):
+		// The state and the streamed audio would cover discarded segments
+		return fmt.Errorf("--max-disk cannot be combined with --resume or --pipeline")
+	}
+
+	switch o.Priority {
+	case "", PriorityOldest, PriorityNewest:
+	default:
+		return fmt.Errorf("invalid --priority %q: use %s or %s", o.Priority, PriorityOldest, PriorityNewest)
+	}
+	switch o.ResumeMode {
+	case "", ResumeCatchUp, ResumeLive:
+	default:
+		return fmt.Errorf("invalid --resume-mode %q: use %s or %s", o.ResumeMode, ResumeCatchUp, ResumeLive)
+	}
+
+	if _, err := ParseVariantPreference(o.Variant); err != nil {
+		return err
+	}
+	if o.IFrameVariant != "" && o.Variant != "" {
+		return fmt.Errorf("--variant cannot be combined with --iframe-variant")
+	}
+
+	if o.ProxyURL != "" {
+		if _, err := hls.ParseProxyURL(o.ProxyURL); err != nil {
+			return err
+		}
+	}
+	if o.WebhookURL != "" {
+		if u, err := url.Parse(o.WebhookURL); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid --webhook-url %q: expected an http or https URL", o.WebhookURL)
+		}
+	} else if o.WebhookSecret != "" {
+		return fmt.Errorf("--webhook-secret requires --webhook-url")
+	}
+	return nil
+}
+
+// validateOutputs checks the files the capture writes.
+func (o Options) validateOutputs() error {
+	if o.Output == "" && !o.DryRun {
+		return fmt.Errorf("either -output or -merge flag is required")
+	}
+	if o.AudioOnly && o.AudioOutput == "" {
+		return fmt.Errorf("--audio-output is required when using --audio-only")
+	}
+
+	// Stdout receives the merged bytes and nothing else: whatever needs the
+	// merged file can't run.
+	if o.Output == StdoutOutput {
+		switch {
+		case o.ExtractAudio || o.ExtractSubtitle || o.AudioOnly || o.SplitTracks || o.AudioSplitOn != "" || o.SubtitleTrack != "":
+			return fmt.Errorf("--output - cannot be combined with --audio, --audio-only, --subtitle, --subtitle-track, --split-tracks or --audio-split-on: they need the merged file, give a file path instead")
+		case len(o.ExtraOutputs) > 0:
+			return fmt.Errorf("--output - cannot be combined with further outputs")
+		case o.Transcode || o.Verify || o.TrimVideo || o.ChecksumFile || o.Thumbnail != "" || o.Upload != nil ||
+			(o.OnDiscontinuity != "" && o.OnDiscontinuity != DiscontinuityIgnore):
+			return fmt.Errorf("--output - cannot be combined with --transcode, --verify, --trim-video, --checksum-file, --thumbnail, --s3-bucket or --on-discontinuity %s/%s: they need the merged file, give a file path instead", DiscontinuitySplit, DiscontinuityRemux)
+		}
+	}
+
+	// Split parts replace the single merged file everything else works on;
+	// remuxed parts are joined into the primary output only.
+	switch o.OnDiscontinuity {
+	case "", DiscontinuityIgnore:
+	case DiscontinuitySplit:
+		if o.ExtractAudio || o.AudioOnly || o.ExtractSubtitle || o.SplitTracks || o.Verify || o.Transcode || o.Pipeline || len(o.ExtraOutputs) > 0 {
+			return fmt.Errorf("--on-discontinuity %s cannot be combined with --audio, --audio-only, --subtitle, --split-tracks, --verify, --transcode, --pipeline or several outputs", DiscontinuitySplit)
+		}
+	case DiscontinuityRemux:
+		if o.RawConcat || len(o.ExtraOutputs) > 0 {
+			return fmt.Errorf("--on-discontinuity %s cannot be combined with --raw-concat or several outputs", DiscontinuityRemux)
+		}
+	default:
+		return fmt.Errorf("invalid --on-discontinuity %q: use %s, %s or %s", o.OnDiscontinuity, DiscontinuityIgnore, DiscontinuitySplit, DiscontinuityRemux)
+	}
+
+	if o.Thumbnail != "" {
+		if err := frame.ValidateOutput(o.Thumbnail); err != nil {
+			return fmt.Errorf("invalid --thumbnail: %w", err)
+		}
+	}
+	return nil
+}
+
+// validateProcessing checks the extraction and rewriting of the merged
+// file.
+func (o Options) validateProcessing() error {
+	// Subtitles are transcribed from the extracted audio, and audio-only
+	// mode keeps nothing else
+	if (o.ExtractSubtitle || o.AudioOnly || o.SplitTracks || o.AudioSplitOn != "") && !o.ExtractAudio {
+		return fmt.Errorf("--subtitle, --audio-only, --split-tracks and --audio-split-on require --audio")
+	}
+	if o.AudioTrack != "" && !o.ExtractAudio {
+		return fmt.Errorf("--audio-track requires --audio, --audio-only or --subtitle")
+	}
+	if o.ExtractAudio {
+		if err := o.AudioOptions.Validate(); err != nil {
+			return fmt.Errorf("invalid audio settings: %w", err)
+		}
+	}
+	if o.ExtractSubtitle || o.SplitTracks {
+		if err := subtitle.ValidateModel(o.SubtitleModel); err != nil {
+			return fmt.Errorf("invalid --subtitle-model: %w", err)
+		}
+	}
+
+	switch o.AudioSplitOn {
+	case "", SplitOnDiscontinuity, SplitOnChapter:
+	default:
+		return fmt.Errorf("invalid --audio-split-on %q: use %s or %s", o.AudioSplitOn, SplitOnDiscontinuity, SplitOnChapter)
+	}
+	if o.AudioSplitOn != "" && o.ExtractSubtitle {
+		return fmt.Errorf("--audio-split-on cannot be combined with --subtitle")
+	}
+
+	// Split tracks derive every path from the video output so the three
+	// files always share a base name, and are aligned on the single merged
+	// video, trimmed along with them.
+	if o.SplitTracks {
+		switch {
+		case o.AudioOnly || o.AudioOutput != "" || o.SubtitleOutput != "" || o.AudioSplitOn != "":
+			return fmt.Errorf("--split-tracks cannot be combined with --audio-only, --audio-output, --subtitle-output or --audio-split-on")
+		case o.Pipeline || o.StateFile != "" || (o.OnDiscontinuity != "" && o.OnDiscontinuity != DiscontinuityIgnore):
+			return fmt.Errorf("--split-tracks cannot be combined with --pipeline, --resume or --on-discontinuity %s/%s", DiscontinuitySplit, DiscontinuityRemux)
+		case (o.TrimStart > 0 || o.TrimEnd > 0) && !o.TrimVideo:
+			return fmt.Errorf("--split-tracks with --trim-start or --trim-end requires --trim-video to keep the tracks aligned")
+		}
+	}
+
+	// The subtitle track is rebased on a single merged file that is kept
+	if o.SubtitleTrack != "" {
+		switch {
+		case o.ExtractSubtitle:
+			return fmt.Errorf("--subtitle-track cannot be combined with --subtitle: use one source of subtitles")
+		case o.AudioOnly || o.StateFile != "" || (o.OnDiscontinuity != "" && o.OnDiscontinuity != DiscontinuityIgnore):
+			return fmt.Errorf("--subtitle-track cannot be combined with --audio-only, --resume or --on-discontinuity %s/%s", DiscontinuitySplit, DiscontinuityRemux)
+		}
+	}
+
+	// Raw concatenation hands the bytes over untouched, so nothing that
+	// needs FFmpeg may run on them.
+	if o.RawConcat && (o.ExtractAudio || o.AudioOnly || o.ExtractSubtitle || o.Verify) {
+		return fmt.Errorf("--raw-concat cannot be combined with --audio, --audio-only, --subtitle or --verify")
+	}
+
+	if o.Transcode {
+		if o.RawConcat || o.AudioOnly {
+			return fmt.Errorf("--transcode cannot be combined with --raw-concat or --audio-only")
+		}
+		// Only the primary output is rewritten, so further copies of the
+		// merge would silently keep the original streams
+		if len(o.ExtraOutputs) > 0 {
+			return fmt.Errorf("--transcode cannot be combined with several outputs")
+		}
+		if err := o.TranscodeOptions.Validate(); err != nil {
+			return fmt.Errorf("invalid --transcode settings: %w", err)
+		}
+	}
+
+	// Audio-only mode deletes the merged file the checksum covers
+	if o.ChecksumFile && o.AudioOnly {
+		return fmt.Errorf("--checksum-file cannot be combined with --audio-only")
+	}
+
+	// The pipeline feeds segments to FFmpeg in download order, so it needs a
+	// single audio file built from segments downloaded oldest first.
+	if o.Pipeline {
+		switch {
+		case !o.ExtractAudio:
+			return fmt.Errorf("--pipeline requires --audio, --audio-only or --subtitle")
+		case o.AudioSplitOn != "" || o.Preview:
+			return fmt.Errorf("--pipeline cannot be combined with --audio-split-on or --preview")
+		case o.Priority == PriorityNewest:
+			return fmt.Errorf("--pipeline cannot be combined with --priority newest")
+		}
+	}
+
+	// Trims are cut from the merged file after the capture, so they need a
+	// single file that FFmpeg processes once the download is complete.
+	if o.TrimStart < 0 || o.TrimEnd < 0 {
+		return fmt.Errorf("--trim-start and --trim-end cannot be negative")
+	}
+	if o.TrimStart > 0 || o.TrimEnd > 0 {
+		switch {
+		case !o.ExtractAudio && !o.TrimVideo:
+			return fmt.Errorf("--trim-start and --trim-end require --audio, --audio-only, --subtitle or --trim-video")
+		case o.Pipeline || o.Preview || o.AudioSplitOn != "" || o.RawConcat || o.OnDiscontinuity == DiscontinuitySplit:
+			return fmt.Errorf("--trim-start and --trim-end cannot be combined with --pipeline, --preview, --audio-split-on, --raw-concat or --on-discontinuity %s", DiscontinuitySplit)
+		}
+	} else if o.TrimVideo {
+		return fmt.Errorf("--trim-video requires --trim-start or --trim-end")
+	}
+	// Audio-only mode keeps no video to trim
+	if o.TrimVideo && o.AudioOnly {
+		return fmt.Errorf("--trim-video cannot be combined with --audio-only")
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package capture
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	start := 5
+	negative := -1
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr string
+	}{
+		{"zero start sequence is unset", Options{Output: "out.ts", From: FromStart}, ""},
+		{"explicit start sequence", Options{Output: "out.ts", StartSequence: &start}, ""},
+		{"negative start sequence", Options{Output: "out.ts", StartSequence: &negative}, "--start-sequence"},
+		{"start sequence with --from start", Options{Output: "out.ts", StartSequence: &start, From: FromStart}, "--from"},
+		{"no output", Options{}, "-output"},
+		{"dry run needs no output", Options{DryRun: true}, ""},
+		{"audio-only without audio output", Options{Output: "out.ts", ExtractAudio: true, AudioOnly: true}, "--audio-output"},
+		{"subtitle without audio", Options{Output: "out.ts", ExtractSubtitle: true}, "require --audio"},
+		{"invalid priority", Options{Output: "out.ts", Priority: "middle"}, "--priority"},
+		{"invalid discontinuity handling", Options{Output: "out.ts", OnDiscontinuity: "drop"}, "--on-discontinuity"},
+		{"stdout with audio", Options{Output: StdoutOutput, ExtractAudio: true}, "--output -"},
+		{"resume with a start sequence", Options{Output: "out.ts", StateFile: "state.json", StartSequence: &start}, "--resume"},
+		{"end before start", Options{Output: "out.ts", StartTime: time.Unix(100, 0), EndTime: time.Unix(50, 0)}, "--end-time"},
+		{"transcode with several outputs", Options{Output: "out.ts", ExtraOutputs: []string{"copy.ts"}, Transcode: true}, "--transcode"},
+		{"subtitle track with whisper", Options{Output: "out.ts", ExtractAudio: true, ExtractSubtitle: true, SubtitleTrack: "en"}, "--subtitle-track"},
+		{"trim video without a trim", Options{Output: "out.ts", TrimVideo: true}, "--trim-video"},
+		{"split tracks trimmed without the video", Options{Output: "out.ts", ExtractAudio: true, SplitTracks: true, TrimStart: time.Second}, "--trim-video"},
+	}
+	for _, tt := range tests {
+		err := tt.opts.Validate()
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: Validate() = %v, want nil", tt.name, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: Validate() = %v, want an error mentioning %s", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRunValidatesOptions(t *testing.T) {
+	// The options are rejected before the playlist is fetched
+	_, err := Run(context.Background(), Options{URL: "http://127.0.0.1:0/live.m3u8", Output: "out.ts", Priority: "middle"})
+	if err == nil || !strings.Contains(err.Error(), "--priority") {
+		t.Fatalf("Run() = %v, want the --priority error", err)
+	}
+}
@@ -0,0 +1,134 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bariiss/stream-capture/internal/hls"
+	"github.com/bariiss/stream-capture/internal/retry"
+)
+
+// Statuses reported in the webhook payload.
+const (
+	webhookCompleted   = "completed"
+	webhookInterrupted = "interrupted"
+	webhookFailed      = "failed"
+)
+
+// WebhookSignatureHeader carries the hex-encoded HMAC-SHA256 of the
+// webhook body, keyed with Options.WebhookSecret, as "sha256=<hex>".
+const WebhookSignatureHeader = "X-Stream-Capture-Signature-256"
+
+// webhookTimeout bounds each delivery attempt.
+const webhookTimeout = 10 * time.Second
+
+// webhookRetryPolicy retries deliveries failing with a network error or a
+// transient status.
+var webhookRetryPolicy = retry.Policy{
+	MaxAttempts: 3,
+	BaseDelay:   time.Second,
+	Multiplier:  2,
+	Jitter:      0.2,
+	Retryable:   hls.IsRetryable,
+}
+
+// webhookPayload is the JSON body POSTed to Options.WebhookURL.
+type webhookPayload struct {
+	Status       string   `json:"status"`
+	URL          string   `json:"url"`
+	Output       string   `json:"output,omitempty"`
+	ExtraOutputs []string `json:"extra_outputs,omitempty"`
+	Parts        []string `json:"parts,omitempty"`
+	Audio        string   `json:"audio,omitempty"`
+	Subtitles    []string `json:"subtitles,omitempty"`
+	Thumbnail    string   `json:"thumbnail,omitempty"`
+	Uploaded     []string `json:"uploaded,omitempty"`
+	Expired      []int    `json:"expired,omitempty"`
+	Stats        Stats    `json:"stats"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// notifyWebhook reports the outcome of a capture to opts.WebhookURL.
+// Delivery failures are logged, never returned: the capture's own result
+// stands.
+func notifyWebhook(ctx context.Context, opts Options, result *Result, captureErr error) {
+	payload := webhookPayload{
+		Status:       webhookCompleted,
+		URL:          opts.URL,
+		Output:       result.Output,
+		ExtraOutputs: result.ExtraOutputs,
+		Parts:        result.Parts,
+		Audio:        result.Audio,
+		Subtitles:    result.Subtitles,
+		Thumbnail:    result.Thumbnail,
+		Uploaded:     result.Uploaded,
+		Expired:      result.Expired,
+		Stats:        result.Stats,
+	}
+	switch {
+	case captureErr != nil:
+		payload.Status = webhookFailed
+		payload.Error = captureErr.Error()
+	case result.Interrupted:
+		payload.Status = webhookInterrupted
+	}
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = &Logger{}
+	}
+	logger = logger.forStream(opts.StreamID, nil)
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		logger.Warnf("Warning: failed to encode webhook payload: %v\n", err)
+		return
+	}
+
+	// An interrupted capture is still reported
+	ctx = context.WithoutCancel(ctx)
+	client := &http.Client{Timeout: webhookTimeout}
+	err = retry.Do(ctx, webhookRetryPolicy, func() error {
+		return postWebhook(ctx, client, opts.WebhookURL, opts.WebhookSecret, body)
+	})
+	if err != nil {
+		logger.Warnf("Warning: webhook delivery failed: %v\n", err)
+	}
+}
+
+// postWebhook makes a single delivery attempt of body, signed with secret
+// when it is set.
+func postWebhook(ctx context.Context, client *http.Client, url, secret string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set(WebhookSignatureHeader, "sha256="+signWebhook(secret, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &hls.StatusError{StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// signWebhook returns the hex-encoded HMAC-SHA256 of body keyed with secret.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
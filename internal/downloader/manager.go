@@ -1,74 +1,468 @@
 package downloader
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
+	"sync/atomic"
 
 	"github.com/bariiss/stream-capture/internal/hls"
 )
 
+// SegmentInfo describes a downloaded segment for auditing.
+type SegmentInfo struct {
+	Sequence int    `json:"sequence"`
+	SHA256   string `json:"sha256"`
+	Size     int64  `json:"size"`
+	URL      string `json:"url"`
+}
+
+// KeyOverride supplies AES-128 key material obtained out of band, used
+// instead of fetching the EXT-X-KEY URI.
+type KeyOverride struct {
+	// Key is the 16-byte AES-128 key.
+	Key []byte
+	// IV, when set, replaces the playlist's (or sequence-derived) IV.
+	IV []byte
+}
+
 // Manager handles downloading and managing HLS segments.
 type Manager struct {
 	fetcher  *hls.Fetcher
 	tempDir  string
-	segments map[int]string // sequence -> file path
+	store    SegmentStore
+	segments map[int]string      // sequence -> file path, empty if the store has no files
+	partial  map[int]string      // sequence -> validator of an interrupted download
+	info     map[int]SegmentInfo // sequence -> hash and size
+	maps     map[int]string      // sequence -> initialization segment URL
+	inits    map[string]string   // initialization segment URL -> file path
+	running  map[int]*download   // sequence -> download in progress
+	evicted  map[int]bool        // sequences removed to stay within MaxDiskBytes
+	stored   int64               // total size of the segments in segments
 	mu       sync.RWMutex
+
+	// received counts the bytes DownloadSegment received, see TotalBytes.
+	received atomic.Int64
+
+	// initMu serializes initialization segment downloads so segments
+	// sharing an EXT-X-MAP fetch it once.
+	initMu sync.Mutex
+	// streamedMap is the initialization segment last written by
+	// WriteSegments, so a stream fed segment by segment gets it once.
+	streamedMap string
+
+	// statePath, if set, receives the manager's State after every
+	// downloaded segment; stateMu serializes taking and writing snapshots.
+	statePath string
+	state     State
+	stateMu   sync.Mutex
+
+	// KeyOverride, when set, decrypts encrypted segments with the given
+	// key instead of the one referenced by the playlist.
+	KeyOverride *KeyOverride
+
+	// IncompleteRetries is how many more times DownloadSegment fetches a
+	// segment whose body ended short of its Content-Length.
+	IncompleteRetries int
+	// VerifyChecksums makes merging check every segment file against the
+	// SHA-256 recorded when it was downloaded, so a file changed on disk
+	// in between fails the merge instead of corrupting it.
+	VerifyChecksums bool
+	// MaxDiskBytes, when positive, caps the total size of the stored
+	// segments: once a download exceeds it, the segments with the lowest
+	// sequence numbers are removed until the rest fit. Merges skip the
+	// evicted segments, producing the retained window.
+	MaxDiskBytes int64
 }
 
 // NewManager creates a new download manager with a temporary directory.
 func NewManager(tempDir string) (*Manager, error) {
+	return NewManagerWithFetcher(tempDir, hls.NewFetcher())
+}
+
+// NewManagerWithFetcher creates a new download manager that downloads
+// segments with the given fetcher, sharing its configuration.
+func NewManagerWithFetcher(tempDir string, fetcher *hls.Fetcher) (*Manager, error) {
+	return NewManagerWithStore(tempDir, fetcher, NewFSStore(tempDir))
+}
+
+// NewManagerWithStore creates a new download manager that keeps finished
+// segments in store. tempDir still holds downloads in progress and
+// initialization segments.
+func NewManagerWithStore(tempDir string, fetcher *hls.Fetcher, store SegmentStore) (*Manager, error) {
 	if err := os.MkdirAll(tempDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create temp directory: %w", err)
 	}
 
 	return &Manager{
-		fetcher:  hls.NewFetcher(),
+		fetcher:  fetcher,
 		tempDir:  tempDir,
+		store:    store,
 		segments: make(map[int]string),
+		partial:  make(map[int]string),
+		info:     make(map[int]SegmentInfo),
+		maps:     make(map[int]string),
+		inits:    make(map[string]string),
+		running:  make(map[int]*download),
+		evicted:  make(map[int]bool),
 	}, nil
 }
 
-// DownloadSegment downloads a segment to the temporary directory.
-// Returns the file path if successful.
-func (m *Manager) DownloadSegment(segment *hls.Segment) (string, error) {
-	m.mu.RLock()
+// DownloadSegment downloads a segment into the manager's store.
+// Returns the file path if successful, empty when the store doesn't keep
+// files, and the number of bytes received from
+// the server, which is zero for a segment already on disk and, for a failed
+// download, counts what arrived before the failure. Canceling ctx aborts the
+// download. A segment arriving shorter than its Content-Length is fetched
+// again up to IncompleteRetries times, resuming from the bytes received when
+// the server allows it.
+//
+// Concurrent calls for the same sequence share a single download: the
+// callers joining one in progress wait for it and receive its path and
+// error, with zero bytes received so that the bytes are counted once.
+func (m *Manager) DownloadSegment(ctx context.Context, segment *hls.Segment) (string, int64, error) {
+	m.mu.Lock()
+	if d, ok := m.running[segment.Sequence]; ok {
+		m.mu.Unlock()
+		select {
+		case <-d.done:
+			return d.path, 0, d.err
+		case <-ctx.Done():
+			return "", 0, ctx.Err()
+		}
+	}
+	d := &download{done: make(chan struct{})}
+	m.running[segment.Sequence] = d
+	m.mu.Unlock()
+
+	d.path, d.received, d.err = m.downloadWithRetries(ctx, segment)
+
+	m.mu.Lock()
+	delete(m.running, segment.Sequence)
+	m.mu.Unlock()
+	close(d.done)
+	return d.path, d.received, d.err
+}
+
+// download is a DownloadSegment call in progress; done is closed once its
+// results are set.
+type download struct {
+	done     chan struct{}
+	path     string
+	received int64
+	err      error
+}
+
+// downloadWithRetries downloads a segment for DownloadSegment, fetching it
+// again while it arrives incomplete.
+func (m *Manager) downloadWithRetries(ctx context.Context, segment *hls.Segment) (string, int64, error) {
+	path, received, err := m.downloadSegment(ctx, segment)
+	for attempt := 0; attempt < m.IncompleteRetries && errors.Is(err, hls.ErrIncompleteSegment) && ctx.Err() == nil; attempt++ {
+		var n int64
+		path, n, err = m.downloadSegment(ctx, segment)
+		received += n
+	}
+	m.received.Add(received)
+	if m.IncompleteRetries > 0 && errors.Is(err, hls.ErrIncompleteSegment) {
+		// Reported without ErrTransferInterrupted: the retries are used up,
+		// so callers shouldn't resume it yet again.
+		return "", received, fmt.Errorf("segment %d: %w after %d attempts (%v)", segment.Sequence, hls.ErrIncompleteSegment, m.IncompleteRetries+1, err)
+	}
+	return path, received, err
+}
+
+// TotalBytes returns the number of bytes received by all DownloadSegment
+// calls so far, counting failed and repeated transfers like the calls do.
+// It is safe to call while downloads are in progress.
+func (m *Manager) TotalBytes() int64 {
+	return m.received.Load()
+}
+
+// downloadSegment makes a single attempt of DownloadSegment. Only one
+// attempt per sequence runs at a time, so the .part file is not shared.
+func (m *Manager) downloadSegment(ctx context.Context, segment *hls.Segment) (string, int64, error) {
+	m.mu.Lock()
 	if path, exists := m.segments[segment.Sequence]; exists {
-		// Check if file still exists
-		if _, err := os.Stat(path); err == nil {
-			m.mu.RUnlock()
-			return path, nil
+		// Check the file is still there and complete
+		if path == "" {
+			m.mu.Unlock()
+			return path, 0, nil
 		}
-		// File doesn't exist, remove from map
+		if info, err := os.Stat(path); err == nil && info.Size() == m.info[segment.Sequence].Size {
+			m.mu.Unlock()
+			return path, 0, nil
+		}
+		// File is gone or changed, download it again
+		m.stored -= m.info[segment.Sequence].Size
 		delete(m.segments, segment.Sequence)
+		delete(m.info, segment.Sequence)
+	}
+	m.mu.Unlock()
+
+	if segment.Map != "" {
+		if _, err := m.downloadInit(ctx, segment.Map); err != nil {
+			return "", 0, err
+		}
 	}
+
+	// Download into a .part file first so an interrupted download can be
+	// resumed by the next call instead of restarting from zero.
+	ext := segment.Ext
+	if ext == "" {
+		ext = hls.DefaultSegmentExt
+	}
+	partName := filepath.Join(m.tempDir, fmt.Sprintf("segment_%d%s.part", segment.Sequence, ext))
+	file, err := os.OpenFile(partName, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create segment file: %w", err)
+	}
+
+	var offset int64
+	if info, err := file.Stat(); err == nil {
+		offset = info.Size()
+	}
+
+	m.mu.RLock()
+	validator := m.partial[segment.Sequence]
 	m.mu.RUnlock()
 
-	// Create segment file
-	filename := filepath.Join(m.tempDir, fmt.Sprintf("segment_%d.ts", segment.Sequence))
-	file, err := os.Create(filename)
+	// CBC decryption can't restart mid-file, so encrypted segments are
+	// always downloaded from the start.
+	if segment.Key != nil {
+		offset, validator = 0, ""
+	}
+
+	open := m.fetcher.OpenSegmentRangeContext
+	if segment.Key != nil {
+		open = m.fetcher.OpenEncryptedSegmentRangeContext
+	}
+	resp, err := open(ctx, segment.URL, segment.Offset, segment.Length, offset, validator)
 	if err != nil {
-		return "", fmt.Errorf("failed to create segment file: %w", err)
+		file.Close()
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	body := &countingReader{r: resp.Body}
+
+	if resp.Offset == 0 {
+		if err := file.Truncate(0); err != nil {
+			file.Close()
+			return "", body.n, fmt.Errorf("failed to reset segment file: %w", err)
+		}
+	}
+	if _, err := file.Seek(resp.Offset, io.SeekStart); err != nil {
+		file.Close()
+		return "", body.n, fmt.Errorf("failed to seek segment file: %w", err)
+	}
+
+	m.mu.Lock()
+	m.partial[segment.Sequence] = resp.Validator
+	m.mu.Unlock()
+
+	// Hash while streaming so no second pass over the data is needed; only
+	// the already downloaded prefix of a resumed file is read back.
+	hasher := sha256.New()
+	if resp.Offset > 0 {
+		if err := hashPrefix(hasher, partName, resp.Offset); err != nil {
+			file.Close()
+			return "", body.n, err
+		}
+	}
+
+	var plain io.Reader = body
+	if segment.Key != nil {
+		if plain, err = m.decrypt(ctx, segment, body); err != nil {
+			file.Close()
+			os.Remove(partName)
+			return "", body.n, err
+		}
 	}
-	defer file.Close()
 
 	// Download segment using streaming to reduce memory usage
-	if err := m.fetcher.FetchSegment(segment.URL, file); err != nil {
-		os.Remove(filename) // Clean up on error
-		return "", err
+	written, copyErr := io.Copy(io.MultiWriter(file, hasher), plain)
+	closeErr := file.Close()
+	if copyErr != nil || closeErr != nil {
+		if errors.Is(copyErr, hls.ErrTooLarge) {
+			// Resuming it would only hit the limit again
+			os.Remove(partName)
+			return "", body.n, fmt.Errorf("failed to write segment: %w", copyErr)
+		}
+		if resp.Validator == "" {
+			// Without a validator the partial file can never be resumed safely.
+			os.Remove(partName)
+		}
+		return "", body.n, fmt.Errorf("failed to write segment: %w: %w", hls.ErrTransferInterrupted, errors.Join(copyErr, closeErr))
+	}
+
+	filename, err := m.storeSegment(segment.Sequence, partName, ext)
+	if err != nil {
+		return "", body.n, err
 	}
 
 	// Store in map
 	m.mu.Lock()
+	delete(m.partial, segment.Sequence)
 	m.segments[segment.Sequence] = filename
+	if segment.Map != "" {
+		m.maps[segment.Sequence] = segment.Map
+	}
+	m.info[segment.Sequence] = SegmentInfo{
+		Sequence: segment.Sequence,
+		SHA256:   hex.EncodeToString(hasher.Sum(nil)),
+		Size:     resp.Offset + written,
+		URL:      segment.URL,
+	}
+	m.stored += resp.Offset + written
+	delete(m.evicted, segment.Sequence)
+	evictErr := m.evictLocked(segment.Sequence)
+	m.mu.Unlock()
+	if evictErr != nil {
+		return filename, body.n, evictErr
+	}
+
+	if err := m.writeState(); err != nil {
+		return filename, body.n, err
+	}
+	return filename, body.n, nil
+}
+
+// evictLocked removes the segments with the lowest sequence numbers, other
+// than keep, until the stored segments fit in MaxDiskBytes. m.mu must be
+// held for writing.
+func (m *Manager) evictLocked(keep int) error {
+	if m.MaxDiskBytes <= 0 {
+		return nil
+	}
+	for m.stored > m.MaxDiskBytes {
+		oldest, found := 0, false
+		for seq := range m.segments {
+			if seq != keep && (!found || seq < oldest) {
+				oldest, found = seq, true
+			}
+		}
+		if !found {
+			return nil
+		}
+		if err := m.store.Remove(oldest); err != nil {
+			return fmt.Errorf("failed to evict segment %d: %w", oldest, err)
+		}
+		m.stored -= m.info[oldest].Size
+		delete(m.segments, oldest)
+		delete(m.info, oldest)
+		delete(m.maps, oldest)
+		m.evicted[oldest] = true
+	}
+	return nil
+}
+
+// StoredBytes returns the total size of the segments currently stored.
+func (m *Manager) StoredBytes() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.stored
+}
+
+// Evicted returns, in ascending order, the sequences removed to stay
+// within MaxDiskBytes.
+func (m *Manager) Evicted() []int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return slices.Sorted(maps.Keys(m.evicted))
+}
+
+// storeSegment hands the complete download at partName to the store and
+// returns the segment's file path, if the store keeps files.
+func (m *Manager) storeSegment(sequence int, partName, ext string) (string, error) {
+	if files, ok := m.store.(fileStore); ok {
+		if err := files.PutFile(sequence, partName, ext); err != nil {
+			return "", err
+		}
+		return files.Path(sequence), nil
+	}
+
+	file, err := os.Open(partName)
+	if err != nil {
+		return "", fmt.Errorf("failed to read segment file: %w", err)
+	}
+	defer os.Remove(partName)
+	defer file.Close()
+	if err := m.store.Put(sequence, file); err != nil {
+		return "", fmt.Errorf("failed to store segment %d: %w", sequence, err)
+	}
+	return "", nil
+}
+
+// downloadInit downloads the EXT-X-MAP initialization segment at mapURL,
+// unless an earlier segment already did, and returns its file path.
+func (m *Manager) downloadInit(ctx context.Context, mapURL string) (string, error) {
+	m.initMu.Lock()
+	defer m.initMu.Unlock()
+
+	m.mu.RLock()
+	path, exists := m.inits[mapURL]
+	count := len(m.inits)
+	m.mu.RUnlock()
+	if exists {
+		return path, nil
+	}
+
+	path = filepath.Join(m.tempDir, fmt.Sprintf("init_%d.mp4", count))
+	file, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to create initialization segment file: %w", err)
+	}
+	_, fetchErr := m.fetcher.FetchSegmentContext(ctx, mapURL, file)
+	closeErr := file.Close()
+	if err := errors.Join(fetchErr, closeErr); err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("failed to download initialization segment %s: %w", mapURL, err)
+	}
+
+	m.mu.Lock()
+	m.inits[mapURL] = path
 	m.mu.Unlock()
+	return path, nil
+}
 
-	return filename, nil
+// decrypt wraps body in an AES-128 decrypter for the segment's key, fetched
+// from the key URI unless KeyOverride supplies it.
+func (m *Manager) decrypt(ctx context.Context, segment *hls.Segment, body io.Reader) (io.Reader, error) {
+	if segment.Key.Method != hls.MethodAES128 {
+		return nil, fmt.Errorf("segment %d: unsupported encryption method %s", segment.Sequence, segment.Key.Method)
+	}
+
+	var key []byte
+	iv := segment.Key.IV
+	if m.KeyOverride != nil {
+		key = m.KeyOverride.Key
+		if m.KeyOverride.IV != nil {
+			iv = m.KeyOverride.IV
+		}
+	} else {
+		var err error
+		if key, err = m.fetcher.FetchKey(ctx, segment.Key.URI); err != nil {
+			return nil, fmt.Errorf("segment %d: %w (supply the key with --key-hex or --key-file if it can't be fetched)", segment.Sequence, err)
+		}
+	}
+
+	reader, err := hls.NewDecryptReader(body, key, iv)
+	if err != nil {
+		return nil, fmt.Errorf("segment %d: %w", segment.Sequence, err)
+	}
+	return reader, nil
 }
 
-// GetSegmentPath returns the file path for a given sequence number.
+// GetSegmentPath returns the file path for a given sequence number and
+// whether it was downloaded. The path is empty when the store doesn't keep
+// files.
 func (m *Manager) GetSegmentPath(sequence int) (string, bool) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -76,43 +470,264 @@ func (m *Manager) GetSegmentPath(sequence int) (string, bool) {
 	return path, exists
 }
 
+// SegmentInfos returns the hash and size of the given downloaded segments,
+// in the order of sequences. Sequences that weren't downloaded are skipped.
+func (m *Manager) SegmentInfos(sequences []int) []SegmentInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	infos := make([]SegmentInfo, 0, len(sequences))
+	for _, seq := range sequences {
+		if info, ok := m.info[seq]; ok {
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
 // MergeSegments merges all downloaded segments into a single output file.
-// Uses streaming to reduce memory usage.
-func (m *Manager) MergeSegments(outputPath string, sequences []int) error {
-	outputFile, err := os.Create(outputPath)
+// Uses streaming to reduce memory usage. The EXT-X-MAP initialization
+// segment, if any, is written once before the first segment using it. It
+// returns the hex-encoded SHA-256 of the merged output. With
+// VerifyChecksums, a segment whose file no longer matches its recorded
+// SHA-256 fails the merge.
+func (m *Manager) MergeSegments(outputPath string, sequences []int) (string, error) {
+	return m.MergeSegmentsToFiles([]string{outputPath}, sequences)
+}
+
+// DiscontinuityParts groups segments, in order, into runs without a
+// discontinuity between them and returns their sequence numbers. A new run
+// starts at a segment flagged with EXT-X-DISCONTINUITY or whose
+// discontinuity sequence is above the previous segment's, which catches a
+// discontinuity whose tag left the live window between two refreshes. A
+// lower sequence is ignored: servers omitting EXT-X-DISCONTINUITY-SEQUENCE
+// make it restart from 0 in every refresh.
+func DiscontinuityParts(segments []*hls.Segment) [][]int {
+	var parts [][]int
+	for i, seg := range segments {
+		if i == 0 || seg.Discontinuity || seg.DiscontinuitySequence > segments[i-1].DiscontinuitySequence {
+			parts = append(parts, nil)
+		}
+		parts[len(parts)-1] = append(parts[len(parts)-1], seg.Sequence)
+	}
+	return parts
+}
+
+// PartPath returns the path of the n-th part (counting from 1) of a split
+// output: "out.ts" becomes "out.part1.ts".
+func PartPath(outputPath string, n int) string {
+	ext := filepath.Ext(outputPath)
+	return fmt.Sprintf("%s.part%d%s", outputPath[:len(outputPath)-len(ext)], n, ext)
+}
+
+// MergeSegmentParts merges each group of sequences into its own file, named
+// by PartPath after outputPath, and returns the paths written. Each part
+// starts with its initialization segment, if any, so it plays on its own.
+func (m *Manager) MergeSegmentParts(outputPath string, parts [][]int) ([]string, error) {
+	paths := make([]string, len(parts))
+	for i, sequences := range parts {
+		paths[i] = PartPath(outputPath, i+1)
+		if _, err := m.MergeSegments(paths[i], sequences); err != nil {
+			return nil, fmt.Errorf("part %d: %w", i+1, err)
+		}
+	}
+	return paths, nil
+}
+
+// MergeSegmentsToFiles merges all downloaded segments into several output
+// files at once (regular files or FIFOs), writing each segment through an
+// io.MultiWriter so the data is read only once. Like MergeSegmentsTo, it
+// hashes the stream as it is written, so the returned hex digest costs no
+// extra read. Regular files are written to <path>.tmp and renamed into
+// place once the merge succeeded, so a failed merge leaves any previous
+// file at the path untouched instead of a truncated one.
+func (m *Manager) MergeSegmentsToFiles(outputPaths []string, sequences []int) (string, error) {
+	outputs := make([]*mergeOutput, 0, len(outputPaths))
+	// Outputs not committed by a successful merge are discarded
+	defer func() {
+		for _, out := range outputs {
+			out.discard()
+		}
+	}()
+	writers := make([]io.Writer, 0, len(outputPaths))
+	for _, path := range outputPaths {
+		out, err := createMergeOutput(path)
+		if err != nil {
+			return "", err
+		}
+		outputs = append(outputs, out)
+		writers = append(writers, out.file)
+	}
+
+	hash, err := m.MergeSegmentsTo(io.MultiWriter(writers...), sequences)
 	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+		return "", err
+	}
+	for _, out := range outputs {
+		if err := out.commit(); err != nil {
+			return "", err
+		}
 	}
-	defer outputFile.Close()
+	return hash, nil
+}
 
+// MergeSegmentsTo merges the given downloaded segments, in order, into w,
+// e.g. stdout, and returns the hex-encoded SHA-256 of the merged stream.
+// Unlike WriteSegments, the merge is a stream of its own: it starts with
+// the initialization segment of its first segment, if any.
+func (m *Manager) MergeSegmentsTo(w io.Writer, sequences []int) (string, error) {
+	hasher := sha256.New()
+	var lastMap string
+	if err := m.mergeTo(io.MultiWriter(w, hasher), sequences, &lastMap); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// mergeOutput is an output file of a merge, written to tmp and renamed to
+// path by commit. FIFOs and devices can't be replaced, so they are written
+// directly and tmp is empty.
+type mergeOutput struct {
+	file *os.File
+	path string
+	tmp  string
+	done bool
+}
+
+// createMergeOutput opens the file receiving the merge into path.
+func createMergeOutput(path string) (*mergeOutput, error) {
+	out := &mergeOutput{path: path}
+	name := path
+	if info, err := os.Stat(path); err != nil || info.Mode().IsRegular() {
+		out.tmp = path + ".tmp"
+		name = out.tmp
+	}
+	file, err := os.Create(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	out.file = file
+	return out, nil
+}
+
+// commit closes the output and moves it into place.
+func (o *mergeOutput) commit() error {
+	o.done = true
+	if err := o.file.Close(); err != nil {
+		if o.tmp != "" {
+			os.Remove(o.tmp)
+		}
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	if o.tmp == "" {
+		return nil
+	}
+	if err := os.Rename(o.tmp, o.path); err != nil {
+		os.Remove(o.tmp)
+		return fmt.Errorf("failed to finalize output file: %w", err)
+	}
+	return nil
+}
+
+// discard closes an output that wasn't committed and removes its
+// temporary file.
+func (o *mergeOutput) discard() {
+	if o.done {
+		return
+	}
+	o.file.Close()
+	if o.tmp != "" {
+		os.Remove(o.tmp)
+	}
+}
+
+// WriteSegments streams the given downloaded segments, in order, into w.
+// It lets callers consume segments while later ones are still downloading.
+// Successive calls are treated as one stream: an initialization segment
+// is only written again when the segments switch to a different one.
+func (m *Manager) WriteSegments(w io.Writer, sequences []int) error {
+	return m.mergeTo(w, sequences, &m.streamedMap)
+}
+
+// mergeTo streams the given segments, in order, into w. Whenever a segment's
+// initialization segment differs from *lastMap, it is written first and
+// *lastMap updated.
+func (m *Manager) mergeTo(w io.Writer, sequences []int, lastMap *string) error {
 	for _, seq := range sequences {
 		m.mu.RLock()
-		segmentPath, exists := m.segments[seq]
+		_, exists := m.segments[seq]
+		evicted := m.evicted[seq]
+		mapURL := m.maps[seq]
+		initPath := m.inits[mapURL]
+		checksum := m.info[seq].SHA256
 		m.mu.RUnlock()
 
+		if evicted {
+			continue
+		}
 		if !exists {
 			return fmt.Errorf("segment %d not found", seq)
 		}
 
-		if err := copyFile(segmentPath, outputFile); err != nil {
+		if mapURL != "" && mapURL != *lastMap {
+			if err := copyFile(initPath, w); err != nil {
+				return fmt.Errorf("failed to copy initialization segment of segment %d: %w", seq, err)
+			}
+		}
+		*lastMap = mapURL
+
+		if !m.VerifyChecksums || checksum == "" {
+			if err := m.copySegment(seq, w); err != nil {
+				return fmt.Errorf("failed to copy segment %d: %w", seq, err)
+			}
+			continue
+		}
+
+		hasher := sha256.New()
+		if err := m.copySegment(seq, io.MultiWriter(w, hasher)); err != nil {
 			return fmt.Errorf("failed to copy segment %d: %w", seq, err)
 		}
+		if sum := hex.EncodeToString(hasher.Sum(nil)); sum != checksum {
+			return fmt.Errorf("segment %d changed on disk since it was downloaded: SHA-256 is %s, expected %s", seq, sum, checksum)
+		}
 	}
 
 	return nil
 }
 
-// Cleanup removes all downloaded segments and the temporary directory.
+// Cleanup removes all downloaded segments from the store and the temporary
+// directory.
 func (m *Manager) Cleanup() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	for _, path := range m.segments {
-		os.Remove(path)
+	var errs []error
+	for seq := range m.segments {
+		if err := m.store.Remove(seq); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove segment %d: %w", seq, err))
+		}
 	}
 	m.segments = make(map[int]string)
+	m.info = make(map[int]SegmentInfo)
+	m.evicted = make(map[int]bool)
+	m.stored = 0
+	m.maps = make(map[int]string)
+	m.inits = make(map[string]string)
+	m.streamedMap = ""
 
-	return os.RemoveAll(m.tempDir)
+	return errors.Join(append(errs, os.RemoveAll(m.tempDir))...)
+}
+
+// copySegment copies a stored segment to a writer using streaming.
+func (m *Manager) copySegment(sequence int, dst io.Writer) error {
+	src, err := m.store.Open(sequence)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	_, err = io.Copy(dst, src)
+	return err
 }
 
 // copyFile copies a file to a writer using streaming.
@@ -126,3 +741,29 @@ func copyFile(srcPath string, dst io.Writer) error {
 	_, err = io.Copy(dst, src)
 	return err
 }
+
+// hashPrefix feeds the first n bytes of the file at path into h.
+func hashPrefix(h io.Writer, path string, n int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read partial segment: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := io.CopyN(h, f, n); err != nil {
+		return fmt.Errorf("failed to read partial segment: %w", err)
+	}
+	return nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
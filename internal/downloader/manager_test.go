@@ -0,0 +1,852 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bariiss/stream-capture/internal/hls"
+)
+
+// Sample key material for the encrypted-stream harness.
+var (
+	sampleKey = []byte("0123456789abcdef")
+	sampleIV  = []byte("fedcba9876543210")
+)
+
+// encrypt AES-128-CBC encrypts plain with PKCS#7 padding, the way HLS
+// packagers do.
+func encrypt(t *testing.T, plain, key, iv []byte) []byte {
+	t.Helper()
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pad := aes.BlockSize - len(plain)%aes.BlockSize
+	padded := append(bytes.Clone(plain), bytes.Repeat([]byte{byte(pad)}, pad)...)
+
+	out := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, padded)
+	return out
+}
+
+// samplePayload returns recognizable segment content larger than the
+// decrypter's read buffer so streaming across reads is exercised.
+func samplePayload(seq int) []byte {
+	return bytes.Repeat([]byte(fmt.Sprintf("segment %d payload|", seq)), 4000)
+}
+
+// testStream is an encrypted stream server that counts key requests.
+type testStream struct {
+	*httptest.Server
+	keyFetches atomic.Int32
+}
+
+// serveKey serves key at path, counting the requests.
+func (s *testStream) serveKey(mux *http.ServeMux, path string, key []byte) {
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		s.keyFetches.Add(1)
+		w.Write(key)
+	})
+}
+
+// encryptedStream serves an AES-128 playlist whose EXT-X-KEY ends with
+// ivAttr (no IV attribute when empty) and segments encrypted with
+// ivFor(sequence).
+func encryptedStream(t *testing.T, ivAttr string, ivFor func(seq int) []byte) *testStream {
+	t.Helper()
+
+	stream := &testStream{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/index.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:41\n#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin\"%s\n", ivAttr)
+		fmt.Fprint(w, "#EXTINF:4.0,\nseg41.ts\n#EXTINF:4.0,\nseg42.ts\n")
+	})
+	for _, seq := range []int{41, 42} {
+		body := encrypt(t, samplePayload(seq), sampleKey, ivFor(seq))
+		mux.HandleFunc(fmt.Sprintf("/seg%d.ts", seq), func(w http.ResponseWriter, r *http.Request) {
+			w.Write(body)
+		})
+	}
+	stream.serveKey(mux, "/key.bin", sampleKey)
+
+	stream.Server = httptest.NewServer(mux)
+	t.Cleanup(stream.Close)
+	return stream
+}
+
+// downloadAll parses the stream's playlist and downloads every segment with
+// the given override, returning the segments' decrypted contents.
+func downloadAll(t *testing.T, server *testStream, override *KeyOverride) ([][]byte, error) {
+	t.Helper()
+
+	fetcher := hls.NewFetcher()
+	content, err := fetcher.FetchPlaylist(server.URL + "/index.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	segments, err := hls.ParsePlaylist(content, server.URL+"/index.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manager, err := NewManagerWithFetcher(t.TempDir(), fetcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.KeyOverride = override
+
+	var contents [][]byte
+	for _, seg := range segments {
+		path, _, err := manager.DownloadSegment(context.Background(), seg)
+		if err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, data)
+	}
+
+	if override != nil && server.keyFetches.Load() > 0 {
+		t.Error("key URI must not be fetched when a key override is set")
+	}
+	return contents, nil
+}
+
+func wantPlaintext(t *testing.T, contents [][]byte) {
+	t.Helper()
+
+	for i, seq := range []int{41, 42} {
+		if !bytes.Equal(contents[i], samplePayload(seq)) {
+			t.Errorf("segment %d: decrypted content doesn't match the original", seq)
+		}
+	}
+}
+
+func TestDownloadSegmentDecryptsWithExplicitIV(t *testing.T) {
+	server := encryptedStream(t, fmt.Sprintf(",IV=0x%x", sampleIV), func(int) []byte { return sampleIV })
+
+	contents, err := downloadAll(t, server, &KeyOverride{Key: sampleKey})
+	if err != nil {
+		t.Fatalf("DownloadSegment returned error: %v", err)
+	}
+	wantPlaintext(t, contents)
+}
+
+func TestDownloadSegmentDecryptsWithSequenceIV(t *testing.T) {
+	server := encryptedStream(t, "", hls.SequenceIV)
+
+	contents, err := downloadAll(t, server, &KeyOverride{Key: sampleKey})
+	if err != nil {
+		t.Fatalf("DownloadSegment returned error: %v", err)
+	}
+	wantPlaintext(t, contents)
+}
+
+func TestDownloadSegmentIVOverride(t *testing.T) {
+	// The playlist advertises a different IV than the one actually used
+	server := encryptedStream(t, ",IV=0x00000000000000000000000000000001", func(int) []byte { return sampleIV })
+
+	contents, err := downloadAll(t, server, &KeyOverride{Key: sampleKey, IV: sampleIV})
+	if err != nil {
+		t.Fatalf("DownloadSegment returned error: %v", err)
+	}
+	wantPlaintext(t, contents)
+}
+
+func TestDownloadSegmentWrongKey(t *testing.T) {
+	server := encryptedStream(t, fmt.Sprintf(",IV=0x%x", sampleIV), func(int) []byte { return sampleIV })
+
+	contents, err := downloadAll(t, server, &KeyOverride{Key: []byte("not the real key")})
+	if err == nil {
+		// A wrong key only fails if the padding happens to be invalid
+		for i, seq := range []int{41, 42} {
+			if bytes.Equal(contents[i], samplePayload(seq)) {
+				t.Errorf("segment %d decrypted correctly with the wrong key", seq)
+			}
+		}
+	}
+}
+
+func TestDownloadSegmentFetchesKey(t *testing.T) {
+	server := encryptedStream(t, "", hls.SequenceIV)
+
+	contents, err := downloadAll(t, server, nil)
+	if err != nil {
+		t.Fatalf("DownloadSegment returned error: %v", err)
+	}
+	wantPlaintext(t, contents)
+
+	// Both segments share the key, which is cached after the first fetch
+	if n := server.keyFetches.Load(); n != 1 {
+		t.Errorf("key fetched %d times, want 1", n)
+	}
+}
+
+func TestDownloadSegmentKeyRotation(t *testing.T) {
+	otherKey := []byte("another key 0123")
+
+	stream := &testStream{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/index.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:41\n",
+			"#EXT-X-KEY:METHOD=AES-128,URI=\"key1.bin\"\n#EXTINF:4.0,\nseg41.ts\n",
+			"#EXT-X-KEY:METHOD=NONE\n#EXTINF:4.0,\nseg42.ts\n",
+			"#EXT-X-KEY:METHOD=AES-128,URI=\"key2.bin\"\n#EXTINF:4.0,\nseg43.ts\n")
+	})
+	bodies := map[int][]byte{
+		41: encrypt(t, samplePayload(41), sampleKey, hls.SequenceIV(41)),
+		42: samplePayload(42),
+		43: encrypt(t, samplePayload(43), otherKey, hls.SequenceIV(43)),
+	}
+	for seq, body := range bodies {
+		mux.HandleFunc(fmt.Sprintf("/seg%d.ts", seq), func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "video/mp2t")
+			w.Write(body)
+		})
+	}
+	stream.serveKey(mux, "/key1.bin", sampleKey)
+	stream.serveKey(mux, "/key2.bin", otherKey)
+	stream.Server = httptest.NewServer(mux)
+	t.Cleanup(stream.Close)
+
+	fetcher := hls.NewFetcher()
+	content, err := fetcher.FetchPlaylist(stream.URL + "/index.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	segments, err := hls.ParsePlaylist(content, stream.URL+"/index.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager, err := NewManagerWithFetcher(t.TempDir(), fetcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, seg := range segments {
+		path, _, err := manager.DownloadSegment(context.Background(), seg)
+		if err != nil {
+			t.Fatalf("segment %d: DownloadSegment returned error: %v", seg.Sequence, err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, samplePayload(seg.Sequence)) {
+			t.Errorf("segment %d: content doesn't match the original", seg.Sequence)
+		}
+	}
+	if n := stream.keyFetches.Load(); n != 2 {
+		t.Errorf("keys fetched %d times, want 2", n)
+	}
+}
+
+func TestDownloadSegmentKeyUnavailable(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/index.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:41\n#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin\"\n#EXTINF:4.0,\nseg41.ts\n#EXTINF:4.0,\nseg42.ts\n")
+	})
+	mux.HandleFunc("/seg41.ts", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(encrypt(t, samplePayload(41), sampleKey, hls.SequenceIV(41)))
+	})
+	mux.HandleFunc("/key.bin", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	})
+	server := &testStream{Server: httptest.NewServer(mux)}
+	t.Cleanup(server.Close)
+
+	_, err := downloadAll(t, server, nil)
+	if err == nil || !strings.Contains(err.Error(), "--key-hex") {
+		t.Fatalf("DownloadSegment error = %v, want a hint about --key-hex", err)
+	}
+}
+
+func TestDownloadSegmentEncryptedUntypedBody(t *testing.T) {
+	// Find an IV making the ciphertext start like markup
+	iv := bytes.Clone(sampleIV)
+	var body []byte
+	for i := 0; ; i++ {
+		iv[0] = byte(i)
+		if body = encrypt(t, samplePayload(41), sampleKey, iv); body[0] == '<' {
+			break
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/index.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:41\n#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin\",IV=0x%x\n#EXTINF:4.0,\nseg41.ts\n", iv)
+	})
+	mux.HandleFunc("/seg41.ts", func(w http.ResponseWriter, r *http.Request) {
+		// No Content-Type, so only the body could tell an error page
+		w.Header()["Content-Type"] = nil
+		w.Write(body)
+	})
+	server := &testStream{Server: httptest.NewServer(mux)}
+	t.Cleanup(server.Close)
+
+	contents, err := downloadAll(t, server, &KeyOverride{Key: sampleKey})
+	if err != nil {
+		t.Fatalf("DownloadSegment returned error: %v", err)
+	}
+	if !bytes.Equal(contents[0], samplePayload(41)) {
+		t.Error("decrypted content doesn't match the original")
+	}
+}
+
+// mergeStream downloads every segment of the playlist served as
+// /index.m3u8 and merges them, returning the merged output.
+func mergeStream(t *testing.T, server *httptest.Server) []byte {
+	t.Helper()
+
+	fetcher := hls.NewFetcher()
+	content, err := fetcher.FetchPlaylist(server.URL + "/index.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	segments, err := hls.ParsePlaylist(content, server.URL+"/index.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager, err := NewManagerWithFetcher(t.TempDir(), fetcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sequences []int
+	for _, seg := range segments {
+		if _, _, err := manager.DownloadSegment(context.Background(), seg); err != nil {
+			t.Fatalf("segment %d: DownloadSegment returned error: %v", seg.Sequence, err)
+		}
+		sequences = append(sequences, seg.Sequence)
+	}
+
+	output := t.TempDir() + "/out"
+	if _, err := manager.MergeSegments(output, sequences); err != nil {
+		t.Fatalf("MergeSegments returned error: %v", err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// serveMedia serves body at path with a media content type, counting the
+// requests in hits when set.
+func serveMedia(mux *http.ServeMux, path string, body []byte, hits *atomic.Int32) {
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if hits != nil {
+			hits.Add(1)
+		}
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Write(body)
+	})
+}
+
+func TestMergeSegmentsPrependsInitSegment(t *testing.T) {
+	var initFetches atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/index.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:1\n#EXT-X-MAP:URI=\"init.mp4\"\n",
+			"#EXTINF:4,\nseg1.m4s\n#EXTINF:4,\nseg2.m4s\n",
+			"#EXT-X-DISCONTINUITY\n#EXT-X-MAP:URI=\"init2.mp4\"\n#EXTINF:4,\nseg3.m4s\n")
+	})
+	serveMedia(mux, "/init.mp4", []byte("[init]"), &initFetches)
+	serveMedia(mux, "/init2.mp4", []byte("[init2]"), &initFetches)
+	for seq := 1; seq <= 3; seq++ {
+		serveMedia(mux, fmt.Sprintf("/seg%d.m4s", seq), []byte(fmt.Sprintf("[seg%d]", seq)), nil)
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	got := mergeStream(t, server)
+	if want := "[init][seg1][seg2][init2][seg3]"; string(got) != want {
+		t.Errorf("merged output = %q, want %q", got, want)
+	}
+	if n := initFetches.Load(); n != 2 {
+		t.Errorf("initialization segments fetched %d times, want 2", n)
+	}
+}
+
+func TestMergeSegmentsWithoutMap(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/index.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:1\n#EXTINF:4,\nseg1.ts\n#EXTINF:4,\nseg2.ts\n")
+	})
+	serveMedia(mux, "/seg1.ts", []byte("[seg1]"), nil)
+	serveMedia(mux, "/seg2.ts", []byte("[seg2]"), nil)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	if got, want := string(mergeStream(t, server)), "[seg1][seg2]"; got != want {
+		t.Errorf("merged output = %q, want %q", got, want)
+	}
+}
+
+func TestMergeSegmentsToWriter(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/index.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:1\n#EXT-X-MAP:URI=\"init.mp4\"\n",
+			"#EXTINF:4,\nseg1.m4s\n#EXTINF:4,\nseg2.m4s\n")
+	})
+	serveMedia(mux, "/init.mp4", []byte("[init]"), nil)
+	serveMedia(mux, "/seg1.m4s", []byte("[seg1]"), nil)
+	serveMedia(mux, "/seg2.m4s", []byte("[seg2]"), nil)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	fetcher := hls.NewFetcher()
+	content, err := fetcher.FetchPlaylist(server.URL + "/index.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	segments, err := hls.ParsePlaylist(content, server.URL+"/index.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager, err := NewManagerWithFetcher(t.TempDir(), fetcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, seg := range segments {
+		if _, _, err := manager.DownloadSegment(context.Background(), seg); err != nil {
+			t.Fatalf("segment %d: DownloadSegment returned error: %v", seg.Sequence, err)
+		}
+	}
+
+	// Each merge is a stream of its own and starts with the init segment
+	for _, sequences := range [][]int{{1, 2}, {2}} {
+		var buf bytes.Buffer
+		hash, err := manager.MergeSegmentsTo(&buf, sequences)
+		if err != nil {
+			t.Fatalf("MergeSegmentsTo(%v) returned error: %v", sequences, err)
+		}
+		want := "[init]"
+		for _, seq := range sequences {
+			want += fmt.Sprintf("[seg%d]", seq)
+		}
+		if buf.String() != want {
+			t.Errorf("MergeSegmentsTo(%v) wrote %q, want %q", sequences, buf.String(), want)
+		}
+		if sum := sha256.Sum256(buf.Bytes()); hash != hex.EncodeToString(sum[:]) {
+			t.Errorf("MergeSegmentsTo(%v) returned hash %s, want the SHA-256 of the output", sequences, hash)
+		}
+	}
+}
+
+func TestDownloadSegmentCanceled(t *testing.T) {
+	started := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stall.ts", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp2t")
+		w.Write(samplePayload(1))
+		w.(http.Flusher).Flush()
+		close(started)
+		// Never finish the body; only cancellation ends the download
+		<-r.Context().Done()
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	manager, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	_, _, err = manager.DownloadSegment(ctx, &hls.Segment{URL: server.URL + "/stall.ts", Sequence: 1})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("DownloadSegment error = %v, want context.Canceled", err)
+	}
+}
+
+func TestDownloadSegmentConcurrentCallsShareDownload(t *testing.T) {
+	payload := samplePayload(1)
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/seg1.ts", func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		// Hold the response so every caller arrives while it is in progress
+		<-release
+		w.Header().Set("Content-Type", "video/mp2t")
+		w.Write(payload)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	manager, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const callers = 8
+	type outcome struct {
+		path     string
+		received int64
+		err      error
+	}
+	outcomes := make(chan outcome, callers)
+	seg := &hls.Segment{URL: server.URL + "/seg1.ts", Sequence: 1}
+	for range callers {
+		go func() {
+			path, received, err := manager.DownloadSegment(context.Background(), seg)
+			outcomes <- outcome{path, received, err}
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	var paths []string
+	var received int64
+	for range callers {
+		o := <-outcomes
+		if o.err != nil {
+			t.Fatalf("DownloadSegment returned error: %v", o.err)
+		}
+		paths = append(paths, o.path)
+		received += o.received
+	}
+
+	if got := fetches.Load(); got != 1 {
+		t.Errorf("segment fetched %d times, want 1", got)
+	}
+	for _, path := range paths {
+		if path != paths[0] {
+			t.Errorf("callers got paths %q and %q, want the same file", paths[0], path)
+		}
+	}
+	if got, err := os.ReadFile(paths[0]); err != nil || !bytes.Equal(got, payload) {
+		t.Errorf("segment file has %d bytes (err %v), want %d", len(got), err, len(payload))
+	}
+	if want := int64(len(payload)); received != want || manager.TotalBytes() != want {
+		t.Errorf("callers received %d bytes in total (TotalBytes %d), want %d counted once", received, manager.TotalBytes(), want)
+	}
+}
+
+func TestMaxDiskBytesEvictsOldestSegments(t *testing.T) {
+	mux := http.NewServeMux()
+	for seq := 1; seq <= 5; seq++ {
+		serveMedia(mux, fmt.Sprintf("/seg%d.ts", seq), []byte(fmt.Sprintf("[seg%d]", seq)), nil)
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	manager, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Room for two and a half 6-byte segments
+	manager.MaxDiskBytes = 15
+
+	paths := make(map[int]string)
+	for seq := 1; seq <= 5; seq++ {
+		seg := &hls.Segment{URL: fmt.Sprintf("%s/seg%d.ts", server.URL, seq), Sequence: seq}
+		path, _, err := manager.DownloadSegment(context.Background(), seg)
+		if err != nil {
+			t.Fatalf("segment %d: DownloadSegment returned error: %v", seq, err)
+		}
+		paths[seq] = path
+	}
+
+	if got, want := manager.Evicted(), []int{1, 2, 3}; !slices.Equal(got, want) {
+		t.Errorf("Evicted() = %v, want %v", got, want)
+	}
+	if got := manager.StoredBytes(); got != 12 {
+		t.Errorf("StoredBytes() = %d, want 12", got)
+	}
+	for seq := 1; seq <= 3; seq++ {
+		if _, err := os.Stat(paths[seq]); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("evicted segment %d still on disk (stat error %v)", seq, err)
+		}
+	}
+
+	output := filepath.Join(t.TempDir(), "out.ts")
+	if _, err := manager.MergeSegments(output, []int{1, 2, 3, 4, 5}); err != nil {
+		t.Fatalf("MergeSegments returned error: %v", err)
+	}
+	if got, err := os.ReadFile(output); err != nil || string(got) != "[seg4][seg5]" {
+		t.Errorf("merged output = %q (err %v), want the retained window %q", got, err, "[seg4][seg5]")
+	}
+}
+
+// truncatingHandler serves payload, cutting the body short on the first
+// truncated requests.
+func truncatingHandler(payload []byte, truncated int32) http.HandlerFunc {
+	var requests atomic.Int32
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp2t")
+		w.Header().Set("Content-Length", fmt.Sprint(len(payload)))
+		if requests.Add(1) <= truncated {
+			w.Write(payload[:len(payload)/2])
+			return
+		}
+		w.Write(payload)
+	}
+}
+
+func TestDownloadSegmentRetriesIncomplete(t *testing.T) {
+	payload := samplePayload(1)
+	server := httptest.NewServer(truncatingHandler(payload, 2))
+	t.Cleanup(server.Close)
+
+	manager, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.IncompleteRetries = 2
+
+	seg := &hls.Segment{URL: server.URL + "/seg1.ts", Sequence: 1}
+	path, received, err := manager.DownloadSegment(context.Background(), seg)
+	if err != nil {
+		t.Fatalf("DownloadSegment returned error: %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("segment file has %d bytes, want %d", len(got), len(payload))
+	}
+	// Two truncated halves without a validator to resume from, then the
+	// whole segment.
+	if want := int64(2 * len(payload)); received != want {
+		t.Errorf("received %d bytes, want %d", received, want)
+	}
+
+	if _, received, err := manager.DownloadSegment(context.Background(), seg); err != nil || received != 0 {
+		t.Errorf("downloading a segment on disk again received %d bytes (err %v), want 0", received, err)
+	}
+	if got, want := manager.TotalBytes(), int64(2*len(payload)); got != want {
+		t.Errorf("TotalBytes() = %d, want %d", got, want)
+	}
+}
+
+func TestDownloadSegmentTooLarge(t *testing.T) {
+	payload := samplePayload(1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp2t")
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(payload)
+	}))
+	t.Cleanup(server.Close)
+
+	fetcher := hls.NewFetcher()
+	fetcher.MaxSegmentSize = int64(len(payload) / 2)
+	dir := t.TempDir()
+	manager, err := NewManagerWithFetcher(dir, fetcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seg := &hls.Segment{URL: server.URL + "/seg1.ts", Sequence: 1}
+	_, _, err = manager.DownloadSegment(context.Background(), seg)
+	if !errors.Is(err, hls.ErrTooLarge) {
+		t.Fatalf("DownloadSegment error = %v, want ErrTooLarge", err)
+	}
+	if errors.Is(err, hls.ErrTransferInterrupted) {
+		t.Errorf("DownloadSegment error = %v, want an oversized segment not to be resumed", err)
+	}
+	if parts, _ := filepath.Glob(filepath.Join(dir, "*.part")); len(parts) > 0 {
+		t.Errorf("partial files left behind: %v", parts)
+	}
+}
+
+func TestDownloadSegmentIncompleteRetriesExhausted(t *testing.T) {
+	server := httptest.NewServer(truncatingHandler(samplePayload(1), 3))
+	t.Cleanup(server.Close)
+
+	manager, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.IncompleteRetries = 1
+
+	_, _, err = manager.DownloadSegment(context.Background(), &hls.Segment{URL: server.URL + "/seg1.ts", Sequence: 1})
+	if !errors.Is(err, hls.ErrIncompleteSegment) {
+		t.Fatalf("DownloadSegment error = %v, want ErrIncompleteSegment", err)
+	}
+	if errors.Is(err, hls.ErrTransferInterrupted) {
+		t.Errorf("DownloadSegment error = %v, want it not to be resumable once retries are used up", err)
+	}
+	if _, ok := manager.GetSegmentPath(1); ok {
+		t.Error("incomplete segment was recorded as downloaded")
+	}
+}
+
+func TestMergeSegmentsDetectsChangedSegment(t *testing.T) {
+	mux := http.NewServeMux()
+	serveMedia(mux, "/seg1.ts", []byte("[seg1]"), nil)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	manager, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.VerifyChecksums = true
+
+	path, _, err := manager.DownloadSegment(context.Background(), &hls.Segment{URL: server.URL + "/seg1.ts", Sequence: 1})
+	if err != nil {
+		t.Fatalf("DownloadSegment returned error: %v", err)
+	}
+	output := t.TempDir() + "/out"
+	if _, err := manager.MergeSegments(output, []int{1}); err != nil {
+		t.Fatalf("MergeSegments returned error before the change: %v", err)
+	}
+
+	// Same size, different content
+	if err := os.WriteFile(path, []byte("[segX]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = manager.MergeSegments(output, []int{1})
+	if err == nil || !strings.Contains(err.Error(), "changed on disk") {
+		t.Fatalf("MergeSegments error = %v, want a changed-on-disk error", err)
+	}
+
+	// The failed merge leaves the previous output as it was
+	if got, err := os.ReadFile(output); err != nil || string(got) != "[seg1]" {
+		t.Errorf("output after the failed merge = %q (err %v), want the previous %q", got, err, "[seg1]")
+	}
+	if _, err := os.Stat(output + ".tmp"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("temporary output left behind after the failed merge (stat error %v)", err)
+	}
+}
+
+func TestMergeSegmentPartsSplitsAtDiscontinuity(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/index.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:1\n#EXTINF:4,\nseg1.ts\n#EXTINF:4,\nseg2.ts\n",
+			"#EXT-X-DISCONTINUITY\n#EXTINF:4,\nseg3.ts\n")
+	})
+	for seq := 1; seq <= 3; seq++ {
+		serveMedia(mux, fmt.Sprintf("/seg%d.ts", seq), []byte(fmt.Sprintf("[seg%d]", seq)), nil)
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	content, err := hls.NewFetcher().FetchPlaylist(server.URL + "/index.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	segments, err := hls.ParsePlaylist(content, server.URL+"/index.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, seg := range segments {
+		if _, _, err := manager.DownloadSegment(context.Background(), seg); err != nil {
+			t.Fatalf("segment %d: DownloadSegment returned error: %v", seg.Sequence, err)
+		}
+	}
+
+	parts := DiscontinuityParts(segments)
+	paths, err := manager.MergeSegmentParts(t.TempDir()+"/out.ts", parts)
+	if err != nil {
+		t.Fatalf("MergeSegmentParts returned error: %v", err)
+	}
+
+	want := map[string]string{"out.part1.ts": "[seg1][seg2]", "out.part2.ts": "[seg3]"}
+	if len(paths) != len(want) {
+		t.Fatalf("got %d parts (%v), want %d", len(paths), paths, len(want))
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name := filepath.Base(path); string(data) != want[name] {
+			t.Errorf("%s = %q, want %q", name, data, want[name])
+		}
+	}
+}
+
+func TestDiscontinuityParts(t *testing.T) {
+	segments := []*hls.Segment{
+		{Sequence: 1, DiscontinuitySequence: 2},
+		{Sequence: 2, DiscontinuitySequence: 2},
+		// Tag dropped out of the window before this segment was seen
+		{Sequence: 3, DiscontinuitySequence: 3},
+		// Parsed from a refresh without EXT-X-DISCONTINUITY-SEQUENCE
+		{Sequence: 4, DiscontinuitySequence: 0},
+		{Sequence: 5, DiscontinuitySequence: 1, Discontinuity: true},
+	}
+
+	got := DiscontinuityParts(segments)
+	want := [][]int{{1, 2}, {3, 4}, {5}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("DiscontinuityParts = %v, want %v", got, want)
+	}
+}
+
+func TestMergeSegmentsByteRanges(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/index.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:1\n",
+			"#EXTINF:4,\n#EXT-X-BYTERANGE:6@0\nmain.ts\n",
+			"#EXTINF:4,\n#EXT-X-BYTERANGE:6\nmain.ts\n")
+	})
+	mux.HandleFunc("/main.ts", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp2t")
+		http.ServeContent(w, r, "main.ts", time.Time{}, strings.NewReader("[seg1][seg2][trailer]"))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	content, err := hls.NewFetcher().FetchPlaylist(server.URL + "/index.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	segments, err := hls.ParsePlaylist(content, server.URL+"/index.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sequences []int
+	for _, seg := range segments {
+		if _, _, err := manager.DownloadSegment(context.Background(), seg); err != nil {
+			t.Fatalf("segment %d: DownloadSegment returned error: %v", seg.Sequence, err)
+		}
+		sequences = append(sequences, seg.Sequence)
+	}
+
+	output := filepath.Join(t.TempDir(), "out.ts")
+	if _, err := manager.MergeSegments(output, sequences); err != nil {
+		t.Fatalf("MergeSegments returned error: %v", err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "[seg1][seg2]" {
+		t.Errorf("merged output = %q, want %q", data, "[seg1][seg2]")
+	}
+}
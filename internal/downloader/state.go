@@ -0,0 +1,167 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/bariiss/stream-capture/internal/hls"
+)
+
+// State is the JSON manifest of a capture in progress, persisted after
+// every downloaded segment so an interrupted capture can be resumed with
+// ResumeManager.
+type State struct {
+	// TempDir holds the downloaded segments; it outlives the process.
+	TempDir     string   `json:"temp_dir"`
+	PlaylistURL string   `json:"playlist_url"`
+	Outputs     []string `json:"outputs"`
+	// StartSequence and TargetSequence delimit the captured range.
+	StartSequence  int `json:"start_sequence"`
+	TargetSequence int `json:"target_sequence"`
+	// Segments lists the completely downloaded segments.
+	Segments []StateSegment `json:"segments"`
+	// Inits maps EXT-X-MAP initialization segment URLs to their files.
+	Inits map[string]string `json:"inits,omitempty"`
+}
+
+// StateSegment is a downloaded segment recorded in a State.
+type StateSegment struct {
+	SegmentInfo
+	Path string `json:"path"`
+	// Map is the segment's initialization segment URL, if any.
+	Map string `json:"map,omitempty"`
+}
+
+// LoadState reads a state file written by a Manager with SaveState.
+func LoadState(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	if state.TempDir == "" {
+		return nil, fmt.Errorf("state file %s has no temp directory", path)
+	}
+	return &state, nil
+}
+
+// SaveState makes the manager write its state to path now and after every
+// downloaded segment. state supplies the capture's details; its TempDir,
+// Segments and Inits are filled in by the manager.
+func (m *Manager) SaveState(path string, state State) error {
+	m.mu.Lock()
+	m.statePath = path
+	m.state = state
+	m.mu.Unlock()
+	return m.writeState()
+}
+
+// ResumeManager creates a manager continuing the capture recorded in state.
+// Segments whose files are still on disk with the recorded size and
+// SHA-256 count as downloaded; the others are downloaded again.
+func ResumeManager(state *State, fetcher *hls.Fetcher) (*Manager, error) {
+	m, err := NewManagerWithFetcher(state.TempDir, fetcher)
+	if err != nil {
+		return nil, err
+	}
+
+	for url, path := range state.Inits {
+		if _, err := os.Stat(path); err == nil {
+			m.inits[url] = path
+		}
+	}
+	for _, seg := range state.Segments {
+		if seg.Map != "" && m.inits[seg.Map] == "" {
+			continue
+		}
+		if !fileMatches(seg.Path, seg.Size, seg.SHA256) {
+			continue
+		}
+		m.segments[seg.Sequence] = seg.Path
+		m.info[seg.Sequence] = seg.SegmentInfo
+		m.stored += seg.Size
+		if store, ok := m.store.(*FSStore); ok {
+			store.setExt(seg.Sequence, filepath.Ext(seg.Path))
+		}
+		if seg.Map != "" {
+			m.maps[seg.Sequence] = seg.Map
+		}
+	}
+	return m, nil
+}
+
+// writeState persists the manager's state, if SaveState enabled it. The
+// file is replaced atomically so a crash never leaves it truncated, and
+// stateMu is held from the snapshot to the rename so concurrent writes land
+// in the order their snapshots were taken: an older snapshot never
+// replaces a newer one.
+func (m *Manager) writeState() error {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+
+	m.mu.RLock()
+	if m.statePath == "" {
+		m.mu.RUnlock()
+		return nil
+	}
+	state := m.state
+	state.TempDir = m.tempDir
+	state.Segments = make([]StateSegment, 0, len(m.segments))
+	for seq, path := range m.segments {
+		state.Segments = append(state.Segments, StateSegment{SegmentInfo: m.info[seq], Path: path, Map: m.maps[seq]})
+	}
+	if len(m.inits) > 0 {
+		state.Inits = make(map[string]string, len(m.inits))
+		for url, path := range m.inits {
+			state.Inits[url] = path
+		}
+	}
+	statePath := m.statePath
+	m.mu.RUnlock()
+
+	slices.SortFunc(state.Segments, func(a, b StateSegment) int {
+		return a.Sequence - b.Sequence
+	})
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	tmp := statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp, statePath); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
+
+// fileMatches reports whether the file at path has the given size and
+// hex-encoded SHA-256.
+func fileMatches(path string, size int64, sum string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	if info, err := f.Stat(); err != nil || info.Size() != size {
+		return false
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false
+	}
+	return hex.EncodeToString(h.Sum(nil)) == sum
+}
@@ -0,0 +1,130 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bariiss/stream-capture/internal/hls"
+)
+
+func TestResumeManagerFromState(t *testing.T) {
+	var hits atomic.Int32
+	mux := http.NewServeMux()
+	for seq := 1; seq <= 3; seq++ {
+		serveMedia(mux, fmt.Sprintf("/seg%d.ts", seq), []byte(fmt.Sprintf("[seg%d]", seq)), &hits)
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	segment := func(seq int) *hls.Segment {
+		return &hls.Segment{URL: fmt.Sprintf("%s/seg%d.ts", server.URL, seq), Sequence: seq}
+	}
+
+	tempDir := t.TempDir()
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	manager, err := NewManager(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.SaveState(statePath, State{PlaylistURL: server.URL, StartSequence: 1, TargetSequence: 3}); err != nil {
+		t.Fatalf("SaveState returned error: %v", err)
+	}
+	for seq := 1; seq <= 2; seq++ {
+		if _, _, err := manager.DownloadSegment(context.Background(), segment(seq)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	state, err := LoadState(statePath)
+	if err != nil {
+		t.Fatalf("LoadState returned error: %v", err)
+	}
+	if state.TempDir != tempDir || state.StartSequence != 1 || state.TargetSequence != 3 || len(state.Segments) != 2 {
+		t.Fatalf("state = %+v, want 2 segments of range 1-3 in %s", state, tempDir)
+	}
+
+	// A segment changed on disk must not be trusted
+	if err := os.WriteFile(state.Segments[1].Path, []byte("[bad2]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := ResumeManager(state, hls.NewFetcher())
+	if err != nil {
+		t.Fatalf("ResumeManager returned error: %v", err)
+	}
+	if _, ok := resumed.GetSegmentPath(1); !ok {
+		t.Error("segment 1 should be restored from the state")
+	}
+	if _, ok := resumed.GetSegmentPath(2); ok {
+		t.Error("modified segment 2 should not be restored")
+	}
+
+	hits.Store(0)
+	var sequences []int
+	for seq := 1; seq <= 3; seq++ {
+		if _, _, err := resumed.DownloadSegment(context.Background(), segment(seq)); err != nil {
+			t.Fatal(err)
+		}
+		sequences = append(sequences, seq)
+	}
+	if n := hits.Load(); n != 2 {
+		t.Errorf("resumed capture made %d requests, want 2", n)
+	}
+
+	output := filepath.Join(t.TempDir(), "out.ts")
+	if _, err := resumed.MergeSegments(output, sequences); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(output); string(got) != "[seg1][seg2][seg3]" {
+		t.Errorf("merged output = %q", got)
+	}
+}
+
+func TestStateAfterConcurrentDownloads(t *testing.T) {
+	var hits atomic.Int32
+	mux := http.NewServeMux()
+	const count = 20
+	for seq := 1; seq <= count; seq++ {
+		serveMedia(mux, fmt.Sprintf("/seg%d.ts", seq), []byte(fmt.Sprintf("[seg%d]", seq)), &hits)
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	manager, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.SaveState(statePath, State{PlaylistURL: server.URL, StartSequence: 1, TargetSequence: count}); err != nil {
+		t.Fatalf("SaveState returned error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for seq := 1; seq <= count; seq++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			segment := &hls.Segment{URL: fmt.Sprintf("%s/seg%d.ts", server.URL, seq), Sequence: seq}
+			if _, _, err := manager.DownloadSegment(context.Background(), segment); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The last write holds every segment, not an older snapshot
+	state, err := LoadState(statePath)
+	if err != nil {
+		t.Fatalf("LoadState returned error: %v", err)
+	}
+	if len(state.Segments) != count {
+		t.Errorf("state lists %d segments, want %d", len(state.Segments), count)
+	}
+}
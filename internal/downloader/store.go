@@ -0,0 +1,159 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bariiss/stream-capture/internal/hls"
+)
+
+// SegmentStore keeps downloaded segments by media sequence number. The
+// Manager downloads each segment into its temporary directory first, so
+// interrupted downloads can be resumed, then hands the complete segment to
+// the store, which merging reads it back from.
+type SegmentStore interface {
+	// Put stores the segment read from r, replacing an earlier copy.
+	Put(sequence int, r io.Reader) error
+	// Open returns the stored segment's contents.
+	Open(sequence int) (io.ReadCloser, error)
+	// Remove deletes the stored segment. Removing a segment that isn't
+	// stored is not an error.
+	Remove(sequence int) error
+	// List returns the sequence numbers of the stored segments in
+	// ascending order.
+	List() ([]int, error)
+}
+
+// fileStore is implemented by stores keeping segments as local files. The
+// Manager moves downloaded files into them instead of copying the data,
+// and reports their paths.
+type fileStore interface {
+	SegmentStore
+	// PutFile moves the file at path into the store as the segment, with
+	// ext as its file extension.
+	PutFile(sequence int, path, ext string) error
+	// Path returns the file the segment is stored in.
+	Path(sequence int) string
+}
+
+// FSStore is the default SegmentStore, keeping each segment as a
+// segment_<sequence><ext> file in a directory, where ext is the extension
+// of the segment's URL (".ts", ".m4s", ...).
+type FSStore struct {
+	dir string
+
+	mu sync.Mutex
+	// exts records the extension of each segment not stored as .ts
+	exts map[int]string
+}
+
+// NewFSStore returns a store keeping segments in dir, which must exist.
+func NewFSStore(dir string) *FSStore {
+	return &FSStore{dir: dir, exts: make(map[int]string)}
+}
+
+// Path returns the file the segment with the given sequence is stored in.
+func (s *FSStore) Path(sequence int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("segment_%d%s", sequence, s.ext(sequence)))
+}
+
+// ext returns the extension the segment is stored with.
+func (s *FSStore) ext(sequence int) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ext, ok := s.exts[sequence]; ok {
+		return ext
+	}
+	return hls.DefaultSegmentExt
+}
+
+// setExt records the extension the segment is stored with.
+func (s *FSStore) setExt(sequence int, ext string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ext == "" || ext == hls.DefaultSegmentExt {
+		delete(s.exts, sequence)
+	} else {
+		s.exts[sequence] = ext
+	}
+}
+
+// Put writes the segment to a temporary file and renames it into place,
+// so a failed write never leaves a truncated segment behind.
+func (s *FSStore) Put(sequence int, r io.Reader) error {
+	tmp, err := os.CreateTemp(s.dir, fmt.Sprintf("segment_%d.*.tmp", sequence))
+	if err != nil {
+		return fmt.Errorf("failed to create segment file: %w", err)
+	}
+	_, copyErr := io.Copy(tmp, r)
+	closeErr := tmp.Close()
+	if err := errors.Join(copyErr, closeErr); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write segment file: %w", err)
+	}
+	return s.PutFile(sequence, tmp.Name(), s.ext(sequence))
+}
+
+// PutFile moves the file at path into the store as segment_<sequence><ext>.
+// It must be on the same file system as the store's directory.
+func (s *FSStore) PutFile(sequence int, path, ext string) error {
+	// A copy stored earlier under another extension is replaced
+	if old := s.Path(sequence); filepath.Ext(old) != ext {
+		os.Remove(old)
+	}
+	s.setExt(sequence, ext)
+	if err := os.Rename(path, s.Path(sequence)); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to finalize segment file: %w", err)
+	}
+	return nil
+}
+
+// Open opens the segment's file.
+func (s *FSStore) Open(sequence int) (io.ReadCloser, error) {
+	return os.Open(s.Path(sequence))
+}
+
+// Remove deletes the segment's file.
+func (s *FSStore) Remove(sequence int) error {
+	if err := os.Remove(s.Path(sequence)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	s.setExt(sequence, "")
+	return nil
+}
+
+// List returns the sequences of the segment files in the directory,
+// whatever their extension, and remembers the extensions.
+func (s *FSStore) List() ([]int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var sequences []int
+	for _, entry := range entries {
+		name, ok := strings.CutPrefix(entry.Name(), "segment_")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		// Partial and temporary files carry a second extension
+		number, ext, ok := strings.Cut(name, ".")
+		if !ok || strings.Contains(ext, ".") {
+			continue
+		}
+		if seq, err := strconv.Atoi(number); err == nil {
+			s.setExt(seq, "."+ext)
+			sequences = append(sequences, seq)
+		}
+	}
+	slices.Sort(sequences)
+	return sequences, nil
+}
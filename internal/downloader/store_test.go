@@ -0,0 +1,219 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bariiss/stream-capture/internal/hls"
+)
+
+// memStore is an in-memory SegmentStore.
+type memStore struct {
+	mu       sync.Mutex
+	segments map[int][]byte
+}
+
+func newMemStore() *memStore {
+	return &memStore{segments: make(map[int][]byte)}
+}
+
+func (s *memStore) Put(sequence int, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.segments[sequence] = data
+	return nil
+}
+
+func (s *memStore) Open(sequence int) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.segments[sequence]
+	if !ok {
+		return nil, fmt.Errorf("segment %d not stored", sequence)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *memStore) Remove(sequence int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.segments, sequence)
+	return nil
+}
+
+func (s *memStore) List() ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Sorted(maps.Keys(s.segments)), nil
+}
+
+func TestManagerWithCustomStore(t *testing.T) {
+	mux := http.NewServeMux()
+	serveMedia(mux, "/seg1.ts", []byte("[seg1]"), nil)
+	serveMedia(mux, "/seg2.ts", []byte("[seg2]"), nil)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	store := newMemStore()
+	tempDir := t.TempDir()
+	manager, err := NewManagerWithStore(tempDir, hls.NewFetcher(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for seq := 1; seq <= 2; seq++ {
+		seg := &hls.Segment{URL: fmt.Sprintf("%s/seg%d.ts", server.URL, seq), Sequence: seq}
+		path, _, err := manager.DownloadSegment(context.Background(), seg)
+		if err != nil {
+			t.Fatalf("segment %d: DownloadSegment returned error: %v", seq, err)
+		}
+		if path != "" {
+			t.Errorf("segment %d: path = %q, want none for a store without files", seq, path)
+		}
+	}
+
+	// Only the store holds the segments; the temp dir keeps no copy
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("temp dir holds %d files, want 0", len(entries))
+	}
+	if got, _ := store.List(); !slices.Equal(got, []int{1, 2}) {
+		t.Errorf("stored sequences = %v, want [1 2]", got)
+	}
+
+	var merged bytes.Buffer
+	if err := manager.WriteSegments(&merged, []int{1, 2}); err != nil {
+		t.Fatalf("WriteSegments returned error: %v", err)
+	}
+	if got, want := merged.String(), "[seg1][seg2]"; got != want {
+		t.Errorf("merged output = %q, want %q", got, want)
+	}
+
+	if err := manager.Cleanup(); err != nil {
+		t.Fatalf("Cleanup returned error: %v", err)
+	}
+	if got, _ := store.List(); len(got) != 0 {
+		t.Errorf("stored sequences after Cleanup = %v, want none", got)
+	}
+}
+
+func TestFSStore(t *testing.T) {
+	dir := t.TempDir()
+	store := NewFSStore(dir)
+
+	for _, seq := range []int{10, 2} {
+		if err := store.Put(seq, strings.NewReader(fmt.Sprintf("[seg%d]", seq))); err != nil {
+			t.Fatalf("Put(%d) returned error: %v", seq, err)
+		}
+	}
+	// Segments of other containers keep their extension
+	if err := os.WriteFile(dir+"/chunk.tmp", []byte("[seg7]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutFile(7, dir+"/chunk.tmp", ".m4s"); err != nil {
+		t.Fatalf("PutFile returned error: %v", err)
+	}
+	if got := filepath.Base(store.Path(7)); got != "segment_7.m4s" {
+		t.Errorf("Path(7) = %s, want segment_7.m4s", got)
+	}
+	// Unrelated files in the directory are not segments
+	if err := os.WriteFile(dir+"/segment_3.ts.part", []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.List()
+	if err != nil {
+		t.Fatalf("List returned error: %v", err)
+	}
+	if !slices.Equal(got, []int{2, 7, 10}) {
+		t.Errorf("List = %v, want [2 7 10]", got)
+	}
+
+	r, err := store.Open(10)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "[seg10]" {
+		t.Errorf("segment 10 = %q, want %q", data, "[seg10]")
+	}
+
+	if err := store.Remove(10); err != nil {
+		t.Fatalf("Remove returned error: %v", err)
+	}
+	if err := store.Remove(10); err != nil {
+		t.Errorf("removing a missing segment returned error: %v", err)
+	}
+	if got, _ := store.List(); !slices.Equal(got, []int{2, 7}) {
+		t.Errorf("List after Remove = %v, want [2 7]", got)
+	}
+
+	// A new store finds the extension of existing segments
+	reopened := NewFSStore(dir)
+	if _, err := reopened.List(); err != nil {
+		t.Fatal(err)
+	}
+	if got := filepath.Base(reopened.Path(7)); got != "segment_7.m4s" {
+		t.Errorf("Path(7) after List = %s, want segment_7.m4s", got)
+	}
+}
+
+func TestManagerSegmentExtensions(t *testing.T) {
+	mux := http.NewServeMux()
+	serveMedia(mux, "/chunk-1.m4s", []byte("[seg1]"), nil)
+	serveMedia(mux, "/seg2.ts", []byte("[seg2]"), nil)
+	serveMedia(mux, "/media", []byte("[seg3]"), nil)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	manager, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	segments := []struct {
+		uri     string
+		wantExt string
+	}{
+		{"/media?seq=3", ".ts"},
+		{"/chunk-1.m4s", ".m4s"},
+		{"/seg2.ts", ".ts"},
+	}
+	// Downloaded out of order, merged in sequence order
+	for i, seq := range []int{3, 1, 2} {
+		uri := server.URL + segments[i].uri
+		seg := &hls.Segment{URL: uri, Sequence: seq, Ext: hls.SegmentExt(uri)}
+		path, _, err := manager.DownloadSegment(context.Background(), seg)
+		if err != nil {
+			t.Fatalf("segment %d: DownloadSegment returned error: %v", seq, err)
+		}
+		if want := fmt.Sprintf("segment_%d%s", seq, segments[i].wantExt); filepath.Base(path) != want {
+			t.Errorf("segment %d stored as %s, want %s", seq, filepath.Base(path), want)
+		}
+	}
+
+	var merged bytes.Buffer
+	if err := manager.WriteSegments(&merged, []int{1, 2, 3}); err != nil {
+		t.Fatalf("WriteSegments returned error: %v", err)
+	}
+	if got, want := merged.String(), "[seg1][seg2][seg3]"; got != want {
+		t.Errorf("merged output = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,85 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// durationPattern matches the container duration FFmpeg prints for its
+// input, e.g. "  Duration: 00:01:04.32, start: 1.400000, bitrate: ...".
+var durationPattern = regexp.MustCompile(`Duration: (\d+):(\d{2}):(\d{2}(?:\.\d+)?)`)
+
+// startPattern matches the start time on the same line: the first
+// presentation timestamp of the input, in seconds.
+var startPattern = regexp.MustCompile(`Duration: .*?, start: (-?\d+(?:\.\d+)?)`)
+
+// Duration returns the duration of the media file at path as reported by
+// FFmpeg, so no separate ffprobe binary is needed.
+func Duration(path string) (time.Duration, error) {
+	out, err := describe(path)
+	if err != nil {
+		return 0, err
+	}
+	duration, ok := parseDuration(out)
+	if !ok {
+		return 0, fmt.Errorf("could not determine the duration of %s", path)
+	}
+	return duration, nil
+}
+
+// StartTime returns the first presentation timestamp of the media file at
+// path as reported by FFmpeg. Segments cut from a live stream keep the
+// stream's timestamps, so a capture rarely starts at zero.
+func StartTime(path string) (time.Duration, error) {
+	out, err := describe(path)
+	if err != nil {
+		return 0, err
+	}
+	start, ok := parseStartTime(out)
+	if !ok {
+		return 0, fmt.Errorf("could not determine the start time of %s", path)
+	}
+	return start, nil
+}
+
+// describe returns FFmpeg's description of the input at path.
+func describe(path string) (string, error) {
+	ffmpegPath, err := LookPath()
+	if err != nil {
+		return "", err
+	}
+
+	// Without an output FFmpeg only describes the input and exits with an
+	// error, so the exit status is ignored in favor of the description.
+	out, _ := exec.Command(ffmpegPath, "-hide_banner", "-i", path).CombinedOutput()
+	return string(out), nil
+}
+
+// parseDuration extracts the input duration from FFmpeg's output.
+func parseDuration(output string) (time.Duration, bool) {
+	match := durationPattern.FindStringSubmatch(output)
+	if match == nil {
+		return 0, false
+	}
+	hours, _ := strconv.Atoi(match[1])
+	minutes, _ := strconv.Atoi(match[2])
+	seconds, _ := strconv.ParseFloat(match[3], 64)
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute +
+		time.Duration(seconds*float64(time.Second)), true
+}
+
+// parseStartTime extracts the input start time from FFmpeg's output.
+func parseStartTime(output string) (time.Duration, bool) {
+	match := startPattern.FindStringSubmatch(output)
+	if match == nil {
+		return 0, false
+	}
+	seconds, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(seconds * float64(time.Second)), true
+}
@@ -0,0 +1,38 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// LookPath finds the ffmpeg binary in PATH. The returned error includes
+// platform-specific installation instructions.
+func LookPath() (string, error) {
+	ffmpegPath, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return "", fmt.Errorf("ffmpeg not found in PATH: %w\n%s", err, InstallHint())
+	}
+	return ffmpegPath, nil
+}
+
+// InstallHint returns platform-specific installation instructions for FFmpeg.
+func InstallHint() string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "To install FFmpeg on macOS, run: brew install ffmpeg"
+	case "linux":
+		return "To install FFmpeg on Linux:\n" +
+			"  Ubuntu/Debian: sudo apt-get update && sudo apt-get install -y ffmpeg\n" +
+			"  Alpine: sudo apk add ffmpeg\n" +
+			"  CentOS/RHEL: sudo yum install ffmpeg (or sudo dnf install ffmpeg)"
+	case "windows":
+		return "To install FFmpeg on Windows:\n" +
+			"  1. Download from https://ffmpeg.org/download.html\n" +
+			"  2. Extract and add the bin directory to your PATH environment variable\n" +
+			"  Or use Chocolatey: choco install ffmpeg\n" +
+			"  Or use Scoop: scoop install ffmpeg"
+	default:
+		return "Please install FFmpeg for your platform. Visit https://ffmpeg.org/download.html"
+	}
+}
@@ -0,0 +1,92 @@
+package frame
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bariiss/stream-capture/internal/ffmpeg"
+)
+
+// Extractor grabs still frames from video files using FFmpeg.
+type Extractor struct {
+	ffmpegPath string
+}
+
+// NewExtractor creates a new frame extractor with FFmpeg path detection.
+func NewExtractor() (*Extractor, error) {
+	ffmpegPath, err := ffmpeg.LookPath()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Extractor{
+		ffmpegPath: ffmpegPath,
+	}, nil
+}
+
+// ValidateOutput checks that the image format, chosen by the extension of
+// path, is supported: .jpg, .jpeg or .png.
+func ValidateOutput(path string) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg", ".png":
+		return nil
+	default:
+		return fmt.Errorf("unsupported image format %q: use a .jpg or .png file", filepath.Ext(path))
+	}
+}
+
+// ExtractFrame writes the video frame at the given offset of videoPath to
+// outputPath as a JPEG or PNG image, chosen by its extension.
+func (e *Extractor) ExtractFrame(videoPath, outputPath string, at time.Duration) error {
+	if err := ValidateOutput(outputPath); err != nil {
+		return err
+	}
+
+	// Ensure output directory exists
+	outputDir := filepath.Dir(outputPath)
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	// A stale image would hide a failure to write the new one
+	if err := os.Remove(outputPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to replace %s: %w", outputPath, err)
+	}
+
+	// -ss before -i: seek the input, which is fast and lands on the frame
+	// -frames:v 1: write a single image
+	// -q:v 2: high JPEG quality (ignored for PNG)
+	// -y: overwrite output file if exists
+	cmd := exec.Command(e.ffmpegPath,
+		"-v", "error",
+		"-ss", strconv.FormatFloat(at.Seconds(), 'f', 3, 64),
+		"-i", videoPath,
+		"-frames:v", "1",
+		"-q:v", "2",
+		"-y",
+		outputPath,
+	)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("ffmpeg frame extraction failed: %w: %s", err, msg)
+		}
+		return fmt.Errorf("ffmpeg frame extraction failed: %w", err)
+	}
+
+	// FFmpeg succeeds without writing anything when seeking past the end
+	if _, err := os.Stat(outputPath); err != nil {
+		return fmt.Errorf("ffmpeg wrote no frame at %v of %s", at, videoPath)
+	}
+	return nil
+}
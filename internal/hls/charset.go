@@ -0,0 +1,59 @@
+package hls
+
+import (
+	"bytes"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// utf8BOM is the byte order mark some servers prepend to UTF-8 playlists.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// windows1252 maps the 0x80-0x9F range where Windows-1252 differs from
+// ISO-8859-1. Zero entries are undefined and fall back to Latin-1.
+var windows1252 = [32]rune{
+	'€', 0, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0, 'Ž', 0,
+	0, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0, 'ž', 'Ÿ',
+}
+
+// decodePlaylist converts a playlist body to a UTF-8 string. Any content type
+// is accepted (application/vnd.apple.mpegurl, audio/x-mpegurl, text/plain,
+// ...), but its charset parameter is honoured; without one, bodies that
+// aren't valid UTF-8 are treated as Latin-1, the historical encoding of .m3u
+// files.
+func decodePlaylist(body []byte, contentType string) string {
+	body = bytes.TrimPrefix(body, utf8BOM)
+
+	charset := ""
+	if _, params, err := mime.ParseMediaType(contentType); err == nil {
+		charset = strings.ToLower(params["charset"])
+	}
+
+	switch charset {
+	case "windows-1252", "cp1252":
+		return decodeSingleByte(body, true)
+	case "iso-8859-1", "latin1", "latin-1", "l1":
+		return decodeSingleByte(body, false)
+	}
+
+	if utf8.Valid(body) {
+		return string(body)
+	}
+	return decodeSingleByte(body, false)
+}
+
+// decodeSingleByte transcodes Latin-1 (or Windows-1252 if cp1252 is set)
+// bytes to UTF-8.
+func decodeSingleByte(body []byte, cp1252 bool) string {
+	var b strings.Builder
+	b.Grow(len(body))
+	for _, c := range body {
+		r := rune(c)
+		if cp1252 && c >= 0x80 && c <= 0x9F && windows1252[c-0x80] != 0 {
+			r = windows1252[c-0x80]
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
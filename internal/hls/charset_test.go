@@ -0,0 +1,47 @@
+package hls
+
+import "testing"
+
+func TestDecodePlaylist(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		want        string
+	}{
+		{"utf-8", "#EXTM3U\n#EXTINF:4,Café\na.ts\n", "application/vnd.apple.mpegurl", "#EXTM3U\n#EXTINF:4,Café\na.ts\n"},
+		{"byte order mark", "\xEF\xBB\xBF#EXTM3U\n", "application/vnd.apple.mpegurl", "#EXTM3U\n"},
+		{"byte order mark with charset", "\xEF\xBB\xBF#EXTM3U\n", "audio/x-mpegurl; charset=utf-8", "#EXTM3U\n"},
+		{"latin-1 charset", "#EXTINF:4,Caf\xE9\n", "audio/x-mpegurl; charset=ISO-8859-1", "#EXTINF:4,Café\n"},
+		{"latin1 alias", "#EXTINF:4,Caf\xE9\n", "audio/mpegurl; charset=latin1", "#EXTINF:4,Café\n"},
+		{"windows-1252 charset", "#EXTINF:4,\x93Live\x94 \x80\n", "text/plain; charset=windows-1252", "#EXTINF:4,“Live” €\n"},
+		// 0x81 is undefined in Windows-1252 and kept as its Latin-1 code point
+		{"windows-1252 undefined byte", "\x81", "text/plain; charset=cp1252", "\u0081"},
+		// Latin-1 maps 0x80-0x9F to C1 controls, not Windows-1252 punctuation
+		{"latin-1 C1 range", "\x93", "text/plain; charset=iso-8859-1", "\u0093"},
+		{"fallback without charset", "#EXTINF:4,Caf\xE9\n", "audio/x-mpegurl", "#EXTINF:4,Café\n"},
+		{"fallback without content type", "#EXTINF:4,Caf\xE9\n", "", "#EXTINF:4,Café\n"},
+		{"fallback with unknown charset", "#EXTINF:4,Caf\xE9\n", "text/plain; charset=koi8-r", "#EXTINF:4,Café\n"},
+		{"invalid content type", "#EXTM3U\n", "not a / type", "#EXTM3U\n"},
+	}
+	for _, tt := range tests {
+		if got := decodePlaylist([]byte(tt.body), tt.contentType); got != tt.want {
+			t.Errorf("%s: decodePlaylist(%q, %q) = %q, want %q", tt.name, tt.body, tt.contentType, got, tt.want)
+		}
+	}
+}
+
+func TestDecodePlaylistKeepsHeader(t *testing.T) {
+	body := "\xEF\xBB\xBF#EXTM3U\n#EXTINF:4,Gr\xFC\xDFe\nseg\xE9.ts\n"
+	content := decodePlaylist([]byte(body), "audio/x-mpegurl")
+	if !HasPlaylistHeader(content) {
+		t.Fatalf("decoded playlist %q lost its #EXTM3U header", content)
+	}
+	segments, err := ParsePlaylist(content, testMediaPlaylistURL)
+	if err != nil {
+		t.Fatalf("ParsePlaylist returned error: %v", err)
+	}
+	if len(segments) != 1 || segments[0].URL != "https://origin.example.com/live/seg%C3%A9.ts" {
+		t.Errorf("segments = %v, want the transcoded URI", segments)
+	}
+}
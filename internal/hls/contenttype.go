@@ -0,0 +1,73 @@
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"strings"
+)
+
+// DefaultSegmentContentTypes are the media types accepted for segment
+// responses unless Fetcher.SegmentContentTypes overrides them. A trailing
+// "/*" matches any subtype.
+var DefaultSegmentContentTypes = []string{
+	"video/*",
+	"audio/*",
+	"application/octet-stream",
+	"binary/octet-stream",
+	"application/mp4",
+	// WebVTT segments of subtitle renditions
+	"text/vtt",
+}
+
+// sniffSize is how much of an untyped segment body is inspected for an
+// error page.
+const sniffSize = 512
+
+// checkSegmentType rejects a segment response that is not media: a
+// Content-Type outside accepted or, when the header is missing, a body that
+// looks like an HTML or JSON error page. It returns a reader that still
+// yields the whole body.
+func checkSegmentType(url, contentType string, body io.Reader, accepted []string) (io.Reader, error) {
+	if accepted == nil {
+		accepted = DefaultSegmentContentTypes
+	}
+
+	if contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || !matchesContentType(mediaType, accepted) {
+			return nil, &ContentTypeError{URL: url, ContentType: contentType}
+		}
+		return body, nil
+	}
+
+	buffered := bufio.NewReaderSize(body, sniffSize)
+	head, _ := buffered.Peek(sniffSize)
+	if looksLikeErrorPage(head) {
+		return nil, &ContentTypeError{URL: url}
+	}
+	return buffered, nil
+}
+
+// matchesContentType reports whether mediaType is one of accepted, where
+// "*" accepts anything and "type/*" any subtype of type.
+func matchesContentType(mediaType string, accepted []string) bool {
+	for _, pattern := range accepted {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "*" || pattern == "*/*" || pattern == mediaType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// looksLikeErrorPage reports whether a body starts like markup or JSON,
+// which no media segment does.
+func looksLikeErrorPage(head []byte) bool {
+	head = bytes.TrimLeft(head, " \t\r\n\ufeff")
+	return len(head) > 0 && (head[0] == '<' || head[0] == '{')
+}
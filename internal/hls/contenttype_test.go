@@ -0,0 +1,127 @@
+package hls
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMatchesContentType(t *testing.T) {
+	tests := []struct {
+		mediaType string
+		accepted  []string
+		want      bool
+	}{
+		{"video/mp2t", DefaultSegmentContentTypes, true},
+		{"audio/aac", DefaultSegmentContentTypes, true},
+		{"application/octet-stream", DefaultSegmentContentTypes, true},
+		{"application/mp4", DefaultSegmentContentTypes, true},
+		{"text/html", DefaultSegmentContentTypes, false},
+		{"application/json", DefaultSegmentContentTypes, false},
+		// "video/*" must not match a type merely starting with "video"
+		{"videos/mp2t", DefaultSegmentContentTypes, false},
+		{"text/html", []string{"*"}, true},
+		{"text/html", []string{"*/*"}, true},
+		{"video/mp2t", []string{" Video/MP2T "}, true},
+		{"video/mp2t", []string{"VIDEO/*"}, true},
+		{"video/mp2t", []string{"audio/*"}, false},
+		{"video/mp2t", []string{}, false},
+	}
+	for _, tt := range tests {
+		if got := matchesContentType(tt.mediaType, tt.accepted); got != tt.want {
+			t.Errorf("matchesContentType(%q, %q) = %v, want %v", tt.mediaType, tt.accepted, got, tt.want)
+		}
+	}
+}
+
+func TestLooksLikeErrorPage(t *testing.T) {
+	tests := []struct {
+		name string
+		head string
+		want bool
+	}{
+		{"html", "<!DOCTYPE html><html>", true},
+		{"xml", "<?xml version=\"1.0\"?><Error>", true},
+		{"json", `{"error":"not found"}`, true},
+		{"leading whitespace", "\r\n  <html>", true},
+		{"byte order mark", "\ufeff{\"error\":1}", true},
+		{"transport stream", "\x47\x40\x00\x10", false},
+		{"fragmented mp4", "\x00\x00\x00\x18ftypiso6", false},
+		{"empty", "", false},
+		{"whitespace only", " \n", false},
+	}
+	for _, tt := range tests {
+		if got := looksLikeErrorPage([]byte(tt.head)); got != tt.want {
+			t.Errorf("%s: looksLikeErrorPage(%q) = %v, want %v", tt.name, tt.head, got, tt.want)
+		}
+	}
+}
+
+func TestCheckSegmentType(t *testing.T) {
+	const (
+		htmlPage = "<html><body>404 Not Found</body></html>"
+		jsonBody = `{"error":"token expired"}`
+		media    = "\x47\x40\x00\x10 transport stream"
+	)
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		accepted    []string
+		wantErr     bool
+	}{
+		{"media type", "video/mp2t", media, nil, false},
+		{"parameterised", "video/mp2t; charset=binary", media, nil, false},
+		{"upper case", "Video/MP2T", media, nil, false},
+		{"wildcard subtype", "audio/x-aac", media, nil, false},
+		{"rejected type", "text/html; charset=utf-8", htmlPage, nil, true},
+		{"invalid header", "video/", media, nil, true},
+		{"custom list", "application/x-custom", media, []string{"application/x-custom"}, false},
+		{"custom list rejects default", "video/mp2t", media, []string{"application/x-custom"}, true},
+		{"accept anything", "text/html", htmlPage, []string{"*"}, false},
+		// A declared media type is trusted: the body isn't sniffed
+		{"html served as media", "video/mp2t", htmlPage, nil, false},
+		{"json served as media", "application/octet-stream", jsonBody, nil, false},
+		// Without a Content-Type, the body is sniffed
+		{"untyped media", "", media, nil, false},
+		{"untyped html", "", htmlPage, nil, true},
+		{"untyped json", "", jsonBody, nil, true},
+		{"untyped empty", "", "", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, err := checkSegmentType("http://example.com/seg.ts", tt.contentType, strings.NewReader(tt.body), tt.accepted)
+			if tt.wantErr {
+				var typeErr *ContentTypeError
+				if !errors.As(err, &typeErr) {
+					t.Fatalf("error = %v, want a ContentTypeError", err)
+				}
+				if typeErr.ContentType != tt.contentType {
+					t.Errorf("ContentTypeError.ContentType = %q, want %q", typeErr.ContentType, tt.contentType)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("checkSegmentType returned error: %v", err)
+			}
+			// Sniffing must not consume the start of the body
+			data, err := io.ReadAll(reader)
+			if err != nil || string(data) != tt.body {
+				t.Errorf("body = %q, %v; want %q", data, err, tt.body)
+			}
+		})
+	}
+}
+
+func TestCheckSegmentTypeSniffsLargeBody(t *testing.T) {
+	body := strings.Repeat("\x47", 3*sniffSize)
+	reader, err := checkSegmentType("http://example.com/seg.ts", "", strings.NewReader(body), nil)
+	if err != nil {
+		t.Fatalf("checkSegmentType returned error: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	if string(data) != body {
+		t.Errorf("read %d bytes, want the whole %d-byte body", len(data), len(body))
+	}
+}
@@ -0,0 +1,96 @@
+package hls
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// httpOnlyPrefix marks HttpOnly cookies in cookies.txt files written by curl
+// and browser extensions; the line is a cookie, not a comment.
+const httpOnlyPrefix = "#HttpOnly_"
+
+// LoadCookieFile adds the cookies of a Netscape-format cookies.txt file (as
+// written by curl, wget or browser extensions) to the Fetcher's cookie jar.
+func (f *Fetcher) LoadCookieFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open cookie file: %w", err)
+	}
+	defer file.Close()
+
+	return f.LoadCookies(file)
+}
+
+// LoadCookies adds the cookies of a Netscape-format cookies.txt stream to
+// the Fetcher's cookie jar. Each line holds seven tab-separated fields:
+// domain, include subdomains, path, secure, expiry (Unix seconds, 0 for a
+// session cookie), name and value.
+func (f *Fetcher) LoadCookies(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		httpOnly := strings.HasPrefix(line, httpOnlyPrefix)
+		line = strings.TrimPrefix(line, httpOnlyPrefix)
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		u, cookie, err := parseCookieLine(line)
+		if err != nil {
+			return fmt.Errorf("cookie file line %d: %w", lineNo, err)
+		}
+		cookie.HttpOnly = httpOnly
+		f.client.Jar.SetCookies(u, []*http.Cookie{cookie})
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read cookie file: %w", err)
+	}
+	return nil
+}
+
+// parseCookieLine parses one cookies.txt entry into the cookie and the URL
+// it is set for.
+func parseCookieLine(line string) (*url.URL, *http.Cookie, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) != 7 {
+		return nil, nil, fmt.Errorf("expected 7 tab-separated fields, got %d", len(fields))
+	}
+	domain, subdomains, path, secure, expiry, name, value := fields[0], fields[1], fields[2], fields[3], fields[4], fields[5], fields[6]
+
+	host := strings.TrimPrefix(domain, ".")
+	if host == "" {
+		return nil, nil, fmt.Errorf("empty domain")
+	}
+	expires, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid expiry %q", expiry)
+	}
+
+	cookie := &http.Cookie{
+		Name:   name,
+		Value:  value,
+		Path:   path,
+		Secure: strings.EqualFold(secure, "TRUE"),
+	}
+	// A Domain attribute makes the jar send the cookie to subdomains too;
+	// without it the cookie is host-only.
+	if strings.EqualFold(subdomains, "TRUE") {
+		cookie.Domain = host
+	}
+	if expires > 0 {
+		cookie.Expires = time.Unix(expires, 0)
+	}
+
+	scheme := "http"
+	if cookie.Secure {
+		scheme = "https"
+	}
+	return &url.URL{Scheme: scheme, Host: host, Path: path}, cookie, nil
+}
@@ -0,0 +1,89 @@
+package hls
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFetcherCarriesCookiesToSegments(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			// Cookies set on a redirect must be kept too
+			http.SetCookie(w, &http.Cookie{Name: "auth", Value: "redirect", Path: "/"})
+			http.Redirect(w, r, "/index.m3u8", http.StatusFound)
+		case "/index.m3u8":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+			w.Write([]byte("#EXTM3U\n"))
+		default:
+			for _, name := range []string{"auth", "session"} {
+				if _, err := r.Cookie(name); err != nil {
+					t.Errorf("segment request is missing the %s cookie", name)
+				}
+			}
+			w.Header().Set("Content-Type", "video/mp2t")
+			w.Write([]byte("segment"))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	f := NewFetcher()
+	if _, err := f.FetchPlaylist(server.URL + "/login"); err != nil {
+		t.Fatalf("FetchPlaylist returned error: %v", err)
+	}
+	if _, err := f.FetchSegment(server.URL+"/seg1.ts", &bytes.Buffer{}); err != nil {
+		t.Fatalf("FetchSegment returned error: %v", err)
+	}
+}
+
+func TestLoadCookies(t *testing.T) {
+	got := make(chan map[string]string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookies := make(map[string]string)
+		for _, c := range r.Cookies() {
+			cookies[c.Name] = c.Value
+		}
+		got <- cookies
+		w.Write([]byte("#EXTM3U\n"))
+	}))
+	t.Cleanup(server.Close)
+
+	cookiesTxt := strings.Join([]string{
+		"# Netscape HTTP Cookie File",
+		"",
+		"127.0.0.1\tFALSE\t/\tFALSE\t0\tsession\tabc",
+		"#HttpOnly_127.0.0.1\tFALSE\t/\tFALSE\t4102444800\ttoken\txyz",
+		"127.0.0.1\tFALSE\t/\tFALSE\t1\texpired\told",
+		"127.0.0.1\tFALSE\t/private\tFALSE\t0\tscoped\tno",
+		"other.example.com\tTRUE\t/\tFALSE\t0\tother\tno",
+	}, "\n")
+
+	f := NewFetcher()
+	if err := f.LoadCookies(strings.NewReader(cookiesTxt)); err != nil {
+		t.Fatalf("LoadCookies returned error: %v", err)
+	}
+	if _, err := f.FetchPlaylist(server.URL + "/index.m3u8"); err != nil {
+		t.Fatalf("FetchPlaylist returned error: %v", err)
+	}
+
+	cookies := <-got
+	want := map[string]string{"session": "abc", "token": "xyz"}
+	if len(cookies) != len(want) {
+		t.Errorf("sent cookies %v, want %v", cookies, want)
+	}
+	for name, value := range want {
+		if cookies[name] != value {
+			t.Errorf("cookie %s = %q, want %q", name, cookies[name], value)
+		}
+	}
+}
+
+func TestLoadCookiesInvalidLine(t *testing.T) {
+	err := NewFetcher().LoadCookies(strings.NewReader("# comment\nexample.com\tTRUE\t/\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("LoadCookies error = %v, want an error for line 2", err)
+	}
+}
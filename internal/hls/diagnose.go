@@ -0,0 +1,95 @@
+package hls
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+// maxDiagnoseRedirects matches the redirect limit of the default client.
+const maxDiagnoseRedirects = 10
+
+// Diagnosis describes a playlist request in detail, for troubleshooting a
+// stream that fails to load.
+type Diagnosis struct {
+	StatusCode  int
+	ContentType string
+	// Redirects lists every URL the request was redirected to, in order.
+	// The last one served the response.
+	Redirects []string
+	// TLS is the connection state of the final response, nil over HTTP.
+	TLS *tls.ConnectionState
+	// TLSHandshake is how long the last TLS handshake took, 0 over HTTP or
+	// on a reused connection.
+	TLSHandshake time.Duration
+	// Body is the decoded response body.
+	Body string
+	// Duration is the time until the whole body was read.
+	Duration time.Duration
+}
+
+// Diagnose fetches a playlist like FetchPlaylist, but records the redirect
+// chain and TLS state and returns the response whatever its status code.
+func (f *Fetcher) Diagnose(rawURL string) (*Diagnosis, error) {
+	return f.DiagnoseContext(context.Background(), rawURL)
+}
+
+// DiagnoseContext is like Diagnose, but aborts the request when ctx is
+// canceled.
+func (f *Fetcher) DiagnoseContext(ctx context.Context, rawURL string) (*Diagnosis, error) {
+	var redirects []string
+	client := *f.client
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxDiagnoseRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxDiagnoseRedirects)
+		}
+		redirects = append(redirects, req.URL.String())
+		return nil
+	}
+
+	var handshakeStart time.Time
+	var handshake time.Duration
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		TLSHandshakeStart: func() { handshakeStart = time.Now() },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			handshake = time.Since(handshakeStart)
+		},
+	})
+	req, err := f.newRequest(ctx, http.MethodGet, rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create playlist request: %w", err)
+	}
+
+	release, err := f.HostLimiter.acquire(ctx, rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch playlist: %w", err)
+	}
+	defer release()
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch playlist: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(limitBody(resp.Body, f.MaxPlaylistSize, &SizeLimitError{Kind: "playlist", URL: rawURL, Limit: f.MaxPlaylistSize}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read playlist: %w", err)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	return &Diagnosis{
+		StatusCode:   resp.StatusCode,
+		ContentType:  contentType,
+		Redirects:    redirects,
+		TLS:          resp.TLS,
+		TLSHandshake: handshake,
+		Body:         decodePlaylist(body, contentType),
+		Duration:     time.Since(start),
+	}, nil
+}
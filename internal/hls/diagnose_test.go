@@ -0,0 +1,70 @@
+package hls
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDiagnoseTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old.m3u8" {
+			http.Redirect(w, r, "/index.m3u8", http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:2\n"))
+	}))
+	t.Cleanup(server.Close)
+
+	config, err := NewTLSConfig(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := NewFetcher()
+	f.SetTLSConfig(config)
+
+	diagnosis, err := f.Diagnose(server.URL + "/old.m3u8")
+	if err != nil {
+		t.Fatalf("Diagnose returned error: %v", err)
+	}
+	if diagnosis.StatusCode != http.StatusOK {
+		t.Errorf("StatusCode = %d, want 200", diagnosis.StatusCode)
+	}
+	if diagnosis.ContentType != "application/vnd.apple.mpegurl" {
+		t.Errorf("ContentType = %q", diagnosis.ContentType)
+	}
+	if len(diagnosis.Redirects) != 1 || diagnosis.Redirects[0] != server.URL+"/index.m3u8" {
+		t.Errorf("Redirects = %v, want [%s/index.m3u8]", diagnosis.Redirects, server.URL)
+	}
+	if diagnosis.TLS == nil || len(diagnosis.TLS.PeerCertificates) == 0 {
+		t.Fatal("TLS state missing for an HTTPS response")
+	}
+	if !diagnosis.TLS.PeerCertificates[0].Equal(server.Certificate()) {
+		t.Error("TLS state doesn't hold the server's certificate")
+	}
+	if diagnosis.TLSHandshake <= 0 {
+		t.Error("TLSHandshake not measured for a new HTTPS connection")
+	}
+	if diagnosis.Body != "#EXTM3U\n#EXT-X-TARGETDURATION:2\n" {
+		t.Errorf("Body = %q", diagnosis.Body)
+	}
+}
+
+func TestDiagnosePlainHTTPErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusNotFound)
+	}))
+	t.Cleanup(server.Close)
+
+	diagnosis, err := NewFetcher().Diagnose(server.URL + "/index.m3u8")
+	if err != nil {
+		t.Fatalf("Diagnose returned error: %v", err)
+	}
+	if diagnosis.StatusCode != http.StatusNotFound {
+		t.Errorf("StatusCode = %d, want 404", diagnosis.StatusCode)
+	}
+	if diagnosis.TLS != nil {
+		t.Error("TLS state reported for a plain HTTP response")
+	}
+}
@@ -0,0 +1,109 @@
+package hls
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrSegmentExpired is matched by errors reporting that a segment has scrolled
+// out of the live playlist window and will never appear again.
+var ErrSegmentExpired = errors.New("segment expired from live window")
+
+// ErrMasterPlaylist is returned when a master playlist, which lists variant
+// streams instead of segments, is parsed as a media playlist.
+var ErrMasterPlaylist = errors.New("master playlist: select a variant to get its segments")
+
+// ErrByteRangeIgnored is matched by errors reporting that a server answered
+// the request for an EXT-X-BYTERANGE sub-range with something other than
+// that range, typically the whole file with 200 OK. Such requests are not
+// retried.
+var ErrByteRangeIgnored = errors.New("byte range not honoured")
+
+// SegmentExpiredError reports a sequence number older than the first segment
+// still present in the playlist.
+type SegmentExpiredError struct {
+	Sequence      int
+	FirstSequence int
+}
+
+func (e *SegmentExpiredError) Error() string {
+	return fmt.Sprintf("segment %d expired from live window (oldest available: %d)", e.Sequence, e.FirstSequence)
+}
+
+// Is makes errors.Is(err, ErrSegmentExpired) match a *SegmentExpiredError.
+func (e *SegmentExpiredError) Is(target error) bool {
+	return target == ErrSegmentExpired
+}
+
+// StatusError reports an HTTP response with an unexpected status code.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.StatusCode)
+}
+
+// ContentTypeError reports a segment response that is not media, typically
+// an HTML or JSON error page served with a 200 status.
+type ContentTypeError struct {
+	URL string
+	// ContentType is the rejected Content-Type header, empty if the body
+	// itself was recognized as an error page.
+	ContentType string
+}
+
+func (e *ContentTypeError) Error() string {
+	if e.ContentType == "" {
+		return fmt.Sprintf("segment %s returned an HTML or JSON body instead of media", e.URL)
+	}
+	return fmt.Sprintf("segment %s returned unexpected content type %q (see --segment-content-types)", e.URL, e.ContentType)
+}
+
+// ErrCredentialsExpired is matched by errors reporting a 403 Forbidden from
+// a stream that was previously accessible, which usually means a signed URL
+// or token has expired.
+var ErrCredentialsExpired = errors.New("credentials likely expired")
+
+// CredentialsExpiredError reports a 403 response after earlier requests to
+// the stream succeeded. It unwraps to the underlying *StatusError.
+type CredentialsExpiredError struct {
+	URL string
+	Err *StatusError
+}
+
+func (e *CredentialsExpiredError) Error() string {
+	return fmt.Sprintf("credentials likely expired: %s returned %d after earlier requests succeeded; refresh the signed URL or token", e.URL, e.Err.StatusCode)
+}
+
+// Is makes errors.Is(err, ErrCredentialsExpired) match a *CredentialsExpiredError.
+func (e *CredentialsExpiredError) Is(target error) bool {
+	return target == ErrCredentialsExpired
+}
+
+func (e *CredentialsExpiredError) Unwrap() error {
+	return e.Err
+}
+
+// IsRetryable reports whether a failed fetch may succeed when repeated:
+// network errors and the transient statuses 408, 429, 500, 502, 503 and
+// 504. Other statuses (e.g. 404), ignored byte ranges, bodies over a size
+// limit and canceled contexts are permanent.
+func IsRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrByteRangeIgnored) || errors.Is(err, ErrTooLarge) {
+		return false
+	}
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		return true
+	}
+	switch statusErr.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
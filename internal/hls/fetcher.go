@@ -1,64 +1,565 @@
 package hls
 
 import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/cookiejar"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Fetcher handles HTTP requests for HLS playlists and segments.
 type Fetcher struct {
 	client *http.Client
+
+	// HostLimiter, when set, bounds simultaneous requests per host. Share
+	// one limiter between Fetchers to bound them together.
+	HostLimiter *HostLimiter
+
+	// RateLimiter, when set, bounds the download speed of segment bodies.
+	// Share one limiter between Fetchers to bound their aggregate speed.
+	RateLimiter *RateLimiter
+
+	// SegmentContentTypes lists the media types accepted for segment
+	// responses; nil means DefaultSegmentContentTypes and "*" accepts any.
+	SegmentContentTypes []string
+
+	// Headers are added to every request (playlists, segments, keys and
+	// probes), e.g. User-Agent, Referer or an authorization token. A Host
+	// header overrides the request's host.
+	Headers http.Header
+
+	// MaxPlaylistSize bounds the decoded size of a playlist body, failing
+	// larger ones with an error matching ErrTooLarge; 0 means unlimited.
+	// NewFetcher sets DefaultMaxPlaylistSize.
+	MaxPlaylistSize int64
+	// MaxSegmentSize likewise bounds the size of a segment body, counting
+	// the bytes before a resumed offset; 0 means unlimited.
+	MaxSegmentSize int64
+
+	// SegmentTimeout fails a segment download, with an error matching
+	// ErrSegmentTimeout, once its body receives no data for this long; 0
+	// waits indefinitely. NewFetcher sets DefaultSegmentTimeout. Unlike
+	// other requests, segment requests have no overall time limit, so a
+	// large segment on a slow link completes as long as it progresses.
+	SegmentTimeout time.Duration
+
+	// Retry controls how playlist and segment requests failing with a
+	// network error or a transient status are retried.
+	Retry RetryPolicy
+	// OnRetry, if set, is called before waiting to retry a request for url;
+	// attempt is the number of the failed attempt, starting at 1.
+	OnRetry func(url string, attempt int, err error, delay time.Duration)
+
+	// keys caches AES-128 key material by key URI; keyFetches are the key
+	// requests in flight.
+	keysMu     sync.Mutex
+	keys       map[string][]byte
+	keyFetches map[string]*keyFetch
+
+	// accessed is set once any playlist or segment request succeeded, so a
+	// later 403 can be told apart from a stream that was never accessible.
+	accessed atomic.Bool
+}
+
+// SegmentResponse is an open segment download returned by OpenSegment.
+type SegmentResponse struct {
+	Body io.ReadCloser
+	// Offset is the byte position Body starts at. It is non-zero only when
+	// the server honoured a resume request.
+	Offset int64
+	// Validator is the strong ETag (or Last-Modified date) of the remote file.
+	// It is passed back to OpenSegment to make sure the file hasn't changed
+	// before a partial download is resumed.
+	Validator string
 }
 
-// NewFetcher creates a new Fetcher with default HTTP client.
+// NewFetcher creates a new Fetcher with default HTTP client. The client
+// keeps a cookie jar, so session cookies set by playlist responses (and
+// the redirects leading to them) are sent with later segment requests.
+// Connections use DefaultConnectTimeout (see SetConnectTimeout).
 func NewFetcher() *Fetcher {
-	return &Fetcher{
+	// cookiejar.New never fails without options
+	jar, _ := cookiejar.New(nil)
+	f := &Fetcher{
 		client: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout: requestTimeout,
+			Jar:     jar,
 		},
+		MaxPlaylistSize: DefaultMaxPlaylistSize,
+		SegmentTimeout:  DefaultSegmentTimeout,
+		Retry:           DefaultRetryPolicy,
 	}
+	f.SetConnectTimeout(DefaultConnectTimeout)
+	return f
 }
 
 // FetchPlaylist fetches the M3U8 playlist from the given URL.
-// Returns the playlist content as a string.
+// Returns the playlist content as a UTF-8 string; legacy .m3u playlists in
+// Latin-1 or Windows-1252 are transcoded.
 func (f *Fetcher) FetchPlaylist(url string) (string, error) {
-	resp, err := f.client.Get(url)
+	return f.FetchPlaylistContext(context.Background(), url)
+}
+
+// FetchPlaylistContext is like FetchPlaylist, but aborts the request when
+// ctx is canceled.
+func (f *Fetcher) FetchPlaylistContext(ctx context.Context, url string) (string, error) {
+	content, _, _, err := f.FetchPlaylistIfModified(ctx, url, PlaylistVersion{})
+	return content, err
+}
+
+// PlaylistVersion holds the validators a server sent with a playlist, so a
+// later request can ask for the playlist only if it changed since.
+type PlaylistVersion struct {
+	ETag         string
+	LastModified string
+}
+
+// FetchPlaylistIfModified is like FetchPlaylistContext, but makes a
+// conditional request with the validators of version. When the server
+// answers 304 Not Modified it returns modified false and no content. The
+// returned version is the one to pass to the next request.
+func (f *Fetcher) FetchPlaylistIfModified(ctx context.Context, url string, version PlaylistVersion) (content string, latest PlaylistVersion, modified bool, err error) {
+	err = f.withRetry(ctx, url, func() error {
+		var err error
+		content, latest, modified, err = f.fetchPlaylist(ctx, url, version)
+		return err
+	})
+	return content, latest, modified, err
+}
+
+// fetchPlaylist makes a single attempt of FetchPlaylistIfModified.
+func (f *Fetcher) fetchPlaylist(ctx context.Context, url string, version PlaylistVersion) (string, PlaylistVersion, bool, error) {
+	req, err := f.newRequest(ctx, http.MethodGet, url)
+	if err != nil {
+		return "", version, false, fmt.Errorf("failed to create playlist request: %w", err)
+	}
+	if version.ETag != "" {
+		req.Header.Set("If-None-Match", version.ETag)
+	}
+	if version.LastModified != "" {
+		req.Header.Set("If-Modified-Since", version.LastModified)
+	}
+
+	release, err := f.HostLimiter.acquire(ctx, url)
 	if err != nil {
-		return "", fmt.Errorf("failed to fetch playlist: %w", err)
+		return "", version, false, fmt.Errorf("failed to fetch playlist: %w", err)
+	}
+	defer release()
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return "", version, false, fmt.Errorf("failed to fetch playlist: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified && version != (PlaylistVersion{}) {
+		f.accessed.Store(true)
+		return "", version, false, nil
+	}
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return "", version, false, f.statusError(url, resp.StatusCode)
 	}
+	f.accessed.Store(true)
 
-	body, err := io.ReadAll(resp.Body)
+	// Origins may compress the playlist even when we didn't negotiate it
+	decoded, err := decodeBody(resp)
 	if err != nil {
-		return "", fmt.Errorf("failed to read playlist: %w", err)
+		return "", version, false, fmt.Errorf("failed to read playlist: %w", err)
 	}
+	body, err := io.ReadAll(limitBody(decoded, f.MaxPlaylistSize, &SizeLimitError{Kind: "playlist", URL: url, Limit: f.MaxPlaylistSize}))
+	if err != nil {
+		return "", version, false, fmt.Errorf("failed to read playlist: %w", err)
+	}
+
+	latest := PlaylistVersion{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+	return decodePlaylist(body, resp.Header.Get("Content-Type")), latest, true, nil
+}
 
-	return string(body), nil
+// FetchSegment fetches a segment and writes it to the given writer,
+// returning the number of bytes written, which for a failed transfer counts
+// those written before the failure. Uses streaming to reduce memory usage.
+func (f *Fetcher) FetchSegment(segmentURL string, writer io.Writer) (int64, error) {
+	return f.FetchSegmentContext(context.Background(), segmentURL, writer)
 }
 
-// FetchSegment fetches a segment and writes it to the given writer.
-// Uses streaming to reduce memory usage.
-func (f *Fetcher) FetchSegment(segmentURL string, writer io.Writer) error {
-	resp, err := f.client.Get(segmentURL)
+// FetchSegmentContext is like FetchSegment, but aborts the download,
+// including a body transfer in progress, when ctx is canceled. A body
+// shorter than the response's Content-Length fails with an error matching
+// ErrIncompleteSegment.
+func (f *Fetcher) FetchSegmentContext(ctx context.Context, segmentURL string, writer io.Writer) (int64, error) {
+	return f.FetchSegmentRangeContext(ctx, segmentURL, 0, 0, writer)
+}
+
+// FetchSegmentRange is like FetchSegment for a segment stored as length
+// bytes at offset within segmentURL (EXT-X-BYTERANGE). A length of 0 fetches
+// the whole resource.
+func (f *Fetcher) FetchSegmentRange(segmentURL string, offset, length int64, writer io.Writer) (int64, error) {
+	return f.FetchSegmentRangeContext(context.Background(), segmentURL, offset, length, writer)
+}
+
+// FetchSegmentRangeContext is like FetchSegmentRange, but aborts the
+// download when ctx is canceled.
+func (f *Fetcher) FetchSegmentRangeContext(ctx context.Context, segmentURL string, offset, length int64, writer io.Writer) (int64, error) {
+	resp, err := f.OpenSegmentRangeContext(ctx, segmentURL, offset, length, 0, "")
 	if err != nil {
-		return fmt.Errorf("failed to fetch segment: %w", err)
+		return 0, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	written, err := io.Copy(writer, resp.Body)
+	if errors.Is(err, ErrTooLarge) {
+		// Fetching it again would only hit the limit again
+		return written, fmt.Errorf("failed to write segment: %w", err)
+	}
+	if err != nil {
+		return written, fmt.Errorf("failed to write segment: %w: %w", ErrTransferInterrupted, err)
+	}
+
+	return written, nil
+}
+
+// OpenSegment requests a segment and returns its body for streaming.
+// When offset is non-zero and validator is set, a Range request guarded by
+// If-Range is sent so the server only returns the remaining bytes if the
+// remote file still matches validator. In every other case the full body is
+// returned and Offset is 0, so callers must start writing from scratch.
+func (f *Fetcher) OpenSegment(segmentURL string, offset int64, validator string) (*SegmentResponse, error) {
+	return f.OpenSegmentContext(context.Background(), segmentURL, offset, validator)
+}
+
+// OpenSegmentContext is like OpenSegment; canceling ctx aborts the request
+// and any read of the returned body. Failed requests are retried according
+// to f.Retry; reading the body is not.
+func (f *Fetcher) OpenSegmentContext(ctx context.Context, segmentURL string, offset int64, validator string) (*SegmentResponse, error) {
+	return f.OpenSegmentRangeContext(ctx, segmentURL, 0, 0, offset, validator)
+}
+
+// OpenSegmentRange is like OpenSegment for a segment stored as rangeLength
+// bytes at rangeOffset within segmentURL (EXT-X-BYTERANGE); offset then
+// counts from the start of the sub-range. The server must answer with 206
+// Partial Content, otherwise an error matching ErrByteRangeIgnored is
+// returned. A rangeLength of 0 requests the whole resource.
+func (f *Fetcher) OpenSegmentRange(segmentURL string, rangeOffset, rangeLength, offset int64, validator string) (*SegmentResponse, error) {
+	return f.OpenSegmentRangeContext(context.Background(), segmentURL, rangeOffset, rangeLength, offset, validator)
+}
+
+// OpenSegmentRangeContext is like OpenSegmentRange; canceling ctx aborts
+// the request and any read of the returned body.
+func (f *Fetcher) OpenSegmentRangeContext(ctx context.Context, segmentURL string, rangeOffset, rangeLength, offset int64, validator string) (*SegmentResponse, error) {
+	return f.openSegmentRangeContext(ctx, segmentURL, rangeOffset, rangeLength, offset, validator, true)
+}
+
+// OpenEncryptedSegmentRangeContext is like OpenSegmentRangeContext for an
+// encrypted segment. Without a Content-Type its body isn't inspected for
+// an error page: ciphertext may start with any byte.
+func (f *Fetcher) OpenEncryptedSegmentRangeContext(ctx context.Context, segmentURL string, rangeOffset, rangeLength, offset int64, validator string) (*SegmentResponse, error) {
+	return f.openSegmentRangeContext(ctx, segmentURL, rangeOffset, rangeLength, offset, validator, false)
+}
+
+// openSegmentRangeContext implements OpenSegmentRangeContext, sniffing
+// untyped bodies for error pages when sniff is set.
+func (f *Fetcher) openSegmentRangeContext(ctx context.Context, segmentURL string, rangeOffset, rangeLength, offset int64, validator string, sniff bool) (*SegmentResponse, error) {
+	var resp *SegmentResponse
+	err := f.withRetry(ctx, segmentURL, func() error {
+		var err error
+		if rangeLength > 0 {
+			resp, err = f.openSegmentRange(ctx, segmentURL, rangeOffset, rangeLength, offset, validator, sniff)
+		} else {
+			resp, err = f.openSegment(ctx, segmentURL, offset, validator, sniff)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if f.MaxSegmentSize > 0 {
+		resp.Body = &limitedBody{
+			ReadCloser: resp.Body,
+			limit:      max(f.MaxSegmentSize-resp.Offset, 0),
+			err:        &SizeLimitError{Kind: "segment", URL: segmentURL, Limit: f.MaxSegmentSize},
+		}
+	}
+	return resp, nil
+}
+
+// openSegment makes a single attempt of OpenSegmentContext.
+func (f *Fetcher) openSegment(ctx context.Context, segmentURL string, offset int64, validator string, sniff bool) (*SegmentResponse, error) {
+	req, err := f.newRequest(ctx, http.MethodGet, segmentURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create segment request: %w", err)
+	}
+
+	resuming := offset > 0 && validator != ""
+	if resuming {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", validator)
+		// Byte ranges of a compressed representation can't be appended to
+		// previously decompressed bytes, so ask for the raw file.
+		req.Header.Set("Accept-Encoding", "identity")
+	}
+
+	release, err := f.HostLimiter.acquire(ctx, segmentURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch segment: %w", err)
+	}
+	resp, err := f.doSegment(req)
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("failed to fetch segment: %w", err)
+	}
+	// The slot is held until the caller closes the body. The length is
+	// checked on the raw body, which is what Content-Length counts.
+	resp.Body = &releasingBody{
+		ReadCloser: f.RateLimiter.throttle(ctx, checkLength(resp.Body, resp.ContentLength)),
+		release:    release,
+	}
+
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent {
+		f.accessed.Store(true)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		body, err := decodeBody(resp)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		if body, err = f.checkSegmentBody(segmentURL, resp, body, sniff); err != nil {
+			return nil, err
+		}
+		return &SegmentResponse{Body: body, Validator: responseValidator(resp)}, nil
+	case http.StatusPartialContent:
+		if resuming && contentRangeStart(resp.Header.Get("Content-Range")) == offset &&
+			validatorMatches(resp, validator) && !isEncoded(resp) {
+			// A resumed body starts mid-file, so only its header can be
+			// checked, not its first bytes
+			body := resp.Body
+			if resp.Header.Get("Content-Type") != "" {
+				if body, err = f.checkSegmentBody(segmentURL, resp, body, sniff); err != nil {
+					return nil, err
+				}
+			}
+			return &SegmentResponse{Body: body, Offset: offset, Validator: validator}, nil
+		}
+		// The server answered with a range we didn't ask for or the file
+		// changed underneath us; start over with a plain request.
+		resp.Body.Close()
+		return f.openSegment(ctx, segmentURL, 0, "", sniff)
+	default:
+		resp.Body.Close()
+		return nil, f.statusError(segmentURL, resp.StatusCode)
+	}
+}
+
+// openSegmentRange makes a single attempt of OpenSegmentRangeContext for a
+// sub-range of segmentURL.
+func (f *Fetcher) openSegmentRange(ctx context.Context, segmentURL string, rangeOffset, rangeLength, offset int64, validator string, sniff bool) (*SegmentResponse, error) {
+	req, err := f.newRequest(ctx, http.MethodGet, segmentURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create segment request: %w", err)
 	}
 
-	_, err = io.Copy(writer, resp.Body)
+	resuming := offset > 0 && validator != ""
+	if !resuming {
+		offset = 0
+	}
+	start, end := rangeOffset+offset, rangeOffset+rangeLength-1
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	if resuming {
+		req.Header.Set("If-Range", validator)
+	}
+	// Byte positions refer to the raw file, not a compressed representation
+	req.Header.Set("Accept-Encoding", "identity")
+
+	release, err := f.HostLimiter.acquire(ctx, segmentURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch segment: %w", err)
+	}
+	resp, err := f.doSegment(req)
 	if err != nil {
-		return fmt.Errorf("failed to write segment: %w", err)
+		release()
+		return nil, fmt.Errorf("failed to fetch segment: %w", err)
+	}
+	resp.Body = &releasingBody{
+		ReadCloser: f.RateLimiter.throttle(ctx, checkLength(resp.Body, resp.ContentLength)),
+		release:    release,
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		f.accessed.Store(true)
+	default:
+		resp.Body.Close()
+		return nil, f.statusError(segmentURL, resp.StatusCode)
+	}
+
+	honoured := resp.StatusCode == http.StatusPartialContent &&
+		contentRangeStart(resp.Header.Get("Content-Range")) == start && !isEncoded(resp)
+	if resuming && (!honoured || !validatorMatches(resp, validator)) {
+		// The file changed underneath us (If-Range sent it whole) or the
+		// server answered another range; fetch the sub-range from its start.
+		resp.Body.Close()
+		return f.openSegmentRange(ctx, segmentURL, rangeOffset, rangeLength, 0, "", sniff)
+	}
+	if !honoured {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s answered bytes=%d-%d with status %d", ErrByteRangeIgnored, segmentURL, start, end, resp.StatusCode)
+	}
+
+	// A resumed body starts mid-segment, so only its header can be checked,
+	// not its first bytes
+	body := resp.Body
+	if !resuming || resp.Header.Get("Content-Type") != "" {
+		if body, err = f.checkSegmentBody(segmentURL, resp, body, sniff); err != nil {
+			return nil, err
+		}
+	}
+	if resuming {
+		return &SegmentResponse{Body: body, Offset: offset, Validator: validator}, nil
 	}
+	return &SegmentResponse{Body: body, Validator: responseValidator(resp)}, nil
+}
 
-	return nil
+// newRequest creates a request carrying f.Headers.
+func (f *Fetcher) newRequest(ctx context.Context, method, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range f.Headers {
+		req.Header[name] = slices.Clone(values)
+	}
+	// net/http sends req.Host, not a Host entry in the header map
+	if host := f.Headers.Get("Host"); host != "" {
+		req.Host = host
+	}
+	return req, nil
+}
+
+// checkSegmentBody validates the content type of a segment response,
+// closing the response if it is rejected. The returned body closes resp.
+// An untyped body is only sniffed for an error page with sniff set.
+func (f *Fetcher) checkSegmentBody(segmentURL string, resp *http.Response, body io.ReadCloser, sniff bool) (io.ReadCloser, error) {
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" && !sniff {
+		return body, nil
+	}
+	checked, err := checkSegmentType(segmentURL, contentType, body, f.SegmentContentTypes)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	if checked == io.Reader(body) {
+		return body, nil
+	}
+	return &decodedBody{Reader: checked, body: body}, nil
+}
+
+// statusError builds the error for an unexpected status code, reporting a
+// 403 from a previously accessible stream as a *CredentialsExpiredError.
+func (f *Fetcher) statusError(url string, code int) error {
+	err := &StatusError{StatusCode: code}
+	if code == http.StatusForbidden && f.accessed.Load() {
+		return &CredentialsExpiredError{URL: url, Err: err}
+	}
+	return err
+}
+
+// decodeBody undoes a Content-Encoding the transport didn't handle itself.
+// Go only decompresses transparently when it negotiated gzip on its own, which
+// it doesn't do for Range requests or when Accept-Encoding was set in
+// Headers, and some CDNs compress responses unasked; writing those bytes
+// verbatim would corrupt the merged output or the parsed playlist.
+func decodeBody(resp *http.Response) (io.ReadCloser, error) {
+	switch encoding := strings.ToLower(resp.Header.Get("Content-Encoding")); encoding {
+	case "", "identity":
+		return resp.Body, nil
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress response: %w", err)
+		}
+		return &decodedBody{Reader: gz, body: resp.Body}, nil
+	case "deflate":
+		return &decodedBody{Reader: newDeflateReader(resp.Body), body: resp.Body}, nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding: %s", encoding)
+	}
+}
+
+// newDeflateReader decompresses an HTTP deflate body. The encoding is
+// specified as zlib-wrapped, but some servers send raw DEFLATE data, so
+// the zlib header is checked for first.
+func newDeflateReader(r io.Reader) io.Reader {
+	buffered := bufio.NewReader(r)
+	header, _ := buffered.Peek(2)
+	if len(header) == 2 && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		if zr, err := zlib.NewReader(buffered); err == nil {
+			return zr
+		}
+	}
+	return flate.NewReader(buffered)
+}
+
+// isEncoded reports whether resp carries a Content-Encoding other than identity.
+func isEncoded(resp *http.Response) bool {
+	encoding := resp.Header.Get("Content-Encoding")
+	return encoding != "" && !strings.EqualFold(encoding, "identity")
+}
+
+// decodedBody closes the underlying response body of a decompressing reader.
+type decodedBody struct {
+	io.Reader
+	body io.Closer
+}
+
+func (d *decodedBody) Close() error {
+	return d.body.Close()
+}
+
+// responseValidator returns a validator usable in If-Range for resp.
+// Weak ETags are not allowed in If-Range, so Last-Modified is used instead.
+func responseValidator(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// validatorMatches reports whether a partial response still refers to the
+// file identified by validator.
+func validatorMatches(resp *http.Response, validator string) bool {
+	current := responseValidator(resp)
+	return current == "" || current == validator
+}
+
+// contentRangeStart parses the first byte position of a Content-Range header
+// ("bytes 100-199/200"). Returns -1 if the header is missing or malformed.
+func contentRangeStart(header string) int64 {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return -1
+	}
+	start, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return -1
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(start), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
 }
@@ -0,0 +1,311 @@
+package hls
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFetcherSendsHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Referer"); got != "https://player.example.com/" {
+			t.Errorf("%s: Referer = %q", r.URL.Path, got)
+		}
+		if got := r.UserAgent(); got != "test-agent/1.0" {
+			t.Errorf("%s: User-Agent = %q", r.URL.Path, got)
+		}
+		if got := r.Host; got != "cdn.example.com" {
+			t.Errorf("%s: Host = %q", r.URL.Path, got)
+		}
+		switch r.URL.Path {
+		case "/index.m3u8":
+			w.Write([]byte("#EXTM3U\n"))
+		case "/key.bin":
+			w.Write([]byte("0123456789abcdef"))
+		default:
+			w.Header().Set("Content-Type", "video/mp2t")
+			w.Write([]byte("segment"))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	f := NewFetcher()
+	f.Headers = http.Header{}
+	f.Headers.Set("Referer", "https://player.example.com/")
+	f.Headers.Set("User-Agent", "test-agent/1.0")
+	f.Headers.Set("Host", "cdn.example.com")
+
+	if _, err := f.FetchPlaylist(server.URL + "/index.m3u8"); err != nil {
+		t.Fatalf("FetchPlaylist returned error: %v", err)
+	}
+	if _, err := f.FetchSegment(server.URL+"/seg1.ts", &bytes.Buffer{}); err != nil {
+		t.Fatalf("FetchSegment returned error: %v", err)
+	}
+	if _, err := f.FetchKey(context.Background(), server.URL+"/key.bin"); err != nil {
+		t.Fatalf("FetchKey returned error: %v", err)
+	}
+}
+
+func TestFetchSegmentIncomplete(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp2t")
+		w.Header().Set("Content-Length", "100")
+		// The connection is closed after the short body
+		w.Write([]byte("truncated"))
+	}))
+	t.Cleanup(server.Close)
+
+	f := NewFetcher()
+	f.Retry.MaxRetries = 0
+	written, err := f.FetchSegment(server.URL+"/seg1.ts", &bytes.Buffer{})
+	if !errors.Is(err, ErrIncompleteSegment) {
+		t.Fatalf("FetchSegment error = %v, want ErrIncompleteSegment", err)
+	}
+	if written != int64(len("truncated")) {
+		t.Errorf("FetchSegment wrote %d bytes, want the %d received before the failure", written, len("truncated"))
+	}
+	if !errors.Is(err, ErrTransferInterrupted) {
+		t.Errorf("FetchSegment error = %v, want it to match ErrTransferInterrupted", err)
+	}
+}
+
+func TestFetchSegmentSlowButSteady(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp2t")
+		// The whole body takes several timeouts, each chunk well within one
+		for range 6 {
+			w.Write([]byte("chunk"))
+			w.(http.Flusher).Flush()
+			time.Sleep(40 * time.Millisecond)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	f := NewFetcher()
+	f.SegmentTimeout = 100 * time.Millisecond
+	var buf bytes.Buffer
+	if _, err := f.FetchSegment(server.URL+"/seg1.ts", &buf); err != nil {
+		t.Fatalf("FetchSegment returned error: %v", err)
+	}
+	if got := buf.Len(); got != 30 {
+		t.Errorf("FetchSegment wrote %d bytes, want 30", got)
+	}
+}
+
+func TestFetchSegmentStalled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp2t")
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+
+	f := NewFetcher()
+	f.Retry.MaxRetries = 0
+	f.SegmentTimeout = 50 * time.Millisecond
+	written, err := f.FetchSegment(server.URL+"/seg1.ts", &bytes.Buffer{})
+	if !errors.Is(err, ErrSegmentTimeout) || !errors.Is(err, ErrTransferInterrupted) {
+		t.Fatalf("FetchSegment error = %v, want ErrSegmentTimeout and ErrTransferInterrupted", err)
+	}
+	if written != int64(len("partial")) {
+		t.Errorf("FetchSegment wrote %d bytes, want the %d received before the stall", written, len("partial"))
+	}
+}
+
+func TestConnectTimeoutAwaitingHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	f := NewFetcher()
+	f.Retry.MaxRetries = 0
+	f.SetConnectTimeout(50 * time.Millisecond)
+	start := time.Now()
+	if _, err := f.FetchSegment(server.URL+"/seg1.ts", &bytes.Buffer{}); err == nil {
+		t.Fatal("FetchSegment succeeded, want a timeout awaiting the response headers")
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("FetchSegment took %v, want it to give up after the connect timeout", elapsed)
+	}
+}
+
+func TestFetchSegmentRange(t *testing.T) {
+	file := []byte("0123456789abcdefghij")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Range"); got != "bytes=5-9" {
+			t.Errorf("Range = %q, want bytes=5-9", got)
+		}
+		w.Header().Set("Content-Type", "video/mp2t")
+		http.ServeContent(w, r, "main.ts", time.Time{}, bytes.NewReader(file))
+	}))
+	t.Cleanup(server.Close)
+
+	var buf bytes.Buffer
+	written, err := NewFetcher().FetchSegmentRange(server.URL+"/main.ts", 5, 5, &buf)
+	if err != nil {
+		t.Fatalf("FetchSegmentRange returned error: %v", err)
+	}
+	if got := buf.String(); got != "56789" {
+		t.Errorf("FetchSegmentRange wrote %q, want %q", got, "56789")
+	}
+	if written != 5 {
+		t.Errorf("FetchSegmentRange returned %d bytes written, want 5", written)
+	}
+}
+
+func TestFetchSegmentRangeIgnored(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "video/mp2t")
+		w.Write([]byte("0123456789abcdefghij"))
+	}))
+	t.Cleanup(server.Close)
+
+	_, err := NewFetcher().FetchSegmentRange(server.URL+"/main.ts", 5, 5, &bytes.Buffer{})
+	if !errors.Is(err, ErrByteRangeIgnored) {
+		t.Fatalf("FetchSegmentRange error = %v, want ErrByteRangeIgnored", err)
+	}
+	if requests != 1 {
+		t.Errorf("server got %d requests, want 1 (no retries)", requests)
+	}
+}
+
+func TestFetchPlaylistCompressed(t *testing.T) {
+	const playlist = "#EXTM3U\n#EXT-X-TARGETDURATION:2\n#EXTINF:2.0,\nseg1.ts\n"
+
+	tests := []struct {
+		name      string
+		encoding  string
+		newWriter func(w io.Writer) io.WriteCloser
+	}{
+		{"gzip", "gzip", func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }},
+		{"deflate", "deflate", func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }},
+		// Some servers send raw DEFLATE data without the zlib wrapper
+		{"raw deflate", "deflate", func(w io.Writer) io.WriteCloser {
+			fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+			return fw
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fixture bytes.Buffer
+			cw := tt.newWriter(&fixture)
+			cw.Write([]byte(playlist))
+			cw.Close()
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", tt.encoding)
+				w.Write(fixture.Bytes())
+			}))
+			t.Cleanup(server.Close)
+
+			f := NewFetcher()
+			// Setting Accept-Encoding stops the transport from decompressing
+			f.Headers = http.Header{"Accept-Encoding": {"gzip, deflate"}}
+			got, err := f.FetchPlaylist(server.URL + "/index.m3u8")
+			if err != nil {
+				t.Fatalf("FetchPlaylist returned error: %v", err)
+			}
+			if got != playlist {
+				t.Errorf("FetchPlaylist = %q, want %q", got, playlist)
+			}
+		})
+	}
+}
+
+func TestFetchPlaylistTooLarge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("#EXTM3U\n"))
+		w.Write(bytes.Repeat([]byte("#EXT-X-PADDING\n"), 100))
+	}))
+	t.Cleanup(server.Close)
+
+	f := NewFetcher()
+	f.MaxPlaylistSize = 512
+	_, err := f.FetchPlaylist(server.URL + "/index.m3u8")
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("FetchPlaylist error = %v, want ErrTooLarge", err)
+	}
+	if IsRetryable(err) {
+		t.Errorf("IsRetryable(%v) = true, want an oversized playlist not to be retried", err)
+	}
+
+	f.MaxPlaylistSize = 0
+	if _, err := f.FetchPlaylist(server.URL + "/index.m3u8"); err != nil {
+		t.Errorf("FetchPlaylist without a limit returned error: %v", err)
+	}
+}
+
+func TestFetchSegmentTooLarge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp2t")
+		w.Write(bytes.Repeat([]byte{0x47}, 1000))
+	}))
+	t.Cleanup(server.Close)
+
+	f := NewFetcher()
+	f.MaxSegmentSize = 100
+	written, err := f.FetchSegment(server.URL+"/seg1.ts", &bytes.Buffer{})
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("FetchSegment error = %v, want ErrTooLarge", err)
+	}
+	if errors.Is(err, ErrTransferInterrupted) {
+		t.Errorf("FetchSegment error = %v, want an oversized segment not to be resumed", err)
+	}
+	if written != 100 {
+		t.Errorf("FetchSegment wrote %d bytes, want the 100 within the limit", written)
+	}
+
+	f.MaxSegmentSize = 1000
+	if _, err := f.FetchSegment(server.URL+"/seg1.ts", &bytes.Buffer{}); err != nil {
+		t.Errorf("FetchSegment of a segment at the limit returned error: %v", err)
+	}
+}
+
+func TestSegmentExists(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("%s request, want HEAD", r.Method)
+		}
+		switch r.URL.Path {
+		case "/seg1.ts":
+			w.Header().Set("Content-Type", "video/mp2t")
+		case "/nohead/seg1.ts":
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case "/error/seg1.ts":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	f := NewFetcher()
+	if exists, err := f.SegmentExists(server.URL + "/seg1.ts"); err != nil || !exists {
+		t.Errorf("SegmentExists(published) = %v, %v; want true", exists, err)
+	}
+	if exists, err := f.SegmentExists(server.URL + "/seg2.ts"); err != nil || exists {
+		t.Errorf("SegmentExists(missing) = %v, %v; want false", exists, err)
+	}
+	if _, err := f.SegmentExists(server.URL + "/nohead/seg1.ts"); !errors.Is(err, ErrHeadUnsupported) {
+		t.Errorf("SegmentExists without HEAD support returned %v, want ErrHeadUnsupported", err)
+	}
+	var statusErr *StatusError
+	if _, err := f.SegmentExists(server.URL + "/error/seg1.ts"); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("SegmentExists on a server error returned %v, want a *StatusError", err)
+	}
+}
@@ -0,0 +1,294 @@
+package hls
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Encryption methods of EXT-X-KEY.
+const (
+	MethodNone      = "NONE"
+	MethodAES128    = "AES-128"
+	MethodSampleAES = "SAMPLE-AES"
+)
+
+// Key describes the EXT-X-KEY in effect for a segment.
+type Key struct {
+	Method string
+	// URI is where the key material is served, resolved against the
+	// playlist URL.
+	URI string
+	// IV is the 16-byte initialization vector. When the tag has no IV
+	// attribute it is derived from the segment's media sequence number.
+	IV []byte
+}
+
+// parseKey parses the attribute list of an EXT-X-KEY tag. It returns nil for
+// METHOD=NONE, which ends encryption for the following segments.
+func parseKey(attrList string, base *url.URL) (*Key, error) {
+	attrs := parseAttributes(attrList)
+
+	key := &Key{Method: attrs["METHOD"]}
+	switch key.Method {
+	case MethodNone:
+		return nil, nil
+	case "":
+		return nil, fmt.Errorf("missing METHOD attribute")
+	}
+
+	uri, err := resolveURI(base, attrs["URI"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid key URI: %w", err)
+	}
+	key.URI = uri
+
+	if iv := attrs["IV"]; iv != "" {
+		if key.IV, err = ParseHex(iv, aes.BlockSize); err != nil {
+			return nil, fmt.Errorf("invalid IV: %w", err)
+		}
+	}
+	return key, nil
+}
+
+// forSequence returns a copy of k whose IV is set, deriving it from the
+// media sequence number when the tag didn't carry one.
+func (k *Key) forSequence(mediaSequence int) *Key {
+	if k == nil {
+		return nil
+	}
+	key := *k
+	if key.IV == nil {
+		key.IV = SequenceIV(mediaSequence)
+	}
+	return &key
+}
+
+// SequenceIV returns the IV the HLS spec prescribes when EXT-X-KEY has no IV
+// attribute: the media sequence number as a 128-bit big-endian integer.
+func SequenceIV(mediaSequence int) []byte {
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], uint64(mediaSequence))
+	return iv
+}
+
+// FetchKey returns the AES-128 key served at uri. Keys are cached per URI,
+// so a key shared by many segments is requested once and rotated keys are
+// fetched as the playlist introduces them. Concurrent calls for a key not
+// cached yet share a single request. Canceling ctx aborts the request, or
+// the wait for another call's request.
+func (f *Fetcher) FetchKey(ctx context.Context, uri string) ([]byte, error) {
+	for {
+		f.keysMu.Lock()
+		if key, ok := f.keys[uri]; ok {
+			f.keysMu.Unlock()
+			return key, nil
+		}
+		if fetch, ok := f.keyFetches[uri]; ok {
+			f.keysMu.Unlock()
+			select {
+			case <-fetch.done:
+			case <-ctx.Done():
+				return nil, fmt.Errorf("failed to fetch key: %w", ctx.Err())
+			}
+			// A request canceled by its own caller is no answer for the
+			// others: the next of them fetches the key again
+			if fetch.err != nil && isContextError(fetch.err) && ctx.Err() == nil {
+				continue
+			}
+			return fetch.key, fetch.err
+		}
+
+		fetch := &keyFetch{done: make(chan struct{})}
+		if f.keyFetches == nil {
+			f.keyFetches = make(map[string]*keyFetch)
+		}
+		f.keyFetches[uri] = fetch
+		f.keysMu.Unlock()
+
+		fetch.key, fetch.err = f.fetchKey(ctx, uri)
+
+		f.keysMu.Lock()
+		delete(f.keyFetches, uri)
+		if fetch.err == nil {
+			if f.keys == nil {
+				f.keys = make(map[string][]byte)
+			}
+			f.keys[uri] = fetch.key
+		}
+		f.keysMu.Unlock()
+		close(fetch.done)
+		return fetch.key, fetch.err
+	}
+}
+
+// keyFetch is a key request in flight, shared by the FetchKey calls
+// waiting for it. key and err are set before done is closed.
+type keyFetch struct {
+	done chan struct{}
+	key  []byte
+	err  error
+}
+
+// isContextError reports whether err comes from a canceled or expired
+// context.
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// fetchKey requests the key at uri.
+func (f *Fetcher) fetchKey(ctx context.Context, uri string) ([]byte, error) {
+	req, err := f.newRequest(ctx, http.MethodGet, uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key request: %w", err)
+	}
+
+	release, err := f.HostLimiter.acquire(ctx, uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch key: %w", err)
+	}
+	defer release()
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch key: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, f.statusError(uri, resp.StatusCode)
+	}
+
+	// Read one byte more than a key so an oversized response is detected
+	key, err := io.ReadAll(io.LimitReader(resp.Body, aes.BlockSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	if len(key) != aes.BlockSize {
+		return nil, fmt.Errorf("key at %s is not %d bytes", uri, aes.BlockSize)
+	}
+	return key, nil
+}
+
+// ParseHex decodes a hexadecimal string of exactly size bytes, with or
+// without a 0x prefix, as used for IV attributes and key overrides.
+func ParseHex(s string, size int) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if rest, ok := strings.CutPrefix(s, "0x"); ok {
+		s = rest
+	} else if rest, ok := strings.CutPrefix(s, "0X"); ok {
+		s = rest
+	}
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != size {
+		return nil, fmt.Errorf("got %d bytes, want %d", len(b), size)
+	}
+	return b, nil
+}
+
+// NewDecryptReader returns a reader yielding the AES-128-CBC decryption of r
+// with PKCS#7 padding removed. Data is decrypted as it streams; only the last
+// block is held back until EOF so its padding can be stripped.
+func NewDecryptReader(r io.Reader, key, iv []byte) (io.Reader, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AES key: %w", err)
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("invalid IV length %d", len(iv))
+	}
+
+	return &decryptReader{
+		src:  r,
+		mode: cipher.NewCBCDecrypter(block, iv),
+		buf:  make([]byte, 0, 32*1024),
+	}, nil
+}
+
+type decryptReader struct {
+	src  io.Reader
+	mode cipher.BlockMode
+	buf  []byte // ciphertext not decrypted yet
+	out  []byte // plaintext ready to be read
+	eof  bool
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.eof {
+			return 0, io.EOF
+		}
+		if err := d.fill(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// fill reads more ciphertext and decrypts every complete block except the
+// last one, which may carry the padding.
+func (d *decryptReader) fill() error {
+	n, err := d.src.Read(d.buf[len(d.buf):cap(d.buf)])
+	d.buf = d.buf[:len(d.buf)+n]
+
+	if err == io.EOF {
+		d.eof = true
+		if len(d.buf)%aes.BlockSize != 0 || len(d.buf) == 0 {
+			return fmt.Errorf("encrypted segment is not a whole number of AES blocks (%d bytes left)", len(d.buf))
+		}
+		d.mode.CryptBlocks(d.buf, d.buf)
+		plain, err := unpad(d.buf)
+		if err != nil {
+			return err
+		}
+		d.out = plain
+		d.buf = d.buf[:0]
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	ready := len(d.buf) - len(d.buf)%aes.BlockSize
+	if ready == len(d.buf) {
+		ready -= aes.BlockSize
+	}
+	if ready <= 0 {
+		return nil
+	}
+
+	plain := make([]byte, ready)
+	d.mode.CryptBlocks(plain, d.buf[:ready])
+	d.out = plain
+	d.buf = d.buf[:copy(d.buf, d.buf[ready:])]
+	return nil
+}
+
+// unpad strips PKCS#7 padding from the final decrypted blocks.
+func unpad(b []byte) ([]byte, error) {
+	pad := int(b[len(b)-1])
+	if pad == 0 || pad > aes.BlockSize || pad > len(b) {
+		return nil, fmt.Errorf("invalid PKCS#7 padding (wrong key or IV?)")
+	}
+	for _, c := range b[len(b)-pad:] {
+		if int(c) != pad {
+			return nil, fmt.Errorf("invalid PKCS#7 padding (wrong key or IV?)")
+		}
+	}
+	return b[:len(b)-pad], nil
+}
@@ -0,0 +1,62 @@
+package hls
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetchKeyConcurrent(t *testing.T) {
+	unblock := make(chan struct{})
+	var slowRequests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow.bin" {
+			slowRequests.Add(1)
+			<-unblock
+		}
+		w.Write([]byte("0123456789abcdef"))
+	}))
+	t.Cleanup(server.Close)
+	var once sync.Once
+	release := func() { once.Do(func() { close(unblock) }) }
+	// Runs before server.Close, which waits for the blocked handlers
+	t.Cleanup(release)
+
+	f := NewFetcher()
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := f.FetchKey(context.Background(), server.URL+"/slow.bin")
+			errs <- err
+		}()
+	}
+
+	for slowRequests.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// Another key isn't held up by the slow one
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := f.FetchKey(ctx, server.URL+"/fast.bin"); err != nil {
+		t.Fatalf("FetchKey(fast) returned error: %v", err)
+	}
+
+	release()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("FetchKey(slow) returned error: %v", err)
+		}
+	}
+	if n := slowRequests.Load(); n != 1 {
+		t.Errorf("slow key requested %d times, want once for all callers", n)
+	}
+}
@@ -0,0 +1,37 @@
+package hls
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrIncompleteSegment is matched by errors reporting that a segment body
+// ended before the length announced by its Content-Length header.
+var ErrIncompleteSegment = errors.New("incomplete segment")
+
+// lengthCheckedBody fails a body that ends short of its Content-Length, so
+// a truncated segment is reported instead of being stored as complete.
+type lengthCheckedBody struct {
+	io.ReadCloser
+	want int64
+	read int64
+}
+
+// checkLength wraps body in a lengthCheckedBody when the response
+// announced its length.
+func checkLength(body io.ReadCloser, contentLength int64) io.ReadCloser {
+	if contentLength < 0 {
+		return body
+	}
+	return &lengthCheckedBody{ReadCloser: body, want: contentLength}
+}
+
+func (b *lengthCheckedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if (err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF)) && b.read < b.want {
+		return n, fmt.Errorf("%w: received %d of %d bytes", ErrIncompleteSegment, b.read, b.want)
+	}
+	return n, err
+}
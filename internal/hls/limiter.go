@@ -0,0 +1,72 @@
+package hls
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"sync"
+)
+
+// HostLimiter bounds the number of simultaneous requests to each host.
+// A single limiter can be shared by several Fetchers so that concurrent
+// captures hitting the same CDN stay polite towards it.
+type HostLimiter struct {
+	maxPerHost int
+
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+// NewHostLimiter creates a limiter allowing maxPerHost simultaneous requests
+// per host. A value <= 0 means unlimited.
+func NewHostLimiter(maxPerHost int) *HostLimiter {
+	return &HostLimiter{
+		maxPerHost: maxPerHost,
+		slots:      make(map[string]chan struct{}),
+	}
+}
+
+// acquire blocks until a request slot for the host of rawURL is free and
+// returns the function releasing it, or ctx's error if ctx is canceled
+// first.
+func (l *HostLimiter) acquire(ctx context.Context, rawURL string) (release func(), err error) {
+	if l == nil || l.maxPerHost <= 0 {
+		return func() {}, nil
+	}
+
+	host := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		host = u.Host
+	}
+
+	l.mu.Lock()
+	slot, ok := l.slots[host]
+	if !ok {
+		slot = make(chan struct{}, l.maxPerHost)
+		l.slots[host] = slot
+	}
+	l.mu.Unlock()
+
+	select {
+	case slot <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() { <-slot })
+	}, nil
+}
+
+// releasingBody releases a host slot when the response body is closed, so
+// the slot is held for the whole streaming download.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
@@ -0,0 +1,40 @@
+package hls
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHostLimiterWaitEndsWithContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp2t")
+		w.Write([]byte("segment"))
+	}))
+	t.Cleanup(server.Close)
+
+	f := NewFetcher()
+	f.HostLimiter = NewHostLimiter(1)
+
+	// The open body holds the host's only slot
+	held, err := f.OpenSegmentContext(context.Background(), server.URL+"/seg0.ts", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = f.FetchSegmentRangeContext(ctx, server.URL+"/seg1.ts", 0, 0, io.Discard)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("FetchSegmentRangeContext error = %v, want the context's deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("waited %v for the slot after the deadline", elapsed)
+	}
+}
@@ -0,0 +1,362 @@
+package hls
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Variant represents a stream entry of a master playlist.
+type Variant struct {
+	URL       string
+	Bandwidth int
+	Width     int
+	Height    int
+	Codecs    string
+	// IFrame marks an EXT-X-I-FRAME-STREAM-INF entry: an I-frame-only
+	// trick-play playlist rather than continuous media.
+	IFrame bool
+	// Audio is the GROUP-ID of the variant's alternative audio renditions
+	// (its AUDIO attribute), empty if it has none.
+	Audio string
+	// Subtitles is the GROUP-ID of the variant's subtitle renditions (its
+	// SUBTITLES attribute), empty if it has none.
+	Subtitles string
+}
+
+// Rendition is an EXT-X-MEDIA entry: one of the alternative audio or
+// subtitle tracks offered to the variants of its group.
+type Rendition struct {
+	GroupID  string
+	Name     string
+	Language string
+	// URL is the rendition's own media playlist. It is empty when the
+	// track is muxed into the variant streams instead.
+	URL        string
+	Default    bool
+	AutoSelect bool
+}
+
+// IsMasterPlaylist reports whether the playlist content lists variant streams
+// instead of media segments.
+func IsMasterPlaylist(playlistContent string) bool {
+	return strings.Contains(playlistContent, "#EXT-X-STREAM-INF") ||
+		strings.Contains(playlistContent, "#EXT-X-I-FRAME-STREAM-INF")
+}
+
+// ParseMasterPlaylist parses the EXT-X-STREAM-INF entries of a master
+// playlist. Each tag's playlist URI is on the line that follows it.
+func ParseMasterPlaylist(playlistContent, baseURL string) ([]*Variant, error) {
+	var variants []*Variant
+
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+
+	var pending *Variant
+	scanner := bufio.NewScanner(strings.NewReader(playlistContent))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if attrList, ok := strings.CutPrefix(line, "#EXT-X-STREAM-INF:"); ok {
+			pending = &Variant{}
+			applyStreamAttributes(pending, parseAttributes(attrList))
+			continue
+		}
+
+		if pending == nil || line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		pending.URL, err = resolveURI(base, line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid variant URI %s: %w", line, err)
+		}
+		variants = append(variants, pending)
+		pending = nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning playlist: %w", err)
+	}
+
+	return variants, nil
+}
+
+// ParseIFrameVariants parses the EXT-X-I-FRAME-STREAM-INF entries of a master
+// playlist. Unlike regular variants the playlist URI is carried in the tag's
+// URI attribute rather than on the following line.
+func ParseIFrameVariants(playlistContent, baseURL string) ([]*Variant, error) {
+	var variants []*Variant
+
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(playlistContent))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		attrList, ok := strings.CutPrefix(line, "#EXT-X-I-FRAME-STREAM-INF:")
+		if !ok {
+			continue
+		}
+
+		attrs := parseAttributes(attrList)
+		variantURL, err := resolveURI(base, attrs["URI"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid I-frame stream URI in %s: %w", line, err)
+		}
+
+		variant := &Variant{URL: variantURL, IFrame: true}
+		applyStreamAttributes(variant, attrs)
+		variants = append(variants, variant)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning playlist: %w", err)
+	}
+
+	return variants, nil
+}
+
+// ParseAudioRenditions parses the EXT-X-MEDIA entries of TYPE=AUDIO of a
+// master playlist, in playlist order.
+func ParseAudioRenditions(playlistContent, baseURL string) ([]*Rendition, error) {
+	return parseRenditions(playlistContent, baseURL, "AUDIO")
+}
+
+// ParseSubtitleRenditions parses the EXT-X-MEDIA entries of TYPE=SUBTITLES
+// of a master playlist, in playlist order.
+func ParseSubtitleRenditions(playlistContent, baseURL string) ([]*Rendition, error) {
+	return parseRenditions(playlistContent, baseURL, "SUBTITLES")
+}
+
+// parseRenditions parses the EXT-X-MEDIA entries of the given TYPE.
+func parseRenditions(playlistContent, baseURL, mediaType string) ([]*Rendition, error) {
+	var renditions []*Rendition
+
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(playlistContent))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		attrList, ok := strings.CutPrefix(line, "#EXT-X-MEDIA:")
+		if !ok {
+			continue
+		}
+		attrs := parseAttributes(attrList)
+		if !strings.EqualFold(attrs["TYPE"], mediaType) {
+			continue
+		}
+
+		rendition := &Rendition{
+			GroupID:    attrs["GROUP-ID"],
+			Name:       attrs["NAME"],
+			Language:   attrs["LANGUAGE"],
+			Default:    strings.EqualFold(attrs["DEFAULT"], "YES"),
+			AutoSelect: strings.EqualFold(attrs["AUTOSELECT"], "YES"),
+		}
+		if uri, ok := attrs["URI"]; ok {
+			if rendition.URL, err = resolveURI(base, uri); err != nil {
+				return nil, fmt.Errorf("invalid rendition URI in %s: %w", line, err)
+			}
+		}
+		renditions = append(renditions, rendition)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning playlist: %w", err)
+	}
+
+	return renditions, nil
+}
+
+// RenditionGroup returns the renditions belonging to the group with
+// groupID.
+func RenditionGroup(renditions []*Rendition, groupID string) []*Rendition {
+	var group []*Rendition
+	for _, r := range renditions {
+		if r.GroupID == groupID {
+			group = append(group, r)
+		}
+	}
+	return group
+}
+
+// SelectRendition returns the rendition chosen by choice: an index
+// into renditions, a language ("en" also matches "en-US") or a NAME,
+// compared case-insensitively. An empty choice picks the DEFAULT=YES
+// rendition, then the first AUTOSELECT=YES one, then the first. It returns
+// nil if nothing matches.
+func SelectRendition(renditions []*Rendition, choice string) *Rendition {
+	if len(renditions) == 0 {
+		return nil
+	}
+
+	if choice == "" {
+		for _, r := range renditions {
+			if r.Default {
+				return r
+			}
+		}
+		for _, r := range renditions {
+			if r.AutoSelect {
+				return r
+			}
+		}
+		return renditions[0]
+	}
+
+	if index, err := strconv.Atoi(choice); err == nil {
+		if index < 0 || index >= len(renditions) {
+			return nil
+		}
+		return renditions[index]
+	}
+
+	// Several renditions may share a language (e.g. a commentary track);
+	// the default one is preferred among them.
+	var matched []*Rendition
+	for _, r := range renditions {
+		if strings.EqualFold(r.Language, choice) || strings.EqualFold(r.Name, choice) {
+			matched = append(matched, r)
+		}
+	}
+	if len(matched) == 0 {
+		for _, r := range renditions {
+			if primary, _, _ := strings.Cut(r.Language, "-"); strings.EqualFold(primary, choice) {
+				matched = append(matched, r)
+			}
+		}
+	}
+	if len(matched) == 0 {
+		return nil
+	}
+	return SelectRendition(matched, "")
+}
+
+// SelectByBandwidth returns the variant with the highest bandwidth, or the
+// lowest if highest is false. Variants without a BANDWIDTH are only picked
+// if none has one; among equals the first listed wins.
+func SelectByBandwidth(variants []*Variant, highest bool) *Variant {
+	if len(variants) == 0 {
+		return nil
+	}
+
+	selected := variants[0]
+	for _, v := range variants[1:] {
+		switch {
+		case v.Bandwidth == 0:
+		case selected.Bandwidth == 0,
+			highest && v.Bandwidth > selected.Bandwidth,
+			!highest && v.Bandwidth < selected.Bandwidth:
+			selected = v
+		}
+	}
+	return selected
+}
+
+// VariantPreference describes which variant SelectVariant picks.
+type VariantPreference struct {
+	// Lowest picks the lowest bandwidth instead of the highest.
+	Lowest bool
+	// Height, when non-zero, narrows the choice to the variants whose
+	// resolution height is closest to it (e.g. 720); bandwidth then breaks
+	// ties. Variants without a RESOLUTION are only used if none has one.
+	Height int
+}
+
+// SelectVariant returns the variant matching pref, or nil if variants is
+// empty.
+func SelectVariant(variants []*Variant, pref VariantPreference) *Variant {
+	if pref.Height > 0 {
+		var closest []*Variant
+		bestDistance := -1
+		for _, v := range variants {
+			if v.Height == 0 {
+				continue
+			}
+			distance := v.Height - pref.Height
+			if distance < 0 {
+				distance = -distance
+			}
+			switch {
+			case bestDistance < 0 || distance < bestDistance:
+				closest, bestDistance = []*Variant{v}, distance
+			case distance == bestDistance:
+				closest = append(closest, v)
+			}
+		}
+		if len(closest) > 0 {
+			variants = closest
+		}
+	}
+	return SelectByBandwidth(variants, !pref.Lowest)
+}
+
+// FilterByCodec returns the variants whose CODECS attribute contains codec,
+// compared case-insensitively (e.g. "avc1", "hvc1", "ec-3").
+func FilterByCodec(variants []*Variant, codec string) []*Variant {
+	codec = strings.ToLower(codec)
+
+	var matched []*Variant
+	for _, v := range variants {
+		if strings.Contains(strings.ToLower(v.Codecs), codec) {
+			matched = append(matched, v)
+		}
+	}
+	return matched
+}
+
+// applyStreamAttributes fills the attributes shared by EXT-X-STREAM-INF and
+// EXT-X-I-FRAME-STREAM-INF.
+func applyStreamAttributes(v *Variant, attrs map[string]string) {
+	v.Bandwidth, _ = strconv.Atoi(attrs["BANDWIDTH"])
+	v.Codecs = attrs["CODECS"]
+	v.Audio = attrs["AUDIO"]
+	v.Subtitles = attrs["SUBTITLES"]
+	if w, h, ok := strings.Cut(attrs["RESOLUTION"], "x"); ok {
+		v.Width, _ = strconv.Atoi(w)
+		v.Height, _ = strconv.Atoi(h)
+	}
+}
+
+// parseAttributes parses an HLS attribute list (KEY=VALUE,KEY="VALUE,...")
+// into a map. Quotes are stripped from quoted-string values.
+func parseAttributes(list string) map[string]string {
+	attrs := make(map[string]string)
+
+	for list != "" {
+		name, rest, ok := strings.Cut(list, "=")
+		if !ok {
+			break
+		}
+		name = strings.TrimSpace(name)
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, list = rest[1:], ""
+			} else {
+				value, list = rest[1:end+1], rest[end+2:]
+			}
+			_, list, _ = strings.Cut(list, ",")
+		} else {
+			value, list, _ = strings.Cut(rest, ",")
+		}
+
+		attrs[strings.ToUpper(name)] = strings.TrimSpace(value)
+	}
+
+	return attrs
+}
@@ -0,0 +1,255 @@
+package hls
+
+import "testing"
+
+const audioMasterPlaylist = `#EXTM3U
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="English",LANGUAGE="en-US",AUTOSELECT=YES,URI="audio/en.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="Deutsch",LANGUAGE="de",DEFAULT=YES,AUTOSELECT=YES,URI="audio/de.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="Commentary",LANGUAGE="en",URI="audio/commentary.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="ac3",NAME="English",LANGUAGE="en"
+#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="English",LANGUAGE="en",URI="subs/en.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=1280000,AUDIO="aac",SUBTITLES="subs"
+video/720.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2560000,AUDIO="ac3"
+video/1080.m3u8
+`
+
+func TestParseAudioRenditions(t *testing.T) {
+	renditions, err := ParseAudioRenditions(audioMasterPlaylist, testPlaylistURL)
+	if err != nil {
+		t.Fatalf("ParseAudioRenditions returned error: %v", err)
+	}
+	if len(renditions) != 4 {
+		t.Fatalf("got %d renditions, want 4 (subtitles excluded)", len(renditions))
+	}
+
+	de := renditions[1]
+	if de.GroupID != "aac" || de.Name != "Deutsch" || de.Language != "de" || !de.Default || !de.AutoSelect {
+		t.Errorf("renditions[1] = %+v, want the default Deutsch rendition of group aac", de)
+	}
+	if want := "https://origin.example.com/live/stream/audio/de.m3u8"; de.URL != want {
+		t.Errorf("renditions[1].URL = %q, want %q", de.URL, want)
+	}
+	if muxed := renditions[3]; muxed.URL != "" {
+		t.Errorf("rendition without URI has URL %q, want it empty (muxed)", muxed.URL)
+	}
+
+	variants, err := ParseMasterPlaylist(audioMasterPlaylist, testPlaylistURL)
+	if err != nil {
+		t.Fatalf("ParseMasterPlaylist returned error: %v", err)
+	}
+	if variants[0].Audio != "aac" || variants[1].Audio != "ac3" {
+		t.Errorf("variant audio groups = %q, %q, want aac, ac3", variants[0].Audio, variants[1].Audio)
+	}
+	if group := RenditionGroup(renditions, "aac"); len(group) != 3 {
+		t.Errorf("RenditionGroup(aac) has %d renditions, want 3", len(group))
+	}
+}
+
+func TestParseSubtitleRenditions(t *testing.T) {
+	renditions, err := ParseSubtitleRenditions(audioMasterPlaylist, testPlaylistURL)
+	if err != nil {
+		t.Fatalf("ParseSubtitleRenditions returned error: %v", err)
+	}
+	if len(renditions) != 1 {
+		t.Fatalf("got %d renditions, want 1 (audio excluded)", len(renditions))
+	}
+	en := renditions[0]
+	if en.GroupID != "subs" || en.Language != "en" {
+		t.Errorf("renditions[0] = %+v, want the English rendition of group subs", en)
+	}
+	if want := "https://origin.example.com/live/stream/subs/en.m3u8"; en.URL != want {
+		t.Errorf("renditions[0].URL = %q, want %q", en.URL, want)
+	}
+
+	variants, err := ParseMasterPlaylist(audioMasterPlaylist, testPlaylistURL)
+	if err != nil {
+		t.Fatalf("ParseMasterPlaylist returned error: %v", err)
+	}
+	if variants[0].Subtitles != "subs" || variants[1].Subtitles != "" {
+		t.Errorf("variant subtitle groups = %q, %q, want subs and none", variants[0].Subtitles, variants[1].Subtitles)
+	}
+}
+
+func TestSelectRendition(t *testing.T) {
+	renditions, err := ParseAudioRenditions(audioMasterPlaylist, testPlaylistURL)
+	if err != nil {
+		t.Fatalf("ParseAudioRenditions returned error: %v", err)
+	}
+	group := RenditionGroup(renditions, "aac")
+
+	tests := []struct {
+		choice string
+		want   string
+	}{
+		{"", "Deutsch"},
+		{"0", "English"},
+		{"2", "Commentary"},
+		{"DE", "Deutsch"},
+		{"en-us", "English"},
+		// An exact language match beats a primary subtag match
+		{"en", "Commentary"},
+		{"commentary", "Commentary"},
+		{"3", ""},
+		{"-1", ""},
+		{"fr", ""},
+	}
+	for _, tc := range tests {
+		got := SelectRendition(group, tc.choice)
+		switch {
+		case tc.want == "" && got != nil:
+			t.Errorf("SelectRendition(%q) = %s, want nil", tc.choice, got.Name)
+		case tc.want != "" && (got == nil || got.Name != tc.want):
+			t.Errorf("SelectRendition(%q) = %+v, want %s", tc.choice, got, tc.want)
+		}
+	}
+
+	// Without a DEFAULT=YES rendition the first autoselected one is used
+	noDefault := []*Rendition{{Name: "a"}, {Name: "b", AutoSelect: true}}
+	if got := SelectRendition(noDefault, ""); got.Name != "b" {
+		t.Errorf("SelectRendition without default = %s, want b", got.Name)
+	}
+}
+
+const iframeMasterPlaylist = `#EXTM3U
+#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,CODECS="avc1.4d401e,mp4a.40.2"
+360p/index.m3u8
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=9000000,RESOLUTION=1920x1080,CODECS="avc1.640028",URI="1080p/iframes.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=5000000,RESOLUTION=1920x1080,CODECS="avc1.640028,mp4a.40.2"
+../hd/1080p.m3u8
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=86000,RESOLUTION=640x360,CODECS="avc1.4d401e",URI="/iframes/360p.m3u8"
+`
+
+func TestParseMasterPlaylistExcludesIFrameStreams(t *testing.T) {
+	variants, err := ParseMasterPlaylist(iframeMasterPlaylist, testPlaylistURL)
+	if err != nil {
+		t.Fatalf("ParseMasterPlaylist returned error: %v", err)
+	}
+	want := []Variant{
+		{URL: "https://origin.example.com/live/stream/360p/index.m3u8", Bandwidth: 800000, Width: 640, Height: 360, Codecs: "avc1.4d401e,mp4a.40.2"},
+		{URL: "https://origin.example.com/live/hd/1080p.m3u8", Bandwidth: 5000000, Width: 1920, Height: 1080, Codecs: "avc1.640028,mp4a.40.2"},
+	}
+	if len(variants) != len(want) {
+		t.Fatalf("got %d variants, want %d (I-frame streams excluded)", len(variants), len(want))
+	}
+	for i, v := range variants {
+		if *v != want[i] {
+			t.Errorf("variants[%d] = %+v, want %+v", i, *v, want[i])
+		}
+	}
+
+	// The I-frame stream's higher bandwidth and equal resolution don't
+	// make it the pick
+	if got := SelectVariant(variants, VariantPreference{}); got != variants[1] {
+		t.Errorf("SelectVariant(max) = %+v, want the 1080p variant", got)
+	}
+	if got := SelectVariant(variants, VariantPreference{Height: 1080}); got != variants[1] {
+		t.Errorf("SelectVariant(1080) = %+v, want the 1080p variant", got)
+	}
+}
+
+func TestParseIFrameVariants(t *testing.T) {
+	variants, err := ParseIFrameVariants(iframeMasterPlaylist, testPlaylistURL)
+	if err != nil {
+		t.Fatalf("ParseIFrameVariants returned error: %v", err)
+	}
+	want := []Variant{
+		{URL: "https://origin.example.com/live/stream/1080p/iframes.m3u8", Bandwidth: 9000000, Width: 1920, Height: 1080, Codecs: "avc1.640028", IFrame: true},
+		{URL: "https://origin.example.com/iframes/360p.m3u8", Bandwidth: 86000, Width: 640, Height: 360, Codecs: "avc1.4d401e", IFrame: true},
+	}
+	if len(variants) != len(want) {
+		t.Fatalf("got %d I-frame variants, want %d", len(variants), len(want))
+	}
+	for i, v := range variants {
+		if *v != want[i] {
+			t.Errorf("variants[%d] = %+v, want %+v", i, *v, want[i])
+		}
+	}
+	if got := SelectByBandwidth(variants, false); got != variants[1] {
+		t.Errorf("SelectByBandwidth(lowest) = %+v, want the 360p I-frame variant", got)
+	}
+}
+
+func TestIFrameOnlyMasterPlaylist(t *testing.T) {
+	content := "#EXTM3U\n#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=86000,URI=\"iframes.m3u8\"\n"
+	if !IsMasterPlaylist(content) {
+		t.Fatal("IsMasterPlaylist = false for a master playlist of I-frame streams")
+	}
+	variants, err := ParseMasterPlaylist(content, testPlaylistURL)
+	if err != nil {
+		t.Fatalf("ParseMasterPlaylist returned error: %v", err)
+	}
+	if len(variants) != 0 {
+		t.Errorf("got %d variants, want none", len(variants))
+	}
+	if _, err := ParseIFrameVariants("#EXTM3U\n#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=86000\n", testPlaylistURL); err == nil {
+		t.Error("ParseIFrameVariants accepted an I-frame stream without URI")
+	}
+}
+
+func TestSelectVariant(t *testing.T) {
+	low := &Variant{URL: "low", Bandwidth: 800000, Width: 640, Height: 360}
+	mid := &Variant{URL: "mid", Bandwidth: 2500000, Width: 1280, Height: 720}
+	midHigh := &Variant{URL: "mid-high", Bandwidth: 3200000, Width: 1280, Height: 720}
+	high := &Variant{URL: "high", Bandwidth: 6000000, Width: 1920, Height: 1080}
+	noResolution := &Variant{URL: "no-resolution", Bandwidth: 9000000}
+	noBandwidth := &Variant{URL: "no-bandwidth", Width: 854, Height: 480}
+	sameBandwidth := &Variant{URL: "same-bandwidth", Bandwidth: 2500000, Width: 1280, Height: 720}
+
+	tests := []struct {
+		name     string
+		variants []*Variant
+		pref     VariantPreference
+		want     *Variant
+	}{
+		{"empty", nil, VariantPreference{}, nil},
+		{"highest", []*Variant{low, high, mid}, VariantPreference{}, high},
+		{"lowest", []*Variant{mid, high, low}, VariantPreference{Lowest: true}, low},
+		{"exact height", []*Variant{low, mid, high}, VariantPreference{Height: 1080}, high},
+		{"closest height", []*Variant{low, mid, high}, VariantPreference{Height: 800}, mid},
+		{"closest height below", []*Variant{low, mid, high}, VariantPreference{Height: 400}, low},
+		{"equal distance broken by bandwidth", []*Variant{low, high}, VariantPreference{Height: 720}, high},
+		{"height tie broken by highest bandwidth", []*Variant{mid, midHigh, high}, VariantPreference{Height: 720}, midHigh},
+		{"height tie broken by lowest bandwidth", []*Variant{midHigh, mid, high}, VariantPreference{Height: 720, Lowest: true}, mid},
+		{"bandwidth tie keeps the first", []*Variant{mid, sameBandwidth}, VariantPreference{Height: 720}, mid},
+		{"missing resolution skipped for height", []*Variant{noResolution, mid}, VariantPreference{Height: 1080}, mid},
+		{"missing resolution used by bandwidth", []*Variant{noResolution, mid}, VariantPreference{}, noResolution},
+		{"no resolution at all", []*Variant{noResolution, {URL: "other", Bandwidth: 100}}, VariantPreference{Height: 720}, noResolution},
+		{"missing bandwidth not lowest", []*Variant{noBandwidth, mid, low}, VariantPreference{Lowest: true}, low},
+		{"missing bandwidth not highest", []*Variant{noBandwidth, low}, VariantPreference{}, low},
+		{"missing bandwidth matched by height", []*Variant{low, noBandwidth, mid}, VariantPreference{Height: 480}, noBandwidth},
+		{"no bandwidth at all", []*Variant{noBandwidth, {URL: "other"}}, VariantPreference{Lowest: true}, noBandwidth},
+	}
+	for _, tt := range tests {
+		if got := SelectVariant(tt.variants, tt.pref); got != tt.want {
+			t.Errorf("%s: SelectVariant(%+v) = %+v, want %+v", tt.name, tt.pref, got, tt.want)
+		}
+	}
+}
+
+func TestParseMasterPlaylistMissingAttributes(t *testing.T) {
+	content := "#EXTM3U\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=1280000\n" +
+		"no-resolution.m3u8\n" +
+		"#EXT-X-STREAM-INF:RESOLUTION=1280x720\n" +
+		"no-bandwidth.m3u8\n"
+	variants, err := ParseMasterPlaylist(content, testPlaylistURL)
+	if err != nil {
+		t.Fatalf("ParseMasterPlaylist returned error: %v", err)
+	}
+	if len(variants) != 2 {
+		t.Fatalf("got %d variants, want 2", len(variants))
+	}
+	if v := variants[0]; v.Bandwidth != 1280000 || v.Width != 0 || v.Height != 0 {
+		t.Errorf("variant without RESOLUTION = %+v", *v)
+	}
+	if v := variants[1]; v.Bandwidth != 0 || v.Width != 1280 || v.Height != 720 {
+		t.Errorf("variant without BANDWIDTH = %+v", *v)
+	}
+	if got := SelectVariant(variants, VariantPreference{Height: 720}); got != variants[1] {
+		t.Errorf("SelectVariant(720) = %+v, want the variant with a resolution", got)
+	}
+	if got := SelectVariant(variants, VariantPreference{Lowest: true}); got != variants[0] {
+		t.Errorf("SelectVariant(min) = %+v, want the variant with a bandwidth", got)
+	}
+}
@@ -3,10 +3,14 @@ package hls
 import (
 	"bufio"
 	"fmt"
+	"math"
 	"net/url"
+	"path"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Segment represents an HLS media segment.
@@ -14,62 +18,324 @@ type Segment struct {
 	URL      string
 	Sequence int
 	Duration float64
+	// Discontinuity is set when the segment follows an EXT-X-DISCONTINUITY
+	// tag (ad break, encoder reset, ...).
+	Discontinuity bool
+	// DiscontinuitySequence numbers the segment's run between
+	// discontinuities: EXT-X-DISCONTINUITY-SEQUENCE plus the discontinuities
+	// before it in the playlist. It stays stable across reloads of a live
+	// playlist, so a change between two segments marks a break even when
+	// the segment carrying the tag was never seen.
+	DiscontinuitySequence int
+	// ProgramDateTime is the wall-clock time of the segment's first sample.
+	// It is taken from EXT-X-PROGRAM-DATE-TIME or extrapolated from the
+	// previous segment, and is zero when the playlist doesn't carry dates.
+	ProgramDateTime time.Time
+	// DateJump is set when the segment's EXT-X-PROGRAM-DATE-TIME doesn't
+	// continue the previous segment's timeline, marking a chapter boundary.
+	DateJump bool
+	// Key is the EXT-X-KEY in effect for the segment, with its IV resolved,
+	// or nil when the segment isn't encrypted.
+	Key *Key
+	// Map is the URL of the EXT-X-MAP initialization segment (fMP4/CMAF
+	// streams) that must precede the segment's data, or empty when the
+	// playlist has none.
+	Map string
+	// Length and Offset locate the segment within its URL as given by
+	// EXT-X-BYTERANGE: Length bytes starting at byte Offset. Length is 0
+	// when the segment is the whole resource.
+	Length int64
+	Offset int64
+	// Ext is the file extension of the segment's URL path, such as ".ts" or
+	// ".m4s", and ".ts" when it has none.
+	Ext string
 }
 
+// DefaultSegmentExt is the extension of segments whose URL has none.
+const DefaultSegmentExt = ".ts"
+
+// SegmentExt returns the file extension of a segment URI's path, ignoring
+// any query string, or DefaultSegmentExt when it has no plausible one.
+func SegmentExt(uri string) string {
+	if u, err := url.Parse(uri); err == nil {
+		uri = u.Path
+	}
+	ext := path.Ext(uri)
+	if len(ext) < 2 || len(ext) > 8 {
+		return DefaultSegmentExt
+	}
+	for i := 1; i < len(ext); i++ {
+		if !isAlphanumeric(ext[i]) {
+			return DefaultSegmentExt
+		}
+	}
+	return ext
+}
+
+// dateJumpTolerance is how far an explicit program date may drift from the
+// extrapolated one before it is treated as a jump.
+const dateJumpTolerance = time.Second
+
+// Playlist types of EXT-X-PLAYLIST-TYPE.
+const (
+	// PlaylistTypeVOD playlists never change.
+	PlaylistTypeVOD = "VOD"
+	// PlaylistTypeEvent playlists only grow: segments are appended but
+	// never removed.
+	PlaylistTypeEvent = "EVENT"
+)
+
 // Playlist represents an HLS playlist with its segments.
 type Playlist struct {
 	Segments []*Segment
+	// Version is the EXT-X-VERSION, 1 when the playlist doesn't declare it.
+	Version int
+	// Type is the EXT-X-PLAYLIST-TYPE, PlaylistTypeVOD or
+	// PlaylistTypeEvent, or empty for a live playlist whose oldest segments
+	// may be removed.
+	Type string
+	// EndList is set when the playlist carries EXT-X-ENDLIST: no segments
+	// will be added to it.
+	EndList bool
+	// TargetDuration is the EXT-X-TARGETDURATION, the upper bound of the
+	// segment durations, or 0 when the playlist doesn't declare it.
+	TargetDuration time.Duration
+}
+
+// MinSequence returns the sequence of the oldest segment in the playlist,
+// or -1 when it has none. Sequences below it have left a live playlist's
+// sliding window and will not be published again.
+func (p *Playlist) MinSequence() int {
+	if first := GetFirstSegment(p.Segments); first != nil {
+		return first.Sequence
+	}
+	return -1
+}
+
+// Diff returns the segments of p that were not present in previous, in
+// playlist order. A nil previous yields every segment. Neither playlist is
+// modified, so Diff is safe to call concurrently on shared playlists.
+func (p *Playlist) Diff(previous *Playlist) []*Segment {
+	if previous == nil || len(previous.Segments) == 0 {
+		return p.Segments
+	}
+
+	seen := make(map[int]struct{}, len(previous.Segments))
+	for _, seg := range previous.Segments {
+		seen[seg.Sequence] = struct{}{}
+	}
+
+	var added []*Segment
+	for _, seg := range p.Segments {
+		if _, ok := seen[seg.Sequence]; !ok {
+			added = append(added, seg)
+		}
+	}
+	return added
 }
 
 // ParsePlaylist parses an M3U8 playlist content and returns a list of segments.
-// Uses pointers to reduce memory allocation overhead.
+// Uses pointers to reduce memory allocation overhead. A master playlist
+// returns ErrMasterPlaylist; resolve it with ParseMasterPlaylist and
+// SelectVariant first. Segments are numbered from EXT-X-MEDIA-SEQUENCE.
 func ParsePlaylist(playlistContent, baseURL string) ([]*Segment, error) {
+	return ParsePlaylistWithOptions(playlistContent, baseURL, ParseOptions{})
+}
+
+// ParsePlaylistFull parses a media playlist like ParsePlaylist, returning
+// its segments along with the header tags describing the playlist.
+func ParsePlaylistFull(playlistContent, baseURL string) (*Playlist, error) {
+	return ParsePlaylistFullWithOptions(playlistContent, baseURL, ParseOptions{})
+}
+
+// ParsePlaylistFullWithOptions is ParsePlaylistFull with the parsing tuned
+// by opts.
+func ParsePlaylistFullWithOptions(playlistContent, baseURL string, opts ParseOptions) (*Playlist, error) {
+	segments, err := ParsePlaylistWithOptions(playlistContent, baseURL, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	playlist := &Playlist{
+		Segments:       segments,
+		Version:        1,
+		EndList:        IsEndList(playlistContent),
+		TargetDuration: ParseTargetDuration(playlistContent),
+	}
+	if match := versionRegex.FindStringSubmatch(playlistContent); match != nil {
+		playlist.Version, _ = strconv.Atoi(match[1])
+	}
+	if match := playlistTypeRegex.FindStringSubmatch(playlistContent); match != nil {
+		playlist.Type = strings.ToUpper(match[1])
+	}
+	return playlist, nil
+}
+
+// ParseOptions tunes ParsePlaylistWithOptions.
+type ParseOptions struct {
+	// SequenceStrategy selects where segment sequence numbers come from;
+	// empty means SequenceMediaSequence.
+	SequenceStrategy SequenceStrategy
+}
+
+// ParsePlaylistWithOptions is ParsePlaylist with the parsing tuned by opts.
+func ParsePlaylistWithOptions(playlistContent, baseURL string, opts ParseOptions) ([]*Segment, error) {
 	var segments []*Segment
 	var currentDuration float64
-	var mediaSequence int
+	var mediaSequence, discontinuitySequence int
+	var discontinuity bool
+	var programDateTime, nextDateTime time.Time
+	var key *Key
+	var initMap string
+	// A byte range applies to the next URI; one without an offset continues
+	// where the previous sub-range of the same URI ended.
+	var rangeLength, rangeOffset int64
+	var hasRange, rangeContinues bool
+	var lastRangeURL string
+	var lastRangeEnd int64
 
 	base, err := url.Parse(baseURL)
 	if err != nil {
 		return nil, fmt.Errorf("invalid base URL: %w", err)
 	}
 
-	scanner := bufio.NewScanner(strings.NewReader(playlistContent))
+	// Legacy .m3u files may lack the #EXTM3U header and are parsed anyway,
+	// but an HTML or JSON error page must not turn into segment URIs.
+	if !HasPlaylistHeader(playlistContent) && looksLikeErrorPage([]byte(playlistContent)) {
+		return nil, fmt.Errorf("not an M3U playlist: missing #EXTM3U header and the body is HTML or JSON")
+	}
+	if IsMasterPlaylist(playlistContent) {
+		return nil, ErrMasterPlaylist
+	}
 
-	// Pre-compiled regex patterns for better performance
-	mediaSeqRegex := regexp.MustCompile(`#EXT-X-MEDIA-SEQUENCE:(\d+)`)
-	durationRegex := regexp.MustCompile(`#EXTINF:([\d.]+)`)
+	// A leading BOM would otherwise make the header line look like a URI
+	scanner := bufio.NewScanner(strings.NewReader(strings.TrimPrefix(playlistContent, "\ufeff")))
+	scanner.Buffer(nil, maxPlaylistLine)
 
 	for scanner.Scan() {
+		// TrimSpace also drops the \r of CRLF line endings and tab indentation
 		line := strings.TrimSpace(scanner.Text())
 
 		if match := mediaSeqRegex.FindStringSubmatch(line); match != nil {
-			mediaSequence, _ = strconv.Atoi(match[1])
+			if mediaSequence, err = strconv.Atoi(match[1]); err != nil {
+				return nil, fmt.Errorf("invalid EXT-X-MEDIA-SEQUENCE %s: %w", line, err)
+			}
 			continue
 		}
 
 		if match := durationRegex.FindStringSubmatch(line); match != nil {
-			currentDuration, _ = strconv.ParseFloat(match[1], 64)
+			// An unparsable, negative or out-of-range duration is treated as
+			// unknown. A repeated EXTINF replaces the previous one.
+			currentDuration = 0
+			if d, err := strconv.ParseFloat(match[1], 64); err == nil && d >= 0 && !math.IsInf(d, 0) {
+				currentDuration = d
+			}
+			continue
+		}
+
+		if match := discontinuitySeqRegex.FindStringSubmatch(line); match != nil {
+			if discontinuitySequence, err = strconv.Atoi(match[1]); err != nil {
+				return nil, fmt.Errorf("invalid EXT-X-DISCONTINUITY-SEQUENCE %s: %w", line, err)
+			}
+			continue
+		}
+
+		if line == "#EXT-X-DISCONTINUITY" {
+			discontinuity = true
+			discontinuitySequence++
+			continue
+		}
+
+		if value, ok := strings.CutPrefix(line, "#EXT-X-BYTERANGE:"); ok {
+			if rangeLength, rangeOffset, rangeContinues, err = parseByteRange(value); err != nil {
+				return nil, fmt.Errorf("invalid EXT-X-BYTERANGE %s: %w", line, err)
+			}
+			hasRange = true
+			continue
+		}
+
+		if attrList, ok := strings.CutPrefix(line, "#EXT-X-KEY:"); ok {
+			if key, err = parseKey(attrList, base); err != nil {
+				return nil, fmt.Errorf("invalid EXT-X-KEY %s: %w", line, err)
+			}
+			continue
+		}
+
+		if attrList, ok := strings.CutPrefix(line, "#EXT-X-MAP:"); ok {
+			if initMap, err = resolveURI(base, parseAttributes(attrList)["URI"]); err != nil {
+				return nil, fmt.Errorf("invalid EXT-X-MAP %s: %w", line, err)
+			}
+			continue
+		}
+
+		if value, ok := strings.CutPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:"); ok {
+			if t, err := parseProgramDateTime(value); err == nil {
+				programDateTime = t
+			}
 			continue
 		}
 
 		// Segment URL line
 		if line != "" && !strings.HasPrefix(line, "#") {
-			segmentURL, err := base.Parse(line)
+			segmentURL, err := resolveURI(base, line)
 			if err != nil {
 				return nil, fmt.Errorf("invalid segment URL %s: %w", line, err)
 			}
 
-			// Extract sequence number from segment URL if available
-			seq := extractSequenceFromURL(line, mediaSequence)
+			// The sub-ranges of a single file share its URL, so they keep
+			// the media sequence.
+			seq := mediaSequence
+			if !hasRange {
+				seq = opts.SequenceStrategy.sequence(line, mediaSequence)
+			}
 
-			segments = append(segments, &Segment{
-				URL:      segmentURL.String(),
-				Sequence: seq,
-				Duration: currentDuration,
-			})
+			segment := &Segment{
+				URL:                   segmentURL,
+				Sequence:              seq,
+				Duration:              currentDuration,
+				Discontinuity:         discontinuity,
+				DiscontinuitySequence: discontinuitySequence,
+				Key:                   key.forSequence(mediaSequence),
+				Map:                   initMap,
+				Ext:                   SegmentExt(segmentURL),
+			}
+			if hasRange {
+				if rangeContinues {
+					if lastRangeURL != segmentURL {
+						return nil, fmt.Errorf("EXT-X-BYTERANGE of %s has no offset and doesn't follow a sub-range of the same URI", line)
+					}
+					rangeOffset = lastRangeEnd
+				}
+				segment.Length, segment.Offset = rangeLength, rangeOffset
+				lastRangeURL, lastRangeEnd = segmentURL, rangeOffset+rangeLength
+			} else {
+				lastRangeURL = ""
+			}
 
+			switch {
+			case !programDateTime.IsZero():
+				segment.ProgramDateTime = programDateTime
+				if !nextDateTime.IsZero() {
+					drift := programDateTime.Sub(nextDateTime)
+					segment.DateJump = drift > dateJumpTolerance || drift < -dateJumpTolerance
+				}
+			case !nextDateTime.IsZero():
+				segment.ProgramDateTime = nextDateTime
+			}
+			if !segment.ProgramDateTime.IsZero() {
+				nextDateTime = segment.ProgramDateTime.Add(time.Duration(currentDuration * float64(time.Second)))
+			}
+
+			segments = append(segments, segment)
+
+			if mediaSequence == math.MaxInt {
+				return nil, fmt.Errorf("media sequence overflows after %s", line)
+			}
 			mediaSequence++
 			currentDuration = 0
+			discontinuity = false
+			programDateTime = time.Time{}
+			hasRange = false
 		}
 	}
 
@@ -80,6 +346,77 @@ func ParsePlaylist(playlistContent, baseURL string) ([]*Segment, error) {
 	return segments, nil
 }
 
+// maxPlaylistLine bounds the length of a playlist line, leaving room for
+// long signed segment URLs.
+const maxPlaylistLine = 1 << 20
+
+// Tag patterns tolerate whitespace after the colon, as written by some
+// packagers (e.g. "#EXTINF: 9.009 ,title").
+var (
+	mediaSeqRegex         = regexp.MustCompile(`^#EXT-X-MEDIA-SEQUENCE:\s*(\d+)`)
+	discontinuitySeqRegex = regexp.MustCompile(`^#EXT-X-DISCONTINUITY-SEQUENCE:\s*(\d+)`)
+	durationRegex         = regexp.MustCompile(`^#EXTINF:\s*(-?[\d.]+)`)
+	targetDurationRegex   = regexp.MustCompile(`(?m)^\s*#EXT-X-TARGETDURATION:\s*([\d.]+)`)
+	versionRegex          = regexp.MustCompile(`(?m)^\s*#EXT-X-VERSION:\s*(\d+)`)
+	playlistTypeRegex     = regexp.MustCompile(`(?m)^\s*#EXT-X-PLAYLIST-TYPE:\s*(\w+)`)
+)
+
+// parseByteRange parses an EXT-X-BYTERANGE value, "<length>[@<offset>]".
+// continues reports a missing offset.
+func parseByteRange(value string) (length, offset int64, continues bool, err error) {
+	lengthPart, offsetPart, hasOffset := strings.Cut(strings.TrimSpace(value), "@")
+	if length, err = strconv.ParseInt(lengthPart, 10, 64); err != nil || length <= 0 {
+		return 0, 0, false, fmt.Errorf("invalid length %q", lengthPart)
+	}
+	if !hasOffset {
+		return length, 0, true, nil
+	}
+	if offset, err = strconv.ParseInt(offsetPart, 10, 64); err != nil || offset < 0 {
+		return 0, 0, false, fmt.Errorf("invalid offset %q", offsetPart)
+	}
+	return length, offset, false, nil
+}
+
+// parseProgramDateTime parses an EXT-X-PROGRAM-DATE-TIME value. The spec
+// requires ISO 8601 with a time zone; some servers omit the colon in the
+// offset, so that form is accepted too.
+func parseProgramDateTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02T15:04:05.999999999Z0700", value)
+}
+
+// HasPlaylistHeader reports whether the first non-empty line is #EXTM3U.
+// ParsePlaylist accepts playlists without it, so callers can warn about
+// a body that may not be a playlist at all.
+func HasPlaylistHeader(playlistContent string) bool {
+	content := strings.TrimLeft(strings.TrimPrefix(playlistContent, "\ufeff"), " \t\r\n")
+	return strings.HasPrefix(content, "#EXTM3U")
+}
+
+// IsEndList reports whether the playlist carries EXT-X-ENDLIST, i.e. it is
+// complete (VOD or an ended live stream) and no segments will be added.
+func IsEndList(playlistContent string) bool {
+	return strings.Contains(playlistContent, "#EXT-X-ENDLIST")
+}
+
+// ParseTargetDuration returns the playlist's EXT-X-TARGETDURATION, or 0
+// when it is missing or invalid. The spec requires whole seconds; decimal
+// values written by some packagers are accepted too.
+func ParseTargetDuration(playlistContent string) time.Duration {
+	match := targetDurationRegex.FindStringSubmatch(playlistContent)
+	if match == nil {
+		return 0
+	}
+	seconds, err := strconv.ParseFloat(match[1], 64)
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
 // GetLastSegment returns a pointer to the segment with the highest sequence number.
 func GetLastSegment(segments []*Segment) *Segment {
 	if len(segments) == 0 {
@@ -96,6 +433,34 @@ func GetLastSegment(segments []*Segment) *Segment {
 	return last
 }
 
+// GetFirstSegment returns a pointer to the segment with the lowest sequence number.
+func GetFirstSegment(segments []*Segment) *Segment {
+	if len(segments) == 0 {
+		return nil
+	}
+
+	first := segments[0]
+	for i := 1; i < len(segments); i++ {
+		if segments[i].Sequence < first.Sequence {
+			first = segments[i]
+		}
+	}
+	return first
+}
+
+// LookupSegment finds a segment by its sequence number in a live playlist.
+// Returns (nil, nil) if the segment hasn't been published yet, and a
+// *SegmentExpiredError if it is older than every segment in the window.
+func LookupSegment(segments []*Segment, sequence int) (*Segment, error) {
+	if seg := FindSegmentBySequence(segments, sequence); seg != nil {
+		return seg, nil
+	}
+	if first := GetFirstSegment(segments); first != nil && first.Sequence > sequence {
+		return nil, &SegmentExpiredError{Sequence: sequence, FirstSequence: first.Sequence}
+	}
+	return nil, nil
+}
+
 // FindSegmentBySequence finds a segment by its sequence number.
 // Returns a pointer to the segment if found, nil otherwise.
 func FindSegmentBySequence(segments []*Segment, sequence int) *Segment {
@@ -107,6 +472,34 @@ func FindSegmentBySequence(segments []*Segment, sequence int) *Segment {
 	return nil
 }
 
+// SegmentAtTime returns the first segment whose EXT-X-PROGRAM-DATE-TIME
+// span ends after t: the one playing at t or, when t falls in a gap, the
+// next one. It returns nil when no dated segment ends after t.
+func SegmentAtTime(segments []*Segment, t time.Time) *Segment {
+	for _, seg := range segments {
+		if !seg.ProgramDateTime.IsZero() && seg.EndDateTime().After(t) {
+			return seg
+		}
+	}
+	return nil
+}
+
+// HasProgramDateTime reports whether any of the segments is dated.
+func HasProgramDateTime(segments []*Segment) bool {
+	return slices.ContainsFunc(segments, func(seg *Segment) bool {
+		return !seg.ProgramDateTime.IsZero()
+	})
+}
+
+// EndDateTime returns the wall-clock time the segment ends at, or the zero
+// time when it has no ProgramDateTime.
+func (s *Segment) EndDateTime() time.Time {
+	if s.ProgramDateTime.IsZero() {
+		return time.Time{}
+	}
+	return s.ProgramDateTime.Add(time.Duration(s.Duration * float64(time.Second)))
+}
+
 // extractSequenceFromURL extracts sequence number from segment URL.
 // Expected format: master_1440_primary_719721.ts
 func extractSequenceFromURL(segmentURL string, defaultSeq int) int {
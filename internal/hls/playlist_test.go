@@ -0,0 +1,518 @@
+package hls
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testMediaPlaylistURL = "https://origin.example.com/live/index.m3u8"
+
+// wantSegments checks the URL, sequence and duration of parsed segments.
+func wantSegments(t *testing.T, segments []*Segment, want []Segment) {
+	t.Helper()
+
+	if len(segments) != len(want) {
+		t.Fatalf("got %d segments, want %d", len(segments), len(want))
+	}
+	for i, w := range want {
+		got := segments[i]
+		if got.URL != w.URL || got.Sequence != w.Sequence || got.Duration != w.Duration {
+			t.Errorf("segment %d = {%s %d %v}, want {%s %d %v}", i, got.URL, got.Sequence, got.Duration, w.URL, w.Sequence, w.Duration)
+		}
+	}
+}
+
+var whitespaceSegments = []Segment{
+	{URL: "https://origin.example.com/live/a.ts", Sequence: 7, Duration: 9.009},
+	{URL: "https://origin.example.com/live/b.ts", Sequence: 8, Duration: 10},
+}
+
+func TestParsePlaylistCRLF(t *testing.T) {
+	content := strings.Join([]string{
+		"#EXTM3U",
+		"#EXT-X-TARGETDURATION:10",
+		"#EXT-X-MEDIA-SEQUENCE:7",
+		"#EXTINF:9.009,",
+		"a.ts",
+		"#EXTINF:10.0,",
+		"b.ts",
+		"",
+	}, "\r\n")
+
+	segments, err := ParsePlaylist(content, testMediaPlaylistURL)
+	if err != nil {
+		t.Fatalf("ParsePlaylist returned error: %v", err)
+	}
+	wantSegments(t, segments, whitespaceSegments)
+}
+
+func TestParsePlaylistTabIndented(t *testing.T) {
+	content := "#EXTM3U\n" +
+		"\t#EXT-X-TARGETDURATION:10\n" +
+		"\t#EXT-X-MEDIA-SEQUENCE:7\n" +
+		"\t#EXTINF:9.009,\n" +
+		"\ta.ts\n" +
+		"  \t#EXTINF:10.0,\n" +
+		"  \tb.ts  \n"
+
+	segments, err := ParsePlaylist(content, testMediaPlaylistURL)
+	if err != nil {
+		t.Fatalf("ParsePlaylist returned error: %v", err)
+	}
+	wantSegments(t, segments, whitespaceSegments)
+}
+
+func TestParsePlaylistSpaceAfterColon(t *testing.T) {
+	content := "#EXTM3U\n" +
+		"#EXT-X-MEDIA-SEQUENCE: 7\n" +
+		"#EXTINF: 9.009 ,title\n" +
+		"a.ts\n" +
+		"#EXTINF:\t10 ,\n" +
+		"b.ts\n"
+
+	segments, err := ParsePlaylist(content, testMediaPlaylistURL)
+	if err != nil {
+		t.Fatalf("ParsePlaylist returned error: %v", err)
+	}
+	wantSegments(t, segments, whitespaceSegments)
+}
+
+func TestParsePlaylistBOM(t *testing.T) {
+	content := "\ufeff#EXTM3U\r\n#EXT-X-MEDIA-SEQUENCE:7\r\n#EXTINF:9.009,\r\na.ts\r\n#EXTINF:10,\r\nb.ts\r\n"
+
+	segments, err := ParsePlaylist(content, testMediaPlaylistURL)
+	if err != nil {
+		t.Fatalf("ParsePlaylist returned error: %v", err)
+	}
+	wantSegments(t, segments, whitespaceSegments)
+}
+
+func TestParsePlaylistWithoutHeader(t *testing.T) {
+	content := "#EXT-X-MEDIA-SEQUENCE:7\n#EXTINF:9.009,\na.ts\n#EXTINF:10,\nb.ts\n"
+	if HasPlaylistHeader(content) {
+		t.Error("HasPlaylistHeader reported a header for a headerless playlist")
+	}
+
+	segments, err := ParsePlaylist(content, testMediaPlaylistURL)
+	if err != nil {
+		t.Fatalf("ParsePlaylist returned error: %v", err)
+	}
+	wantSegments(t, segments, whitespaceSegments)
+}
+
+func TestParsePlaylistRejectsErrorPage(t *testing.T) {
+	for _, content := range []string{
+		"<!DOCTYPE html>\n<html><body>403 Forbidden</body></html>\n",
+		"\n  {\"error\": \"token expired\"}\n",
+	} {
+		if _, err := ParsePlaylist(content, testMediaPlaylistURL); err == nil {
+			t.Errorf("ParsePlaylist(%q) succeeded", content)
+		}
+	}
+}
+
+func TestHasPlaylistHeader(t *testing.T) {
+	tests := []struct {
+		content string
+		want    bool
+	}{
+		{"#EXTM3U\n#EXTINF:4,\na.ts\n", true},
+		{"\ufeff#EXTM3U\n", true},
+		{"\r\n\n  #EXTM3U\n", true},
+		{"#EXTINF:4,\na.ts\n", false},
+		{"a.ts\n#EXTM3U\n", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := HasPlaylistHeader(tt.content); got != tt.want {
+			t.Errorf("HasPlaylistHeader(%q) = %v, want %v", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestParsePlaylistMap(t *testing.T) {
+	content := "#EXTM3U\n" +
+		"#EXT-X-MEDIA-SEQUENCE:7\n" +
+		"#EXT-X-MAP:URI=\"init.mp4\"\n" +
+		"#EXTINF:4,\na.m4s\n" +
+		"#EXT-X-DISCONTINUITY\n" +
+		"#EXT-X-MAP:URI=\"/other/init.mp4\"\n" +
+		"#EXTINF:4,\nb.m4s\n"
+
+	segments, err := ParsePlaylist(content, testMediaPlaylistURL)
+	if err != nil {
+		t.Fatalf("ParsePlaylist returned error: %v", err)
+	}
+	want := []string{
+		"https://origin.example.com/live/init.mp4",
+		"https://origin.example.com/other/init.mp4",
+	}
+	if len(segments) != len(want) {
+		t.Fatalf("got %d segments, want %d", len(segments), len(want))
+	}
+	for i, w := range want {
+		if segments[i].Map != w {
+			t.Errorf("segment %d Map = %q, want %q", i, segments[i].Map, w)
+		}
+	}
+}
+
+func TestParsePlaylistWithoutMap(t *testing.T) {
+	segments, err := ParsePlaylist("#EXTM3U\n#EXTINF:4,\na.ts\n", testMediaPlaylistURL)
+	if err != nil {
+		t.Fatalf("ParsePlaylist returned error: %v", err)
+	}
+	if len(segments) != 1 || segments[0].Map != "" {
+		t.Errorf("segments = %+v, want one segment without a map", segments)
+	}
+}
+
+func TestParsePlaylistDiscontinuitySequence(t *testing.T) {
+	content := "#EXTM3U\n" +
+		"#EXT-X-MEDIA-SEQUENCE:10\n" +
+		"#EXT-X-DISCONTINUITY-SEQUENCE:3\n" +
+		"#EXTINF:4,\na.ts\n" +
+		"#EXT-X-DISCONTINUITY\n" +
+		"#EXTINF:4,\nb.ts\n" +
+		"#EXTINF:4,\nc.ts\n" +
+		"#EXT-X-DISCONTINUITY\n" +
+		"#EXTINF:4,\nd.ts\n"
+
+	segments, err := ParsePlaylist(content, testMediaPlaylistURL)
+	if err != nil {
+		t.Fatalf("ParsePlaylist returned error: %v", err)
+	}
+	want := []struct {
+		discontinuity bool
+		sequence      int
+	}{{false, 3}, {true, 4}, {false, 4}, {true, 5}}
+	if len(segments) != len(want) {
+		t.Fatalf("got %d segments, want %d", len(segments), len(want))
+	}
+	for i, w := range want {
+		if segments[i].Discontinuity != w.discontinuity || segments[i].DiscontinuitySequence != w.sequence {
+			t.Errorf("segment %d: Discontinuity = %v, DiscontinuitySequence = %d, want %v, %d",
+				i, segments[i].Discontinuity, segments[i].DiscontinuitySequence, w.discontinuity, w.sequence)
+		}
+	}
+}
+
+func TestParsePlaylistByteRange(t *testing.T) {
+	content := "#EXTM3U\n" +
+		"#EXT-X-MEDIA-SEQUENCE:7\n" +
+		"#EXTINF:4,\n#EXT-X-BYTERANGE:1000@0\nmain_1.ts\n" +
+		"#EXTINF:4,\n#EXT-X-BYTERANGE:1500\nmain_1.ts\n" +
+		"#EXTINF:4,\n#EXT-X-BYTERANGE:800@4000\nmain_1.ts\n" +
+		"#EXTINF:4,\nwhole.ts\n"
+
+	segments, err := ParsePlaylist(content, testMediaPlaylistURL)
+	if err != nil {
+		t.Fatalf("ParsePlaylist returned error: %v", err)
+	}
+	want := []struct {
+		sequence       int
+		length, offset int64
+	}{{7, 1000, 0}, {8, 1500, 1000}, {9, 800, 4000}, {10, 0, 0}}
+	if len(segments) != len(want) {
+		t.Fatalf("got %d segments, want %d", len(segments), len(want))
+	}
+	for i, w := range want {
+		seg := segments[i]
+		if seg.Sequence != w.sequence || seg.Length != w.length || seg.Offset != w.offset {
+			t.Errorf("segment %d: Sequence = %d, Length = %d, Offset = %d, want %d, %d, %d",
+				i, seg.Sequence, seg.Length, seg.Offset, w.sequence, w.length, w.offset)
+		}
+	}
+}
+
+func TestParsePlaylistByteRangeWithoutPrevious(t *testing.T) {
+	content := "#EXTM3U\n" +
+		"#EXTINF:4,\n#EXT-X-BYTERANGE:1000@0\na.ts\n" +
+		"#EXTINF:4,\n#EXT-X-BYTERANGE:1000\nb.ts\n"
+
+	if _, err := ParsePlaylist(content, testMediaPlaylistURL); err == nil {
+		t.Fatal("ParsePlaylist accepted a byte range without offset following another URI")
+	}
+}
+
+func TestParseTargetDuration(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    time.Duration
+	}{
+		{"integer", "#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXTINF:6,\na.ts\n", 6 * time.Second},
+		{"CRLF and indented", "#EXTM3U\r\n\t#EXT-X-TARGETDURATION: 10\r\n#EXTINF:10,\r\na.ts\r\n", 10 * time.Second},
+		{"decimal", "#EXTM3U\n#EXT-X-TARGETDURATION:2.5\n", 2500 * time.Millisecond},
+		{"missing", "#EXTM3U\n#EXTINF:6,\na.ts\n", 0},
+		{"zero", "#EXTM3U\n#EXT-X-TARGETDURATION:0\n", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseTargetDuration(tt.content); got != tt.want {
+				t.Errorf("ParseTargetDuration = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePlaylistFull(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    Playlist
+	}{
+		{
+			"VOD",
+			"#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:6\n#EXTINF:6,\na.ts\n#EXT-X-ENDLIST\n",
+			Playlist{Version: 7, Type: PlaylistTypeVOD, TargetDuration: 6 * time.Second, EndList: true},
+		},
+		{
+			"event",
+			"#EXTM3U\r\n#EXT-X-VERSION: 3\r\n#EXT-X-PLAYLIST-TYPE: EVENT\r\n#EXTINF:4,\r\na.ts\r\n",
+			Playlist{Version: 3, Type: PlaylistTypeEvent},
+		},
+		{
+			"live without header tags",
+			"#EXTM3U\n#EXTINF:4,\na.ts\n",
+			Playlist{Version: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			playlist, err := ParsePlaylistFull(tt.content, testMediaPlaylistURL)
+			if err != nil {
+				t.Fatalf("ParsePlaylistFull returned error: %v", err)
+			}
+			if len(playlist.Segments) != 1 {
+				t.Errorf("got %d segments, want 1", len(playlist.Segments))
+			}
+			if playlist.Version != tt.want.Version || playlist.Type != tt.want.Type ||
+				playlist.TargetDuration != tt.want.TargetDuration || playlist.EndList != tt.want.EndList {
+				t.Errorf("ParsePlaylistFull = version %d, type %q, target duration %v, endlist %v; want %d, %q, %v, %v",
+					playlist.Version, playlist.Type, playlist.TargetDuration, playlist.EndList,
+					tt.want.Version, tt.want.Type, tt.want.TargetDuration, tt.want.EndList)
+			}
+		})
+	}
+
+	if _, err := ParsePlaylistFull("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1\nv.m3u8\n", testMediaPlaylistURL); !errors.Is(err, ErrMasterPlaylist) {
+		t.Errorf("ParsePlaylistFull of a master playlist returned %v, want ErrMasterPlaylist", err)
+	}
+}
+
+// mustParse parses a media playlist made of the given lines after the
+// #EXTM3U header, failing the test on error.
+func mustParse(t *testing.T, lines ...string) []*Segment {
+	t.Helper()
+
+	content := "#EXTM3U\n" + strings.Join(lines, "\n") + "\n"
+	segments, err := ParsePlaylist(content, testMediaPlaylistURL)
+	if err != nil {
+		t.Fatalf("ParsePlaylist returned error: %v", err)
+	}
+	return segments
+}
+
+func TestParsePlaylistSequence(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  []Segment
+	}{
+		{
+			name:  "missing media sequence starts at 0",
+			lines: []string{"#EXTINF:4,", "a.ts", "#EXTINF:4,", "b.ts"},
+			want: []Segment{
+				{URL: "https://origin.example.com/live/a.ts", Sequence: 0, Duration: 4},
+				{URL: "https://origin.example.com/live/b.ts", Sequence: 1, Duration: 4},
+			},
+		},
+		{
+			name:  "media sequence",
+			lines: []string{"#EXT-X-MEDIA-SEQUENCE:41", "#EXTINF:4,", "a.ts", "#EXTINF:4,", "b.ts"},
+			want: []Segment{
+				{URL: "https://origin.example.com/live/a.ts", Sequence: 41, Duration: 4},
+				{URL: "https://origin.example.com/live/b.ts", Sequence: 42, Duration: 4},
+			},
+		},
+		{
+			// Numbers in URLs are only used with another SequenceStrategy
+			name:  "number in URL",
+			lines: []string{"#EXT-X-MEDIA-SEQUENCE:5", "#EXTINF:4,", "master_1440_primary_719721.ts", "#EXTINF:4,", "plain.ts"},
+			want: []Segment{
+				{URL: "https://origin.example.com/live/master_1440_primary_719721.ts", Sequence: 5, Duration: 4},
+				{URL: "https://origin.example.com/live/plain.ts", Sequence: 6, Duration: 4},
+			},
+		},
+		{
+			name:  "duplicate EXTINF",
+			lines: []string{"#EXTINF:4,", "#EXTINF:6,", "a.ts"},
+			want:  []Segment{{URL: "https://origin.example.com/live/a.ts", Sequence: 0, Duration: 6}},
+		},
+		{
+			name:  "negative duration",
+			lines: []string{"#EXTINF:4,", "a.ts", "#EXTINF:-2,", "b.ts"},
+			want: []Segment{
+				{URL: "https://origin.example.com/live/a.ts", Sequence: 0, Duration: 4},
+				{URL: "https://origin.example.com/live/b.ts", Sequence: 1, Duration: 0},
+			},
+		},
+		{
+			name:  "duration out of range",
+			lines: []string{"#EXTINF:" + strings.Repeat("9", 400) + ",", "a.ts"},
+			want:  []Segment{{URL: "https://origin.example.com/live/a.ts", Sequence: 0, Duration: 0}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantSegments(t, mustParse(t, tt.lines...), tt.want)
+		})
+	}
+}
+
+func TestParsePlaylistLongURL(t *testing.T) {
+	// Signed CDN URLs can carry very long query strings
+	uri := "a.ts?token=" + strings.Repeat("x", 200_000)
+	segments := mustParse(t, "#EXTINF:4,", uri)
+	wantSegments(t, segments, []Segment{{URL: "https://origin.example.com/live/" + uri, Sequence: 0, Duration: 4}})
+}
+
+func TestParsePlaylistInvalidSequence(t *testing.T) {
+	for _, content := range []string{
+		"#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:99999999999999999999\n#EXTINF:4,\na.ts\n",
+		"#EXTM3U\n#EXT-X-DISCONTINUITY-SEQUENCE:99999999999999999999\n#EXTINF:4,\na.ts\n",
+		"#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:9223372036854775807\n#EXTINF:4,\na.ts\n#EXTINF:4,\nb.ts\n",
+	} {
+		if _, err := ParsePlaylist(content, testMediaPlaylistURL); err == nil {
+			t.Errorf("ParsePlaylist accepted %q", content)
+		}
+	}
+}
+
+func FuzzParsePlaylist(f *testing.F) {
+	seeds := []string{
+		"#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXT-X-MEDIA-SEQUENCE:7\n#EXTINF:9.009,\na.ts\n#EXTINF:10.0,\nb.ts\n",
+		"#EXTM3U\r\n#EXT-X-MEDIA-SEQUENCE:7\r\n#EXTINF:9.009,\r\na.ts\r\n",
+		"\ufeff#EXTM3U\n#EXTINF:4,\nmaster_1440_primary_719721.ts\n",
+		"#EXTM3U\n#EXTINF:4,\n#EXTINF:-2,\na.ts\n#EXTINF:,\nb.ts\n",
+		"#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:99999999999999999999999\n#EXTINF:99999999999999999999999999999999999999.9,\na_99999999999999999999999.ts\n",
+		"#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"key\",IV=0x0123\n#EXTINF:4,\na.ts\n",
+		"#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4\",BYTERANGE=\"100@0\"\n#EXT-X-BYTERANGE:1000\na.m4s\n",
+		"#EXTM3U\n#EXT-X-PROGRAM-DATE-TIME:2024-01-01T00:00:00Z\n#EXTINF:4,\na.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:4,\nb.ts\n",
+		"#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1000\nlow.m3u8\n",
+		"#EXTM3U\n#EXTINF:4,\nhttp://[::1\n",
+		"",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, content string) {
+		segments, err := ParsePlaylist(content, testMediaPlaylistURL)
+		if err != nil {
+			return
+		}
+		for i, seg := range segments {
+			if seg.URL == "" {
+				t.Errorf("segment %d has no URL", i)
+			}
+			if seg.Duration < 0 || math.IsInf(seg.Duration, 0) || math.IsNaN(seg.Duration) {
+				t.Errorf("segment %d has duration %v", i, seg.Duration)
+			}
+			if seg.Sequence < 0 {
+				t.Errorf("segment %d has sequence %d", i, seg.Sequence)
+			}
+		}
+	})
+}
+
+func TestSegmentExt(t *testing.T) {
+	tests := []struct {
+		uri  string
+		want string
+	}{
+		{"https://cdn.example.com/live/seg_1.ts", ".ts"},
+		{"chunk-00042.m4s?token=abc.def", ".m4s"},
+		{"/audio/frag.aac", ".aac"},
+		{"https://cdn.example.com/media/42", ".ts"},
+		{"segment.ts/", ".ts"},
+		{"weird.e%20x", ".ts"},
+	}
+	for _, tt := range tests {
+		if got := SegmentExt(tt.uri); got != tt.want {
+			t.Errorf("SegmentExt(%q) = %q, want %q", tt.uri, got, tt.want)
+		}
+	}
+
+	segments := mustParse(t, "#EXTINF:4,", "a.m4s", "#EXTINF:4,", "b")
+	if segments[0].Ext != ".m4s" || segments[1].Ext != ".ts" {
+		t.Errorf("Ext = %q, %q, want .m4s, .ts", segments[0].Ext, segments[1].Ext)
+	}
+}
+
+func TestSegmentAtTime(t *testing.T) {
+	content := "#EXTM3U\n#EXT-X-TARGETDURATION:4\n#EXT-X-MEDIA-SEQUENCE:10\n" +
+		"#EXTINF:4,\na.ts\n" +
+		"#EXT-X-PROGRAM-DATE-TIME:2024-01-01T20:00:00Z\n#EXTINF:4,\nb.ts\n#EXTINF:4,\nc.ts\n" +
+		"#EXT-X-PROGRAM-DATE-TIME:2024-01-01T20:00:30Z\n#EXTINF:4,\nd.ts\n"
+	segments, err := ParsePlaylist(content, "https://example.com/live.m3u8")
+	if err != nil {
+		t.Fatalf("ParsePlaylist returned error: %v", err)
+	}
+	if !HasProgramDateTime(segments) {
+		t.Fatal("HasProgramDateTime = false, want true")
+	}
+
+	at := func(s string) time.Time {
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		time string
+		want int // sequence, or -1 for none
+	}{
+		{"2024-01-01T19:00:00Z", 11},
+		{"2024-01-01T20:00:00Z", 11},
+		{"2024-01-01T20:00:05Z", 12},
+		{"2024-01-01T20:00:08Z", 13}, // in the gap before d.ts
+		{"2024-01-01T20:00:33Z", 13},
+		{"2024-01-01T20:00:34Z", -1},
+	}
+	for _, tt := range tests {
+		got := SegmentAtTime(segments, at(tt.time))
+		switch {
+		case got == nil && tt.want >= 0:
+			t.Errorf("SegmentAtTime(%s) = nil, want %d", tt.time, tt.want)
+		case got != nil && got.Sequence != tt.want:
+			t.Errorf("SegmentAtTime(%s) = %d, want %d", tt.time, got.Sequence, tt.want)
+		}
+	}
+
+	undated, err := ParsePlaylist("#EXTM3U\n#EXTINF:4,\na.ts\n", "https://example.com/live.m3u8")
+	if err != nil {
+		t.Fatalf("ParsePlaylist returned error: %v", err)
+	}
+	if HasProgramDateTime(undated) || SegmentAtTime(undated, at("2024-01-01T20:00:00Z")) != nil {
+		t.Error("undated playlist matched a program date-time")
+	}
+}
+
+func TestPlaylistMinSequence(t *testing.T) {
+	playlist, err := ParsePlaylistFull("#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:42\n#EXTINF:4,\na.ts\n#EXTINF:4,\nb.ts\n", testMediaPlaylistURL)
+	if err != nil {
+		t.Fatalf("ParsePlaylistFull returned error: %v", err)
+	}
+	if got := playlist.MinSequence(); got != 42 {
+		t.Errorf("MinSequence = %d, want 42", got)
+	}
+	if got := (&Playlist{}).MinSequence(); got != -1 {
+		t.Errorf("MinSequence of an empty playlist = %d, want -1", got)
+	}
+}
@@ -0,0 +1,129 @@
+package hls
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PlaylistPoller keeps the latest version of a media playlist for any
+// number of consumers. Each poll is a conditional request, and a playlist
+// the server reports unchanged, or that comes back byte for byte the same,
+// is not parsed again. A PlaylistPoller is safe for concurrent use.
+type PlaylistPoller struct {
+	fetcher *Fetcher
+	opts    ParseOptions
+
+	mu          sync.Mutex
+	url         string
+	version     PlaylistVersion
+	content     string
+	latest      *Playlist
+	subscribers []chan *Playlist
+}
+
+// NewPlaylistPoller returns a poller fetching the playlist at url with
+// fetcher and parsing it with opts.
+func NewPlaylistPoller(fetcher *Fetcher, url string, opts ParseOptions) *PlaylistPoller {
+	return &PlaylistPoller{fetcher: fetcher, url: url, opts: opts}
+}
+
+// SetURL switches the poller to another URL of the playlist, e.g. one with
+// refreshed credentials. The next poll fetches it unconditionally.
+func (p *PlaylistPoller) SetURL(url string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.url = url
+	p.version = PlaylistVersion{}
+	p.content = ""
+}
+
+// Poll fetches the playlist once and returns its latest version. changed
+// reports whether it differs from the previous poll; the first successful
+// poll always counts as a change. Changed playlists are published to the
+// subscribers. Concurrent calls are serialized.
+func (p *PlaylistPoller) Poll(ctx context.Context) (playlist *Playlist, changed bool, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	content, version, modified, err := p.fetcher.FetchPlaylistIfModified(ctx, p.url, p.version)
+	if err != nil {
+		return nil, false, err
+	}
+	if p.latest != nil && (!modified || content == p.content) {
+		p.version = version
+		return p.latest, false, nil
+	}
+
+	playlist, err = ParsePlaylistFullWithOptions(content, p.url, p.opts)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to parse playlist: %w", err)
+	}
+	p.version = version
+	p.content = content
+	p.latest = playlist
+
+	for _, ch := range p.subscribers {
+		// Replace an update the subscriber hasn't received yet
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- playlist
+	}
+	return playlist, true, nil
+}
+
+// GetLatest returns the playlist of the last successful poll, or nil
+// before the first one.
+func (p *PlaylistPoller) GetLatest() *Playlist {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.latest
+}
+
+// Subscribe returns a channel receiving each changed playlist. A slow
+// subscriber only gets the newest one it missed. The channel is closed when
+// Run returns.
+func (p *PlaylistPoller) Subscribe() <-chan *Playlist {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ch := make(chan *Playlist, 1)
+	p.subscribers = append(p.subscribers, ch)
+	return ch
+}
+
+// Run polls the playlist every interval until it ends with #EXT-X-ENDLIST,
+// a poll fails or ctx is canceled, then closes the subscriber channels.
+func (p *PlaylistPoller) Run(ctx context.Context, interval time.Duration) error {
+	defer func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for _, ch := range p.subscribers {
+			close(ch)
+		}
+		p.subscribers = nil
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		playlist, _, err := p.Poll(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if playlist.EndList {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
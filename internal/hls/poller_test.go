@@ -0,0 +1,128 @@
+package hls
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+const pollerPlaylist = "#EXTM3U\n#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:10\n#EXTINF:2.0,\nseg10.ts\n"
+
+func TestPlaylistPollerConditionalRequests(t *testing.T) {
+	var mu sync.Mutex
+	content, etag := pollerPlaylist, `"v1"`
+	var full, notModified int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full++
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(content))
+	}))
+	t.Cleanup(server.Close)
+
+	p := NewPlaylistPoller(NewFetcher(), server.URL+"/live.m3u8", ParseOptions{})
+	updates := p.Subscribe()
+
+	first, changed, err := p.Poll(context.Background())
+	if err != nil {
+		t.Fatalf("Poll returned error: %v", err)
+	}
+	if !changed || len(first.Segments) != 1 {
+		t.Fatalf("first Poll = %d segments, changed %v; want 1 segment, changed", len(first.Segments), changed)
+	}
+	if got := <-updates; got != first {
+		t.Errorf("subscriber received %p, want the first playlist %p", got, first)
+	}
+
+	again, changed, err := p.Poll(context.Background())
+	if err != nil {
+		t.Fatalf("Poll returned error: %v", err)
+	}
+	if changed || again != first {
+		t.Errorf("unchanged Poll returned changed %v, a new playlist %v; want the cached one", changed, again != first)
+	}
+	select {
+	case <-updates:
+		t.Error("subscriber notified of an unchanged playlist")
+	default:
+	}
+
+	mu.Lock()
+	content, etag = pollerPlaylist+"#EXTINF:2.0,\nseg11.ts\n", `"v2"`
+	mu.Unlock()
+	updated, changed, err := p.Poll(context.Background())
+	if err != nil {
+		t.Fatalf("Poll returned error: %v", err)
+	}
+	if !changed || len(updated.Segments) != 2 || p.GetLatest() != updated {
+		t.Errorf("updated Poll = %d segments, changed %v; want 2 segments, changed and latest", len(updated.Segments), changed)
+	}
+	if full != 2 || notModified != 1 {
+		t.Errorf("server saw %d full and %d conditional responses, want 2 and 1", full, notModified)
+	}
+}
+
+func TestPlaylistPollerSkipsIdenticalContent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// No validators: the poller falls back to comparing bodies
+		w.Write([]byte(pollerPlaylist))
+	}))
+	t.Cleanup(server.Close)
+
+	p := NewPlaylistPoller(NewFetcher(), server.URL+"/live.m3u8", ParseOptions{})
+	first, _, err := p.Poll(context.Background())
+	if err != nil {
+		t.Fatalf("Poll returned error: %v", err)
+	}
+	again, changed, err := p.Poll(context.Background())
+	if err != nil {
+		t.Fatalf("Poll returned error: %v", err)
+	}
+	if changed || again != first {
+		t.Errorf("Poll of identical content reported changed %v, want the cached playlist", changed)
+	}
+}
+
+func TestPlaylistPollerRun(t *testing.T) {
+	var mu sync.Mutex
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		polls++
+		w.Header().Set("Last-Modified", time.Unix(int64(polls), 0).UTC().Format(http.TimeFormat))
+		body := pollerPlaylist
+		if polls == 3 {
+			body += "#EXT-X-ENDLIST\n"
+		} else if polls == 2 {
+			body += "#EXTINF:2.0,\nseg11.ts\n"
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	p := NewPlaylistPoller(NewFetcher(), server.URL+"/live.m3u8", ParseOptions{})
+	updates := p.Subscribe()
+	done := make(chan error, 1)
+	go func() { done <- p.Run(context.Background(), time.Millisecond) }()
+
+	var last *Playlist
+	for playlist := range updates {
+		last = playlist
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if last == nil || !last.EndList {
+		t.Errorf("last published playlist %+v, want the ended one", last)
+	}
+}
@@ -0,0 +1,96 @@
+package hls
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PredictSegment guesses the segment that a live playlist will list with
+// the given sequence, from the naming of its last two segments. URLs are
+// predictable when they differ only in one number that grows with the
+// sequence, as in seg_1041.ts and seg_1042.ts; query strings and the
+// rest of the URL must match. The prediction takes its duration, EXT-X-MAP
+// and discontinuity sequence from the last segment and extrapolates its
+// ProgramDateTime. It returns nil when the URLs don't follow such a
+// pattern, and for encrypted and byte-range segments, whose keys and
+// offsets can't be guessed.
+func PredictSegment(segments []*Segment, sequence int) *Segment {
+	if len(segments) < 2 {
+		return nil
+	}
+	prev, last := segments[len(segments)-2], segments[len(segments)-1]
+	if last.Key != nil || last.Length > 0 || last.Sequence <= prev.Sequence || sequence <= last.Sequence {
+		return nil
+	}
+
+	prevParts, lastParts := splitDigits(prev.URL), splitDigits(last.URL)
+	if len(prevParts) != len(lastParts) {
+		return nil
+	}
+	// Exactly one part may differ, by the distance of the sequences
+	changed := -1
+	for i := range lastParts {
+		if prevParts[i] == lastParts[i] {
+			continue
+		}
+		if changed >= 0 || !isDigits(lastParts[i]) || !isDigits(prevParts[i]) {
+			return nil
+		}
+		changed = i
+	}
+	if changed < 0 {
+		return nil
+	}
+	prevNumber, err1 := strconv.Atoi(prevParts[changed])
+	lastNumber, err2 := strconv.Atoi(lastParts[changed])
+	if err1 != nil || err2 != nil || lastNumber-prevNumber != last.Sequence-prev.Sequence {
+		return nil
+	}
+
+	// Keep zero padding, as in chunk-00042.m4s
+	number := lastNumber + sequence - last.Sequence
+	digits := lastParts[changed]
+	parts := append([]string(nil), lastParts...)
+	if len(digits) > 1 && digits[0] == '0' {
+		parts[changed] = fmt.Sprintf("%0*d", len(digits), number)
+	} else {
+		parts[changed] = strconv.Itoa(number)
+	}
+
+	predicted := &Segment{
+		URL:                   strings.Join(parts, ""),
+		Sequence:              sequence,
+		Duration:              last.Duration,
+		DiscontinuitySequence: last.DiscontinuitySequence,
+		Map:                   last.Map,
+		Ext:                   last.Ext,
+	}
+	if !last.ProgramDateTime.IsZero() {
+		gap := time.Duration(float64(sequence-last.Sequence-1) * last.Duration * float64(time.Second))
+		predicted.ProgramDateTime = last.EndDateTime().Add(gap)
+	}
+	return predicted
+}
+
+// splitDigits splits s into alternating runs of digits and non-digits.
+func splitDigits(s string) []string {
+	var parts []string
+	start := 0
+	for i := 1; i <= len(s); i++ {
+		if i == len(s) || isDigit(s[i]) != isDigit(s[start]) {
+			parts = append(parts, s[start:i])
+			start = i
+		}
+	}
+	return parts
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isDigits(s string) bool {
+	return s != "" && isDigit(s[0])
+}
@@ -0,0 +1,67 @@
+package hls
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPredictSegment(t *testing.T) {
+	segment := func(url string, sequence int) *Segment {
+		return &Segment{URL: url, Sequence: sequence, Duration: 2, Ext: ".ts"}
+	}
+	tests := []struct {
+		name     string
+		segments []*Segment
+		sequence int
+		want     string // empty when unpredictable
+	}{
+		{"plain", []*Segment{segment("https://cdn.example.com/live/seg_1041.ts", 1041), segment("https://cdn.example.com/live/seg_1042.ts", 1042)}, 1043, "https://cdn.example.com/live/seg_1043.ts"},
+		{"offset numbering", []*Segment{segment("https://cdn.example.com/720p/7.ts", 101), segment("https://cdn.example.com/720p/8.ts", 102)}, 104, "https://cdn.example.com/720p/10.ts"},
+		{"zero padded", []*Segment{segment("https://cdn.example.com/chunk-00098.m4s", 98), segment("https://cdn.example.com/chunk-00099.m4s", 99)}, 100, "https://cdn.example.com/chunk-00100.m4s"},
+		{"same query", []*Segment{segment("https://cdn.example.com/s5.ts?v=2", 5), segment("https://cdn.example.com/s6.ts?v=2", 6)}, 7, "https://cdn.example.com/s7.ts?v=2"},
+		{"signed query", []*Segment{segment("https://cdn.example.com/s5.ts?sig=ab12", 5), segment("https://cdn.example.com/s6.ts?sig=9f3c", 6)}, 7, ""},
+		{"hashed names", []*Segment{segment("https://cdn.example.com/a8f3.ts", 5), segment("https://cdn.example.com/c1d9.ts", 6)}, 7, ""},
+		{"wrong step", []*Segment{segment("https://cdn.example.com/s10.ts", 5), segment("https://cdn.example.com/s20.ts", 6)}, 7, ""},
+		{"single segment", []*Segment{segment("https://cdn.example.com/s6.ts", 6)}, 7, ""},
+		{"already listed", []*Segment{segment("https://cdn.example.com/s5.ts", 5), segment("https://cdn.example.com/s6.ts", 6)}, 6, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := PredictSegment(tt.segments, tt.sequence)
+			switch {
+			case got == nil && tt.want != "":
+				t.Fatalf("PredictSegment = nil, want %s", tt.want)
+			case got != nil && tt.want == "":
+				t.Fatalf("PredictSegment = %s, want nil", got.URL)
+			case got != nil && (got.URL != tt.want || got.Sequence != tt.sequence):
+				t.Fatalf("PredictSegment = %s (%d), want %s (%d)", got.URL, got.Sequence, tt.want, tt.sequence)
+			}
+		})
+	}
+}
+
+func TestPredictSegmentSkipsEncrypted(t *testing.T) {
+	key := &Key{Method: "AES-128", URI: "https://cdn.example.com/key"}
+	segments := []*Segment{
+		{URL: "https://cdn.example.com/s5.ts", Sequence: 5, Key: key},
+		{URL: "https://cdn.example.com/s6.ts", Sequence: 6, Key: key},
+	}
+	if got := PredictSegment(segments, 7); got != nil {
+		t.Errorf("PredictSegment of an encrypted segment = %s, want nil", got.URL)
+	}
+}
+
+func TestPredictSegmentProgramDateTime(t *testing.T) {
+	start := time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC)
+	segments := []*Segment{
+		{URL: "https://cdn.example.com/s5.ts", Sequence: 5, Duration: 4, ProgramDateTime: start},
+		{URL: "https://cdn.example.com/s6.ts", Sequence: 6, Duration: 4, ProgramDateTime: start.Add(4 * time.Second)},
+	}
+	got := PredictSegment(segments, 8)
+	if got == nil {
+		t.Fatal("PredictSegment = nil")
+	}
+	if want := start.Add(12 * time.Second); !got.ProgramDateTime.Equal(want) {
+		t.Errorf("ProgramDateTime = %v, want %v", got.ProgramDateTime, want)
+	}
+}
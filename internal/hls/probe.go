@@ -0,0 +1,143 @@
+package hls
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ProbeInfo describes a remote resource discovered without downloading it.
+type ProbeInfo struct {
+	StatusCode int
+	// ContentLength is the full size of the resource, or -1 if unknown.
+	ContentLength int64
+	ContentType   string
+	AcceptRanges  bool
+	// Method is the request method that produced the information: HEAD,
+	// or GET when the server doesn't support HEAD.
+	Method string
+}
+
+// Probe discovers the size, content type and range support of a resource.
+// It sends a HEAD request and, when the server rejects HEAD (405 or 501),
+// falls back to a GET for the first byte only (Range: bytes=0-0), so the
+// object is never downloaded in full. Non-2xx responses return the probe
+// information together with a *StatusError.
+func (f *Fetcher) Probe(rawURL string) (*ProbeInfo, error) {
+	return f.ProbeContext(context.Background(), rawURL)
+}
+
+// ProbeContext is like Probe, but aborts the requests when ctx is
+// canceled.
+func (f *Fetcher) ProbeContext(ctx context.Context, rawURL string) (*ProbeInfo, error) {
+	info, err := f.probe(ctx, http.MethodHead, rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if headUnsupported(info.StatusCode) {
+		info, err = f.probe(ctx, http.MethodGet, rawURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if info.StatusCode < 200 || info.StatusCode > 299 {
+		return info, &StatusError{StatusCode: info.StatusCode}
+	}
+	return info, nil
+}
+
+// probe performs a single probing request with the given method.
+func (f *Fetcher) probe(ctx context.Context, method, rawURL string) (*ProbeInfo, error) {
+	req, err := f.newRequest(ctx, method, rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create probe request: %w", err)
+	}
+	if method == http.MethodGet {
+		req.Header.Set("Range", "bytes=0-0")
+	}
+
+	release, err := f.HostLimiter.acquire(ctx, rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe %s: %w", rawURL, err)
+	}
+	defer release()
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe %s: %w", rawURL, err)
+	}
+	// Close without draining: for a GET that ignored the range we don't
+	// want the rest of the body.
+	resp.Body.Close()
+
+	info := &ProbeInfo{
+		StatusCode:    resp.StatusCode,
+		ContentLength: resp.ContentLength,
+		ContentType:   resp.Header.Get("Content-Type"),
+		AcceptRanges:  strings.EqualFold(resp.Header.Get("Accept-Ranges"), "bytes"),
+		Method:        method,
+	}
+
+	if resp.StatusCode == http.StatusPartialContent {
+		// A ranged GET reports the full size in Content-Range
+		info.AcceptRanges = true
+		info.ContentLength = contentRangeTotal(resp.Header.Get("Content-Range"))
+	}
+
+	return info, nil
+}
+
+// contentRangeTotal parses the complete length of a Content-Range header
+// ("bytes 0-0/1234"). Returns -1 if it is missing or unknown ("*").
+func contentRangeTotal(header string) int64 {
+	_, total, ok := strings.Cut(header, "/")
+	if !ok {
+		return -1
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(total), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// ErrHeadUnsupported is returned by SegmentExists when the server rejects
+// HEAD requests (405 Method Not Allowed or 501 Not Implemented).
+var ErrHeadUnsupported = errors.New("server does not support HEAD requests")
+
+// SegmentExists reports whether the segment at url is published, with a
+// HEAD request: true for 200 or 206, false for 404. Other statuses return
+// a *StatusError and rejected HEAD requests ErrHeadUnsupported.
+func (f *Fetcher) SegmentExists(url string) (bool, error) {
+	return f.SegmentExistsContext(context.Background(), url)
+}
+
+// SegmentExistsContext is like SegmentExists, but aborts the request when
+// ctx is canceled.
+func (f *Fetcher) SegmentExistsContext(ctx context.Context, url string) (bool, error) {
+	info, err := f.probe(ctx, http.MethodHead, url)
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case info.StatusCode == http.StatusOK || info.StatusCode == http.StatusPartialContent:
+		return true, nil
+	case info.StatusCode == http.StatusNotFound:
+		return false, nil
+	case headUnsupported(info.StatusCode):
+		return false, fmt.Errorf("probing %s: %w", url, ErrHeadUnsupported)
+	}
+	return false, f.statusError(url, info.StatusCode)
+}
+
+// headUnsupported reports whether a HEAD request's status code means the
+// server rejects HEAD requests.
+func headUnsupported(code int) bool {
+	return code == http.StatusMethodNotAllowed || code == http.StatusNotImplemented
+}
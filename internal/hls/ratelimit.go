@@ -0,0 +1,155 @@
+package hls
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter bounds the aggregate speed of segment downloads with a token
+// bucket. A single limiter can be shared by several Fetchers so that the
+// sum of all concurrent downloads stays under the rate, not each of them.
+type RateLimiter struct {
+	rate  float64 // bytes per second
+	burst float64 // bucket size in bytes
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a limiter allowing bytesPerSecond bytes per second
+// across everything sharing it. A value <= 0 means unlimited.
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	rate := float64(bytesPerSecond)
+	// Allow a tenth of a second of data at once so reads stay reasonably
+	// large without letting the rate spike
+	burst := max(rate/10, 1024)
+	return &RateLimiter{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// chunk returns how many bytes a single read may take at once.
+func (l *RateLimiter) chunk() int {
+	return int(l.burst)
+}
+
+// wait blocks until n bytes may be transferred or ctx is done.
+func (l *RateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.burst)
+	l.last = now
+	// Reserve the bytes right away, going into debt if needed, so waiting
+	// readers are served in turn
+	l.tokens -= float64(n)
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttle wraps body so reading it is limited by l. It returns body as is
+// for an unlimited limiter.
+func (l *RateLimiter) throttle(ctx context.Context, body io.ReadCloser) io.ReadCloser {
+	if l == nil || l.rate <= 0 {
+		return body
+	}
+	return &throttledBody{ReadCloser: body, ctx: ctx, limiter: l}
+}
+
+// throttledBody paces reads of a response body through a RateLimiter.
+type throttledBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *RateLimiter
+}
+
+func (b *throttledBody) Read(p []byte) (int, error) {
+	if len(p) > b.limiter.chunk() {
+		p = p[:b.limiter.chunk()]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if waitErr := b.limiter.wait(b.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// rateUnits maps the units ParseRate and ParseSize accept to their size
+// in bytes.
+var rateUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"k":   1 << 10,
+	"kb":  1 << 10,
+	"kib": 1 << 10,
+	"m":   1 << 20,
+	"mb":  1 << 20,
+	"mib": 1 << 20,
+	"g":   1 << 30,
+	"gb":  1 << 30,
+	"gib": 1 << 30,
+	"t":   1 << 40,
+	"tb":  1 << 40,
+	"tib": 1 << 40,
+}
+
+// ParseRate parses a transfer rate such as "2MB/s", "500KB/s" or "750k"
+// into bytes per second. Units are binary (1KB = 1024 bytes) and the "/s"
+// suffix is optional. "0" means unlimited.
+func ParseRate(s string) (int64, error) {
+	n, ok := parseBytes(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), "/s"))
+	if !ok {
+		return 0, fmt.Errorf("invalid rate %q: use a number of bytes per second with an optional KB, MB or GB unit, e.g. 2MB/s", s)
+	}
+	return n, nil
+}
+
+// ParseSize parses a size such as "2GB", "500MB" or "750k" into bytes,
+// with the units of ParseRate.
+func ParseSize(s string) (int64, error) {
+	n, ok := parseBytes(strings.ToLower(strings.TrimSpace(s)))
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: use a number of bytes with an optional KB, MB, GB or TB unit, e.g. 2GB", s)
+	}
+	return n, nil
+}
+
+// parseBytes parses a lowercase number of bytes with an optional unit.
+func parseBytes(value string) (int64, bool) {
+	i := strings.IndexFunc(value, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(value)
+	}
+	number, unit := value[:i], strings.TrimSpace(value[i:])
+
+	n, err := strconv.ParseFloat(number, 64)
+	scale, ok := rateUnits[unit]
+	if err != nil || !ok || n < 0 {
+		return 0, false
+	}
+	return int64(n * scale), true
+}
@@ -0,0 +1,102 @@
+package hls
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"2MB/s", 2 << 20},
+		{"500KB/s", 500 << 10},
+		{"1.5m", 3 << 19},
+		{"750k", 750 << 10},
+		{"4096", 4096},
+		{"1 GiB/s", 1 << 30},
+		{"0", 0},
+	}
+	for _, tt := range tests {
+		got, err := ParseRate(tt.in)
+		if err != nil {
+			t.Errorf("ParseRate(%q) returned error: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRate(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "fast", "2XB/s", "-1MB", "MB/s"} {
+		if _, err := ParseRate(in); err == nil {
+			t.Errorf("ParseRate(%q) returned no error", in)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"2GB", 2 << 30},
+		{"500MB", 500 << 20},
+		{"1.5 TiB", 3 << 39},
+		{"750k", 750 << 10},
+		{"4096", 4096},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if err != nil {
+			t.Errorf("ParseSize(%q) returned error: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "big", "2XB", "-1MB", "GB", "2MB/s"} {
+		if _, err := ParseSize(in); err == nil {
+			t.Errorf("ParseSize(%q) returned no error", in)
+		}
+	}
+}
+
+func TestRateLimiterBoundsAggregateRate(t *testing.T) {
+	payload := bytes.Repeat([]byte("x"), 64<<10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp2t")
+		w.Write(payload)
+	}))
+	t.Cleanup(server.Close)
+
+	// Both fetchers share the limiter, so the 128 KiB they download
+	// together take at least (128 KiB - burst) / 256 KiB/s ~ 0.4s
+	limiter := NewRateLimiter(256 << 10)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f := NewFetcher()
+			f.RateLimiter = limiter
+			if _, err := f.FetchSegment(server.URL+"/seg.ts", io.Discard); err != nil {
+				t.Errorf("FetchSegment returned error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("downloads took %v, want at least 300ms at 256 KiB/s", elapsed)
+	}
+}
@@ -0,0 +1,56 @@
+package hls
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/bariiss/stream-capture/internal/retry"
+)
+
+// RetryPolicy controls how a Fetcher retries requests that fail with a
+// retryable error (see IsRetryable). Delays grow exponentially from
+// BaseDelay by Multiplier, capped at MaxDelay, with ±20% jitter.
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt; 0
+	// disables retrying.
+	MaxRetries int
+	// BaseDelay is the wait before the first retry.
+	BaseDelay time.Duration
+	// MaxDelay caps the wait between retries (0 means no cap).
+	MaxDelay time.Duration
+	// Multiplier grows the delay after every retry.
+	Multiplier float64
+}
+
+// DefaultRetryPolicy is the policy of a Fetcher returned by NewFetcher.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 2,
+	BaseDelay:  500 * time.Millisecond,
+	MaxDelay:   5 * time.Second,
+	Multiplier: 2,
+}
+
+// ErrTransferInterrupted is matched by errors reporting that a segment body
+// broke off after the response was received. The request itself isn't
+// retried by the Fetcher, as the caller may be able to resume the download.
+var ErrTransferInterrupted = errors.New("transfer interrupted")
+
+// withRetry calls fn according to f.Retry, reporting each retry to
+// f.OnRetry.
+func (f *Fetcher) withRetry(ctx context.Context, url string, fn func() error) error {
+	policy := retry.Policy{
+		MaxAttempts: f.Retry.MaxRetries + 1,
+		BaseDelay:   f.Retry.BaseDelay,
+		MaxDelay:    f.Retry.MaxDelay,
+		Multiplier:  f.Retry.Multiplier,
+		Jitter:      0.2,
+		Retryable:   IsRetryable,
+	}
+	if f.OnRetry != nil {
+		policy.OnRetry = func(attempt int, err error, delay time.Duration) {
+			f.OnRetry(url, attempt, err, delay)
+		}
+	}
+	return retry.Do(ctx, policy, fn)
+}
@@ -0,0 +1,93 @@
+package hls
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyServer fails the first failures requests with status, then serves
+// body. It returns the server and its request counter.
+func flakyServer(t *testing.T, failures int32, status int, body string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		w.Header().Set("Content-Type", "video/mp2t")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server, &hits
+}
+
+// quickFetcher returns a Fetcher retrying without noticeable delays.
+func quickFetcher(maxRetries int) *Fetcher {
+	f := NewFetcher()
+	f.Retry = RetryPolicy{MaxRetries: maxRetries, BaseDelay: time.Millisecond, Multiplier: 2}
+	return f
+}
+
+func TestFetchPlaylistRetriesTransientStatus(t *testing.T) {
+	server, hits := flakyServer(t, 2, http.StatusServiceUnavailable, "#EXTM3U\n")
+
+	var retries int
+	f := quickFetcher(2)
+	f.OnRetry = func(url string, attempt int, err error, delay time.Duration) { retries++ }
+
+	content, err := f.FetchPlaylist(server.URL)
+	if err != nil {
+		t.Fatalf("FetchPlaylist returned error: %v", err)
+	}
+	if content != "#EXTM3U\n" {
+		t.Errorf("content = %q", content)
+	}
+	if n := hits.Load(); n != 3 {
+		t.Errorf("got %d requests, want 3", n)
+	}
+	if retries != 2 {
+		t.Errorf("OnRetry called %d times, want 2", retries)
+	}
+}
+
+func TestFetchSegmentRetriesUpToMax(t *testing.T) {
+	server, hits := flakyServer(t, 10, http.StatusBadGateway, "segment")
+
+	_, err := quickFetcher(1).FetchSegment(server.URL, &bytes.Buffer{})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadGateway {
+		t.Fatalf("FetchSegment error = %v, want status 502", err)
+	}
+	if n := hits.Load(); n != 2 {
+		t.Errorf("got %d requests, want 2", n)
+	}
+}
+
+func TestFetchSegmentFailsFastOnNotFound(t *testing.T) {
+	server, hits := flakyServer(t, 10, http.StatusNotFound, "segment")
+
+	if _, err := quickFetcher(3).FetchSegment(server.URL, &bytes.Buffer{}); err == nil {
+		t.Fatal("FetchSegment succeeded, want an error")
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("got %d requests, want 1", n)
+	}
+}
+
+func TestFetchSegmentRetriesDisabled(t *testing.T) {
+	server, hits := flakyServer(t, 1, http.StatusTooManyRequests, "segment")
+
+	if _, err := quickFetcher(0).FetchSegment(server.URL, &bytes.Buffer{}); err == nil {
+		t.Fatal("FetchSegment succeeded, want an error")
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("got %d requests, want 1", n)
+	}
+}
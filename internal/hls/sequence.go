@@ -0,0 +1,89 @@
+package hls
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// SequenceStrategy selects where ParsePlaylistWithOptions takes segment
+// sequence numbers from.
+type SequenceStrategy string
+
+const (
+	// SequenceMediaSequence numbers segments from EXT-X-MEDIA-SEQUENCE, as
+	// the HLS spec prescribes. It is the default.
+	SequenceMediaSequence SequenceStrategy = "media-sequence"
+	// SequenceRegex takes the number of URLs like
+	// master_1440_primary_719721.ts ("_<digits>.ts"), falling back to the
+	// media sequence.
+	SequenceRegex SequenceStrategy = "regex"
+	// SequenceFilename takes the number ending the file name, as in
+	// chunk-00042.m4s or 42.ts, falling back to the media sequence.
+	SequenceFilename SequenceStrategy = "filename"
+)
+
+// SequenceStrategies lists the valid strategies.
+var SequenceStrategies = []SequenceStrategy{SequenceMediaSequence, SequenceRegex, SequenceFilename}
+
+// ParseSequenceStrategy validates a strategy name. An empty name selects
+// SequenceMediaSequence.
+func ParseSequenceStrategy(name string) (SequenceStrategy, error) {
+	if name == "" {
+		return SequenceMediaSequence, nil
+	}
+	for _, strategy := range SequenceStrategies {
+		if name == string(strategy) {
+			return strategy, nil
+		}
+	}
+	return "", fmt.Errorf("unknown sequence strategy %q: use %s, %s or %s", name, SequenceMediaSequence, SequenceRegex, SequenceFilename)
+}
+
+// sequence returns the sequence number of the segment at the playlist URI
+// line whose media sequence number is mediaSequence.
+func (s SequenceStrategy) sequence(line string, mediaSequence int) int {
+	switch s {
+	case SequenceRegex:
+		return extractSequenceFromURL(line, mediaSequence)
+	case SequenceFilename:
+		return extractSequenceFromFilename(line, mediaSequence)
+	default:
+		return mediaSequence
+	}
+}
+
+// extractSequenceFromFilename returns the number ending the file name of
+// uri, ignoring its extension and query string, or defaultSeq when there
+// is none. The number must stand alone, after a separator or as the whole
+// name, so that hashed names like 3f9c2e1b7.ts are not misread.
+func extractSequenceFromFilename(uri string, defaultSeq int) int {
+	if u, err := url.Parse(uri); err == nil {
+		uri = u.Path
+	}
+	name := path.Base(uri)
+	name = strings.TrimSuffix(name, path.Ext(name))
+
+	digits := len(name)
+	for digits > 0 && name[digits-1] >= '0' && name[digits-1] <= '9' {
+		digits--
+	}
+	if digits == len(name) {
+		return defaultSeq
+	}
+	if digits > 0 && isAlphanumeric(name[digits-1]) {
+		return defaultSeq
+	}
+	seq, err := strconv.Atoi(name[digits:])
+	if err != nil {
+		return defaultSeq
+	}
+	return seq
+}
+
+// isAlphanumeric reports whether c is an ASCII letter or digit.
+func isAlphanumeric(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,99 @@
+package hls
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSequenceStrategies(t *testing.T) {
+	playlists := []struct {
+		name string
+		uris []string
+		// want maps each strategy to the expected sequences
+		want map[SequenceStrategy][]int
+	}{
+		{
+			name: "legacy names",
+			uris: []string{"master_1440_primary_719721.ts", "master_1440_primary_719722.ts"},
+			want: map[SequenceStrategy][]int{
+				SequenceMediaSequence: {40, 41},
+				SequenceRegex:         {719721, 719722},
+				SequenceFilename:      {719721, 719722},
+			},
+		},
+		{
+			name: "m4s",
+			uris: []string{"chunk-00042.m4s", "chunk-00043.m4s"},
+			want: map[SequenceStrategy][]int{
+				SequenceMediaSequence: {40, 41},
+				SequenceRegex:         {40, 41},
+				SequenceFilename:      {42, 43},
+			},
+		},
+		{
+			name: "numeric names",
+			uris: []string{"/live/720p/1001.ts", "/live/720p/1002.ts"},
+			want: map[SequenceStrategy][]int{
+				SequenceMediaSequence: {40, 41},
+				SequenceRegex:         {40, 41},
+				SequenceFilename:      {1001, 1002},
+			},
+		},
+		{
+			// The sequence in the query string isn't part of the file name,
+			// and "_7.ts" in the query misleads the regex
+			name: "query parameter",
+			uris: []string{"segment.ts?seq=7&name=a_7.ts", "segment.ts?seq=8&name=a_8.ts"},
+			want: map[SequenceStrategy][]int{
+				SequenceMediaSequence: {40, 41},
+				SequenceRegex:         {7, 8},
+				SequenceFilename:      {40, 41},
+			},
+		},
+		{
+			name: "hashed names",
+			uris: []string{"3f9c2e1b7.ts", "a81d0c4e2.ts"},
+			want: map[SequenceStrategy][]int{
+				SequenceMediaSequence: {40, 41},
+				SequenceRegex:         {40, 41},
+				SequenceFilename:      {40, 41},
+			},
+		},
+	}
+
+	for _, pl := range playlists {
+		lines := []string{"#EXTM3U", "#EXT-X-MEDIA-SEQUENCE:40"}
+		for _, uri := range pl.uris {
+			lines = append(lines, "#EXTINF:4,", uri)
+		}
+		content := strings.Join(lines, "\n") + "\n"
+
+		for strategy, want := range pl.want {
+			t.Run(pl.name+"/"+string(strategy), func(t *testing.T) {
+				segments, err := ParsePlaylistWithOptions(content, testMediaPlaylistURL, ParseOptions{SequenceStrategy: strategy})
+				if err != nil {
+					t.Fatalf("ParsePlaylistWithOptions returned error: %v", err)
+				}
+				var got []int
+				for _, seg := range segments {
+					got = append(got, seg.Sequence)
+				}
+				if !slices.Equal(got, want) {
+					t.Errorf("sequences = %v, want %v", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestParseSequenceStrategy(t *testing.T) {
+	for _, name := range []string{"", "media-sequence", "regex", "filename"} {
+		if _, err := ParseSequenceStrategy(name); err != nil {
+			t.Errorf("ParseSequenceStrategy(%q) returned error: %v", name, err)
+		}
+	}
+	if _, err := ParseSequenceStrategy("url"); err == nil {
+		t.Error("ParseSequenceStrategy accepted an unknown strategy")
+	}
+}
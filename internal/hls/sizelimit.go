@@ -0,0 +1,62 @@
+package hls
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxPlaylistSize is the Fetcher's default bound on a playlist body,
+// far above the few hundred kilobytes of even long VOD playlists.
+const DefaultMaxPlaylistSize = 8 << 20
+
+// ErrTooLarge is matched by errors reporting a playlist or segment body
+// larger than the Fetcher allows. Such fetches are not retried.
+var ErrTooLarge = errors.New("response too large")
+
+// SizeLimitError reports a response body exceeding a Fetcher size limit.
+type SizeLimitError struct {
+	// Kind is what was fetched: "playlist" or "segment".
+	Kind  string
+	URL   string
+	Limit int64
+}
+
+func (e *SizeLimitError) Error() string {
+	return fmt.Sprintf("%s %s exceeds the size limit of %d bytes", e.Kind, e.URL, e.Limit)
+}
+
+// Is makes errors.Is(err, ErrTooLarge) match a *SizeLimitError.
+func (e *SizeLimitError) Is(target error) bool {
+	return target == ErrTooLarge
+}
+
+// limitedBody fails a body once more than limit bytes were read from it,
+// returning the bytes up to the limit first.
+type limitedBody struct {
+	io.ReadCloser
+	limit int64
+	read  int64
+	err   *SizeLimitError
+}
+
+// limitBody wraps body in a limitedBody reporting err, unless limit is not
+// positive.
+func limitBody(body io.ReadCloser, limit int64, err *SizeLimitError) io.ReadCloser {
+	if limit <= 0 {
+		return body
+	}
+	return &limitedBody{ReadCloser: body, limit: limit, err: err}
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.read > b.limit {
+		return 0, b.err
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if b.read > b.limit {
+		return n - int(b.read-b.limit), b.err
+	}
+	return n, err
+}
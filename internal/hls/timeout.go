@@ -0,0 +1,95 @@
+package hls
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Default timeouts of a Fetcher returned by NewFetcher.
+const (
+	// DefaultConnectTimeout bounds connecting, the TLS handshake and the
+	// wait for the response headers, each on its own.
+	DefaultConnectTimeout = 10 * time.Second
+	// DefaultSegmentTimeout is how long a segment body may go without
+	// receiving data.
+	DefaultSegmentTimeout = 30 * time.Second
+	// requestTimeout bounds playlist, key and probe requests as a whole.
+	// Segment requests are bounded by the connect and segment timeouts
+	// instead, so a large segment can take as long as it keeps progressing.
+	requestTimeout = 30 * time.Second
+)
+
+// ErrSegmentTimeout is matched by errors reporting that a segment body
+// received no data for the Fetcher's SegmentTimeout. It is reported as an
+// ErrTransferInterrupted, so the download can be resumed.
+var ErrSegmentTimeout = errors.New("segment transfer stalled")
+
+// SetConnectTimeout bounds how long every request of the Fetcher may take
+// to connect, to complete the TLS handshake and, once sent, to receive the
+// response headers; each step gets the whole timeout. Zero removes the
+// bounds.
+func (f *Fetcher) SetConnectTimeout(timeout time.Duration) {
+	transport := f.transport()
+	transport.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = timeout
+	transport.ResponseHeaderTimeout = timeout
+}
+
+// doSegment sends a segment request. The client's overall timeout doesn't
+// apply; with SegmentTimeout set, reading the body fails with
+// ErrSegmentTimeout once a read waits that long for data.
+func (f *Fetcher) doSegment(req *http.Request) (*http.Response, error) {
+	client := *f.client
+	client.Timeout = 0
+	if f.SegmentTimeout <= 0 {
+		return client.Do(req)
+	}
+
+	ctx, cancel := context.WithCancelCause(req.Context())
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel(nil)
+		return nil, err
+	}
+	resp.Body = newStallBody(ctx, cancel, resp.Body, f.SegmentTimeout)
+	return resp, nil
+}
+
+// stallBody aborts the request of a body whose reads wait longer than
+// timeout for data. Time between reads, e.g. spent throttled by a
+// RateLimiter, doesn't count.
+type stallBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func newStallBody(ctx context.Context, cancel context.CancelCauseFunc, body io.ReadCloser, timeout time.Duration) *stallBody {
+	timer := time.AfterFunc(timeout, func() { cancel(ErrSegmentTimeout) })
+	timer.Stop()
+	return &stallBody{ReadCloser: body, ctx: ctx, cancel: cancel, timer: timer, timeout: timeout}
+}
+
+func (b *stallBody) Read(p []byte) (int, error) {
+	b.timer.Reset(b.timeout)
+	n, err := b.ReadCloser.Read(p)
+	b.timer.Stop()
+	if err != nil && errors.Is(context.Cause(b.ctx), ErrSegmentTimeout) {
+		err = fmt.Errorf("%w: no data for %v", ErrSegmentTimeout, b.timeout)
+	}
+	return n, err
+}
+
+func (b *stallBody) Close() error {
+	b.timer.Stop()
+	err := b.ReadCloser.Close()
+	b.cancel(nil)
+	return err
+}
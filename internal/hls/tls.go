@@ -0,0 +1,52 @@
+package hls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// NewTLSConfig returns the TLS settings for servers with self-signed or
+// privately issued certificates. insecure disables certificate
+// verification altogether; caFile, if set, is a PEM bundle of CA
+// certificates trusted in addition to the system roots.
+func NewTLSConfig(insecure bool, caFile string) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: insecure}
+	if caFile == "" {
+		return config, nil
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificates: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		// Not every platform exposes its roots; trust the bundle alone
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", caFile)
+	}
+	config.RootCAs = pool
+	return config, nil
+}
+
+// SetTLSConfig makes every request of the Fetcher, playlists, segments and
+// keys alike, use config for HTTPS connections.
+func (f *Fetcher) SetTLSConfig(config *tls.Config) {
+	f.transport().TLSClientConfig = config
+}
+
+// transport returns the Fetcher's own transport, replacing the shared
+// default one on first use so settings don't leak to other clients.
+func (f *Fetcher) transport() *http.Transport {
+	if transport, ok := f.client.Transport.(*http.Transport); ok {
+		return transport
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	f.client.Transport = transport
+	return transport
+}
@@ -0,0 +1,78 @@
+package hls
+
+import (
+	"encoding/pem"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTLSPlaylistServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("#EXTM3U\n"))
+	}))
+	// Rejected handshakes are expected
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFetcherRejectsUnknownCA(t *testing.T) {
+	server := newTLSPlaylistServer(t)
+
+	f := NewFetcher()
+	f.Retry.MaxRetries = 0
+	if _, err := f.FetchPlaylist(server.URL + "/index.m3u8"); err == nil {
+		t.Fatal("FetchPlaylist succeeded against a self-signed certificate")
+	}
+}
+
+func TestFetcherInsecureSkipVerify(t *testing.T) {
+	server := newTLSPlaylistServer(t)
+
+	config, err := NewTLSConfig(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := NewFetcher()
+	f.SetTLSConfig(config)
+	if _, err := f.FetchPlaylist(server.URL + "/index.m3u8"); err != nil {
+		t.Fatalf("FetchPlaylist returned error: %v", err)
+	}
+}
+
+func TestFetcherCACertFile(t *testing.T) {
+	server := newTLSPlaylistServer(t)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := NewTLSConfig(false, caFile)
+	if err != nil {
+		t.Fatalf("NewTLSConfig returned error: %v", err)
+	}
+	f := NewFetcher()
+	f.SetTLSConfig(config)
+	if _, err := f.FetchPlaylist(server.URL + "/index.m3u8"); err != nil {
+		t.Fatalf("FetchPlaylist returned error: %v", err)
+	}
+}
+
+func TestNewTLSConfigRejectsFileWithoutCertificates(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewTLSConfig(false, caFile); err == nil {
+		t.Error("NewTLSConfig accepted a file without certificates")
+	}
+}
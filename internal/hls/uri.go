@@ -0,0 +1,25 @@
+package hls
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// resolveURI resolves a URI found in a playlist, either a segment line or a
+// URI attribute of a tag (EXT-X-MAP, EXT-X-KEY, EXT-X-MEDIA,
+// EXT-X-I-FRAME-STREAM-INF, ...), against the playlist URL. Every tag parser
+// must go through it so relative, absolute and protocol-relative ("//host/x")
+// references resolve the same way everywhere.
+func resolveURI(base *url.URL, ref string) (string, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return "", fmt.Errorf("empty URI")
+	}
+
+	resolved, err := base.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return resolved.String(), nil
+}
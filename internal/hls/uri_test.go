@@ -0,0 +1,170 @@
+package hls
+
+import (
+	"net/url"
+	"testing"
+)
+
+const testPlaylistURL = "https://origin.example.com/live/stream/index.m3u8?token=abc"
+
+// uriForms are the reference forms every URI-carrying tag must resolve the
+// same way, with their expected result against testPlaylistURL.
+var uriForms = []struct {
+	name string
+	ref  string
+	want string
+}{
+	{"relative", "chunk_1.ts", "https://origin.example.com/live/stream/chunk_1.ts"},
+	{"relative parent", "../other/chunk_1.ts", "https://origin.example.com/live/other/chunk_1.ts"},
+	{"root relative", "/media/chunk_1.ts", "https://origin.example.com/media/chunk_1.ts"},
+	{"absolute", "http://cdn.example.net/a/chunk_1.ts", "http://cdn.example.net/a/chunk_1.ts"},
+	{"protocol relative", "//cdn.example.net/a/chunk_1.ts", "https://cdn.example.net/a/chunk_1.ts"},
+	{"query", "chunk_1.ts?sig=xyz", "https://origin.example.com/live/stream/chunk_1.ts?sig=xyz"},
+	// The forms EXT-X-MAP initialization segments come in
+	{"init segment", "init.mp4", "https://origin.example.com/live/stream/init.mp4"},
+	{"root relative init segment", "/media/init.mp4", "https://origin.example.com/media/init.mp4"},
+	{"protocol relative init segment", "//cdn.example.net/init.mp4", "https://cdn.example.net/init.mp4"},
+}
+
+func TestResolveURI(t *testing.T) {
+	base, err := url.Parse(testPlaylistURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range uriForms {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := resolveURI(base, tc.ref)
+			if err != nil {
+				t.Fatalf("resolveURI(%q) returned error: %v", tc.ref, err)
+			}
+			if got != tc.want {
+				t.Errorf("resolveURI(%q) = %q, want %q", tc.ref, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestResolveURIEmpty(t *testing.T) {
+	base, _ := url.Parse(testPlaylistURL)
+	if _, err := resolveURI(base, "  "); err == nil {
+		t.Error("resolveURI with an empty reference should fail")
+	}
+}
+
+func TestSegmentURIResolution(t *testing.T) {
+	for _, tc := range uriForms {
+		t.Run(tc.name, func(t *testing.T) {
+			content := "#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:1\n#EXTINF:4.0,\n" + tc.ref + "\n"
+
+			segments, err := ParsePlaylist(content, testPlaylistURL)
+			if err != nil {
+				t.Fatalf("ParsePlaylist returned error: %v", err)
+			}
+			if len(segments) != 1 {
+				t.Fatalf("got %d segments, want 1", len(segments))
+			}
+			if segments[0].URL != tc.want {
+				t.Errorf("segment URL = %q, want %q", segments[0].URL, tc.want)
+			}
+		})
+	}
+}
+
+func TestIFrameStreamURIResolution(t *testing.T) {
+	for _, tc := range uriForms {
+		t.Run(tc.name, func(t *testing.T) {
+			content := "#EXTM3U\n#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=86000,URI=\"" + tc.ref + "\"\n"
+
+			variants, err := ParseIFrameVariants(content, testPlaylistURL)
+			if err != nil {
+				t.Fatalf("ParseIFrameVariants returned error: %v", err)
+			}
+			if len(variants) != 1 {
+				t.Fatalf("got %d variants, want 1", len(variants))
+			}
+			if variants[0].URL != tc.want {
+				t.Errorf("variant URL = %q, want %q", variants[0].URL, tc.want)
+			}
+		})
+	}
+}
+
+func TestStreamURIResolution(t *testing.T) {
+	for _, tc := range uriForms {
+		t.Run(tc.name, func(t *testing.T) {
+			content := "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1280000,CODECS=\"avc1.4d401f,mp4a.40.2\"\n" + tc.ref + "\n"
+
+			variants, err := ParseMasterPlaylist(content, testPlaylistURL)
+			if err != nil {
+				t.Fatalf("ParseMasterPlaylist returned error: %v", err)
+			}
+			if len(variants) != 1 {
+				t.Fatalf("got %d variants, want 1", len(variants))
+			}
+			if variants[0].URL != tc.want {
+				t.Errorf("variant URL = %q, want %q", variants[0].URL, tc.want)
+			}
+		})
+	}
+}
+
+func TestKeyURIResolution(t *testing.T) {
+	for _, tc := range uriForms {
+		t.Run(tc.name, func(t *testing.T) {
+			content := "#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:1\n#EXT-X-KEY:METHOD=AES-128,URI=\"" + tc.ref + "\"\n#EXTINF:4.0,\nsegment.ts\n"
+
+			segments, err := ParsePlaylist(content, testPlaylistURL)
+			if err != nil {
+				t.Fatalf("ParsePlaylist returned error: %v", err)
+			}
+			if len(segments) != 1 {
+				t.Fatalf("got %d segments, want 1", len(segments))
+			}
+			if segments[0].Key == nil {
+				t.Fatal("segment has no key")
+			}
+			if segments[0].Key.URI != tc.want {
+				t.Errorf("key URI = %q, want %q", segments[0].Key.URI, tc.want)
+			}
+		})
+	}
+}
+
+func TestMapURIResolution(t *testing.T) {
+	for _, tc := range uriForms {
+		t.Run(tc.name, func(t *testing.T) {
+			content := "#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:1\n#EXT-X-MAP:URI=\"" + tc.ref + "\"\n#EXTINF:4.0,\nsegment.m4s\n"
+
+			segments, err := ParsePlaylist(content, testPlaylistURL)
+			if err != nil {
+				t.Fatalf("ParsePlaylist returned error: %v", err)
+			}
+			if len(segments) != 1 {
+				t.Fatalf("got %d segments, want 1", len(segments))
+			}
+			if segments[0].Map != tc.want {
+				t.Errorf("map URI = %q, want %q", segments[0].Map, tc.want)
+			}
+		})
+	}
+}
+
+func TestAudioRenditionURIResolution(t *testing.T) {
+	for _, tc := range uriForms {
+		t.Run(tc.name, func(t *testing.T) {
+			content := "#EXTM3U\n#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aac\",NAME=\"English\",URI=\"" + tc.ref + "\"\n"
+
+			renditions, err := ParseAudioRenditions(content, testPlaylistURL)
+			if err != nil {
+				t.Fatalf("ParseAudioRenditions returned error: %v", err)
+			}
+			if len(renditions) != 1 {
+				t.Fatalf("got %d renditions, want 1", len(renditions))
+			}
+			if renditions[0].URL != tc.want {
+				t.Errorf("rendition URL = %q, want %q", renditions[0].URL, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package mux
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bariiss/stream-capture/internal/ffmpeg"
+)
+
+// Remuxer moves media into another container using FFmpeg. The streams are
+// copied as they are, so unlike a transcode it is fast and lossless.
+type Remuxer struct {
+	ffmpegPath string
+}
+
+// NewRemuxer creates a new remuxer with FFmpeg path detection.
+func NewRemuxer() (*Remuxer, error) {
+	ffmpegPath, err := ffmpeg.LookPath()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Remuxer{
+		ffmpegPath: ffmpegPath,
+	}, nil
+}
+
+// Remux copies every stream of inputPath into outputPath, whose extension
+// selects the container (e.g. a merged MPEG-TS capture into .mp4).
+func (r *Remuxer) Remux(inputPath, outputPath string) error {
+	// Ensure output directory exists
+	outputDir := filepath.Dir(outputPath)
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	// -c copy: no re-encoding
+	// -y: overwrite output file if exists
+	args := []string{"-i", inputPath, "-c", "copy"}
+	args = append(args, containerArgs(outputPath)...)
+	args = append(args, "-y", outputPath)
+	return r.run(args, "remuxing")
+}
+
+// Trim copies the part of inputPath between start and start+duration into
+// outputPath without re-encoding. Stream copy can only cut at keyframes,
+// so the output starts at the last keyframe before start.
+func (r *Remuxer) Trim(inputPath, outputPath string, start, duration time.Duration) error {
+	// Ensure output directory exists
+	outputDir := filepath.Dir(outputPath)
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	// -ss before -i seeks the input; -t limits the output length
+	// -c copy: no re-encoding
+	// -y: overwrite output file if exists
+	args := []string{"-ss", formatSeconds(start), "-i", inputPath, "-t", formatSeconds(duration), "-c", "copy"}
+	args = append(args, containerArgs(outputPath)...)
+	args = append(args, "-y", outputPath)
+	return r.run(args, "trimming")
+}
+
+// Concat joins inputPaths, in order, into outputPath without re-encoding.
+// Unlike a byte-wise concatenation, FFmpeg's concat demuxer shifts the
+// timestamps of each input to continue where the previous one ended, which
+// smooths over the timestamp resets of HLS discontinuities.
+func (r *Remuxer) Concat(inputPaths []string, outputPath string) error {
+	// Ensure output directory exists
+	outputDir := filepath.Dir(outputPath)
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	list, err := os.CreateTemp("", "stream-capture-concat-*.txt")
+	if err != nil {
+		return fmt.Errorf("failed to create concat list: %w", err)
+	}
+	defer os.Remove(list.Name())
+
+	for _, path := range inputPaths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			list.Close()
+			return fmt.Errorf("failed to resolve %s: %w", path, err)
+		}
+		// Single quotes are escaped by closing, escaping and reopening
+		fmt.Fprintf(list, "file '%s'\n", strings.ReplaceAll(abs, "'", `'\''`))
+	}
+	if err := list.Close(); err != nil {
+		return fmt.Errorf("failed to write concat list: %w", err)
+	}
+
+	// -f concat -safe 0: read the inputs from the list, allowing absolute paths
+	// -c copy: no re-encoding
+	// -y: overwrite output file if exists
+	args := []string{"-f", "concat", "-safe", "0", "-i", list.Name(), "-c", "copy"}
+	args = append(args, containerArgs(outputPath)...)
+	args = append(args, "-y", outputPath)
+	return r.run(args, "concatenation")
+}
+
+// containerArgs returns the muxer options for the container of outputPath.
+func containerArgs(outputPath string) []string {
+	switch strings.ToLower(filepath.Ext(outputPath)) {
+	case ".mp4", ".m4v", ".mov":
+		// -movflags +faststart: put the MP4 index first so playback and
+		// seeking can start before the whole file is read
+		return []string{"-movflags", "+faststart"}
+	}
+	return nil
+}
+
+// formatSeconds formats d as FFmpeg seconds with millisecond precision.
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// run runs FFmpeg with args; what names the operation in errors.
+func (r *Remuxer) run(args []string, what string) error {
+	cmd := exec.Command(r.ffmpegPath, args...)
+
+	// FFmpeg reports its progress and errors on the terminal; the returned
+	// error only carries the exit status
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg %s failed: %w", what, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+// Package retry runs operations with exponential backoff and jitter.
+package retry
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// Policy controls how often and how fast an operation is retried.
+type Policy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	// Values below 1 are treated as 1.
+	MaxAttempts int
+	// BaseDelay is the wait before the second attempt.
+	BaseDelay time.Duration
+	// MaxDelay caps the wait between attempts, jitter included (0 means
+	// no cap).
+	MaxDelay time.Duration
+	// Multiplier grows the delay after every attempt (values below 1 are
+	// treated as 1, a constant delay).
+	Multiplier float64
+	// Jitter randomizes each delay by up to this fraction in either
+	// direction, e.g. 0.2 for ±20%, so clients don't retry in lockstep.
+	Jitter float64
+	// Retryable reports whether an error is worth another attempt. A nil
+	// Retryable retries every error.
+	Retryable func(error) bool
+	// OnRetry, if set, is called before waiting for the next attempt.
+	OnRetry func(attempt int, err error, delay time.Duration)
+}
+
+// Do calls fn until it succeeds, the policy's attempts are used up, the
+// error isn't retryable, or ctx is done. It returns the last error from fn,
+// or ctx's error if the context ended while waiting.
+func Do(ctx context.Context, policy Policy, fn func() error) error {
+	attempts := max(policy.MaxAttempts, 1)
+	delay := policy.BaseDelay
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if attempt >= attempts || (policy.Retryable != nil && !policy.Retryable(err)) {
+			return err
+		}
+
+		wait := policy.jitter(delay)
+		if policy.OnRetry != nil {
+			policy.OnRetry(attempt, err, wait)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		delay = policy.next(delay)
+	}
+}
+
+// next returns the delay that follows d.
+func (p Policy) next(d time.Duration) time.Duration {
+	d = time.Duration(float64(d) * max(p.Multiplier, 1))
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+// jitter spreads d by up to ±Jitter of its length, without exceeding
+// MaxDelay.
+func (p Policy) jitter(d time.Duration) time.Duration {
+	if p.Jitter <= 0 || d <= 0 {
+		return d
+	}
+	spread := float64(d) * p.Jitter
+	d = time.Duration(float64(d) - spread + rand.Float64()*2*spread)
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
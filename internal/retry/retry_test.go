@@ -0,0 +1,161 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errTransient = errors.New("transient")
+
+func TestDoRespectsMaxAttempts(t *testing.T) {
+	tests := []struct {
+		maxAttempts int
+		want        int
+	}{
+		{0, 1},
+		{1, 1},
+		{3, 3},
+		{5, 5},
+	}
+	for _, tt := range tests {
+		calls := 0
+		err := Do(context.Background(), Policy{MaxAttempts: tt.maxAttempts}, func() error {
+			calls++
+			return errTransient
+		})
+		if !errors.Is(err, errTransient) {
+			t.Errorf("MaxAttempts %d: Do = %v, want the last error", tt.maxAttempts, err)
+		}
+		if calls != tt.want {
+			t.Errorf("MaxAttempts %d: %d attempts, want %d", tt.maxAttempts, calls, tt.want)
+		}
+	}
+}
+
+func TestDoStopsOnSuccess(t *testing.T) {
+	calls := 0
+	err := Do(context.Background(), Policy{MaxAttempts: 5}, func() error {
+		calls++
+		if calls < 3 {
+			return errTransient
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("Do = %v after %d attempts, want success after 3", err, calls)
+	}
+}
+
+func TestDoStopsOnPermanentError(t *testing.T) {
+	errPermanent := errors.New("permanent")
+	calls := 0
+	policy := Policy{MaxAttempts: 5, Retryable: func(err error) bool { return err == errTransient }}
+	err := Do(context.Background(), policy, func() error {
+		calls++
+		if calls == 2 {
+			return errPermanent
+		}
+		return errTransient
+	})
+	if !errors.Is(err, errPermanent) || calls != 2 {
+		t.Errorf("Do = %v after %d attempts, want the permanent error after 2", err, calls)
+	}
+}
+
+func TestDoReportsRetries(t *testing.T) {
+	var attempts []int
+	var delays []time.Duration
+	policy := Policy{
+		MaxAttempts: 4,
+		BaseDelay:   time.Millisecond,
+		Multiplier:  2,
+		MaxDelay:    3 * time.Millisecond,
+		OnRetry: func(attempt int, err error, delay time.Duration) {
+			attempts = append(attempts, attempt)
+			delays = append(delays, delay)
+		},
+	}
+	Do(context.Background(), policy, func() error { return errTransient })
+
+	wantDelays := []time.Duration{time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond}
+	if len(attempts) != 3 || attempts[0] != 1 || attempts[2] != 3 {
+		t.Errorf("OnRetry attempts = %v, want [1 2 3]", attempts)
+	}
+	for i, want := range wantDelays {
+		if i < len(delays) && delays[i] != want {
+			t.Errorf("delay %d = %v, want %v", i+1, delays[i], want)
+		}
+	}
+}
+
+func TestDoStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	policy := Policy{MaxAttempts: 10, BaseDelay: time.Hour}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- Do(ctx, policy, func() error {
+			calls++
+			return errTransient
+		})
+	}()
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Do = %v, want context.Canceled", err)
+		}
+		if calls != 1 {
+			t.Errorf("%d attempts, want 1 before the cancellation", calls)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Do kept waiting after the context was cancelled")
+	}
+}
+
+func TestJitterStaysWithinBounds(t *testing.T) {
+	policy := Policy{BaseDelay: time.Second, MaxDelay: 1100 * time.Millisecond, Jitter: 0.5}
+	for range 1000 {
+		d := policy.jitter(time.Second)
+		if d < 500*time.Millisecond || d > policy.MaxDelay {
+			t.Fatalf("jitter(1s) = %v, want 500ms to %v", d, policy.MaxDelay)
+		}
+	}
+
+	// The cap applies to the jittered wait, not only the base delay
+	capped := policy.next(10 * time.Second)
+	for range 1000 {
+		if d := policy.jitter(capped); d > policy.MaxDelay {
+			t.Fatalf("jitter(%v) = %v, above MaxDelay %v", capped, d, policy.MaxDelay)
+		}
+	}
+}
+
+func TestJitterDisabled(t *testing.T) {
+	if d := (Policy{}).jitter(time.Second); d != time.Second {
+		t.Errorf("jitter without Jitter = %v, want 1s", d)
+	}
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		policy Policy
+		delay  time.Duration
+		want   time.Duration
+	}{
+		{Policy{Multiplier: 2}, time.Second, 2 * time.Second},
+		{Policy{Multiplier: 2, MaxDelay: 3 * time.Second}, 2 * time.Second, 3 * time.Second},
+		// A multiplier below 1 keeps the delay constant
+		{Policy{Multiplier: 0.5}, time.Second, time.Second},
+		{Policy{}, time.Second, time.Second},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.next(tt.delay); got != tt.want {
+			t.Errorf("%+v.next(%v) = %v, want %v", tt.policy, tt.delay, got, tt.want)
+		}
+	}
+}
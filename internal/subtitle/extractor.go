@@ -6,11 +6,22 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
+	"time"
 )
 
 // Extractor handles subtitle extraction from audio files using OpenAI Whisper.
 type Extractor struct {
 	whisperPath string
+
+	// Translate makes Whisper translate the speech into English subtitles
+	// instead of transcribing it in its own language.
+	Translate bool
+
+	// WordTimestamps makes Whisper time every word, which the json format
+	// records per segment. Whisper takes noticeably longer with it.
+	WordTimestamps bool
 }
 
 // NewExtractor creates a new subtitle extractor with Whisper path detection.
@@ -27,30 +38,40 @@ func NewExtractor() (*Extractor, error) {
 	}, nil
 }
 
-// ExtractSubtitle extracts subtitles from an audio file using Whisper.
-// Returns the path to the output subtitle file (SRT format).
+// ExtractSubtitle extracts subtitles from an audio file using Whisper in
+// each of formats (FormatSRT if none are given) and returns the paths
+// written, as laid out by OutputPaths. Several formats are produced by a
+// single Whisper run.
 // model can be: tiny, base, small, medium, large, large-v2, large-v3 (default: base)
-func (e *Extractor) ExtractSubtitle(audioPath string, outputPath string, language string, model string) error {
+func (e *Extractor) ExtractSubtitle(audioPath string, outputPath string, language string, model string, formats []string) ([]string, error) {
 	// Ensure output directory exists
 	outputDir := filepath.Dir(outputPath)
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %w", err)
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	if len(formats) == 0 {
+		formats = []string{FormatSRT}
+	}
+	outputFormat := formats[0]
+	if len(formats) > 1 {
+		outputFormat = formatAll
 	}
 
 	// Whisper command arguments
 	// --model: model to use (default: base)
 	// --output_dir: directory for output files
-	// --output_format: srt format
+	// --output_format: srt, vtt, txt, json, or all of them
 	// --language: optional language code (e.g., "tr", "en")
 	if model == "" {
-		model = "base" // Default model
+		model = DefaultModel
 	}
 
 	args := []string{
 		audioPath,
 		"--model", model,
 		"--output_dir", outputDir,
-		"--output_format", "srt",
+		"--output_format", outputFormat,
 	}
 
 	// Add language if specified
@@ -58,32 +79,150 @@ func (e *Extractor) ExtractSubtitle(audioPath string, outputPath string, languag
 		args = append(args, "--language", language)
 	}
 
+	// --task translate: English output whatever the source language is;
+	// language then only tells Whisper what it is listening to
+	if e.Translate {
+		args = append(args, "--task", "translate")
+	}
+
+	// --word_timestamps True: align each word, adding a "words" list with
+	// start and end times to every segment of the json output
+	if e.WordTimestamps {
+		args = append(args, "--word_timestamps", "True")
+	}
+
 	cmd := exec.Command(e.whisperPath, args...)
 
-	// Capture both stdout and stderr for better error messages
+	// FFmpeg writes straight to the terminal, so a failure is explained by
+	// its own output rather than the returned error
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
+	started := time.Now()
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("whisper extraction failed: %w", err)
+		return nil, fmt.Errorf("whisper extraction failed: %w", err)
 	}
 
-	// Whisper creates output file with same name as input but with .srt extension
-	// in the output directory. We need to check if our desired output path matches.
+	// Whisper names its output after the input file, but the exact name
+	// varies between versions (multiple dots, subdirectories), so look for
+	// what was actually produced instead of assuming a single path.
+	paths := OutputPaths(outputPath, formats)
+	for i, format := range formats {
+		producedPath, err := findSubtitleOutput(outputDir, audioPath, format, started)
+		if err != nil {
+			return nil, err
+		}
+
+		// If the produced path doesn't match desired output path, move it
+		if producedPath != paths[i] {
+			if err := moveFile(producedPath, paths[i]); err != nil {
+				return nil, fmt.Errorf("failed to move %s subtitle file to desired location: %w", format, err)
+			}
+		}
+	}
+
+	// Drop the formats Whisper wrote for "all" that weren't asked for:
+	// only files at the name Whisper gives them and written by this run,
+	// never a lookalike the search above would accept
+	if outputFormat == formatAll {
+		for _, format := range whisperFormats {
+			if slices.Contains(formats, format) {
+				continue
+			}
+			path := whisperOutputPath(outputDir, audioPath, format)
+			if info, err := os.Stat(path); err == nil && !info.ModTime().Before(started.Add(-time.Second)) {
+				os.Remove(path)
+			}
+		}
+	}
+
+	return paths, nil
+}
+
+// findSubtitleOutput locates the subtitle file Whisper produced for
+// audioPath in outputDir. The expected "<name without extension>.<format>"
+// is tried first; otherwise files in outputDir (or one subdirectory level)
+// starting with the audio name, with the extension stripped or up to its
+// first dot, are considered and the newest one written since started wins.
+func findSubtitleOutput(outputDir, audioPath, format string, started time.Time) (string, error) {
 	audioBaseName := filepath.Base(audioPath)
-	ext := filepath.Ext(audioBaseName)
-	expectedSrtName := audioBaseName[:len(audioBaseName)-len(ext)] + ".srt"
-	expectedSrtPath := filepath.Join(outputDir, expectedSrtName)
-
-	// If the expected path doesn't match desired output path, rename it
-	if expectedSrtPath != outputPath {
-		if err := os.Rename(expectedSrtPath, outputPath); err != nil {
-			// If rename fails, try to copy
-			return fmt.Errorf("failed to move subtitle file to desired location: %w", err)
+	stem := strings.TrimSuffix(audioBaseName, filepath.Ext(audioBaseName))
+
+	expected := whisperOutputPath(outputDir, audioPath, format)
+	if info, err := os.Stat(expected); err == nil && !info.IsDir() {
+		return expected, nil
+	}
+
+	prefixes := []string{stem}
+	if short, _, ok := strings.Cut(audioBaseName, "."); ok && short != stem {
+		prefixes = append(prefixes, short)
+	}
+
+	var best string
+	var bestTime time.Time
+	for _, prefix := range prefixes {
+		for _, pattern := range []string{
+			filepath.Join(escapeGlob(outputDir), escapeGlob(prefix)+"*."+format),
+			filepath.Join(escapeGlob(outputDir), "*", escapeGlob(prefix)+"*."+format),
+		} {
+			matches, _ := filepath.Glob(pattern)
+			for _, match := range matches {
+				info, err := os.Stat(match)
+				if err != nil || info.IsDir() || info.ModTime().Before(started.Add(-time.Second)) {
+					continue
+				}
+				if best == "" || info.ModTime().After(bestTime) {
+					best, bestTime = match, info.ModTime()
+				}
+			}
 		}
 	}
 
-	return nil
+	if best == "" {
+		return "", fmt.Errorf("whisper did not produce a .%s file for %s in %s", format, audioBaseName, outputDir)
+	}
+	return best, nil
+}
+
+// whisperOutputPath returns where Whisper writes the format output of
+// audioPath: "<name without extension>.<format>" in outputDir.
+func whisperOutputPath(outputDir, audioPath, format string) string {
+	base := filepath.Base(audioPath)
+	return filepath.Join(outputDir, strings.TrimSuffix(base, filepath.Ext(base))+"."+format)
+}
+
+// moveFile renames src to dst, retrying briefly: the file may still be held
+// open right after Whisper exits on some platforms. Whisper writes into
+// the directory of dst, so the rename never crosses file systems.
+func moveFile(src, dst string) error {
+	const attempts = 3
+
+	var err error
+	for i := 0; i < attempts; i++ {
+		if err = os.Rename(src, dst); err == nil {
+			return nil
+		}
+		time.Sleep(time.Duration(i+1) * 100 * time.Millisecond)
+	}
+	return err
+}
+
+// escapeGlob escapes the characters filepath.Glob treats as patterns.
+// Windows has no glob escaping since backslash is the path separator.
+func escapeGlob(s string) string {
+	if runtime.GOOS == "windows" {
+		return s
+	}
+
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
 }
 
 // getInstallHint returns platform-specific installation instructions for Whisper.
@@ -0,0 +1,81 @@
+package subtitle
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// fakeWhisper returns an Extractor running a script that writes a file for
+// each of formats, named the way Whisper names them, into its
+// --output_dir.
+func fakeWhisper(t *testing.T, formats string) *Extractor {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake whisper is a shell script")
+	}
+	script := `#!/bin/sh
+audio=$1
+while [ "$#" -gt 0 ]; do
+	if [ "$1" = --output_dir ]; then dir=$2; fi
+	shift
+done
+name=$(basename "$audio")
+for format in ` + formats + `; do
+	echo "$format" > "$dir/${name%.*}.$format"
+done
+`
+	path := filepath.Join(t.TempDir(), "whisper")
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return &Extractor{whisperPath: path}
+}
+
+func TestFindSubtitleOutputMultipleDots(t *testing.T) {
+	dir := t.TempDir()
+	// Whisper versions differ in how much of "talk.en.mp3" they keep
+	produced := filepath.Join(dir, "talk.srt")
+	if err := os.WriteFile(produced, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := findSubtitleOutput(dir, filepath.Join(dir, "talk.en.mp3"), FormatSRT, time.Now())
+	if err != nil {
+		t.Fatalf("findSubtitleOutput returned error: %v", err)
+	}
+	if got != produced {
+		t.Errorf("findSubtitleOutput = %q, want %q", got, produced)
+	}
+}
+
+func TestExtractSubtitleKeepsLookalikes(t *testing.T) {
+	dir := t.TempDir()
+	audio := filepath.Join(dir, "talk.mp3")
+	// Written just before the run, with a name the search would accept
+	notes := filepath.Join(dir, "talk-notes.txt")
+	if err := os.WriteFile(notes, []byte("mine"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	e := fakeWhisper(t, "srt vtt json tsv")
+	paths, err := e.ExtractSubtitle(audio, filepath.Join(dir, "talk.srt"), "", "", []string{FormatSRT, FormatVTT})
+	if err != nil {
+		t.Fatalf("ExtractSubtitle returned error: %v", err)
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("requested output: %v", err)
+		}
+	}
+	// Formats Whisper wrote unasked are removed, the user's file is not
+	for _, unwanted := range []string{"talk.json", "talk.tsv"} {
+		if _, err := os.Stat(filepath.Join(dir, unwanted)); !os.IsNotExist(err) {
+			t.Errorf("%s was kept", unwanted)
+		}
+	}
+	if _, err := os.Stat(notes); err != nil {
+		t.Errorf("lookalike file removed: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package subtitle
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Subtitle formats Whisper can write.
+const (
+	FormatSRT  = "srt"
+	FormatVTT  = "vtt"
+	FormatTXT  = "txt"
+	FormatJSON = "json"
+)
+
+// formatAll makes Whisper write every format it knows in one run.
+const formatAll = "all"
+
+// Formats lists the supported subtitle formats.
+var Formats = []string{FormatSRT, FormatVTT, FormatTXT, FormatJSON}
+
+// whisperFormats are all the formats Whisper writes for formatAll, so the
+// unrequested ones can be cleaned up.
+var whisperFormats = []string{FormatSRT, FormatVTT, FormatTXT, FormatJSON, "tsv"}
+
+// ParseFormats parses a comma-separated list of subtitle formats, e.g.
+// "srt,vtt". Duplicates are dropped; an empty list means FormatSRT.
+func ParseFormats(list string) ([]string, error) {
+	var formats []string
+	for _, format := range strings.Split(list, ",") {
+		format = strings.ToLower(strings.TrimSpace(format))
+		switch {
+		case format == "":
+			continue
+		case !slices.Contains(Formats, format):
+			return nil, fmt.Errorf("unsupported subtitle format %q: use %s", format, strings.Join(Formats, ", "))
+		case !slices.Contains(formats, format):
+			formats = append(formats, format)
+		}
+	}
+	if len(formats) == 0 {
+		return []string{FormatSRT}, nil
+	}
+	return formats, nil
+}
+
+// OutputPaths returns where each of formats is written for outputPath. A
+// single format is written to outputPath itself; several are written to
+// siblings of outputPath with the format's extension instead of its own.
+func OutputPaths(outputPath string, formats []string) []string {
+	if len(formats) == 1 {
+		return []string{outputPath}
+	}
+
+	stem := strings.TrimSuffix(outputPath, extOf(outputPath))
+	paths := make([]string, len(formats))
+	for i, format := range formats {
+		paths[i] = stem + "." + format
+	}
+	return paths
+}
+
+// extOf returns the extension of path if it is one of a subtitle format,
+// so "talk.en.srt" loses ".srt" but "talk.en" keeps ".en".
+func extOf(path string) string {
+	ext := filepath.Ext(path)
+	if slices.Contains(whisperFormats, strings.ToLower(strings.TrimPrefix(ext, "."))) {
+		return ext
+	}
+	return ""
+}
@@ -0,0 +1,33 @@
+package subtitle
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// DefaultModel is the Whisper model used when none is given.
+const DefaultModel = "base"
+
+// Models lists the Whisper models, smallest first. The ".en" variants are
+// English-only and more accurate for English at the same size.
+var Models = []string{
+	"tiny", "tiny.en",
+	"base", "base.en",
+	"small", "small.en",
+	"medium", "medium.en",
+	"turbo",
+	"large", "large-v1", "large-v2", "large-v3", "large-v3-turbo",
+}
+
+// ValidateModel checks that model names a Whisper model, so a typo is
+// reported before the capture rather than after it.
+func ValidateModel(model string) error {
+	if model == "" || slices.Contains(Models, model) {
+		return nil
+	}
+	return fmt.Errorf("unknown Whisper model %q: use one of %s "+
+		"(larger models are more accurate, especially for non-English audio, but need more RAM and time: "+
+		"about 1 GB for tiny and base, 2 GB for small, 5 GB for medium and 10 GB for large)",
+		model, strings.Join(Models, ", "))
+}
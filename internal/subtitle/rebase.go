@@ -0,0 +1,278 @@
+package subtitle
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mpegTSClock is the frequency of MPEG-TS presentation timestamps.
+const mpegTSClock = 90000
+
+var (
+	// cueTimingRegex matches a cue timing line in SRT (comma) or WebVTT (dot)
+	// notation, keeping any trailing WebVTT cue settings.
+	cueTimingRegex = regexp.MustCompile(`^((?:\d+:)?\d{1,2}:\d{2}[.,]\d{3})\s+-->\s+((?:\d+:)?\d{1,2}:\d{2}[.,]\d{3})(.*)$`)
+
+	// timestampMapRegex matches the WebVTT X-TIMESTAMP-MAP header used by HLS
+	// to tie cue times to the MPEG-TS timeline of the media segments.
+	timestampMapRegex = regexp.MustCompile(`^X-TIMESTAMP-MAP=.*$`)
+)
+
+// Rebase rewrites the cue timings of an SRT or WebVTT document so they start
+// from the captured media rather than the original stream timeline.
+// offset is the stream time of the first captured segment and is subtracted
+// from every cue. For WebVTT with an X-TIMESTAMP-MAP header the cues are first
+// mapped onto the MPEG-TS timeline and the header is dropped, since the output
+// is no longer aligned with the source PTS. Cues that end before zero are
+// removed and cues straddling zero are clamped to start at zero.
+func Rebase(r io.Reader, w io.Writer, offset time.Duration) error {
+	blocks, err := readBlocks(r)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	if err := writeBlocks(bw, rebaseBlocks(blocks, offset)); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// MergeWebVTT combines WebVTT documents of consecutive subtitle segments into
+// a single WebVTT file, rebasing each one as Rebase does. Cues repeated across
+// segment boundaries are written only once.
+func MergeWebVTT(w io.Writer, segments []io.Reader, offset time.Duration) error {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString("WEBVTT\n\n"); err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for i, segment := range segments {
+		blocks, err := readBlocks(segment)
+		if err != nil {
+			return fmt.Errorf("failed to read subtitle segment %d: %w", i, err)
+		}
+
+		var cues [][]string
+		for _, block := range rebaseBlocks(blocks, offset) {
+			if !isCue(block) {
+				continue
+			}
+			key := strings.Join(block, "\n")
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			cues = append(cues, block)
+		}
+
+		if err := writeBlocks(bw, cues); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// rebaseBlocks shifts the cues in blocks by the document's timestamp map and
+// offset, dropping cues that end up entirely before zero.
+func rebaseBlocks(blocks [][]string, offset time.Duration) [][]string {
+	shift := -offset
+	out := make([][]string, 0, len(blocks))
+
+	for _, block := range blocks {
+		if mapped, ok := timestampMapShift(block); ok {
+			shift += mapped
+			block = dropTimestampMap(block)
+		}
+
+		for i, line := range block {
+			match := cueTimingRegex.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+
+			start, err1 := parseTimestamp(match[1])
+			end, err2 := parseTimestamp(match[2])
+			if err1 != nil || err2 != nil {
+				break
+			}
+
+			start, end = start+shift, end+shift
+			if end <= 0 {
+				block = nil
+				break
+			}
+			start = max(start, 0)
+
+			sep := byte('.')
+			if strings.Contains(match[1], ",") {
+				sep = ','
+			}
+			block[i] = formatTimestamp(start, sep) + " --> " + formatTimestamp(end, sep) + match[3]
+			break
+		}
+
+		if block != nil {
+			out = append(out, block)
+		}
+	}
+
+	return renumberSRT(out)
+}
+
+// timestampMapShift returns the offset an X-TIMESTAMP-MAP header in block
+// applies to the document's cues.
+func timestampMapShift(block []string) (time.Duration, bool) {
+	for _, line := range block {
+		if !timestampMapRegex.MatchString(line) {
+			continue
+		}
+
+		var mpegts int64
+		var local time.Duration
+		for _, field := range strings.Split(strings.TrimPrefix(line, "X-TIMESTAMP-MAP="), ",") {
+			name, value, _ := strings.Cut(field, ":")
+			switch name {
+			case "MPEGTS":
+				mpegts, _ = strconv.ParseInt(value, 10, 64)
+			case "LOCAL":
+				local, _ = parseTimestamp(value)
+			}
+		}
+
+		return time.Duration(mpegts)*time.Second/mpegTSClock - local, true
+	}
+	return 0, false
+}
+
+// dropTimestampMap removes the X-TIMESTAMP-MAP line from a header block.
+func dropTimestampMap(block []string) []string {
+	kept := block[:0:0]
+	for _, line := range block {
+		if !timestampMapRegex.MatchString(line) {
+			kept = append(kept, line)
+		}
+	}
+	return kept
+}
+
+// renumberSRT restores consecutive SRT cue numbers after cues were dropped.
+// WebVTT blocks are left untouched.
+func renumberSRT(blocks [][]string) [][]string {
+	n := 1
+	for _, block := range blocks {
+		if len(block) < 2 || !cueTimingRegex.MatchString(block[1]) || !strings.Contains(block[1], ",") {
+			continue
+		}
+		if _, err := strconv.Atoi(block[0]); err == nil {
+			block[0] = strconv.Itoa(n)
+			n++
+		}
+	}
+	return blocks
+}
+
+// isCue reports whether block contains a cue timing line.
+func isCue(block []string) bool {
+	for _, line := range block {
+		if cueTimingRegex.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// readBlocks splits a subtitle document into blank-line separated blocks.
+func readBlocks(r io.Reader) ([][]string, error) {
+	var blocks [][]string
+	var current []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(blocks) == 0 && len(current) == 0 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+
+		if strings.TrimSpace(line) == "" {
+			if len(current) > 0 {
+				blocks = append(blocks, current)
+				current = nil
+			}
+			continue
+		}
+		current = append(current, line)
+	}
+	if len(current) > 0 {
+		blocks = append(blocks, current)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning subtitles: %w", err)
+	}
+	return blocks, nil
+}
+
+// writeBlocks writes blocks separated by blank lines.
+func writeBlocks(w *bufio.Writer, blocks [][]string) error {
+	for _, block := range blocks {
+		for _, line := range block {
+			if _, err := w.WriteString(line + "\n"); err != nil {
+				return err
+			}
+		}
+		if err := w.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseTimestamp parses "[HH:]MM:SS.mmm" (or with a comma before the
+// milliseconds, as in SRT) into a duration.
+func parseTimestamp(s string) (time.Duration, error) {
+	s = strings.Replace(strings.TrimSpace(s), ",", ".", 1)
+
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+
+	seconds, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp %q: %w", s, err)
+	}
+
+	total := time.Duration(seconds * float64(time.Second))
+	multiplier := time.Minute
+	for i := len(parts) - 2; i >= 0; i-- {
+		v, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid timestamp %q: %w", s, err)
+		}
+		total += time.Duration(v) * multiplier
+		multiplier *= 60
+	}
+
+	return total.Round(time.Millisecond), nil
+}
+
+// formatTimestamp formats d as "HH:MM:SS.mmm" using sep before the
+// milliseconds.
+func formatTimestamp(d time.Duration, sep byte) string {
+	d = d.Round(time.Millisecond)
+	h := d / time.Hour
+	d -= h * time.Hour
+	m := d / time.Minute
+	d -= m * time.Minute
+	s := d / time.Second
+	d -= s * time.Second
+	return fmt.Sprintf("%02d:%02d:%02d%c%03d", h, m, s, sep, d/time.Millisecond)
+}
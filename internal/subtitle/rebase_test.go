@@ -0,0 +1,58 @@
+package subtitle
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRebaseSRT(t *testing.T) {
+	input := "1\n00:01:00,000 --> 00:01:02,500\nbefore the capture\n\n" +
+		"2\n00:01:09,000 --> 00:01:11,000\nstraddling the start\n\n" +
+		"3\n00:01:15,250 --> 00:01:17,000\ninside\n"
+	var out strings.Builder
+	if err := Rebase(strings.NewReader(input), &out, 70*time.Second); err != nil {
+		t.Fatalf("Rebase returned error: %v", err)
+	}
+	// The first cue ends before the capture and is dropped, the rest are
+	// renumbered
+	want := "1\n00:00:00,000 --> 00:00:01,000\nstraddling the start\n\n" +
+		"2\n00:00:05,250 --> 00:00:07,000\ninside\n\n"
+	if out.String() != want {
+		t.Errorf("Rebase = %q, want %q", out.String(), want)
+	}
+}
+
+func TestRebaseWebVTTTimestampMap(t *testing.T) {
+	// MPEGTS 900000 puts LOCAL 0 at 10s of the stream
+	input := "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:900000,LOCAL:00:00:00.000\n\n" +
+		"00:00:04.000 --> 00:00:06.000 align:start\nhello\n"
+	var out strings.Builder
+	if err := Rebase(strings.NewReader(input), &out, 12*time.Second); err != nil {
+		t.Fatalf("Rebase returned error: %v", err)
+	}
+	want := "WEBVTT\n\n00:00:02.000 --> 00:00:04.000 align:start\nhello\n\n"
+	if out.String() != want {
+		t.Errorf("Rebase = %q, want %q", out.String(), want)
+	}
+}
+
+func TestMergeWebVTT(t *testing.T) {
+	// A cue spanning the segment boundary is repeated in both segments
+	segments := []io.Reader{
+		strings.NewReader("WEBVTT\n\n01:00:00.000 --> 01:00:01.000\none\n\n01:00:01.500 --> 01:00:02.500\ntwo\n"),
+		strings.NewReader("WEBVTT\n\n01:00:01.500 --> 01:00:02.500\ntwo\n\n01:00:03.000 --> 01:00:03.750\nthree\n"),
+	}
+	var out strings.Builder
+	if err := MergeWebVTT(&out, segments, time.Hour); err != nil {
+		t.Fatalf("MergeWebVTT returned error: %v", err)
+	}
+	want := "WEBVTT\n\n" +
+		"00:00:00.000 --> 00:00:01.000\none\n\n" +
+		"00:00:01.500 --> 00:00:02.500\ntwo\n\n" +
+		"00:00:03.000 --> 00:00:03.750\nthree\n\n"
+	if out.String() != want {
+		t.Errorf("MergeWebVTT = %q, want %q", out.String(), want)
+	}
+}
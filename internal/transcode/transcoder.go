@@ -0,0 +1,141 @@
+package transcode
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/bariiss/stream-capture/internal/ffmpeg"
+)
+
+// Supported video encoders.
+const (
+	EncoderH264 = "libx264"
+	EncoderH265 = "libx265"
+)
+
+// Options controls the re-encoding of a file.
+type Options struct {
+	// Encoder is the FFmpeg video encoder: EncoderH264 (default) or EncoderH265.
+	Encoder string
+	// Preset is the encoder speed/size trade-off (ultrafast ... veryslow).
+	// Empty leaves the encoder default (medium).
+	Preset string
+	// VideoBitrate is the target video bitrate in FFmpeg notation
+	// (e.g. "2500k", "4M"). Empty leaves rate control to the encoder.
+	VideoBitrate string
+	// Scale is the target resolution as WIDTHxHEIGHT (e.g. "1280x720").
+	// Either side may be -2 to keep the aspect ratio. Empty keeps the
+	// source resolution.
+	Scale string
+}
+
+// Transcoder re-encodes media files using FFmpeg. Unlike a remux, the video
+// is decoded and encoded again, which is much slower and lossy.
+type Transcoder struct {
+	ffmpegPath string
+}
+
+// NewTranscoder creates a new transcoder with FFmpeg path detection.
+func NewTranscoder() (*Transcoder, error) {
+	ffmpegPath, err := ffmpeg.LookPath()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Transcoder{
+		ffmpegPath: ffmpegPath,
+	}, nil
+}
+
+// Validate checks the options without running FFmpeg, so a bad value is
+// reported before a long capture rather than after it.
+func (o Options) Validate() error {
+	switch o.Encoder {
+	case "", EncoderH264, EncoderH265:
+	default:
+		return fmt.Errorf("unsupported video encoder %q: use %s or %s", o.Encoder, EncoderH264, EncoderH265)
+	}
+
+	if o.Scale != "" {
+		if _, err := o.scaleFilter(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Transcode re-encodes the video of inputPath into outputPath according to
+// opts. Audio is copied unchanged.
+func (t *Transcoder) Transcode(inputPath, outputPath string, opts Options) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	// Ensure output directory exists
+	outputDir := filepath.Dir(outputPath)
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	encoder := opts.Encoder
+	if encoder == "" {
+		encoder = EncoderH264
+	}
+
+	// -c:v: video encoder, -c:a copy: keep the audio as is
+	// -vf scale=W:H: resize, -b:v: target video bitrate
+	// -y: overwrite output file if exists
+	args := []string{"-i", inputPath, "-c:v", encoder}
+	if opts.Preset != "" {
+		args = append(args, "-preset", opts.Preset)
+	}
+	if opts.VideoBitrate != "" {
+		args = append(args, "-b:v", opts.VideoBitrate)
+	}
+	if opts.Scale != "" {
+		filter, _ := opts.scaleFilter()
+		args = append(args, "-vf", filter)
+	}
+	args = append(args, "-c:a", "copy", "-y", outputPath)
+
+	cmd := exec.Command(t.ffmpegPath, args...)
+
+	// Capture both stdout and stderr for better error messages
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg transcoding failed: %w", err)
+	}
+
+	return nil
+}
+
+// scaleFilter turns Scale (WIDTHxHEIGHT) into an FFmpeg scale filter.
+func (o Options) scaleFilter() (string, error) {
+	w, h, ok := strings.Cut(strings.ToLower(o.Scale), "x")
+	if !ok || !isDimension(w) || !isDimension(h) {
+		return "", fmt.Errorf("invalid scale %q: use WIDTHxHEIGHT, e.g. 1280x720 or -2x720", o.Scale)
+	}
+	return "scale=" + w + ":" + h, nil
+}
+
+// isDimension reports whether s is a positive pixel count or -1/-2, FFmpeg's
+// keep-aspect-ratio markers.
+func isDimension(s string) bool {
+	if s == "-1" || s == "-2" {
+		return true
+	}
+	if s == "" || s == "0" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
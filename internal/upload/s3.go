@@ -0,0 +1,89 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// defaultRegion is used when neither AWS_REGION nor the AWS config names
+// one; S3-compatible services generally accept any region.
+const defaultRegion = "us-east-1"
+
+// S3Options selects where captured files are uploaded.
+type S3Options struct {
+	Bucket string
+	// Endpoint, when set, is the URL of an S3-compatible service such as
+	// MinIO, which is addressed with path-style URLs.
+	Endpoint string
+	// Prefix is prepended to each file's base name to form its object
+	// key, e.g. "captures/".
+	Prefix string
+}
+
+// S3Uploader uploads files to an S3-compatible bucket. Credentials and the
+// region come from the standard AWS environment variables
+// (AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_REGION, AWS_PROFILE...)
+// and shared config files.
+type S3Uploader struct {
+	uploader *manager.Uploader
+	opts     S3Options
+}
+
+// NewS3Uploader loads the AWS configuration and creates an uploader for
+// opts.Bucket.
+func NewS3Uploader(ctx context.Context, opts S3Options) (*S3Uploader, error) {
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("no S3 bucket given")
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
+	}
+	if cfg.Region == "" {
+		cfg.Region = defaultRegion
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if opts.Endpoint != "" {
+			o.BaseEndpoint = aws.String(opts.Endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return &S3Uploader{
+		uploader: manager.NewUploader(client),
+		opts:     opts,
+	}, nil
+}
+
+// Key returns the object key the file at path is uploaded to.
+func (u *S3Uploader) Key(path string) string {
+	return u.opts.Prefix + filepath.Base(path)
+}
+
+// Upload streams the file at path to the bucket and returns the object's
+// URL. Large files are sent as a multipart upload, read from disk part by
+// part rather than loaded into memory.
+func (u *S3Uploader) Upload(ctx context.Context, path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s for upload: %w", path, err)
+	}
+	defer file.Close()
+
+	out, err := u.uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(u.opts.Bucket),
+		Key:    aws.String(u.Key(path)),
+		Body:   file,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload %s to s3://%s/%s: %w", path, u.opts.Bucket, u.Key(path), err)
+	}
+	return out.Location, nil
+}
@@ -0,0 +1,79 @@
+package verify
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/bariiss/stream-capture/internal/ffmpeg"
+)
+
+// Verifier checks that media files decode cleanly using FFmpeg.
+type Verifier struct {
+	ffmpegPath string
+}
+
+// Result holds the outcome of a decode pass.
+type Result struct {
+	// Errors is the number of decode errors FFmpeg reported.
+	Errors int
+	// Messages are the error lines printed by FFmpeg.
+	Messages []string
+}
+
+// NewVerifier creates a new verifier with FFmpeg path detection.
+func NewVerifier() (*Verifier, error) {
+	ffmpegPath, err := ffmpeg.LookPath()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Verifier{
+		ffmpegPath: ffmpegPath,
+	}, nil
+}
+
+// Verify decodes every frame of the file at path without writing any output
+// and reports the errors FFmpeg encountered. Gaps and wrong containers show
+// up here even when the merge itself completed.
+func (v *Verifier) Verify(path string) (*Result, error) {
+	// FFmpeg decode pass
+	// -v error: only print errors
+	// -f null -: decode everything and discard the result
+	cmd := exec.Command(v.ffmpegPath,
+		"-v", "error",
+		"-i", path,
+		"-f", "null",
+		"-",
+	)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	runErr := cmd.Run()
+
+	result := &Result{}
+	scanner := bufio.NewScanner(&stderr)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			result.Messages = append(result.Messages, line)
+		}
+	}
+	result.Errors = len(result.Messages)
+
+	if runErr != nil {
+		if _, ok := runErr.(*exec.ExitError); !ok {
+			return nil, fmt.Errorf("ffmpeg verification failed: %w", runErr)
+		}
+		// FFmpeg gave up on the file; count that as an error even if it
+		// didn't print anything.
+		if result.Errors == 0 {
+			result.Errors = 1
+			result.Messages = append(result.Messages, runErr.Error())
+		}
+	}
+
+	return result, nil
+}